@@ -0,0 +1,81 @@
+package decrypt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	ipfs "github.com/ipfs/go-ipfs-api"
+	"github.com/misnaged/annales/logger"
+	"github.com/spf13/cobra"
+
+	"gitsec-backend/internal"
+	"gitsec-backend/internal/snapshot"
+	"gitsec-backend/pkg/signer"
+)
+
+// walletKeyEnv is the environment variable the wallet key
+// is read from when no key file is given
+const walletKeyEnv = "WALLET_KEY"
+
+// Cmd returns the "decrypt" command of the application.
+// This command fetches the encrypted repository snapshot
+// from IPFS and decrypts it with the wallet key. The key is
+// read from a file or the environment, so it doesn't show up
+// in the shell history and the process list.
+func Cmd(app *internal.App) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "decrypt <metadata CID>",
+		Short: "Decrypt encrypted repository snapshot",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keyFile, _ := cmd.Flags().GetString("key-file")
+			out, _ := cmd.Flags().GetString("out")
+
+			key, err := walletKey(keyFile)
+			if err != nil {
+				return err
+			}
+
+			wallet, err := signer.NewSigner(key, 0)
+			if err != nil {
+				return fmt.Errorf("wallet key: %w", err)
+			}
+
+			meta, err := snapshot.Decrypt(ipfs.NewShell(app.Config().Ipfs.Address), wallet, args[0], out)
+			if err != nil {
+				return fmt.Errorf("decrypt snapshot %s: %w", args[0], err)
+			}
+
+			logger.Log().Infof("repository %s commit %s decrypted to %s", meta.Name, meta.Commit, out)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("key-file", "", "file with the hex encoded wallet private key the content key is wrapped to, "+
+		"the key is read from "+walletKeyEnv+" if it's not set")
+	cmd.Flags().String("out", ".", "directory the snapshot is written to")
+
+	return cmd
+}
+
+// walletKey reads the hex encoded wallet key from the
+// file, or from the environment if no file is given
+func walletKey(file string) (string, error) {
+	if file == "" {
+		key := os.Getenv(walletKeyEnv)
+		if key == "" {
+			return "", errors.New("wallet key is required: set --key-file or " + walletKeyEnv)
+		}
+		return key, nil
+	}
+
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("read wallet key: %w", err)
+	}
+
+	return strings.TrimSpace(string(raw)), nil
+}
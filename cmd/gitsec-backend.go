@@ -5,6 +5,8 @@ import (
 
 	"github.com/misnaged/annales/logger"
 
+	"gitsec-backend/cmd/decrypt"
+	"gitsec-backend/cmd/restore"
 	"gitsec-backend/cmd/root"
 	"gitsec-backend/cmd/serve"
 	"gitsec-backend/internal"
@@ -12,7 +14,7 @@ import (
 
 // main is the entry point of the application
 // It creates an instance of the internal application and adds
-// the "serve" command to the root command. Then it executes
+// the "serve", "decrypt" and "restore" commands to the root command. Then it executes
 // the root command. If any error occurs, it logs the error and
 // exits the application with status code 1.
 func main() {
@@ -24,6 +26,8 @@ func main() {
 
 	rootCmd := root.Cmd(app)
 	rootCmd.AddCommand(serve.Cmd(app))
+	rootCmd.AddCommand(decrypt.Cmd(app))
+	rootCmd.AddCommand(restore.Cmd(app))
 
 	if err := rootCmd.Execute(); err != nil {
 		logger.Log().Infof("An error occurred: %s", err.Error())
@@ -0,0 +1,46 @@
+package restore
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"gitsec-backend/internal"
+)
+
+// Cmd returns the "restore" command of the application.
+// This command restores repositories from their snapshots
+// anchored on-chain, e.g. after the repositories storage
+// is lost.
+func Cmd(app *internal.App) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore <repository ID>...",
+		Short: "Restore repositories from IPFS",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			force, _ := cmd.Flags().GetBool("force")
+			allowMissing, _ := cmd.Flags().GetBool("allow-missing")
+
+			ids := make([]int, 0, len(args))
+			for _, arg := range args {
+				id, err := strconv.Atoi(arg)
+				if err != nil {
+					return fmt.Errorf("invalid repository ID %q: %w", arg, err)
+				}
+				ids = append(ids, id)
+			}
+
+			if err := app.Init(); err != nil {
+				return fmt.Errorf("application initialisation: %w", err)
+			}
+
+			return app.Restore(ids, force, allowMissing)
+		},
+	}
+
+	cmd.Flags().Bool("force", false, "replace existing repositories, they are moved aside")
+	cmd.Flags().Bool("allow-missing", false, "restore repositories missing objects which weren't published to IPFS")
+
+	return cmd
+}
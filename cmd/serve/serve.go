@@ -5,6 +5,7 @@ import (
 
 	"github.com/misnaged/annales/logger"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 
 	"gitsec-backend/internal"
 )
@@ -13,7 +14,7 @@ import (
 // This command is responsible for initializing and
 // running the application.
 func Cmd(app *internal.App) *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "serve",
 		Short: "Run Application",
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -27,4 +28,9 @@ func Cmd(app *internal.App) *cobra.Command {
 			logger.Log().Info(app.Version())
 		},
 	}
+
+	cmd.Flags().Bool("dry-run", false, "compute content CIDs without pinning and anchoring them")
+	_ = viper.BindPFlag("dry_run", cmd.Flags().Lookup("dry-run"))
+
+	return cmd
 }
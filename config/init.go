@@ -15,18 +15,43 @@ func init() {
 	viper.SetDefault("http.port", 8080)
 
 	viper.SetDefault("git.path", ".repos/")
+	viper.SetDefault("git.storage", "os")
+	viper.SetDefault("git.storer", "filesystem")
+	viper.SetDefault("git.idle_timeout", "10m")
+	viper.SetDefault("git.objects_cache", 96)
+	viper.SetDefault("git.auto_heal", false)
 
 	viper.SetDefault("ipfs.address", "http://127.0.0.1:5001")
+	viper.SetDefault("ipfs.gateway", "")
+	viper.SetDefault("ipfs.cache_size", 512)
 
 	viper.SetDefault("blockchain.name", "gnosis")
 	viper.SetDefault("blockchain.network", "chiado")
 	viper.SetDefault("blockchain.rpc", "wss://rpc.chiado.gnosis.gateway.fm/ws")
 	viper.SetDefault("blockchain.contract", "")
 
+	viper.SetDefault("encryption.repositories", []string{})
+	viper.SetDefault("encryption.recipients", []string{})
+
+	viper.SetDefault("dry_run", false)
+
 	// signer private key
 	viper.SetDefault("signer", "")
 
 	viper.SetDefault("pinata.jwt", "")
 
+	viper.SetDefault("pinning_service.endpoint", "")
+	viper.SetDefault("pinning_service.token", "")
+
 	viper.SetDefault("pinner", "pinata")
+
+	viper.SetDefault("pinning.concurrency", 8)
+	viper.SetDefault("pinning.timeout", "2m")
+	viper.SetDefault("pinning.max_file_size", 64)
+	viper.SetDefault("pinning.exclude", []string{})
+	viper.SetDefault("pinning.quorum", 1)
+	viper.SetDefault("pinning.retain", 10)
+	viper.SetDefault("pinning.bundle_chain", 10)
+	viper.SetDefault("pinning.retries", 5)
+	viper.SetDefault("pinning.rate_limit", 3)
 }
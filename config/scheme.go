@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 // Scheme represents the application configuration scheme.
 type Scheme struct {
 	// Env is the application environment.
@@ -11,6 +13,7 @@ type Scheme struct {
 	// Git is the configuration for the Git server.
 	Git *Git
 
+	// Pinner is the comma separated list of pinners content is pinned with.
 	Pinner string
 
 	// Ipfs is the configuration for the Ipfs client.
@@ -18,8 +21,21 @@ type Scheme struct {
 
 	Pinata *Pinata
 
+	// PinningService is the configuration of IPFS Pinning Service API provider.
+	PinningService *PinningService `mapstructure:"pinning_service"`
+
+	// Pinning is the configuration of repository content pinning.
+	Pinning *Pinning
+
 	Blockchain *Blockchain
 
+	// Encryption is the configuration of private repositories encryption.
+	Encryption *Encryption
+
+	// DryRun computes content CIDs locally without pinning
+	// them and anchoring on-chain, e.g. for audits.
+	DryRun bool `mapstructure:"dry_run"`
+
 	// ETH account private key that will be using to sign outcoming transactions
 	Signer string
 
@@ -36,18 +52,109 @@ type HTTP struct {
 type Git struct {
 	// Path is the path to the Git repositories.
 	Path string
+
+	// Storage is the filesystem repositories are stored in: os,
+	// memory, which loses them on restart, ipfs, which keeps its
+	// index under the Path, or mfs, which stores them under the
+	// Path within the IPFS node MFS.
+	Storage string
+
+	// Storer is the way repositories are stored in the Storage:
+	// filesystem, as git stores them, blocks, which stores objects
+	// as blocks shared by repositories, or ipfs, which stores them
+	// as git-raw blocks in the IPFS node.
+	Storer string
+
+	// IdleTimeout is the time after which not used
+	// opened repository is evicted from the cache.
+	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
+
+	// ObjectsCache is the size in megabytes of git
+	// objects cache shared between all repositories.
+	ObjectsCache int64 `mapstructure:"objects_cache"`
+
+	// AutoHeal registers all on-chain repositories on startup and
+	// restores the ones missing on the filesystem from IPFS.
+	AutoHeal bool `mapstructure:"auto_heal"`
 }
 
 // Ipfs represent Ipfs client configuration scheme.
 type Ipfs struct {
 	// Address of Ipfs node
 	Address string
+
+	// Gateway is the base URL of the IPFS gateway repository bundles
+	// are downloaded from by protocol v2 clients, empty disables
+	// bundle-uri advertisement.
+	Gateway string
+
+	// CacheSize is the size in megabytes of the local disk cache
+	// of file contents read by the ipfs git storage, zero reads
+	// them into memory.
+	CacheSize int64 `mapstructure:"cache_size"`
+}
+
+// Pinning represents repository content pinning configuration scheme.
+type Pinning struct {
+	// Concurrency is the maximum number of files pinned at the same time.
+	Concurrency int
+
+	// Timeout is the maximum time a single file is pinned for.
+	Timeout time.Duration
+
+	// MaxFileSize is the maximum size in megabytes of published file,
+	// larger files are listed in metadata without their content.
+	MaxFileSize int64 `mapstructure:"max_file_size"`
+
+	// Exclude are path patterns of files not published.
+	Exclude []string
+
+	// Quorum is the number of pinners which should pin the content
+	// when several pinners are configured.
+	Quorum int
+
+	// Retries is the number of retries of pinner API
+	// requests failed with transient errors.
+	Retries int
+
+	// RateLimit is the maximum number of pinner API requests
+	// per second, not positive means requests aren't limited.
+	RateLimit float64 `mapstructure:"rate_limit"`
+
+	// Retain is the number of the last anchored versions of every
+	// repository kept pinned, not positive keeps all versions.
+	Retain int
+
+	// BundleChain is the maximum number of incremental git bundles
+	// published after the full one, the next update starts a new
+	// chain with a full bundle.
+	BundleChain int `mapstructure:"bundle_chain"`
 }
 
 type Pinata struct {
 	Jwt string
 }
 
+// PinningService represents IPFS Pinning Service API provider configuration scheme.
+type PinningService struct {
+	// Endpoint is the API endpoint, e.g. https://api.example.com/psa
+	Endpoint string
+
+	// Token is the API access token
+	Token string
+}
+
+// Encryption represents private repositories encryption configuration scheme.
+type Encryption struct {
+	// Repositories are owner/name patterns of repositories which content
+	// is encrypted before pinning, e.g. "0xOwner/*" or "*/*" for all.
+	Repositories []string
+
+	// Recipients are hex encoded secp256k1 public keys of collaborators
+	// able to decrypt every encrypted repository besides its owner.
+	Recipients []string
+}
+
 type Blockchain struct {
 	Name     string
 	Network  string
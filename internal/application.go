@@ -16,6 +16,7 @@ import (
 	"gitsec-backend/config"
 	"gitsec-backend/internal/server"
 	"gitsec-backend/internal/service"
+	"gitsec-backend/pkg/multierr"
 )
 
 // App is main microservice application instance that
@@ -57,6 +58,11 @@ func (app *App) Init() (err error) {
 		return fmt.Errorf("initialize application service layer: %w", err)
 	}
 
+	// repositories stored by name aren't found once they're stored by ID
+	if err := app.srv.MigrateLayout(context.Background()); err != nil {
+		return fmt.Errorf("migrate repositories layout: %w", err)
+	}
+
 	app.httpServer = server.NewHTTPServer(app.Config(), app.srv)
 
 	return nil
@@ -82,6 +88,12 @@ func (app *App) initBlockchain(cfg *config.Blockchain) (err error) {
 
 // Serve start serving Application service
 func (app *App) Serve() error {
+	if app.config.Git.AutoHeal {
+		if err := app.srv.Heal(context.Background()); err != nil {
+			logger.Log().Error(fmt.Errorf("auto-heal: %w", err))
+		}
+	}
+
 	go app.srv.StartListener()
 
 	go func() {
@@ -106,6 +118,20 @@ func (app *App) Serve() error {
 	return nil
 }
 
+// Restore restores repositories with the given on-chain
+// IDs from their anchored IPFS snapshots
+func (app *App) Restore(ids []int, force, allowMissing bool) error {
+	var errs []error
+
+	for _, id := range ids {
+		if err := app.srv.Restore(context.Background(), id, force, allowMissing); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return multierr.Join(errs...)
+}
+
 // Stop shutdown the application
 func (app *App) Stop() error {
 	if err := app.httpServer.Close(); err != nil {
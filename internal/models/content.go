@@ -0,0 +1,100 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Reasons files are not published to IPFS
+const (
+	// SkipTooLarge is set for files larger than the size limit
+	SkipTooLarge = "too_large"
+	// SkipExcluded is set for files matching exclude patterns
+	SkipExcluded = "excluded"
+)
+
+// ContentPolicy limits repository files published to IPFS. Skipped
+// files are still listed in the metadata, but their content is not
+// published.
+type ContentPolicy struct {
+	// MaxFileSize is the maximum size of published file in bytes,
+	// not positive size means files are not limited
+	MaxFileSize int64 `json:"max_file_size"`
+
+	// Exclude are path patterns of files not published. Pattern matches
+	// the file path, its base name or any of its parent directories.
+	Exclude []string `json:"exclude"`
+}
+
+// NewContentPolicy creates a new ContentPolicy and validates its patterns.
+func NewContentPolicy(maxFileSize int64, exclude []string) (*ContentPolicy, error) {
+	p := &ContentPolicy{MaxFileSize: maxFileSize}
+
+	for _, pattern := range exclude {
+		pattern = strings.Trim(strings.TrimSpace(pattern), "/")
+		if pattern == "" {
+			continue
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+
+		p.Exclude = append(p.Exclude, pattern)
+	}
+
+	return p, nil
+}
+
+// Skip returns the reason the file with the given path and size is not
+// published, or empty string if it's published.
+func (p *ContentPolicy) Skip(name string, size int64) string {
+	if p == nil {
+		return ""
+	}
+
+	if p.excluded(name) {
+		return SkipExcluded
+	}
+
+	if p.MaxFileSize > 0 && size > p.MaxFileSize {
+		return SkipTooLarge
+	}
+
+	return ""
+}
+
+// excluded checks whether the path matches any of exclude patterns
+func (p *ContentPolicy) excluded(name string) bool {
+	for _, pattern := range p.Exclude {
+		if ok, _ := path.Match(pattern, path.Base(name)); ok {
+			return true
+		}
+
+		for dir := name; dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if ok, _ := path.Match(pattern, dir); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Fingerprint identifies the policy, content published with different
+// policies can't be reused.
+func (p *ContentPolicy) Fingerprint() string {
+	if p == nil {
+		return ""
+	}
+
+	// marshalling of the plain struct never fails
+	b, _ := json.Marshal(p)
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:8])
+}
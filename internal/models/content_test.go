@@ -0,0 +1,48 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentPolicy_Skip(t *testing.T) {
+	policy, err := NewContentPolicy(10, []string{"*.zip", "data/raw", " /vendor/ "})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		size int64
+		want string
+	}{
+		{name: "main.go", size: 10, want: ""},
+		{name: "main.go", size: 11, want: SkipTooLarge},
+		{name: "dist/app.zip", size: 1, want: SkipExcluded},
+		{name: "data/raw/set.csv", size: 1, want: SkipExcluded},
+		{name: "data/set.csv", size: 1, want: ""},
+		{name: "vendor/lib/lib.go", size: 1, want: SkipExcluded},
+		{name: "src/vendor.go", size: 1, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, policy.Skip(tt.name, tt.size))
+		})
+	}
+
+	var unlimited *ContentPolicy
+	assert.Empty(t, unlimited.Skip("app.zip", 1<<40))
+}
+
+func TestNewContentPolicy(t *testing.T) {
+	_, err := NewContentPolicy(0, []string{"[a-"})
+	assert.Error(t, err)
+
+	a, err := NewContentPolicy(1, []string{"*.zip"})
+	require.NoError(t, err)
+	b, err := NewContentPolicy(2, []string{"*.zip"})
+	require.NoError(t, err)
+
+	assert.NotEqual(t, a.Fingerprint(), b.Fingerprint())
+}
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"gitsec-backend/pkg/envelope"
+)
+
+// EncryptionPolicy selects repositories published encrypted and the
+// collaborators their content keys are wrapped to besides the owner.
+type EncryptionPolicy struct {
+	// repositories are owner/name patterns of encrypted repositories
+	repositories []string
+	// recipients are public keys of collaborators
+	recipients []*ecdsa.PublicKey
+}
+
+// NewEncryptionPolicy creates a new EncryptionPolicy. Patterns match the
+// owner namespaced repository name case insensitively, e.g. "0xabc.../*".
+// Recipients are hex encoded secp256k1 public keys.
+func NewEncryptionPolicy(repositories, recipients []string) (*EncryptionPolicy, error) {
+	p := &EncryptionPolicy{}
+
+	for _, pattern := range repositories {
+		pattern = strings.ToLower(strings.Trim(strings.TrimSpace(pattern), "/"))
+		if pattern == "" {
+			continue
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid encrypted repository pattern %q: %w", pattern, err)
+		}
+
+		p.repositories = append(p.repositories, pattern)
+	}
+
+	for _, key := range recipients {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+
+		pub, err := envelope.ParsePublicKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient public key %q: %w", key, err)
+		}
+
+		p.recipients = append(p.recipients, pub)
+	}
+
+	return p, nil
+}
+
+// Encrypted reports whether the repository is published encrypted.
+func (p *EncryptionPolicy) Encrypted(repo *Repo) bool {
+	if p == nil {
+		return false
+	}
+
+	name := strings.ToLower(repo.FullName())
+
+	for _, pattern := range p.repositories {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Recipients returns public keys of the configured collaborators.
+func (p *EncryptionPolicy) Recipients() []*ecdsa.PublicKey {
+	if p == nil {
+		return nil
+	}
+	return p.recipients
+}
+
+// EncryptedMetadata is the published manifest of the encrypted repository.
+// It's what the on-chain CID points to: the metadata is encrypted with the
+// repository content key, and the key is wrapped to every recipient.
+type EncryptedMetadata struct {
+	// Cipher is the content encryption scheme
+	Cipher string `json:"cipher"`
+	// Recipients are the content key wrapped to the owner and collaborators
+	Recipients []envelope.Recipient `json:"recipients"`
+	// Metadata is the encrypted RepoMetadata
+	Metadata []byte `json:"metadata"`
+}
+
+// SealMetadata encrypts the metadata with the content key.
+func SealMetadata(meta *RepoMetadata, key []byte, recipients []envelope.Recipient) (*EncryptedMetadata, error) {
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return nil, fmt.Errorf("marshal repository metadata: %w", err)
+	}
+
+	sealed, err := envelope.Seal(key, raw)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt repository metadata: %w", err)
+	}
+
+	return &EncryptedMetadata{Cipher: envelope.Cipher, Recipients: recipients, Metadata: sealed}, nil
+}
+
+// Open decrypts the metadata with the content key.
+func (e *EncryptedMetadata) Open(key []byte) (*RepoMetadata, error) {
+	if e.Cipher != envelope.Cipher {
+		return nil, fmt.Errorf("unsupported cipher %q", e.Cipher)
+	}
+
+	raw, err := envelope.Open(key, e.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt repository metadata: %w", err)
+	}
+
+	meta := &RepoMetadata{}
+	if err := json.Unmarshal(raw, meta); err != nil {
+		return nil, fmt.Errorf("decode repository metadata: %w", err)
+	}
+
+	return meta, nil
+}
+
+// DecodeMetadata decodes the published metadata, which is either plain
+// RepoMetadata or EncryptedMetadata. The other returned value is nil.
+func DecodeMetadata(r io.Reader) (*RepoMetadata, *EncryptedMetadata, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read metadata: %w", err)
+	}
+
+	var probe struct {
+		Cipher string `json:"cipher"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return nil, nil, fmt.Errorf("decode metadata: %w", err)
+	}
+
+	if probe.Cipher != "" {
+		encrypted := &EncryptedMetadata{}
+		if err := json.Unmarshal(raw, encrypted); err != nil {
+			return nil, nil, fmt.Errorf("decode encrypted metadata: %w", err)
+		}
+
+		if len(encrypted.Recipients) == 0 {
+			return nil, nil, errors.New("decode encrypted metadata: no recipients")
+		}
+
+		return nil, encrypted, nil
+	}
+
+	meta := &RepoMetadata{}
+	if err := json.Unmarshal(raw, meta); err != nil {
+		return nil, nil, fmt.Errorf("decode metadata: %w", err)
+	}
+
+	return meta, nil, nil
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/pkg/envelope"
+)
+
+func TestEncryptionPolicy(t *testing.T) {
+	collaborator, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	owner := common.HexToAddress("0x00000000000000000000000000000000000000aB")
+
+	policy, err := NewEncryptionPolicy(
+		[]string{" 0x00000000000000000000000000000000000000ab/* ", "*/secret"},
+		[]string{hex.EncodeToString(crypto.CompressPubkey(&collaborator.PublicKey))},
+	)
+	require.NoError(t, err)
+
+	assert.True(t, policy.Encrypted(&Repo{Owner: owner, Name: "any"}))
+	assert.True(t, policy.Encrypted(&Repo{Owner: common.HexToAddress("0x01"), Name: "secret"}))
+	assert.False(t, policy.Encrypted(&Repo{Owner: common.HexToAddress("0x01"), Name: "public"}))
+	require.Len(t, policy.Recipients(), 1)
+	assert.True(t, collaborator.PublicKey.Equal(policy.Recipients()[0]))
+
+	var disabled *EncryptionPolicy
+	assert.False(t, disabled.Encrypted(&Repo{Owner: owner, Name: "any"}))
+
+	_, err = NewEncryptionPolicy([]string{"["}, nil)
+	assert.Error(t, err)
+
+	_, err = NewEncryptionPolicy(nil, []string{"0x1234"})
+	assert.Error(t, err)
+}
+
+func TestEncryptedMetadata(t *testing.T) {
+	owner, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	key, err := envelope.NewKey()
+	require.NoError(t, err)
+
+	recipients, err := envelope.Wrap(key, []*ecdsa.PublicKey{&owner.PublicKey})
+	require.NoError(t, err)
+
+	meta := &RepoMetadata{Name: "secret", Commit: "abc", Tree: []*RepoFile{{Name: "main.go", Hash: "bafy"}}}
+
+	sealed, err := SealMetadata(meta, key, recipients)
+	require.NoError(t, err)
+
+	raw, err := json.Marshal(sealed)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "main.go")
+
+	plain, encrypted, err := DecodeMetadata(bytes.NewReader(raw))
+	require.NoError(t, err)
+	assert.Nil(t, plain)
+	require.NotNil(t, encrypted)
+
+	unwrapped, err := envelope.Unwrap(encrypted.Recipients, owner)
+	require.NoError(t, err)
+
+	opened, err := encrypted.Open(unwrapped)
+	require.NoError(t, err)
+	assert.Equal(t, meta, opened)
+
+	raw, err = json.Marshal(meta)
+	require.NoError(t, err)
+
+	plain, encrypted, err = DecodeMetadata(bytes.NewReader(raw))
+	require.NoError(t, err)
+	assert.Nil(t, encrypted)
+	assert.Equal(t, meta, plain)
+}
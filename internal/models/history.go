@@ -0,0 +1,522 @@
+package models
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	formatgraph "github.com/go-git/go-git/v5/plumbing/format/commitgraph"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/object/commitgraph"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+const (
+	// commitGraphPath is the path of the persisted commit-graph
+	// file relative to the repository root
+	commitGraphPath = "objects/info/commit-graph"
+	// commitGraphTmpPath is the path the commit-graph is written to
+	// before it atomically replaces the previous one
+	commitGraphTmpPath = commitGraphPath + ".tmp"
+)
+
+// History is the result of a single reverse-chronological
+// walk over the repository history.
+type History struct {
+	// LastCommits maps requested paths to the last commit touching them.
+	LastCommits map[string]*object.Commit
+	// Commits is the number of commits reachable from all references.
+	Commits int
+}
+
+// History walks the repository history from all references once, newest
+// commits first, counts the commits and assigns the last commit touching
+// each of the given paths. A commit touches a path if the path entry differs
+// from the entry in every commit parent. Trees are only read until all paths
+// are assigned, the rest of the walk reads commit-graph nodes only.
+func (r *Repo) History(paths []string) (*History, error) {
+	h := &History{LastCommits: make(map[string]*object.Commit, len(paths))}
+
+	pending := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		pending[p] = true
+	}
+
+	err := r.walkCommits(func(node commitgraph.CommitNode) error {
+		h.Commits++
+
+		touched, err := touchedPaths(node, pending)
+		if err != nil || len(touched) == 0 {
+			return err
+		}
+
+		commit, err := node.Commit()
+		if err != nil {
+			return fmt.Errorf("failed to retrieve commit %s: %w", node.ID(), err)
+		}
+
+		for _, p := range touched {
+			h.LastCommits[p] = commit
+			delete(pending, p)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// errReached stops the walk once the commit is reached
+var errReached = errors.New("commit reached")
+
+// Reachable reports whether the commit is reachable from repository
+// references. Commits of the history rewritten by a force push aren't.
+func (r *Repo) Reachable(hash plumbing.Hash) (bool, error) {
+	err := r.walkCommits(func(node commitgraph.CommitNode) error {
+		if node.ID() == hash {
+			return errReached
+		}
+		return nil
+	})
+	if errors.Is(err, errReached) {
+		return true, nil
+	}
+
+	return false, err
+}
+
+// walkCommits calls fn for every commit reachable from repository
+// references in reverse-chronological order until fn fails.
+// Commits are read from the persisted commit-graph if it exists.
+func (r *Repo) walkCommits(fn func(node commitgraph.CommitNode) error) error {
+	index, closeIndex, err := r.commitNodeIndex()
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	tips, err := r.tips()
+	if err != nil {
+		return err
+	}
+
+	queue := &commitQueue{}
+	seen := make(map[plumbing.Hash]bool)
+
+	for _, tip := range tips {
+		if seen[tip] {
+			continue
+		}
+		seen[tip] = true
+
+		node, err := index.Get(tip)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve commit %s: %w", tip, err)
+		}
+		heap.Push(queue, node)
+	}
+
+	for queue.Len() > 0 {
+		node := heap.Pop(queue).(commitgraph.CommitNode)
+
+		if err := fn(node); err != nil {
+			return err
+		}
+
+		if err := node.ParentNodes().ForEach(func(parent commitgraph.CommitNode) error {
+			if !seen[parent.ID()] {
+				seen[parent.ID()] = true
+				heap.Push(queue, parent)
+			}
+			return nil
+		}); err != nil {
+			return fmt.Errorf("failed to retrieve commit %s parents: %w", node.ID(), err)
+		}
+	}
+
+	return nil
+}
+
+// tips returns commits all repository references point to.
+func (r *Repo) tips() ([]plumbing.Hash, error) {
+	refs, err := r.Repocore.References()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve repository references: %w", err)
+	}
+
+	var tips []plumbing.Hash
+	if err := refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		obj, err := r.Repocore.Object(plumbing.AnyObject, ref.Hash())
+		if err != nil {
+			return fmt.Errorf("failed to retrieve %s object: %w", ref.Name(), err)
+		}
+
+		for {
+			switch o := obj.(type) {
+			case *object.Commit:
+				tips = append(tips, o.Hash)
+				return nil
+			case *object.Tag:
+				if obj, err = o.Object(); err != nil {
+					return fmt.Errorf("failed to peel tag %s: %w", ref.Name(), err)
+				}
+			default:
+				return nil
+			}
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	return tips, nil
+}
+
+// commitNodeIndex returns commits index backed by the persisted
+// commit-graph. It falls back to the object storage for commits missing
+// in the graph or if the graph doesn't exist.
+func (r *Repo) commitNodeIndex() (commitgraph.CommitNodeIndex, func(), error) {
+	f, err := r.fileSystem.Open(commitGraphPath)
+	if os.IsNotExist(err) {
+		return commitgraph.NewObjectCommitNodeIndex(r.Repocore.Storer), func() {}, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open commit-graph: %w", err)
+	}
+
+	index, err := formatgraph.OpenFileIndex(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, fmt.Errorf("failed to read commit-graph: %w", err)
+	}
+
+	return commitgraph.NewGraphCommitNodeIndex(index, r.Repocore.Storer), func() { _ = f.Close() }, nil
+}
+
+// WriteCommitGraph updates the persisted commit-graph with commits reachable
+// from repository references. Only commits missing in the existing graph
+// are read from the object storage.
+func (r *Repo) WriteCommitGraph() error {
+	index := formatgraph.NewMemoryIndex()
+	generations := make(map[plumbing.Hash]int)
+
+	if err := r.copyCommitGraph(index, generations); err != nil {
+		return err
+	}
+
+	tips, err := r.tips()
+	if err != nil {
+		return err
+	}
+
+	var added []plumbing.Hash
+	commits := make(map[plumbing.Hash]*object.Commit)
+
+	stack := tips
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if _, err := index.GetIndexByHash(hash); err == nil {
+			continue
+		}
+		if _, ok := commits[hash]; ok {
+			continue
+		}
+
+		commit, err := object.GetCommit(r.Repocore.Storer, hash)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve commit %s: %w", hash, err)
+		}
+
+		commits[hash] = commit
+		added = append(added, hash)
+		stack = append(stack, commit.ParentHashes...)
+	}
+
+	if len(added) == 0 {
+		return nil
+	}
+
+	for _, hash := range added {
+		if err := computeGeneration(hash, commits, generations); err != nil {
+			return err
+		}
+
+		commit := commits[hash]
+		index.Add(hash, &formatgraph.CommitData{
+			TreeHash:     commit.TreeHash,
+			ParentHashes: commit.ParentHashes,
+			Generation:   generations[hash],
+			When:         commit.Committer.When,
+		})
+	}
+
+	f, err := r.fileSystem.Create(commitGraphTmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create commit-graph: %w", err)
+	}
+
+	if err := formatgraph.NewEncoder(f).Encode(index); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to encode commit-graph: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write commit-graph: %w", err)
+	}
+
+	if err := r.fileSystem.Rename(commitGraphTmpPath, commitGraphPath); err != nil {
+		return fmt.Errorf("failed to replace commit-graph: %w", err)
+	}
+
+	return nil
+}
+
+// copyCommitGraph copies all commits of the persisted commit-graph to the
+// given index and records their generation numbers.
+func (r *Repo) copyCommitGraph(index *formatgraph.MemoryIndex, generations map[plumbing.Hash]int) error {
+	f, err := r.fileSystem.Open(commitGraphPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open commit-graph: %w", err)
+	}
+	defer f.Close()
+
+	existing, err := formatgraph.OpenFileIndex(f)
+	if err != nil {
+		// broken graph is rebuilt from scratch
+		return nil
+	}
+
+	for _, hash := range existing.Hashes() {
+		i, err := existing.GetIndexByHash(hash)
+		if err != nil {
+			return fmt.Errorf("failed to read commit-graph: %w", err)
+		}
+
+		data, err := existing.GetCommitDataByIndex(i)
+		if err != nil {
+			return fmt.Errorf("failed to read commit-graph: %w", err)
+		}
+
+		index.Add(hash, &formatgraph.CommitData{
+			TreeHash:     data.TreeHash,
+			ParentHashes: data.ParentHashes,
+			Generation:   data.Generation,
+			When:         data.When,
+		})
+		generations[hash] = data.Generation
+	}
+
+	return nil
+}
+
+// computeGeneration computes the generation number of the given commit:
+// one for root commits and one more than the maximum parent generation
+// otherwise. Parents are either new commits or already have a generation.
+func computeGeneration(hash plumbing.Hash, commits map[plumbing.Hash]*object.Commit, generations map[plumbing.Hash]int) error {
+	stack := []plumbing.Hash{hash}
+
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		if _, ok := generations[current]; ok {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		commit, ok := commits[current]
+		if !ok {
+			return fmt.Errorf("commit %s is missing in commit-graph", current)
+		}
+
+		generation, ready := 1, true
+		for _, parent := range commit.ParentHashes {
+			g, ok := generations[parent]
+			if !ok {
+				stack = append(stack, parent)
+				ready = false
+				continue
+			}
+			if g+1 > generation {
+				generation = g + 1
+			}
+		}
+
+		if ready {
+			generations[current] = generation
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	return nil
+}
+
+// touchedPaths returns pending paths the given commit touched.
+func touchedPaths(node commitgraph.CommitNode, pending map[string]bool) ([]string, error) {
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	tree, err := node.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve commit %s tree: %w", node.ID(), err)
+	}
+
+	paths := make([]string, 0, len(pending))
+	for p := range pending {
+		paths = append(paths, p)
+	}
+
+	if node.NumParents() == 0 {
+		return diffPaths(tree, nil, paths)
+	}
+
+	touched := paths
+	if err := node.ParentNodes().ForEach(func(parent commitgraph.CommitNode) error {
+		if len(touched) == 0 {
+			return storer.ErrStop
+		}
+
+		parentTree, err := parent.Tree()
+		if err != nil {
+			return fmt.Errorf("failed to retrieve commit %s tree: %w", parent.ID(), err)
+		}
+
+		touched, err = diffPaths(tree, parentTree, touched)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return touched, nil
+}
+
+// diffPaths returns the given paths which entries differ between trees a and
+// b. Nil tree is handled as empty. Subtrees with equal hashes are skipped
+// without being read.
+func diffPaths(a, b *object.Tree, paths []string) ([]string, error) {
+	groups := make(map[string][]string)
+	for _, p := range paths {
+		head, tail := splitPath(p)
+		groups[head] = append(groups[head], tail)
+	}
+
+	var diff []string
+	for head, tails := range groups {
+		ea, eb := treeEntry(a, head), treeEntry(b, head)
+
+		if ea == nil && eb == nil {
+			continue
+		}
+
+		if ea != nil && eb != nil && ea.Hash == eb.Hash && ea.Mode == eb.Mode {
+			continue
+		}
+
+		var subtrees []string
+		for _, tail := range tails {
+			if tail == "" {
+				diff = append(diff, head)
+				continue
+			}
+			subtrees = append(subtrees, tail)
+		}
+
+		if len(subtrees) == 0 {
+			continue
+		}
+
+		sa, err := subtree(a, ea)
+		if err != nil {
+			return nil, err
+		}
+
+		sb, err := subtree(b, eb)
+		if err != nil {
+			return nil, err
+		}
+
+		subdiff, err := diffPaths(sa, sb, subtrees)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range subdiff {
+			diff = append(diff, head+"/"+p)
+		}
+	}
+
+	return diff, nil
+}
+
+// splitPath splits the path into its first element and the rest.
+func splitPath(p string) (head, tail string) {
+	if i := strings.IndexByte(p, '/'); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return p, ""
+}
+
+// treeEntry returns the tree entry with the given name or nil.
+func treeEntry(t *object.Tree, name string) *object.TreeEntry {
+	if t == nil {
+		return nil
+	}
+
+	for i := range t.Entries {
+		if t.Entries[i].Name == name {
+			return &t.Entries[i]
+		}
+	}
+
+	return nil
+}
+
+// subtree returns the tree of the given entry of t, or nil
+// if the entry doesn't exist or isn't a directory.
+func subtree(t *object.Tree, e *object.TreeEntry) (*object.Tree, error) {
+	if e == nil || e.Mode != filemode.Dir {
+		return nil, nil
+	}
+
+	sub, err := t.Tree(e.Name)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to retrieve tree %s: %w", e.Name, err)
+	}
+
+	return sub, nil
+}
+
+// commitQueue is a priority queue of commits ordered
+// by commit time, the newest commits first.
+type commitQueue []commitgraph.CommitNode
+
+func (q commitQueue) Len() int { return len(q) }
+
+func (q commitQueue) Less(i, j int) bool { return q[i].CommitTime().After(q[j].CommitTime()) }
+
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *commitQueue) Push(x interface{}) { *q = append(*q, x.(commitgraph.CommitNode)) }
+
+func (q *commitQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	x := old[n-1]
+	*q = old[:n-1]
+	return x
+}
@@ -0,0 +1,168 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	formatgraph "github.com/go-git/go-git/v5/plumbing/format/commitgraph"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepo_History(t *testing.T) {
+	dot := memfs.New()
+
+	core, err := git.Init(filesystem.NewStorage(dot, cache.NewObjectLRUDefault()), memfs.New())
+	require.NoError(t, err)
+
+	repo := &Repo{Name: "api", fileSystem: dot, Repocore: core}
+
+	start := time.Now().Add(-time.Hour)
+	c1 := commitFiles(t, core, start, map[string][]byte{"a": []byte("1"), "b/x": []byte("1")})
+	c2 := commitFiles(t, core, start.Add(time.Minute), map[string][]byte{"a": []byte("2")})
+	c3 := commitFiles(t, core, start.Add(2*time.Minute), map[string][]byte{"b/y": []byte("1")})
+	c4 := commitFiles(t, core, start.Add(3*time.Minute), map[string][]byte{"c": []byte("1")})
+
+	assertHistory := func(commits int) {
+		t.Helper()
+
+		history, err := repo.History([]string{"a", "b/x", "b/y", "c"})
+		require.NoError(t, err)
+		assert.Equal(t, commits, history.Commits)
+
+		require.Len(t, history.LastCommits, 4)
+		assert.Equal(t, c2.Hash, history.LastCommits["a"].Hash)
+		assert.Equal(t, c1.Hash, history.LastCommits["b/x"].Hash)
+		assert.Equal(t, c3.Hash, history.LastCommits["b/y"].Hash)
+		assert.Equal(t, c4.Hash, history.LastCommits["c"].Hash)
+	}
+
+	// without commit-graph
+	assertHistory(4)
+
+	require.NoError(t, repo.WriteCommitGraph())
+	assertHistory(4)
+
+	// incremental commit-graph update
+	c5 := commitFiles(t, core, start.Add(4*time.Minute), map[string][]byte{"d": []byte("1")})
+	require.NoError(t, repo.WriteCommitGraph())
+	assertHistory(5)
+
+	f, err := dot.Open(commitGraphPath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	graph, err := formatgraph.OpenFileIndex(f)
+	require.NoError(t, err)
+	require.Len(t, graph.Hashes(), 5)
+
+	i, err := graph.GetIndexByHash(c5.Hash)
+	require.NoError(t, err)
+
+	data, err := graph.GetCommitDataByIndex(i)
+	require.NoError(t, err)
+	assert.Equal(t, 5, data.Generation)
+	assert.Equal(t, c4.Hash, data.ParentHashes[0])
+}
+
+func TestRepo_HistoryMerge(t *testing.T) {
+	dot := memfs.New()
+
+	core, err := git.Init(filesystem.NewStorage(dot, cache.NewObjectLRUDefault()), memfs.New())
+	require.NoError(t, err)
+
+	repo := &Repo{Name: "api", fileSystem: dot, Repocore: core}
+
+	wt, err := core.Worktree()
+	require.NoError(t, err)
+
+	start := time.Now().Add(-time.Hour)
+	commitFiles(t, core, start, map[string][]byte{"a": []byte("1"), "f": []byte("1"), "m": []byte("1")})
+
+	require.NoError(t, wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("feature"), Create: true}))
+	feature := commitFiles(t, core, start.Add(time.Minute), map[string][]byte{"f": []byte("2")})
+
+	require.NoError(t, wt.Checkout(&git.CheckoutOptions{Branch: plumbing.Master}))
+	master := commitFiles(t, core, start.Add(2*time.Minute), map[string][]byte{"m": []byte("2")})
+
+	// the merge takes "f" from the feature branch and changes "a" on its own
+	for name, content := range map[string][]byte{"f": []byte("2"), "a": []byte("merged")} {
+		require.NoError(t, util.WriteFile(wt.Filesystem, name, content, 0644))
+		_, err = wt.Add(name)
+		require.NoError(t, err)
+	}
+
+	hash, err := wt.Commit("merge", &git.CommitOptions{
+		Author:  &object.Signature{Name: "alice", When: start.Add(3 * time.Minute)},
+		Parents: []plumbing.Hash{master.Hash, feature.Hash},
+	})
+	require.NoError(t, err)
+
+	assertHistory := func() {
+		t.Helper()
+
+		history, err := repo.History([]string{"a", "f", "m"})
+		require.NoError(t, err)
+
+		// commits of both branches are counted once
+		assert.Equal(t, 4, history.Commits)
+
+		require.Len(t, history.LastCommits, 3)
+		assert.Equal(t, hash, history.LastCommits["a"].Hash)
+		assert.Equal(t, feature.Hash, history.LastCommits["f"].Hash)
+		assert.Equal(t, master.Hash, history.LastCommits["m"].Hash)
+	}
+
+	assertHistory()
+
+	require.NoError(t, repo.WriteCommitGraph())
+	assertHistory()
+}
+
+func TestRepo_Reachable(t *testing.T) {
+	dot := memfs.New()
+
+	core, err := git.Init(filesystem.NewStorage(dot, cache.NewObjectLRUDefault()), memfs.New())
+	require.NoError(t, err)
+
+	repo := &Repo{Name: "api", fileSystem: dot, Repocore: core}
+
+	start := time.Now().Add(-time.Hour)
+	c1 := commitFiles(t, core, start, map[string][]byte{"a": []byte("1"), "b": []byte("1")})
+	c2 := commitFiles(t, core, start.Add(time.Minute), map[string][]byte{"a": []byte("2")})
+
+	for _, hash := range []plumbing.Hash{c1.Hash, c2.Hash} {
+		reachable, err := repo.Reachable(hash)
+		require.NoError(t, err)
+		assert.True(t, reachable)
+	}
+
+	// the force push replaces the last commit with the amended one
+	require.NoError(t, core.Storer.SetReference(plumbing.NewHashReference(plumbing.Master, c1.Hash)))
+	amended := commitFiles(t, core, start.Add(2*time.Minute), map[string][]byte{"a": []byte("2"), "b": []byte("2")})
+
+	reachable, err := repo.Reachable(c2.Hash)
+	require.NoError(t, err)
+	assert.False(t, reachable)
+
+	// last commits of all files are recomputed for the rewritten history
+	meta := &RepoMetadata{Tree: []*RepoFile{
+		{Name: "a", Commit: c2.Hash.String()},
+		{Name: "b", Commit: c1.Hash.String(), Changed: true},
+	}}
+
+	history, err := repo.History(meta.FileNames())
+	require.NoError(t, err)
+	meta.FillCommit(history)
+
+	assert.Equal(t, 2, meta.CommitsCount)
+	assert.Equal(t, amended.Hash.String(), meta.Tree[0].Commit)
+	assert.Equal(t, amended.Hash.String(), meta.Tree[1].Commit)
+}
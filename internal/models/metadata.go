@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
 )
 
 type RepoMetadata struct {
@@ -16,51 +18,204 @@ type RepoMetadata struct {
 	Commit       string      `json:"commit"`
 	Timestamp    int64       `json:"timestamp"`
 	CommitsCount int         `json:"commits_count"`
+	// CommitCID is the git-raw CID of the commit, the whole history
+	// is reachable from it
+	CommitCID string `json:"commit_cid,omitempty"`
+	// Branch is the branch HEAD points to, the commit is restored to it
+	Branch string `json:"branch,omitempty"`
+	// SkippedObjects are hashes of git objects larger than the size
+	// limit, they are not published as git-raw blocks
+	SkippedObjects []string `json:"skipped_objects,omitempty"`
+	// Root is the CID of the UnixFS directory of the repository tree
+	Root string `json:"root,omitempty"`
+	// Policy is the fingerprint of the content policy files were
+	// published with
+	Policy string `json:"policy,omitempty"`
+	// Failed lists files which failed to be added to the tree
+	// directory, they are published again with the next metadata update.
+	Failed []string `json:"failed,omitempty"`
+	// Bundles is the chain of git bundles of the repository history, the
+	// first one is full, each next one is incremental against the previous
+	Bundles []*Bundle `json:"bundles,omitempty"`
 }
 
-func (m *RepoMetadata) FillContent(tree *object.Tree) error {
-	if err := tree.Files().ForEach(func(f *object.File) error {
+// Bundle is the published git bundle.
+type Bundle struct {
+	// CID is the CID of the UnixFS file of the bundle
+	CID string `json:"cid"`
+	// Refs are hashes of the bundled references by their names
+	Refs map[string]string `json:"refs"`
+	// Prerequisites are commits the bundle requires, they are
+	// bundled by the previous bundles of the chain
+	Prerequisites []string `json:"prerequisites,omitempty"`
+	// Size is the cumulative DAG size of the bundle
+	Size uint64 `json:"size"`
+}
+
+// FillContent fills metadata content with all files of the tree.
+// File contents are not read, only sizes and binary flags are detected.
+func (m *RepoMetadata) FillContent(tree *object.Tree, policy *ContentPolicy) error {
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
 
-		content, err := f.Contents()
+	for {
+		name, entry, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
 		if err != nil {
-			return fmt.Errorf("failed to retrieve content: %w", err)
+			return fmt.Errorf("failed to iter tree: %w", err)
 		}
 
-		m.Tree = append(m.Tree, &RepoFile{
-			Name:    f.Name,
-			Content: content,
-			Hash:    f.Hash.String(),
-		})
+		if !entry.Mode.IsFile() {
+			continue
+		}
 
-		return nil
-	}); err != nil {
+		f, err := newRepoFile(tree, name, entry, policy)
+		if err != nil {
+			return err
+		}
+
+		m.Tree = append(m.Tree, f)
+	}
+
+	return nil
+}
+
+// FillContentFrom fills metadata content incrementally. Only files changed
+// between prevTree and tree are described again, unchanged files are carried
+// over from the previously published metadata prev. Files missing in prev or
+// failed to be pinned previously are handled as changed.
+func (m *RepoMetadata) FillContentFrom(prev *RepoMetadata, prevTree, tree *object.Tree, policy *ContentPolicy) error {
+	changes, err := object.DiffTree(prevTree, tree)
+	if err != nil {
+		return fmt.Errorf("failed to diff trees: %w", err)
+	}
+
+	changed := make(map[string]bool, len(changes))
+	for _, c := range changes {
+		action, err := c.Action()
+		if err != nil {
+			return fmt.Errorf("failed to get change action: %w", err)
+		}
+
+		if action != merkletrie.Delete {
+			changed[c.To.Name] = true
+		}
+	}
+
+	published := make(map[string]*RepoFile, len(prev.Tree))
+	for _, f := range prev.Tree {
+		published[f.Name] = f
+	}
+
+	for _, name := range prev.Failed {
+		delete(published, name)
+	}
+
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	for {
+		name, entry, err := walker.Next()
 		if errors.Is(err, io.EOF) {
-			fmt.Println("EOF")
-		} else {
+			break
+		}
+		if err != nil {
 			return fmt.Errorf("failed to iter tree: %w", err)
 		}
+
+		if !entry.Mode.IsFile() {
+			continue
+		}
+
+		if f, ok := published[name]; ok && !changed[name] {
+			m.Tree = append(m.Tree, &RepoFile{
+				Name:      f.Name,
+				Hash:      f.Hash,
+				Author:    f.Author,
+				Commit:    f.Commit,
+				Timestamp: f.Timestamp,
+				Size:      f.Size,
+				Mode:      f.Mode,
+				Binary:    f.Binary,
+				Skipped:   f.Skipped,
+			})
+			continue
+		}
+
+		f, err := newRepoFile(tree, name, entry, policy)
+		if err != nil {
+			return err
+		}
+
+		m.Tree = append(m.Tree, f)
 	}
 
 	return nil
 }
 
-func (m *RepoMetadata) FillCommit(repo *Repo) error {
+// newRepoFile describes the changed file of the tree. Binary flag is
+// detected for published files only, skipped ones are never read.
+func newRepoFile(tree *object.Tree, name string, entry object.TreeEntry, policy *ContentPolicy) (*RepoFile, error) {
+	file, err := tree.TreeEntryFile(&entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve file %s: %w", name, err)
+	}
+
+	f := &RepoFile{
+		Name:    name,
+		Size:    file.Size,
+		Mode:    entry.Mode.String(),
+		Skipped: policy.Skip(name, file.Size),
+		Changed: true,
+	}
+
+	if f.Skipped == "" && entry.Mode != filemode.Symlink {
+		if f.Binary, err = file.IsBinary(); err != nil {
+			return nil, fmt.Errorf("failed to detect file %s type: %w", name, err)
+		}
+	}
+
+	return f, nil
+}
+
+// ChangedFiles returns names of files changed since
+// the previously published metadata.
+func (m *RepoMetadata) ChangedFiles() []string {
+	var names []string
 	for _, f := range m.Tree {
-		commit, err := repo.FileLastCommit(f.Name)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				fmt.Println("EOF")
-				continue
-			} else {
-				return fmt.Errorf("failed to retrieve file last commit: %w", err)
-			}
+		if f.Changed {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+// FileNames returns names of all files of the metadata.
+func (m *RepoMetadata) FileNames() []string {
+	names := make([]string, 0, len(m.Tree))
+	for _, f := range m.Tree {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// FillCommit fills the commits count and the last commit of every
+// file the given history is walked for, other files keep theirs.
+func (m *RepoMetadata) FillCommit(history *History) {
+	m.CommitsCount = history.Commits
+
+	for _, f := range m.Tree {
+		commit, ok := history.LastCommits[f.Name]
+		if !ok {
+			continue
 		}
 
 		f.Author = commit.Author.Name
 		f.Commit = commit.Hash.String()
 		f.Timestamp = commit.Author.When.Unix()
 	}
-	return nil
 }
 
 type RepoFile struct {
@@ -69,5 +224,16 @@ type RepoFile struct {
 	Author    string `json:"author"`
 	Commit    string `json:"commit"`
 	Timestamp int64  `json:"timestamp"`
-	Content   string `json:"-"`
+	// Size is the file size in bytes
+	Size int64 `json:"size"`
+	// Mode is the git file mode, e.g. 0100755 for executables
+	Mode string `json:"mode"`
+	// Binary reports whether the file content is binary
+	Binary bool `json:"binary"`
+	// Skipped is the reason the file content is not published,
+	// see SkipTooLarge and SkipExcluded
+	Skipped string `json:"skipped,omitempty"`
+	// Changed reports whether the file has been changed since the
+	// previously published metadata.
+	Changed bool `json:"-"`
 }
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commitFiles writes given files to the worktree, removes files with nil
+// content and commits the result at the given time
+func commitFiles(t *testing.T, repo *git.Repository, when time.Time, files map[string][]byte) *object.Commit {
+	t.Helper()
+
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	for name, content := range files {
+		if content == nil {
+			_, err = wt.Remove(name)
+			require.NoError(t, err)
+			continue
+		}
+
+		require.NoError(t, util.WriteFile(wt.Filesystem, name, content, 0644))
+		_, err = wt.Add(name)
+		require.NoError(t, err)
+	}
+
+	hash, err := wt.Commit("commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "alice", When: when},
+	})
+	require.NoError(t, err)
+
+	commit, err := repo.CommitObject(hash)
+	require.NoError(t, err)
+
+	return commit
+}
+
+// commitTree returns the tree of the given commit
+func commitTree(t *testing.T, commit *object.Commit) *object.Tree {
+	t.Helper()
+
+	tree, err := commit.Tree()
+	require.NoError(t, err)
+
+	return tree
+}
+
+func TestRepoMetadata_FillContentFrom(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	require.NoError(t, err)
+
+	prevTree := commitTree(t, commitFiles(t, repo, time.Now(), map[string][]byte{
+		"a.txt":     []byte("a"),
+		"b.txt":     []byte("b"),
+		"src/d.txt": []byte("d"),
+		"data.bin":  {0, 1, 2},
+	}))
+
+	tree := commitTree(t, commitFiles(t, repo, time.Now(), map[string][]byte{
+		"a.txt":     nil,
+		"b.txt":     []byte("b2"),
+		"src/c.txt": []byte("c"),
+	}))
+
+	prev := &RepoMetadata{Tree: []*RepoFile{
+		{Name: "a.txt", Hash: "cid-a", Commit: "c1"},
+		{Name: "b.txt", Hash: "cid-b", Commit: "c1"},
+		{Name: "src/d.txt", Hash: "cid-d", Commit: "c1", Author: "bob", Size: 1},
+	}}
+
+	policy, err := NewContentPolicy(1, []string{"vendor"})
+	require.NoError(t, err)
+
+	meta := &RepoMetadata{}
+	require.NoError(t, meta.FillContentFrom(prev, prevTree, tree, policy))
+
+	files := make(map[string]*RepoFile)
+	for _, f := range meta.Tree {
+		files[f.Name] = f
+	}
+
+	require.Len(t, files, 4)
+	assert.NotContains(t, files, "a.txt")
+
+	assert.True(t, files["b.txt"].Changed)
+	assert.Equal(t, int64(2), files["b.txt"].Size)
+	assert.Equal(t, SkipTooLarge, files["b.txt"].Skipped)
+
+	assert.True(t, files["src/c.txt"].Changed)
+	assert.Equal(t, int64(1), files["src/c.txt"].Size)
+	assert.Equal(t, "0100644", files["src/c.txt"].Mode)
+	assert.False(t, files["src/c.txt"].Binary)
+	assert.Empty(t, files["src/c.txt"].Skipped)
+
+	assert.False(t, files["src/d.txt"].Changed)
+	assert.Equal(t, "cid-d", files["src/d.txt"].Hash)
+	assert.Equal(t, "bob", files["src/d.txt"].Author)
+	assert.Equal(t, int64(1), files["src/d.txt"].Size)
+
+	assert.True(t, files["data.bin"].Changed)
+	assert.Equal(t, SkipTooLarge, files["data.bin"].Skipped)
+}
+
+func TestRepoMetadata_FillContentBinary(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	require.NoError(t, err)
+
+	tree := commitTree(t, commitFiles(t, repo, time.Now(), map[string][]byte{
+		"data.bin":        {0, 1, 2},
+		"main.go":         []byte("package main"),
+		"vendor/lib.go":   []byte("package lib"),
+		"assets/logo.png": {0, 1},
+	}))
+
+	policy, err := NewContentPolicy(0, []string{"vendor", "*.png"})
+	require.NoError(t, err)
+
+	meta := &RepoMetadata{}
+	require.NoError(t, meta.FillContent(tree, policy))
+
+	files := make(map[string]*RepoFile)
+	for _, f := range meta.Tree {
+		files[f.Name] = f
+	}
+
+	require.Len(t, files, 4)
+	assert.True(t, files["data.bin"].Binary)
+	assert.False(t, files["main.go"].Binary)
+	assert.Equal(t, SkipExcluded, files["vendor/lib.go"].Skipped)
+	assert.Equal(t, SkipExcluded, files["assets/logo.png"].Skipped)
+	assert.Empty(t, files["main.go"].Skipped)
+}
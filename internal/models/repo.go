@@ -4,7 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"strconv"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -13,8 +13,10 @@ import (
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage"
 	"github.com/go-git/go-git/v5/storage/filesystem"
 	"github.com/spf13/viper"
 )
@@ -47,84 +49,160 @@ type Repo struct {
 	Repocore *git.Repository
 }
 
-// NewRepo creates a new Repo instance.
-func NewRepo(name, description, basePath, forkFrom string, id int, owner common.Address, fs billy.Filesystem) (*Repo, error) {
-	repo := &Repo{
+// OpenStorage opens the storage of the repository
+// stored on the given filesystem.
+type OpenStorage func(fs billy.Filesystem, objects cache.Object) (storage.Storer, error)
+
+// FilesystemStorage opens the storage of the repository
+// stored as files the way git stores bare repositories.
+func FilesystemStorage(fs billy.Filesystem, objects cache.Object) (storage.Storer, error) {
+	return filesystem.NewStorage(fs, objects), nil
+}
+
+// NewRepo creates a new Repo instance. Repository storage
+// is created or opened later by InitRepo.
+func NewRepo(name, description, basePath, forkFrom string, id int, owner common.Address) (*Repo, error) {
+	if err := ValidateRepoName(name); err != nil {
+		return nil, err
+	}
+
+	return &Repo{
 		Name:        name,
 		Description: description,
 		BasePath:    basePath,
 		ID:          id,
 		Owner:       owner,
 		ForkFrom:    forkFrom,
-	}
-
-	if err := repo.InitRepo(fs); err != nil {
-		return nil, fmt.Errorf("failed to init Repocore: %w", err)
-	}
+	}, nil
+}
 
-	return repo, nil
+// StoragePath returns the repository directory relative to the base path.
+// Repositories are stored by their on-chain ID, so names chosen by users
+// never end up in filesystem paths.
+func (r *Repo) StoragePath() string {
+	return strconv.Itoa(r.ID)
 }
 
 // FullPath returns the full path to the repository directory.
 func (r *Repo) FullPath() string {
-	return fmt.Sprintf("%s/%s/", r.BasePath, r.Name)
+	return fmt.Sprintf("%s/%s/", r.BasePath, r.StoragePath())
+}
+
+// FullName returns the owner namespaced repository name.
+func (r *Repo) FullName() string {
+	return fmt.Sprintf("%s/%s", r.Owner.Hex(), r.Name)
+}
+
+// URLPath returns the owner namespaced repository path
+// used in git remote URLs.
+func (r *Repo) URLPath() string {
+	return fmt.Sprintf("%s/%s.git", r.Owner.Hex(), r.Name)
 }
 
 // InitRepo initializes the repository
 // by creating the filesystem, server, and endpoint.
-func (r *Repo) InitRepo(fs billy.Filesystem) (err error) {
-	r.fileSystem, err = fs.Chroot(r.Name)
+// The storage is opened on the filesystem with open,
+// given objects cache is shared with it.
+func (r *Repo) InitRepo(fs billy.Filesystem, objects cache.Object, open OpenStorage) (err error) {
+	if r.ID < 0 {
+		return fmt.Errorf("invalid repository ID %d", r.ID)
+	}
+
+	r.fileSystem, err = fs.Chroot(r.StoragePath())
 	if err != nil {
 		return fmt.Errorf("init chroot filesystem: %w", err)
 	}
 
-	if err := r.initFileSystem(); err != nil {
+	if err := r.initFileSystem(objects, open); err != nil {
 		return fmt.Errorf("failed to init Repocore filesystem: %w", err)
 	}
 
-	r.initServer()
-
 	if err := r.initEndpoint(); err != nil {
 		return fmt.Errorf("failed to init Repocore endpoint: %w", err)
 	}
 
+	r.initServer()
+
+	return nil
+}
+
+// ShareCore makes r use the already initialized repository
+// storage, server and endpoint of the given repo.
+func (r *Repo) ShareCore(from *Repo) {
+	r.fileSystem = from.fileSystem
+	r.server = from.server
+	r.endpoint = from.endpoint
+	r.Repocore = from.Repocore
+}
+
+// Close releases resources held by the repository storage.
+func (r *Repo) Close() error {
+	if r.Repocore == nil {
+		return nil
+	}
+
+	if c, ok := r.Repocore.Storer.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// WarmUp loads repository packfile indexes. Storage loads them lazily and
+// not thread safe, so it should be called after opening the repository and
+// after each write, before the repository is read concurrently.
+func (r *Repo) WarmUp() error {
+	if _, err := r.Repocore.Storer.EncodedObject(plumbing.AnyObject, plumbing.ZeroHash); err != nil && !errors.Is(err, plumbing.ErrObjectNotFound) {
+		return fmt.Errorf("failed to load repository indexes: %w", err)
+	}
+
 	return nil
 }
 
 // initFileSystem initializes the file system for the repository.
-// If the file system does not already exist, a new repository is created.
-func (r *Repo) initFileSystem() (err error) {
-	if !r.isRepoFSExists() {
-		if r.ForkFrom != "" {
-			r.Repocore, err = git.Clone(filesystem.NewStorage(r.fileSystem, cache.NewObjectLRU(500)), nil, &git.CloneOptions{
-				URL:               r.ForkFrom,
-				RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
-			})
-			if err != nil {
-				return fmt.Errorf("failed to clone Repocore on fs: %w", err)
-			}
-		} else {
-			r.Repocore, err = git.Init(filesystem.NewStorage(r.fileSystem, cache.NewObjectLRU(500)), nil)
-			if err != nil {
-				if errors.Is(err, git.ErrRepositoryAlreadyExists) {
-					return nil
-				}
-				return fmt.Errorf("failed to create new Repocore on fs: %w", err)
-			}
-		}
-	} else {
-		r.Repocore, err = git.Open(filesystem.NewStorage(r.fileSystem, cache.NewObjectLRU(500)), nil)
+// If the repository does not already exist, a new repository is created.
+func (r *Repo) initFileSystem(objects cache.Object, open OpenStorage) (err error) {
+	storage, err := open(r.fileSystem, objects)
+	if err != nil {
+		return fmt.Errorf("failed to open Repocore storage: %w", err)
+	}
+
+	exists, err := isInitialized(storage)
+	if err != nil {
+		return fmt.Errorf("failed to check Repocore storage: %w", err)
+	}
+
+	if exists {
+		r.Repocore, err = git.Open(storage, nil)
 		if err != nil {
 			return fmt.Errorf("failed to open Repocore on fs: %w", err)
 		}
+		return nil
+	}
+
+	if r.ForkFrom != "" {
+		r.Repocore, err = git.Clone(storage, nil, &git.CloneOptions{
+			URL:               r.ForkFrom,
+			RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to clone Repocore on fs: %w", err)
+		}
+		return nil
+	}
+
+	r.Repocore, err = git.Init(storage, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create new Repocore on fs: %w", err)
 	}
 
 	return nil
 }
 
 // initServer initializes the server for the repository.
+// Server sessions reuse the already opened repository storage.
 func (r *Repo) initServer() {
-	r.server = server.NewServer(server.NewFilesystemLoader(r.fileSystem))
+	r.server = server.NewServer(server.MapLoader{r.endpoint.String(): r.Repocore.Storer})
 }
 
 // initEndpoint initializes the endpoint for the repository.
@@ -162,28 +240,54 @@ func (r *Repo) NewSessionFromType(sessionType GitSessionType) (transport.Session
 	}
 }
 
-// isRepoFSExists checks if the filesystem for the repository exists.
-// Returns true if the filesystem exists, false otherwise.
-func (r *Repo) isRepoFSExists() bool {
-	if _, err := r.fileSystem.Stat(r.FullPath()); os.IsNotExist(err) {
-		return false
+// RepoExists checks whether the repository is initialized in the directory
+// of the filesystem. The storage is opened with open, so the repository
+// stored the way the storage doesn't read isn't found.
+func RepoExists(fs billy.Filesystem, dir string, open OpenStorage) (bool, error) {
+	chroot, err := fs.Chroot(dir)
+	if err != nil {
+		return false, fmt.Errorf("failed to open %s: %w", dir, err)
 	}
-	return true
-}
 
-func (r *Repo) Head() (p *plumbing.Reference, err error) {
-	r.Repocore, err = git.Open(filesystem.NewStorage(r.fileSystem, cache.NewObjectLRU(500)), r.fileSystem)
+	storage, err := open(chroot, cache.NewObjectLRUDefault())
 	if err != nil {
-		return nil, fmt.Errorf("failed to open Repocore on fs: %w", err)
+		return false, fmt.Errorf("failed to open %s storage: %w", dir, err)
+	}
+
+	if c, ok := storage.(io.Closer); ok {
+		defer c.Close()
 	}
 
-	// FIXME this way doesn't work ¯\_(ツ)_/¯
-	/*ref, err := r.Repocore.Head()
+	return isInitialized(storage)
+}
+
+// isInitialized checks if the storage has the repository,
+// i.e. its HEAD is set.
+func isInitialized(s storer.ReferenceStorer) (bool, error) {
+	_, err := s.Reference(plumbing.HEAD)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return false, nil
+	}
 	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Head returns the reference HEAD points to. Newly created repositories
+// point HEAD to "master", while clients usually push "main", so "main"
+// is used when the HEAD target doesn't exist.
+func (r *Repo) Head() (*plumbing.Reference, error) {
+	ref, err := r.Repocore.Head()
+	if err == nil {
+		return ref, nil
+	}
+
+	if !errors.Is(err, plumbing.ErrReferenceNotFound) {
 		return nil, fmt.Errorf("failed to get repository head: %w", err)
-	}*/
+	}
 
-	ref, err := r.Repocore.Reference(plumbing.NewBranchReferenceName("main"), false)
+	ref, err = r.Repocore.Reference(plumbing.NewBranchReferenceName("main"), true)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get repository head: %w", err)
 	}
@@ -191,6 +295,46 @@ func (r *Repo) Head() (p *plumbing.Reference, err error) {
 	return ref, nil
 }
 
+// InitHead points HEAD to the first of the given branches which exists if
+// the branch HEAD points to doesn't, so the first pushed branch becomes the
+// default one whatever it's named.
+func (r *Repo) InitHead(branches []plumbing.ReferenceName) error {
+	head, err := r.Repocore.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return fmt.Errorf("failed to get repository head: %w", err)
+	}
+
+	if head.Type() != plumbing.SymbolicReference {
+		return nil
+	}
+
+	_, err = r.Repocore.Storer.Reference(head.Target())
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return fmt.Errorf("failed to get repository head %s: %w", head.Target(), err)
+	}
+
+	for _, name := range branches {
+		if !name.IsBranch() {
+			continue
+		}
+
+		if _, err := r.Repocore.Storer.Reference(name); err != nil {
+			continue
+		}
+
+		if err := r.Repocore.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, name)); err != nil {
+			return fmt.Errorf("failed to point repository head to %s: %w", name, err)
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
 func (r *Repo) Commit(hash plumbing.Hash) (*object.Commit, error) {
 	commit, err := r.Repocore.CommitObject(hash)
 	if err != nil {
@@ -218,7 +362,7 @@ func (r *Repo) GenMeta() (*RepoMetadata, error) {
 	meta := &RepoMetadata{
 		Name:         r.Name,
 		Description:  r.Description,
-		ExternalUrl:  viper.GetString("baseurl") + r.Name,
+		ExternalUrl:  viper.GetString("baseurl") + r.URLPath(),
 		Tree:         []*RepoFile{},
 		Commit:       "repository created",
 		Timestamp:    time.Now().Unix(),
@@ -233,35 +377,12 @@ func (r *Repo) GenMeta() (*RepoMetadata, error) {
 		return nil, fmt.Errorf("failed to retrieve last commit: %w", err)
 	}
 
-	commitsCount, err := r.CommitsCount()
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve commits count: %w", err)
-	}
-
-	meta.CommitsCount = commitsCount
 	meta.Timestamp = commit.Author.When.Unix()
 	meta.Commit = commit.Hash.String()
 
 	return meta, nil
 }
 
-func (r *Repo) CommitsCount() (int, error) {
-	cIter, err := r.Repocore.Log(&git.LogOptions{All: true})
-	if err != nil {
-		return 0, fmt.Errorf("failed to retrieve repository logs: %w", err)
-	}
-
-	var cCount int
-	if err := cIter.ForEach(func(c *object.Commit) error {
-		cCount++
-		return nil
-	}); err != nil {
-		return 0, fmt.Errorf("failed to iter over repository commits: %w", err)
-	}
-
-	return cCount, nil
-}
-
 func (r *Repo) LastCommit() (*object.Commit, error) {
 	logs, err := r.Repocore.Log(&git.LogOptions{All: true})
 	if err != nil {
@@ -270,12 +391,3 @@ func (r *Repo) LastCommit() (*object.Commit, error) {
 
 	return logs.Next()
 }
-
-func (r *Repo) FileLastCommit(fileName string) (*object.Commit, error) {
-	logs, err := r.Repocore.Log(&git.LogOptions{FileName: &fileName, All: true})
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve logs for file %s: %w", fileName, err)
-	}
-
-	return logs.Next()
-}
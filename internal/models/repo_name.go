@@ -0,0 +1,45 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxRepoNameLength is the maximum allowed length of a repository name
+const maxRepoNameLength = 100
+
+// repoNamePattern is the set of characters allowed in a repository name
+var repoNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ErrInvalidRepoName is returned when a repository name
+// doesn't pass validation
+var ErrInvalidRepoName = errors.New("invalid repository name")
+
+// ValidateRepoName checks that the given repository name is safe to be
+// used in URLs and can't escape the repositories base path.
+// Allowed names start with a letter or a digit, contain only letters,
+// digits, dots, dashes and underscores and don't end with ".git".
+func ValidateRepoName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%w: empty name", ErrInvalidRepoName)
+	case len(name) > maxRepoNameLength:
+		return fmt.Errorf("%w: %q is longer than %d characters", ErrInvalidRepoName, name, maxRepoNameLength)
+	case !repoNamePattern.MatchString(name):
+		return fmt.Errorf("%w: %q contains forbidden characters", ErrInvalidRepoName, name)
+	case strings.Contains(name, ".."):
+		return fmt.Errorf("%w: %q contains \"..\"", ErrInvalidRepoName, name)
+	case strings.HasSuffix(strings.ToLower(name), ".git"):
+		return fmt.Errorf("%w: %q ends with \".git\"", ErrInvalidRepoName, name)
+	}
+
+	return nil
+}
+
+// TrimGitSuffix returns the repository name from the given URL path
+// segment without the optional ".git" suffix
+func TrimGitSuffix(segment string) string {
+	return strings.TrimSuffix(segment, ".git")
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRepoName(t *testing.T) {
+	testCases := []struct {
+		name      string
+		input     string
+		expectErr bool
+	}{
+		{name: "simple", input: "api", expectErr: false},
+		{name: "with allowed symbols", input: "gitsec-backend_v1.2", expectErr: false},
+		{name: "empty", input: "", expectErr: true},
+		{name: "parent directory", input: "..", expectErr: true},
+		{name: "current directory", input: ".", expectErr: true},
+		{name: "hidden", input: ".repos", expectErr: true},
+		{name: "double dots", input: "a..b", expectErr: true},
+		{name: "slash", input: "owner/api", expectErr: true},
+		{name: "path escape", input: "../../etc", expectErr: true},
+		{name: "backslash", input: `a\b`, expectErr: true},
+		{name: "git suffix", input: "api.git", expectErr: true},
+		{name: "space", input: "my repo", expectErr: true},
+		{name: "too long", input: string(make([]byte, maxRepoNameLength+1)), expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateRepoName(tc.input)
+			if tc.expectErr {
+				assert.ErrorIs(t, err, ErrInvalidRepoName)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepo_InitHead(t *testing.T) {
+	core, err := git.Init(memory.NewStorage(), memfs.New())
+	require.NoError(t, err)
+
+	repo := &Repo{Name: "api", Repocore: core}
+
+	commit := commitFiles(t, core, time.Now(), map[string][]byte{"a": []byte("1")})
+	require.NoError(t, core.Storer.RemoveReference(plumbing.Master))
+	require.NoError(t, core.Storer.SetReference(plumbing.NewHashReference("refs/heads/trunk", commit.Hash)))
+	require.NoError(t, core.Storer.SetReference(plumbing.NewHashReference("refs/tags/v1", commit.Hash)))
+
+	// HEAD points to the first pushed branch instead of the missing master
+	require.NoError(t, repo.InitHead([]plumbing.ReferenceName{"refs/tags/v1", "refs/heads/missing", "refs/heads/trunk"}))
+
+	head, err := core.Storer.Reference(plumbing.HEAD)
+	require.NoError(t, err)
+	assert.Equal(t, plumbing.ReferenceName("refs/heads/trunk"), head.Target())
+
+	// HEAD pointing to the existing branch isn't changed
+	require.NoError(t, core.Storer.SetReference(plumbing.NewHashReference("refs/heads/feature", commit.Hash)))
+	require.NoError(t, repo.InitHead([]plumbing.ReferenceName{"refs/heads/feature"}))
+
+	head, err = core.Storer.Reference(plumbing.HEAD)
+	require.NoError(t, err)
+	assert.Equal(t, plumbing.ReferenceName("refs/heads/trunk"), head.Target())
+}
@@ -0,0 +1,247 @@
+package repository
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/misnaged/annales/logger"
+
+	"gitsec-backend/internal/models"
+)
+
+// Handles caches opened repositories, so storage, packfile indexes and
+// transport server are reused between requests instead of being reopened
+// every time. All repositories share one objects cache.
+//
+// Every handle carries a read-write lock: writers (receive-pack and
+// metadata update) are serialised, readers run concurrently.
+type Handles struct {
+	// fs is the base filesystem repositories are stored on
+	fs billy.Filesystem
+	// open opens storages of repositories
+	open models.OpenStorage
+	// objects is the objects cache shared between repositories
+	objects cache.Object
+	// idle is the time after which unused handle is evicted
+	idle time.Duration
+
+	mu      sync.Mutex
+	handles map[int]*handle
+	// resets are repositories which storage is being replaced,
+	// the channel is closed once it's replaced
+	resets map[int]chan struct{}
+}
+
+// handle is an opened repository
+type handle struct {
+	// repo is the opened repository
+	repo *models.Repo
+	// err is the repository opening error
+	err error
+	// open guards repository opening
+	open sync.Once
+	// lock serialises writers to the repository
+	lock sync.RWMutex
+	// refs is the number of not released acquisitions
+	refs int
+	// lastUsed is the time the handle was released last time
+	lastUsed time.Time
+	// stale is set once the repository storage is replaced,
+	// the repository is reopened then
+	stale bool
+}
+
+// NewHandles creates new Handles for repositories stored on the given
+// filesystem with shared objects cache of the given size. Repository
+// storages are opened with open.
+func NewHandles(fs billy.Filesystem, open models.OpenStorage, objectsCacheSize cache.FileSize, idle time.Duration) *Handles {
+	return &Handles{
+		fs:      fs,
+		open:    open,
+		objects: cache.NewObjectLRU(objectsCacheSize),
+		idle:    idle,
+		handles: make(map[int]*handle),
+		resets:  make(map[int]chan struct{}),
+	}
+}
+
+// Acquire opens the given repository or takes it from the cache and locks it
+// for reading or writing. Repository is created on the filesystem if it
+// doesn't exist yet. Returned release function must be called once the
+// caller is done with the repository.
+func (h *Handles) Acquire(repo *models.Repo, write bool) (release func(), err error) {
+	h.mu.Lock()
+	for {
+		done, ok := h.resets[repo.ID]
+		if !ok {
+			break
+		}
+
+		h.mu.Unlock()
+		<-done
+		h.mu.Lock()
+	}
+
+	hd, ok := h.handles[repo.ID]
+	if !ok {
+		hd = &handle{}
+		h.handles[repo.ID] = hd
+	}
+	hd.refs++
+	h.mu.Unlock()
+
+	hd.open.Do(func() {
+		opened := *repo
+		if hd.err = opened.InitRepo(h.fs, h.objects, h.open); hd.err != nil {
+			return
+		}
+		hd.err = opened.WarmUp()
+		hd.repo = &opened
+	})
+
+	if hd.err != nil {
+		h.release(repo.ID, hd, true)
+		return nil, fmt.Errorf("failed to open repository %d: %w", repo.ID, hd.err)
+	}
+
+	if write {
+		hd.lock.Lock()
+	} else {
+		hd.lock.RLock()
+	}
+
+	// storage is replaced while waiting for the lock
+	if hd.stale {
+		if write {
+			hd.lock.Unlock()
+		} else {
+			hd.lock.RUnlock()
+		}
+		h.release(repo.ID, hd, false)
+
+		return h.Acquire(repo, write)
+	}
+
+	repo.ShareCore(hd.repo)
+
+	return func() {
+		if write {
+			if err := hd.repo.WarmUp(); err != nil {
+				logger.Log().Errorf("repository %d: %s", repo.ID, err)
+			}
+			hd.lock.Unlock()
+		} else {
+			hd.lock.RUnlock()
+		}
+		h.release(repo.ID, hd, false)
+	}, nil
+}
+
+// Reset runs replace once nobody uses the repository, so its storage can be
+// replaced, and drops the cached handle, so the repository is reopened from
+// the new storage. Acquisitions wait for the replacement to complete.
+func (h *Handles) Reset(id int, replace func() error) error {
+	done := make(chan struct{})
+
+	h.mu.Lock()
+	for {
+		pending, ok := h.resets[id]
+		if !ok {
+			break
+		}
+
+		h.mu.Unlock()
+		<-pending
+		h.mu.Lock()
+	}
+
+	h.resets[id] = done
+	hd := h.handles[id]
+	delete(h.handles, id)
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		delete(h.resets, id)
+		h.mu.Unlock()
+
+		close(done)
+	}()
+
+	if hd != nil {
+		// waits for the current users and the opening in progress
+		hd.lock.Lock()
+		hd.stale = true
+		hd.lock.Unlock()
+		hd.open.Do(func() {})
+
+		if hd.repo != nil {
+			if err := hd.repo.Close(); err != nil {
+				logger.Log().Errorf("failed to close repository %d: %s", id, err)
+			}
+		}
+	}
+
+	return replace()
+}
+
+// release decrements handle references and drops
+// failed handle, so the next acquisition retries opening.
+func (h *Handles) release(id int, hd *handle, failed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hd.refs--
+	hd.lastUsed = time.Now()
+
+	if failed && hd.refs == 0 && h.handles[id] == hd {
+		delete(h.handles, id)
+	}
+}
+
+// Run evicts idle handles until stop channel is closed.
+func (h *Handles) Run(stop <-chan struct{}) {
+	if h.idle <= 0 {
+		<-stop
+		h.evict(0)
+		return
+	}
+
+	ticker := time.NewTicker(h.idle / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			h.evict(0)
+			return
+		case <-ticker.C:
+			h.evict(h.idle)
+		}
+	}
+}
+
+// evict closes not used handles idle for longer than the given duration.
+func (h *Handles) evict(idle time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for id, hd := range h.handles {
+		if hd.refs != 0 || time.Since(hd.lastUsed) < idle {
+			continue
+		}
+
+		delete(h.handles, id)
+
+		if hd.repo == nil {
+			continue
+		}
+
+		if err := hd.repo.Close(); err != nil {
+			logger.Log().Errorf("failed to close repository %d: %s", id, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/internal/models"
+	"gitsec-backend/pkg/gitstore"
+)
+
+func TestHandles_Acquire(t *testing.T) {
+	h := NewHandles(memfs.New(), models.FilesystemStorage, cache.MiByte, time.Minute)
+
+	first := &models.Repo{ID: 1, Name: "api"}
+	releaseFirst, err := h.Acquire(first, false)
+	require.NoError(t, err)
+
+	second := &models.Repo{ID: 1, Name: "api"}
+	releaseSecond, err := h.Acquire(second, false)
+	require.NoError(t, err)
+
+	assert.Same(t, first.Repocore, second.Repocore, "readers must share opened repository")
+
+	acquired := make(chan struct{})
+	go func() {
+		release, err := h.Acquire(&models.Repo{ID: 1, Name: "api"}, true)
+		assert.NoError(t, err)
+		release()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("writer acquired repository locked by readers")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	releaseFirst()
+	releaseSecond()
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("writer didn't acquire released repository")
+	}
+
+	h.evict(0)
+	assert.Empty(t, h.handles)
+
+	third := &models.Repo{ID: 1, Name: "api"}
+	release, err := h.Acquire(third, false)
+	require.NoError(t, err)
+	defer release()
+
+	assert.NotSame(t, first.Repocore, third.Repocore, "evicted repository must be reopened")
+}
+
+func TestHandles_Reset(t *testing.T) {
+	h := NewHandles(memfs.New(), models.FilesystemStorage, cache.MiByte, time.Minute)
+
+	first := &models.Repo{ID: 1, Name: "api"}
+	release, err := h.Acquire(first, false)
+	require.NoError(t, err)
+
+	replaced := make(chan struct{})
+	reset := make(chan error, 1)
+	go func() {
+		reset <- h.Reset(1, func() error {
+			close(replaced)
+			return nil
+		})
+	}()
+
+	select {
+	case <-replaced:
+		t.Fatal("storage replaced while repository is used")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	require.NoError(t, <-reset)
+
+	second := &models.Repo{ID: 1, Name: "api"}
+	release, err = h.Acquire(second, false)
+	require.NoError(t, err)
+	defer release()
+
+	assert.NotSame(t, first.Repocore, second.Repocore, "repository must be reopened once its storage is replaced")
+}
+
+func TestHandles_BlocksStorage(t *testing.T) {
+	blocks := gitstore.NewFSBlockstore(memfs.New())
+	open := func(fs billy.Filesystem, objects cache.Object) (storage.Storer, error) {
+		return gitstore.NewStorage(blocks, fs, objects)
+	}
+
+	fs := memfs.New()
+	h := NewHandles(fs, open, cache.MiByte, time.Minute)
+
+	repo := &models.Repo{ID: 1, Name: "api"}
+	release, err := h.Acquire(repo, true)
+	require.NoError(t, err)
+
+	head, err := repo.Repocore.Storer.Reference(plumbing.HEAD)
+	require.NoError(t, err)
+	release()
+
+	// the initialized repository is opened from its index, not created again
+	_, err = fs.Stat("1/" + gitstore.IndexFile)
+	require.NoError(t, err)
+	_, err = fs.Stat("1/HEAD")
+	assert.True(t, os.IsNotExist(err), "refs aren't stored as files")
+
+	// the repository is only found by the storer it's stored with
+	exists, err := models.RepoExists(fs, "1", open)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = models.RepoExists(fs, "1", models.FilesystemStorage)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	h.evict(0)
+
+	reopened := &models.Repo{ID: 1, Name: "api"}
+	release, err = h.Acquire(reopened, false)
+	require.NoError(t, err)
+	defer release()
+
+	assert.IsType(t, &gitstore.Storage{}, reopened.Repocore.Storer)
+
+	ref, err := reopened.Repocore.Storer.Reference(plumbing.HEAD)
+	require.NoError(t, err)
+	assert.Equal(t, head, ref)
+}
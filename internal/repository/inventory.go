@@ -0,0 +1,202 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+// inventoryDir is the directory inventories are stored in
+const inventoryDir = "pins"
+
+// Version is a published repository metadata version with CIDs pinned for it.
+type Version struct {
+	// Metadata is the metadata CID
+	Metadata string `json:"metadata"`
+	// Commit is the published commit, empty for the created repository
+	Commit string `json:"commit,omitempty"`
+	// Pins are CIDs pinned for the version, including the metadata
+	Pins []string `json:"pins"`
+	// Anchored is set once the metadata CID is sent to the contract
+	Anchored bool `json:"anchored"`
+	// Published is the time the version was published at
+	Published time.Time `json:"published"`
+}
+
+// Inventory keeps published versions of repositories and pins which belong
+// to them, so pins of superseded versions can be removed. Inventory of
+// every repository is stored as a JSON file on the base filesystem.
+type Inventory struct {
+	fs billy.Filesystem
+
+	mu sync.Mutex
+}
+
+// NewInventory creates a new Inventory stored on the given filesystem.
+func NewInventory(fs billy.Filesystem) *Inventory {
+	return &Inventory{fs: fs}
+}
+
+// Versions returns published versions of the repository, oldest first.
+func (i *Inventory) Versions(id int) ([]Version, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.load(id)
+}
+
+// Add records the published version of the repository.
+func (i *Inventory) Add(id int, version Version) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	versions, err := i.load(id)
+	if err != nil {
+		return err
+	}
+
+	return i.save(id, append(versions, version))
+}
+
+// Anchor marks the version with the given metadata CID as anchored.
+func (i *Inventory) Anchor(id int, metadata string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	versions, err := i.load(id)
+	if err != nil {
+		return err
+	}
+
+	for n := range versions {
+		if versions[n].Metadata == metadata {
+			versions[n].Anchored = true
+		}
+	}
+
+	return i.save(id, versions)
+}
+
+// Remove removes unpinned CIDs from the repository versions. Versions
+// left without pins are removed.
+func (i *Inventory) Remove(id int, unpinned []string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	versions, err := i.load(id)
+	if err != nil {
+		return err
+	}
+
+	removed := make(map[string]bool, len(unpinned))
+	for _, hash := range unpinned {
+		removed[hash] = true
+	}
+
+	res := versions[:0]
+	for _, v := range versions {
+		pins := v.Pins[:0]
+		for _, hash := range v.Pins {
+			if !removed[hash] {
+				pins = append(pins, hash)
+			}
+		}
+		v.Pins = pins
+
+		if len(v.Pins) != 0 {
+			res = append(res, v)
+		}
+	}
+
+	return i.save(id, res)
+}
+
+// Retain returns CIDs pinned only by versions which aren't retained. The last
+// keep anchored versions and versions with referenced metadata are retained.
+func Retain(versions []Version, keep int, referenced map[string]bool) []string {
+	retained := make(map[string]bool)
+
+	anchored := 0
+	for n := len(versions) - 1; n >= 0; n-- {
+		v := versions[n]
+
+		if v.Anchored && anchored < keep {
+			anchored++
+		} else if !referenced[v.Metadata] {
+			continue
+		}
+
+		for _, hash := range v.Pins {
+			retained[hash] = true
+		}
+	}
+
+	var res []string
+	for _, v := range versions {
+		for _, hash := range v.Pins {
+			if !retained[hash] {
+				retained[hash] = true
+				res = append(res, hash)
+			}
+		}
+	}
+	return res
+}
+
+// load reads versions of the repository
+func (i *Inventory) load(id int) ([]Version, error) {
+	f, err := i.fs.Open(inventoryPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open repository %d inventory: %w", id, err)
+	}
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("read repository %d inventory: %w", id, err)
+	}
+
+	var versions []Version
+	if err := json.Unmarshal(content, &versions); err != nil {
+		return nil, fmt.Errorf("decode repository %d inventory: %w", id, err)
+	}
+
+	return versions, nil
+}
+
+// save writes versions of the repository, replacing the previous
+// inventory only once the new one is written
+func (i *Inventory) save(id int, versions []Version) error {
+	content, err := json.Marshal(versions)
+	if err != nil {
+		return fmt.Errorf("encode repository %d inventory: %w", id, err)
+	}
+
+	name := inventoryPath(id)
+	if err := util.WriteFile(i.fs, name+".tmp", content, 0o644); err != nil {
+		return fmt.Errorf("write repository %d inventory: %w", id, err)
+	}
+
+	if err := i.fs.Rename(name+".tmp", name); err != nil {
+		return fmt.Errorf("replace repository %d inventory: %w", id, err)
+	}
+
+	return nil
+}
+
+// inventoryPath returns the inventory file of the repository
+func inventoryPath(id int) string {
+	return path.Join(inventoryDir, strconv.Itoa(id)+".json")
+}
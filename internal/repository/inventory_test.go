@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInventory(t *testing.T) {
+	inv := NewInventory(memfs.New())
+
+	versions, err := inv.Versions(1)
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+
+	require.NoError(t, inv.Add(1, Version{Metadata: "meta-1", Pins: []string{"meta-1"}}))
+	require.NoError(t, inv.Add(1, Version{Metadata: "meta-2", Commit: "c2", Pins: []string{"meta-2", "root-2", "commit-2"}}))
+	require.NoError(t, inv.Add(2, Version{Metadata: "other", Pins: []string{"other"}}))
+	require.NoError(t, inv.Anchor(1, "meta-2"))
+
+	require.NoError(t, inv.Remove(1, []string{"meta-1", "root-2"}))
+
+	versions, err = inv.Versions(1)
+	require.NoError(t, err)
+	assert.Equal(t, []Version{{Metadata: "meta-2", Commit: "c2", Pins: []string{"meta-2", "commit-2"}, Anchored: true}}, versions)
+
+	versions, err = inv.Versions(2)
+	require.NoError(t, err)
+	assert.Len(t, versions, 1)
+}
+
+func TestRetain(t *testing.T) {
+	versions := []Version{
+		{Metadata: "meta-1", Pins: []string{"meta-1"}, Anchored: true},
+		{Metadata: "meta-2", Pins: []string{"meta-2", "root-a", "commit-2"}, Anchored: true},
+		{Metadata: "meta-3", Pins: []string{"meta-3", "root-b", "commit-3"}, Anchored: true},
+		{Metadata: "meta-4", Pins: []string{"meta-4", "root-b", "commit-4"}},
+		{Metadata: "meta-5", Pins: []string{"meta-5", "root-c", "commit-5"}, Anchored: true},
+		{Metadata: "meta-6", Pins: []string{"meta-6", "root-a", "commit-6"}, Anchored: true},
+	}
+
+	unpin := Retain(versions, 2, map[string]bool{"meta-3": true})
+	assert.Equal(t, []string{"meta-1", "meta-2", "commit-2", "meta-4", "commit-4"}, unpin)
+
+	// versions never anchored aren't retained
+	assert.Equal(t, []string{"meta-4", "commit-4"}, Retain(versions, 10, nil))
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/misnaged/annales/logger"
+
+	"gitsec-backend/internal/models"
+	"gitsec-backend/pkg/multierr"
+)
+
+// MigrateLayout moves repositories stored in directories named after them,
+// the layout used before repositories were stored by their on-chain ID, to
+// their ID directories. Directory of a name belongs to the repository with
+// the lowest ID, the one the name resolves to. Repositories are moved once,
+// the ones already stored by ID are left untouched. It returns the number
+// of repositories moved.
+func MigrateLayout(fs billy.Filesystem, repos []*models.Repo) (int, error) {
+	owners := make(map[string]*models.Repo)
+	for _, repo := range repos {
+		if owner, ok := owners[repo.Name]; !ok || repo.ID < owner.ID {
+			owners[repo.Name] = repo
+		}
+	}
+
+	var (
+		errs  []error
+		moved int
+	)
+
+	for name, repo := range owners {
+		// numeric names can't be told apart from ID directories
+		if _, err := strconv.Atoi(name); err == nil {
+			continue
+		}
+
+		legacy, err := isGitDir(fs, name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !legacy {
+			continue
+		}
+
+		if _, err := fs.Stat(repo.StoragePath()); err == nil {
+			errs = append(errs, fmt.Errorf("repository %s ID %d is stored both in %s and %s, move one of them aside", repo.Name, repo.ID, name, repo.StoragePath()))
+			continue
+		} else if !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("failed to stat %s: %w", repo.StoragePath(), err))
+			continue
+		}
+
+		if err := fs.Rename(name, repo.StoragePath()); err != nil {
+			errs = append(errs, fmt.Errorf("failed to move repository %s to %s: %w", name, repo.StoragePath(), err))
+			continue
+		}
+
+		logger.Log().Infof("repository %s ID %d moved to %s", repo.Name, repo.ID, repo.StoragePath())
+		moved++
+	}
+
+	return moved, multierr.Join(errs...)
+}
+
+// isGitDir checks whether the directory holds a git repository
+func isGitDir(fs billy.Filesystem, dir string) (bool, error) {
+	info, err := fs.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("failed to stat %s: %w", dir, err)
+	case !info.IsDir():
+		return false, nil
+	}
+
+	if _, err := fs.Stat(path.Join(dir, "HEAD")); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat %s: %w", path.Join(dir, "HEAD"), err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/internal/models"
+)
+
+func TestMigrateLayout(t *testing.T) {
+	fs := memfs.New()
+
+	for _, name := range []string{"api/HEAD", "both/HEAD", "4/HEAD", "7/HEAD", "pins/1.json"} {
+		require.NoError(t, util.WriteFile(fs, name, []byte("ref: refs/heads/master\n"), 0o644))
+	}
+
+	repos := []*models.Repo{
+		{ID: 3, Name: "api"},
+		// the name resolves to the lowest ID
+		{ID: 1, Name: "api"},
+		{ID: 2, Name: "pins"},
+		{ID: 4, Name: "both"},
+		{ID: 5, Name: "new"},
+		{ID: 6, Name: "7"},
+	}
+
+	moved, err := MigrateLayout(fs, repos)
+	assert.ErrorContains(t, err, "repository both ID 4 is stored both in both and 4")
+	assert.Equal(t, 1, moved)
+
+	_, err = fs.Stat("1/HEAD")
+	assert.NoError(t, err)
+	_, err = fs.Stat("api")
+	assert.Error(t, err)
+
+	// ID directory taken by another repository isn't overwritten
+	_, err = fs.Stat("both/HEAD")
+	assert.NoError(t, err)
+
+	// directories which aren't repositories are left as is
+	_, err = fs.Stat("pins/1.json")
+	assert.NoError(t, err)
+
+	// numeric names are left as is
+	_, err = fs.Stat("7/HEAD")
+	assert.NoError(t, err)
+
+	// migration is idempotent
+	require.NoError(t, util.RemoveAll(fs, "both"))
+	moved, err = MigrateLayout(fs, repos)
+	require.NoError(t, err)
+	assert.Equal(t, 0, moved)
+}
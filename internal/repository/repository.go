@@ -1,28 +1,74 @@
 package repository
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
 
 	"gitsec-backend/internal/models"
 )
 
+// ErrRepoNotFound is returned when the requested repository doesn't exist
+var ErrRepoNotFound = errors.New("repo doesn't exist")
+
+// ErrRepoExists is returned when the created repository already exists
+var ErrRepoExists = errors.New("repo already exists")
+
 type IRepository interface {
 	CreateRepo(repo *models.Repo) error
 
+	// GetRepo fills the given repo by its owner and name. If the owner
+	// is empty, repo is resolved by name only (legacy routes).
 	GetRepo(repo *models.Repo) error
+
+	// UpdateMetadata sets the latest published metadata hash of the repository
+	UpdateMetadata(id int, metadata string) error
 }
 
+// Repository is an in-memory repositories store. Repositories are keyed by
+// their on-chain ID, names are only indexes on top of it. When several
+// repositories share the same name, the one with the lowest ID wins, so
+// resolution doesn't depend on the order events were received in.
 type Repository struct {
-	repositories map[string]*models.Repo
+	mu sync.RWMutex
+
+	// repositories holds repositories by ID
+	repositories map[int]*models.Repo
+
+	// owned indexes repository IDs by owner and name
+	owned map[ownedKey][]int
+
+	// named indexes repository IDs by name only
+	named map[string][]int
+}
+
+// ownedKey is the key of owner namespaced repository index
+type ownedKey struct {
+	owner common.Address
+	name  string
 }
 
 func NewRepository() IRepository {
-	return &Repository{repositories: make(map[string]*models.Repo)}
+	return &Repository{
+		repositories: make(map[int]*models.Repo),
+		owned:        make(map[ownedKey][]int),
+		named:        make(map[string][]int),
+	}
 }
 
 func (r *Repository) CreateRepo(repo *models.Repo) error {
-	if _, ok := r.repositories[repo.Name]; ok {
-		return fmt.Errorf("repo already exist")
+	if err := models.ValidateRepoName(repo.Name); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.repositories[repo.ID]; ok {
+		return fmt.Errorf("%w: ID %d", ErrRepoExists, repo.ID)
 	}
 
 	newRepo := &models.Repo{
@@ -32,23 +78,64 @@ func (r *Repository) CreateRepo(repo *models.Repo) error {
 		ID:          repo.ID,
 		Owner:       repo.Owner,
 		Metadata:    repo.Metadata,
+		ForkFrom:    repo.ForkFrom,
 		Repocore:    repo.Repocore,
 	}
 
-	r.repositories[repo.Name] = newRepo
+	r.repositories[repo.ID] = newRepo
+
+	key := ownedKey{owner: repo.Owner, name: repo.Name}
+	r.owned[key] = insertID(r.owned[key], repo.ID)
+	r.named[repo.Name] = insertID(r.named[repo.Name], repo.ID)
+
 	return nil
 }
 
 func (r *Repository) GetRepo(repo *models.Repo) error {
-	re, ok := r.repositories[repo.Name]
-	if !ok {
-		return fmt.Errorf("repo doesn't exist")
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var ids []int
+	if repo.Owner == (common.Address{}) {
+		ids = r.named[repo.Name]
+	} else {
+		ids = r.owned[ownedKey{owner: repo.Owner, name: repo.Name}]
+	}
+
+	if len(ids) == 0 {
+		return ErrRepoNotFound
 	}
 
+	re := r.repositories[ids[0]]
+
+	repo.Name = re.Name
 	repo.Metadata = re.Metadata
 	repo.ID = re.ID
 	repo.Description = re.Description
 	repo.Owner = re.Owner
 	repo.BasePath = re.BasePath
+	repo.ForkFrom = re.ForkFrom
+	return nil
+}
+
+func (r *Repository) UpdateMetadata(id int, metadata string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	repo, ok := r.repositories[id]
+	if !ok {
+		return ErrRepoNotFound
+	}
+
+	repo.Metadata = metadata
 	return nil
 }
+
+// insertID inserts the given ID into sorted IDs slice
+func insertID(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
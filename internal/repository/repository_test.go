@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/internal/models"
+)
+
+func TestRepository_GetRepo(t *testing.T) {
+	alice := common.HexToAddress("0x1")
+	bob := common.HexToAddress("0x2")
+
+	r := NewRepository()
+
+	// events are received out of order, the lowest ID must win anyway
+	require.NoError(t, r.CreateRepo(&models.Repo{ID: 7, Name: "api", Owner: bob}))
+	require.NoError(t, r.CreateRepo(&models.Repo{ID: 3, Name: "api", Owner: alice}))
+	require.NoError(t, r.CreateRepo(&models.Repo{ID: 5, Name: "api", Owner: bob}))
+
+	assert.Error(t, r.CreateRepo(&models.Repo{ID: 5, Name: "web", Owner: bob}))
+	assert.ErrorIs(t, r.CreateRepo(&models.Repo{ID: 9, Name: "../api", Owner: bob}), models.ErrInvalidRepoName)
+
+	testCases := []struct {
+		name      string
+		owner     common.Address
+		repo      string
+		expected  int
+		expectErr bool
+	}{
+		{name: "owner namespaced", owner: alice, repo: "api", expected: 3},
+		{name: "owner namespaced collision", owner: bob, repo: "api", expected: 5},
+		{name: "legacy by name", repo: "api", expected: 3},
+		{name: "unknown name", owner: alice, repo: "web", expectErr: true},
+		{name: "unknown owner", owner: common.HexToAddress("0x3"), repo: "api", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &models.Repo{Name: tc.repo, Owner: tc.owner}
+			err := r.GetRepo(repo)
+			if tc.expectErr {
+				assert.ErrorIs(t, err, ErrRepoNotFound)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, repo.ID)
+		})
+	}
+}
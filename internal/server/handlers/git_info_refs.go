@@ -4,10 +4,10 @@ import (
 	"fmt"
 	"net/http"
 
-	"github.com/go-chi/chi/v5"
 	"github.com/misnaged/annales/logger"
 
 	"gitsec-backend/internal/models"
+	"gitsec-backend/pkg/protov2"
 )
 
 // InfoRef is an HTTP handler function that handles requests
@@ -22,9 +22,25 @@ func (h *Handlers) InfoRef() http.HandlerFunc {
 
 		rw.Header().Set("content-type", fmt.Sprintf("application/x-%s-advertisement", infoRefRequestType.String()))
 
-		resp, err := h.srv.InfoRef(r.Context(), chi.URLParam(r, repoNamePath), infoRefRequestType)
+		owner, name := repoFromRequest(r)
+
+		if infoRefRequestType == models.GitSessionUploadPack && protov2.RequestedVersion2(r.Header.Get(gitProtocolHeader)) {
+			caps, err := h.srv.InfoRefV2(r.Context(), owner, name)
+			if err != nil {
+				http.Error(rw, err.Error(), errorStatus(err))
+				logger.Log().Error(err)
+				return
+			}
+
+			if err = caps.Encode(rw); err != nil {
+				logger.Log().Error(err)
+			}
+			return
+		}
+
+		resp, err := h.srv.InfoRef(r.Context(), owner, name, infoRefRequestType)
 		if err != nil {
-			http.Error(rw, err.Error(), 500)
+			http.Error(rw, err.Error(), errorStatus(err))
 			logger.Log().Error(err)
 			return
 		}
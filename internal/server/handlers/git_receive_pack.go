@@ -4,7 +4,6 @@ import (
 	"log"
 	"net/http"
 
-	"github.com/go-chi/chi/v5"
 	"github.com/misnaged/annales/logger"
 )
 
@@ -14,9 +13,11 @@ func (h *Handlers) GitReceivePack() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		rw.Header().Set("content-type", "application/x-git-receive-pack-result")
 
-		resp, err := h.srv.ReceivePack(r.Context(), r.Body, chi.URLParam(r, repoNamePath))
+		owner, name := repoFromRequest(r)
+
+		resp, err := h.srv.ReceivePack(r.Context(), r.Body, owner, name)
 		if err != nil {
-			http.Error(rw, err.Error(), 500)
+			http.Error(rw, err.Error(), errorStatus(err))
 			logger.Log().Error(err)
 			return
 		}
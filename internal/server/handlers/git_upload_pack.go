@@ -4,8 +4,9 @@ import (
 	"log"
 	"net/http"
 
-	"github.com/go-chi/chi/v5"
 	"github.com/misnaged/annales/logger"
+
+	"gitsec-backend/pkg/protov2"
 )
 
 // GitUploadPack is an HTTP handler that processes a
@@ -14,12 +15,32 @@ func (h *Handlers) GitUploadPack() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		rw.Header().Set("content-type", "application/x-git-upload-pack-result")
 
-		resp, err := h.srv.UploadPack(r.Context(), r.Body, chi.URLParam(r, repoNamePath))
+		owner, name := repoFromRequest(r)
+
+		if protov2.RequestedVersion2(r.Header.Get(gitProtocolHeader)) {
+			resp, err := h.srv.UploadPackV2(r.Context(), r.Body, owner, name)
+			if err != nil {
+				http.Error(rw, err.Error(), errorStatus(err))
+				logger.Log().Error(err)
+				return
+			}
+
+			// the response is streamed, it can't be replaced with an error
+			if err = resp.Encode(rw); err != nil {
+				logger.Log().Error(err)
+			}
+			return
+		}
+
+		resp, err := h.srv.UploadPack(r.Context(), r.Body, owner, name)
 		if err != nil {
-			http.Error(rw, err.Error(), 500)
+			http.Error(rw, err.Error(), errorStatus(err))
 			logger.Log().Error(err)
 			return
 		}
+		// the packfile isn't closed if the response fails to be encoded
+		// before it, closing it releases the repository
+		defer resp.Close()
 
 		if err = resp.Encode(rw); err != nil {
 			http.Error(rw, err.Error(), 500)
@@ -1,10 +1,24 @@
 package handlers
 
-import "gitsec-backend/internal/service"
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"gitsec-backend/internal/models"
+	"gitsec-backend/internal/repository"
+	"gitsec-backend/internal/service"
+	"gitsec-backend/pkg/protov2"
+)
 
 const (
 	// repoNamePath is the path parameter key for the repository name
 	repoNamePath = "repoName"
+	// ownerPath is the path parameter key for the repository owner address
+	ownerPath = "owner"
+	// gitProtocolHeader is the header clients request the protocol version with
+	gitProtocolHeader = "Git-Protocol"
 )
 
 // Handlers represents a set of HTTP handlers for handling
@@ -24,3 +38,20 @@ func NewHandlers(dir string, srv service.IGitService) *Handlers {
 		srv: srv,
 	}
 }
+
+// repoFromRequest returns repository owner and name from the request path.
+// Owner is empty for legacy not namespaced routes.
+func repoFromRequest(r *http.Request) (owner, name string) {
+	return chi.URLParam(r, ownerPath), models.TrimGitSuffix(chi.URLParam(r, repoNamePath))
+}
+
+// errorStatus returns HTTP status code for the given service error
+func errorStatus(err error) int {
+	if errors.Is(err, repository.ErrRepoNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, protov2.ErrInvalidRequest) || errors.Is(err, protov2.ErrUnknownCommand) || errors.Is(err, protov2.ErrUnsupportedArgument) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/misnaged/annales/logger"
+)
+
+// Health is an HTTP handler function that reports whether the
+// storage repositories are stored in is healthy.
+func (h *Handlers) Health() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if err := h.srv.Health(r.Context()); err != nil {
+			http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+			logger.Log().Error(err)
+			return
+		}
+
+		rw.Header().Set("content-type", "text/plain")
+		_, _ = rw.Write([]byte("ok\n"))
+	}
+}
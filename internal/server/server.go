@@ -46,6 +46,14 @@ func (s *HTTPServer) registerRoutes() {
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 
+	r.Get("/healthz", s.handlers.Health())
+
+	// owner namespaced routes: /{owner}/{repoName}.git
+	r.HandleFunc("/{owner}/{repoName}/info/refs", s.handlers.InfoRef())
+	r.HandleFunc("/{owner}/{repoName}/git-upload-pack", s.handlers.GitUploadPack())
+	r.HandleFunc("/{owner}/{repoName}/git-receive-pack", s.handlers.GitReceivePack())
+
+	// legacy routes, repository is resolved by name only
 	r.HandleFunc("/{repoName}/info/refs", s.handlers.InfoRef())
 	r.HandleFunc("/{repoName}/git-upload-pack", s.handlers.GitUploadPack())
 	r.HandleFunc("/{repoName}/git-receive-pack", s.handlers.GitReceivePack())
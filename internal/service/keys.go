@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/misnaged/annales/logger"
+
+	"gitsec-backend/internal/models"
+	"gitsec-backend/pkg/envelope"
+	"gitsec-backend/pkg/signer"
+)
+
+// errNoCreationTx is returned when the transaction the repository
+// is created with isn't found in the contract events
+var errNoCreationTx = errors.New("repository creation transaction not found")
+
+// contentKeys is the content key of the encrypted repository
+// and public keys of the recipients it's wrapped to
+type contentKeys struct {
+	key        []byte
+	recipients []*ecdsa.PublicKey
+}
+
+// newContentKeys creates the content keys of the repository if it's
+// encrypted. The key is wrapped to the owner, which public key is recovered
+// from the transaction the repository is created with, to the configured
+// collaborators and to the service, so it can publish the next versions.
+func (g *GitService) newContentKeys(repo *models.Repo, tx common.Hash) (*contentKeys, error) {
+	if !g.encryption.Encrypted(repo) {
+		return nil, nil
+	}
+
+	owner, err := g.ownerKey(repo, tx)
+	if err != nil {
+		return nil, fmt.Errorf("encrypted repository %s: %w", repo.Name, err)
+	}
+
+	key, err := envelope.NewKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &contentKeys{key: key, recipients: g.recipients(owner)}, nil
+}
+
+// contentKeys returns the content keys the repository version is published
+// with: nil for plain repositories, the previous key wrapped to the current
+// recipients for encrypted ones. The recipients are the owner, the configured
+// collaborators and the service. A new key is generated once any previous
+// recipient is removed, so it can't decrypt the next versions. Encrypted
+// repository without previous keys, e.g. published plain before or its
+// previous metadata can't be read, gets new ones too.
+func (g *GitService) contentKeys(repo *models.Repo, prev *contentKeys) (*contentKeys, error) {
+	if !g.encryption.Encrypted(repo) {
+		return nil, nil
+	}
+
+	if prev == nil {
+		return g.recoverContentKeys(repo, "has no previous content key")
+	}
+
+	// the owner public key is only known from the previous recipients
+	var owner *ecdsa.PublicKey
+	for _, pub := range prev.recipients {
+		if crypto.PubkeyToAddress(*pub) == repo.Owner {
+			owner = pub
+			break
+		}
+	}
+
+	if owner == nil {
+		return g.recoverContentKeys(repo, "content key isn't wrapped to the owner")
+	}
+
+	recipients := g.recipients(owner)
+
+	current := make(map[common.Address]bool, len(recipients))
+	for _, pub := range recipients {
+		current[crypto.PubkeyToAddress(*pub)] = true
+	}
+
+	var removed []string
+	for _, pub := range prev.recipients {
+		if addr := crypto.PubkeyToAddress(*pub); !current[addr] {
+			removed = append(removed, addr.Hex())
+		}
+	}
+
+	if len(removed) == 0 {
+		return &contentKeys{key: prev.key, recipients: recipients}, nil
+	}
+
+	logger.Log().Infof("encrypted repository %s recipients %s are removed, new content key is generated", repo.Name, strings.Join(removed, ", "))
+
+	key, err := envelope.NewKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &contentKeys{key: key, recipients: recipients}, nil
+}
+
+// recoverContentKeys creates new content keys of the repository which
+// previous ones can't be used, the owner public key is recovered from the
+// transaction the repository is created with
+func (g *GitService) recoverContentKeys(repo *models.Repo, reason string) (*contentKeys, error) {
+	tx, err := g.creationTx(repo)
+	if err != nil {
+		return nil, fmt.Errorf("encrypted repository %s: %w", repo.Name, err)
+	}
+
+	logger.Log().Warningf("encrypted repository %s %s, new one is wrapped to the owner from transaction %s", repo.Name, reason, tx.Hex())
+
+	return g.newContentKeys(repo, tx)
+}
+
+// recipients returns the given public keys with the
+// configured collaborators and the service ones
+func (g *GitService) recipients(pubs ...*ecdsa.PublicKey) []*ecdsa.PublicKey {
+	res := append([]*ecdsa.PublicKey{}, pubs...)
+	res = append(res, g.encryption.Recipients()...)
+	return append(res, g.signer.PublicKey())
+}
+
+// openKeys unwraps the content key of the encrypted metadata with the service key
+func (g *GitService) openKeys(encrypted *models.EncryptedMetadata) (*contentKeys, error) {
+	key, err := g.signer.Unwrap(encrypted.Recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients, err := envelope.PublicKeys(encrypted.Recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	return &contentKeys{key: key, recipients: recipients}, nil
+}
+
+// ownerKey recovers the public key of the repository owner
+// from the signature of the transaction sent by the owner
+func (g *GitService) ownerKey(repo *models.Repo, hash common.Hash) (*ecdsa.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), metadataFetchTimeout)
+	defer cancel()
+
+	tx, _, err := g.blockchain.TransactionByHash(ctx, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction %s: %w", hash.Hex(), err)
+	}
+
+	pub, err := signer.RecoverPublicKey(tx, g.chainId)
+	if err != nil {
+		return nil, fmt.Errorf("transaction %s: %w", hash.Hex(), err)
+	}
+
+	if sender := crypto.PubkeyToAddress(*pub); sender != repo.Owner {
+		return nil, fmt.Errorf("transaction %s is sent by %s, not by the owner %s", hash.Hex(), sender.Hex(), repo.Owner.Hex())
+	}
+
+	return pub, nil
+}
+
+// creationTx finds the transaction the repository is created or forked
+// with in the contract events
+func (g *GitService) creationTx(repo *models.Repo) (common.Hash, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), metadataFetchTimeout)
+	defer cancel()
+
+	opts := &bind.FilterOpts{Context: ctx}
+	id := big.NewInt(int64(repo.ID))
+
+	if repo.ForkFrom != "" {
+		it, err := g.contract.FilterRepositoryForked(opts)
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("failed to filter repository fork events: %w", err)
+		}
+		defer it.Close()
+
+		for it.Next() {
+			if it.Event.RepId.Cmp(id) == 0 {
+				return it.Event.Raw.TxHash, nil
+			}
+		}
+
+		if err := it.Error(); err != nil {
+			return common.Hash{}, fmt.Errorf("failed to filter repository fork events: %w", err)
+		}
+
+		return common.Hash{}, errNoCreationTx
+	}
+
+	it, err := g.contract.FilterRepositoryCreated(opts)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to filter repository creation events: %w", err)
+	}
+	defer it.Close()
+
+	for it.Next() {
+		if it.Event.RepId.Cmp(id) == 0 {
+			return it.Event.Raw.TxHash, nil
+		}
+	}
+
+	if err := it.Error(); err != nil {
+		return common.Hash{}, fmt.Errorf("failed to filter repository creation events: %w", err)
+	}
+
+	return common.Hash{}, errNoCreationTx
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/internal/models"
+	"gitsec-backend/pkg/envelope"
+	"gitsec-backend/pkg/signer"
+)
+
+// newKey generates the secp256k1 key pair
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	return key
+}
+
+// addresses returns addresses of the public keys
+func addresses(pubs []*ecdsa.PublicKey) []common.Address {
+	res := make([]common.Address, 0, len(pubs))
+	for _, pub := range pubs {
+		res = append(res, crypto.PubkeyToAddress(*pub))
+	}
+	return res
+}
+
+func TestGitService_ContentKeys(t *testing.T) {
+	owner, kept, removed := newKey(t), newKey(t), newKey(t)
+
+	service, err := signer.NewSigner(hexutil.Encode(crypto.FromECDSA(newKey(t)))[2:], 0)
+	require.NoError(t, err)
+
+	policy, err := models.NewEncryptionPolicy([]string{"*/*"}, []string{hexutil.Encode(crypto.FromECDSAPub(&kept.PublicKey))})
+	require.NoError(t, err)
+
+	g := &GitService{encryption: policy, signer: service}
+	repo := &models.Repo{Name: "api", Owner: crypto.PubkeyToAddress(owner.PublicKey)}
+
+	key, err := envelope.NewKey()
+	require.NoError(t, err)
+
+	// the key is kept while recipients are only added
+	prev := &contentKeys{key: key, recipients: []*ecdsa.PublicKey{&owner.PublicKey, service.PublicKey()}}
+
+	keys, err := g.contentKeys(repo, prev)
+	require.NoError(t, err)
+	assert.Equal(t, key, keys.key)
+
+	current := []common.Address{repo.Owner, crypto.PubkeyToAddress(kept.PublicKey), service.Address}
+	assert.Equal(t, current, addresses(keys.recipients))
+
+	// and it's rotated once a recipient is removed, the key is
+	// wrapped to the current recipients only
+	prev.recipients = append(prev.recipients, &removed.PublicKey)
+
+	keys, err = g.contentKeys(repo, prev)
+	require.NoError(t, err)
+	assert.NotEqual(t, key, keys.key)
+	assert.Equal(t, current, addresses(keys.recipients))
+}
@@ -0,0 +1,372 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/ipfs/go-cid"
+	"github.com/misnaged/annales/logger"
+
+	"gitsec-backend/internal/models"
+	"gitsec-backend/internal/repository"
+	"gitsec-backend/pkg/envelope"
+	"gitsec-backend/pkg/pinner"
+	"gitsec-backend/pkg/unixfs"
+)
+
+// updateRepositoryMeta publishes the metadata of the pushed repository
+// version with its tree, objects and bundle, and anchors it on-chain.
+func (g *GitService) updateRepositoryMeta(repo *models.Repo) error {
+	if err := repo.WriteCommitGraph(); err != nil {
+		logger.Log().Warningf("failed to write repository %s commit-graph: %s", repo.Name, err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to get repo head: %w", err)
+	}
+
+	tree, err := repo.Tree(head.Hash())
+	if err != nil {
+		return fmt.Errorf("failed to get repo tree: %w", err)
+	}
+
+	meta, err := repo.GenMeta()
+	if err != nil {
+		return fmt.Errorf("failed to generate repository meta: %w", err)
+	}
+
+	if head.Name().IsBranch() {
+		meta.Branch = head.Name().Short()
+	}
+
+	prev, prevTree, prevKeys, err := g.previousMeta(repo)
+	if err != nil {
+		// encrypted repository gets new content keys
+		// if the previous ones can't be read
+		logger.Log().Warningf("repository %s metadata will be published from scratch: %s", repo.Name, err)
+	}
+
+	meta.Policy = g.policy.Fingerprint()
+
+	if prev != nil && prev.Policy != meta.Policy {
+		logger.Log().Infof("repository %s metadata will be published from scratch: content policy changed", repo.Name)
+		prev, prevTree = nil, nil
+	}
+
+	keys, err := g.contentKeys(repo, prevKeys)
+	if err != nil {
+		return err
+	}
+
+	// plain and encrypted content can't be reused by each other
+	if prev != nil && (keys == nil) != (prevKeys == nil) {
+		logger.Log().Infof("repository %s metadata will be published from scratch: encryption changed", repo.Name)
+		prev, prevTree = nil, nil
+	}
+
+	// content encrypted with the previous key isn't reused with the new one
+	if prev != nil && keys != nil && !bytes.Equal(keys.key, prevKeys.key) {
+		logger.Log().Infof("repository %s metadata will be published from scratch: content key changed", repo.Name)
+		prev, prevTree = nil, nil
+	}
+
+	if prev != nil {
+		if err := meta.FillContentFrom(prev, prevTree, tree, g.policy); err != nil {
+			return fmt.Errorf("failed to fill metadata content: %w", err)
+		}
+	} else {
+		if err := meta.FillContent(tree, g.policy); err != nil {
+			return fmt.Errorf("failed to fill metadata content: %w", err)
+		}
+	}
+
+	paths := meta.ChangedFiles()
+
+	if prev != nil {
+		// last commits of unchanged files are carried over only
+		// if the previous history is continued, a force push
+		// could rewrite the commits they refer to
+		reachable, err := repo.Reachable(plumbing.NewHash(prev.Commit))
+		if err != nil {
+			return fmt.Errorf("failed to walk repository history: %w", err)
+		}
+
+		if !reachable {
+			logger.Log().Infof("repository %s history is rewritten, last commits of all files are recomputed", repo.Name)
+			paths = meta.FileNames()
+		}
+	}
+
+	history, err := repo.History(paths)
+	if err != nil {
+		return fmt.Errorf("failed to walk repository history: %w", err)
+	}
+
+	meta.FillCommit(history)
+
+	if keys != nil {
+		// git-raw blocks can't be encrypted, so encrypted
+		// repositories publish their files only
+		if err := g.StoreEncrypted(meta, repo, tree, prev, keys); err != nil {
+			return err
+		}
+	} else {
+		if err := g.StoreTree(meta, repo, tree, prev, prevTree); err != nil {
+			return err
+		}
+
+		if err := g.StoreObjects(meta, repo, head.Hash(), prev); err != nil {
+			return err
+		}
+
+		// bundles are an extra way to rebuild the repository,
+		// failure keeps the previous chain to be extended next time
+		if err := g.StoreBundle(meta, repo, prev); err != nil {
+			logger.Log().Warningf("failed to publish repository %s bundle: %s", repo.Name, err)
+		}
+	}
+
+	hash, err := g.pinMeta(pinner.PinName(repo.FullName(), meta.Commit, "meta.json"), meta, keys)
+	if err != nil {
+		return err
+	}
+
+	logger.Log().Infof("repository %s metadata %s pinned to IPFS", repo.Name, hash)
+
+	repo.Metadata = hash
+
+	g.recordVersion(repo, repository.Version{
+		Metadata: hash,
+		Commit:   meta.Commit,
+		Pins:     append(nonEmpty(hash, meta.Root, meta.CommitCID), bundleCIDs(meta)...),
+	})
+
+	if err := g.repository.UpdateMetadata(repo.ID, hash); err != nil {
+		return fmt.Errorf("failed to store repository metadata hash: %w", err)
+	}
+
+	if err := g.anchor(repo, hash); err != nil {
+		return err
+	}
+
+	g.prune(repo)
+
+	return nil
+}
+
+// pinMeta pins the repository metadata and returns its CID. The CID is
+// computed locally as well, pinner returning another one isn't trusted.
+// With content keys the encrypted manifest is pinned instead.
+func (g *GitService) pinMeta(name string, meta *models.RepoMetadata, keys *contentKeys) (string, error) {
+	var published interface{} = meta
+
+	if keys != nil {
+		recipients, err := envelope.Wrap(keys.key, keys.recipients)
+		if err != nil {
+			return "", err
+		}
+
+		if published, err = models.SealMetadata(meta, keys.key, recipients); err != nil {
+			return "", err
+		}
+	}
+
+	metaJson, err := json.Marshal(published)
+	if err != nil {
+		return "", fmt.Errorf("marshal repository metadata: %w", err)
+	}
+
+	expected, err := unixfs.FileCID(bytes.NewReader(metaJson))
+	if err != nil {
+		return "", fmt.Errorf("compute repository metadata cid: %w", err)
+	}
+
+	ctx, cancel := g.pinContext()
+	defer cancel()
+
+	hash, err := g.pinner.Pin(ctx, name, bytes.NewReader(metaJson))
+	if err != nil {
+		return "", fmt.Errorf("pin repository metadata to ipfs: %w", err)
+	}
+
+	if c, err := cid.Decode(hash); err != nil || !c.Equals(expected) {
+		return "", fmt.Errorf("pinner returned metadata CID %q, expected %s", hash, expected)
+	}
+
+	return hash, nil
+}
+
+// anchor sends the repository metadata CID to the contract. The CID is
+// validated first, so a pinner failure never ends up anchored on-chain.
+func (g *GitService) anchor(repo *models.Repo, hash string) error {
+	if _, err := cid.Decode(hash); err != nil {
+		return fmt.Errorf("refuse to anchor repository %s metadata: invalid CID %q: %w", repo.Name, hash, err)
+	}
+
+	if g.dryRun {
+		logger.Log().Infof("dry run: repository %s ID %d metadata %s is not anchored", repo.Name, repo.ID, hash)
+		return nil
+	}
+
+	sign, err := g.signer.Sign(g.chainId)
+	if err != nil {
+		return fmt.Errorf("prepare tx signing: %w", err)
+	}
+
+	tx, err := g.contract.UpdateIPFS(sign, big.NewInt(int64(repo.ID)), hash)
+	if err != nil {
+		return fmt.Errorf("failed to send transaction: %w", err)
+	}
+
+	logger.Log().Infof("transaction %s to update repository %s ID %d metadata %s send to blockchan", tx.Hash().Hex(), repo.Name, repo.ID, hash)
+
+	if err := g.inventory.Anchor(repo.ID, hash); err != nil {
+		logger.Log().Warningf("failed to record repository %s metadata %s anchored: %s", repo.Name, hash, err)
+	}
+
+	return nil
+}
+
+// pinContext returns the context of a single pinner request
+func (g *GitService) pinContext() (context.Context, context.CancelFunc) {
+	if g.timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), g.timeout)
+}
+
+// recordVersion records the published repository version in the inventory.
+// Failure only leaves the version pinned forever, so it doesn't fail the push.
+func (g *GitService) recordVersion(repo *models.Repo, version repository.Version) {
+	if g.dryRun {
+		return
+	}
+
+	version.Published = time.Now().UTC()
+
+	if err := g.inventory.Add(repo.ID, version); err != nil {
+		logger.Log().Warningf("failed to record repository %s metadata %s pins: %s", repo.Name, version.Metadata, err)
+	}
+}
+
+// prune unpins repository versions which aren't retained: all but the last
+// anchored versions and the version referenced by the contract. The just
+// sent transaction is usually not mined yet, so the previous version stays
+// pinned until it's superseded on-chain too.
+func (g *GitService) prune(repo *models.Repo) {
+	if g.retain <= 0 || g.dryRun {
+		return
+	}
+
+	onChain, err := g.contract.GetRepository(&bind.CallOpts{Context: context.Background()}, big.NewInt(int64(repo.ID)))
+	if err != nil {
+		logger.Log().Warningf("repository %s is not pruned: failed to get on-chain metadata: %s", repo.Name, err)
+		return
+	}
+
+	versions, err := g.inventory.Versions(repo.ID)
+	if err != nil {
+		logger.Log().Warningf("repository %s is not pruned: %s", repo.Name, err)
+		return
+	}
+
+	var unpinned []string
+	for _, hash := range repository.Retain(versions, g.retain, map[string]bool{onChain.IPFS: true}) {
+		if err := g.unpin(hash); err != nil {
+			logger.Log().Warningf("failed to unpin repository %s content %s: %s", repo.Name, hash, err)
+			continue
+		}
+
+		unpinned = append(unpinned, hash)
+	}
+
+	if len(unpinned) == 0 {
+		return
+	}
+
+	if err := g.inventory.Remove(repo.ID, unpinned); err != nil {
+		logger.Log().Warningf("failed to remove repository %s unpinned content from inventory: %s", repo.Name, err)
+	}
+
+	logger.Log().Infof("repository %s pruned, %d superseded pins removed", repo.Name, len(unpinned))
+}
+
+// unpin unpins the CID from the pinners and the IPFS node
+func (g *GitService) unpin(hash string) error {
+	ctx, cancel := g.pinContext()
+	defer cancel()
+
+	if err := g.pinner.Unpin(ctx, hash); err != nil {
+		return err
+	}
+
+	if err := g.node.Unpin(ctx, hash); err != nil {
+		return fmt.Errorf("ipfs node: %w", err)
+	}
+
+	return nil
+}
+
+// nonEmpty returns not empty values
+func nonEmpty(values ...string) []string {
+	var res []string
+	for _, v := range values {
+		if v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// previousMeta fetches the previously published repository metadata and the
+// tree of its commit. It returns nil metadata if the repository has never
+// been published with a commit. Encrypted metadata is decrypted, its content
+// keys are returned too.
+func (g *GitService) previousMeta(repo *models.Repo) (*models.RepoMetadata, *object.Tree, *contentKeys, error) {
+	// nothing is published with dry run
+	if repo.Metadata == "" || g.dryRun {
+		return nil, nil, nil, nil
+	}
+
+	r, err := g.ipfs.Cat(repo.Metadata)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to fetch metadata %s: %w", repo.Metadata, err)
+	}
+	defer r.Close()
+
+	prev, encrypted, err := models.DecodeMetadata(r)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to decode metadata %s: %w", repo.Metadata, err)
+	}
+
+	var keys *contentKeys
+
+	if encrypted != nil {
+		if keys, err = g.openKeys(encrypted); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to open metadata %s: %w", repo.Metadata, err)
+		}
+
+		if prev, err = encrypted.Open(keys.key); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to open metadata %s: %w", repo.Metadata, err)
+		}
+	}
+
+	if !plumbing.IsHash(prev.Commit) {
+		return nil, nil, keys, nil
+	}
+
+	tree, err := repo.Tree(plumbing.NewHash(prev.Commit))
+	if err != nil {
+		return nil, nil, keys, err
+	}
+
+	return prev, tree, keys, nil
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/ipfs/go-cid"
+	"github.com/misnaged/annales/logger"
+
+	"gitsec-backend/internal/models"
+	"gitsec-backend/pkg/bundle"
+	"gitsec-backend/pkg/envelope"
+	"gitsec-backend/pkg/pinner"
+	"gitsec-backend/pkg/unixfs"
+)
+
+// StoreTree publishes the repository tree as UnixFS directory and pins it.
+// Subtrees not changed since the previously published metadata are reused.
+// Files failed to be added don't fail the update, they are reported in the
+// metadata Failed list instead.
+func (g *GitService) StoreTree(meta *models.RepoMetadata, repo *models.Repo, tree *object.Tree, prev *models.RepoMetadata, prevTree *object.Tree) error {
+	var previous *unixfs.Previous
+	if prev != nil && prev.Root != "" {
+		root, err := cid.Decode(prev.Root)
+		if err != nil {
+			logger.Log().Warningf("repository %s tree will be published from scratch: invalid root %q: %s", repo.Name, prev.Root, err)
+		} else {
+			previous = &unixfs.Previous{Root: root, Tree: prevTree, Failed: prev.Failed}
+		}
+	}
+
+	res, err := g.tree.Build(context.Background(), tree, previous)
+	if err != nil {
+		return fmt.Errorf("failed to publish repository tree: %w", err)
+	}
+
+	return g.pinTree(meta, repo, res)
+}
+
+// StoreEncrypted publishes files of the encrypted repository tree as a flat
+// UnixFS directory and pins it. Files are encrypted with the content key,
+// directory entries are named by CIDs of encrypted files, so neither file
+// contents nor paths are revealed. Files not changed since the previously
+// published metadata are kept without encrypting them again.
+func (g *GitService) StoreEncrypted(meta *models.RepoMetadata, repo *models.Repo, tree *object.Tree, prev *models.RepoMetadata, keys *contentKeys) error {
+	flat := &unixfs.Flat{
+		Keep: make(map[string]cid.Cid),
+		Transform: func(r io.Reader) (io.Reader, error) {
+			return envelope.Encrypt(keys.key, r)
+		},
+	}
+
+	if prev != nil && prev.Root != "" {
+		if root, err := cid.Decode(prev.Root); err == nil {
+			flat.Previous = root
+		}
+	}
+
+	for _, f := range meta.Tree {
+		if f.Skipped != "" {
+			continue
+		}
+
+		if !f.Changed && f.Hash != "" {
+			if c, err := cid.Decode(f.Hash); err == nil {
+				flat.Keep[f.Name] = c
+				continue
+			}
+		}
+
+		flat.Add = append(flat.Add, f.Name)
+	}
+
+	res, err := g.tree.BuildFlat(context.Background(), tree, flat)
+	if err != nil {
+		return fmt.Errorf("failed to publish encrypted repository tree: %w", err)
+	}
+
+	return g.pinTree(meta, repo, res)
+}
+
+// pinTree records the published tree in the metadata and pins its root.
+// Files failed to be added are reported in the metadata Failed list.
+func (g *GitService) pinTree(meta *models.RepoMetadata, repo *models.Repo, res *unixfs.Result) error {
+	for _, f := range meta.Tree {
+		if c, ok := res.Files[f.Name]; ok {
+			f.Hash = c.String()
+		}
+
+		if err, ok := res.Failed[f.Name]; ok {
+			logger.Log().Errorf("failed to add file %s to IPFS: %s", f.Name, err)
+
+			f.Hash = ""
+			meta.Failed = append(meta.Failed, f.Name)
+		}
+	}
+
+	if len(meta.Failed) != 0 {
+		logger.Log().Warningf("repository %s: %d files failed to be added to IPFS", repo.Name, len(meta.Failed))
+	}
+
+	meta.Root = res.Root.String()
+
+	results := g.pipeline.Pin(context.Background(), []pinner.Job{{
+		Key:  "tree",
+		Name: pinner.PinName(repo.FullName(), meta.Commit, ""),
+		Hash: meta.Root,
+	}})
+	if err := results[0].Err; err != nil {
+		return fmt.Errorf("failed to pin repository tree %s: %w", meta.Root, err)
+	}
+
+	logger.Log().Infof("repository %s tree %s pinned, %d files added", repo.Name, meta.Root, len(res.Files))
+
+	return nil
+}
+
+// StoreObjects publishes git objects reachable from the commit as git-raw
+// blocks and records the commit CID in the metadata. Objects reachable from
+// the previously published commit are skipped.
+func (g *GitService) StoreObjects(meta *models.RepoMetadata, repo *models.Repo, commit plumbing.Hash, prev *models.RepoMetadata) error {
+	var published []plumbing.Hash
+	if prev != nil && prev.CommitCID != "" {
+		published = append(published, plumbing.NewHash(prev.Commit))
+		meta.SkippedObjects = append(meta.SkippedObjects, prev.SkippedObjects...)
+	}
+
+	res, err := g.objects.Publish(context.Background(), repo.Repocore.Storer, commit, published, len(meta.SkippedObjects) > 0)
+	if err != nil {
+		return fmt.Errorf("failed to publish repository objects: %w", err)
+	}
+
+	meta.CommitCID = res.Commit.String()
+
+	for _, hash := range res.Skipped {
+		meta.SkippedObjects = append(meta.SkippedObjects, hash.String())
+	}
+
+	if !res.Complete {
+		// pinning services traverse the whole DAG, which
+		// never completes with skipped objects missing
+		logger.Log().Infof("repository %s commit %s published, %d objects put, %d too large skipped, objects are only pinned on the IPFS node", repo.Name, meta.CommitCID, res.Put, len(meta.SkippedObjects))
+		return nil
+	}
+
+	results := g.pipeline.Pin(context.Background(), []pinner.Job{{
+		Key:  "objects",
+		Name: pinner.PinName(repo.FullName(), commit.String(), ".git"),
+		Hash: meta.CommitCID,
+	}})
+	if err := results[0].Err; err != nil {
+		// remote pinning services may not traverse git-raw
+		// DAGs, objects are still served by the IPFS node
+		logger.Log().Warningf("failed to pin repository %s objects %s: %s", repo.Name, meta.CommitCID, err)
+	}
+
+	logger.Log().Infof("repository %s commit %s published, %d objects put, %d too large skipped", repo.Name, meta.CommitCID, res.Put, len(res.Skipped))
+
+	return nil
+}
+
+// StoreBundle publishes the git bundle of the repository references and
+// appends it to the bundle chain of the previously published metadata.
+// The bundle is incremental against the last bundle of the chain, a full
+// one starts a new chain once the chain is long enough. Nothing is
+// published if references didn't change since the last bundle.
+func (g *GitService) StoreBundle(meta *models.RepoMetadata, repo *models.Repo, prev *models.RepoMetadata) error {
+	var chain []*models.Bundle
+	if prev != nil && len(prev.Bundles) != 0 && len(prev.Bundles) <= g.bundleChain {
+		chain = prev.Bundles
+	}
+
+	// the chain is carried over unless the new bundle is published
+	meta.Bundles = chain
+
+	refs, err := bundle.References(repo.Repocore.Storer)
+	if err != nil {
+		return err
+	}
+
+	if len(refs) == 0 {
+		return nil
+	}
+
+	bundled := make(map[string]string, len(refs))
+	for _, ref := range refs {
+		bundled[ref.Name().String()] = ref.Hash().String()
+	}
+
+	var exclude []plumbing.Hash
+	if len(chain) != 0 {
+		last := chain[len(chain)-1]
+		if reflect.DeepEqual(last.Refs, bundled) {
+			return nil
+		}
+
+		for _, hash := range last.Refs {
+			exclude = append(exclude, plumbing.NewHash(hash))
+		}
+	}
+
+	ctx := context.Background()
+
+	pr, pw := io.Pipe()
+
+	headers := make(chan *bundle.Header, 1)
+	go func() {
+		header, err := bundle.Create(pw, repo.Repocore.Storer, refs, exclude)
+		headers <- header
+		pw.CloseWithError(err)
+	}()
+
+	c, size, err := g.publish.Add(ctx, pr)
+	// unblocks bundle writing if adding failed
+	pr.CloseWithError(err)
+	header := <-headers
+	if err != nil {
+		return fmt.Errorf("failed to add bundle: %w", err)
+	}
+
+	if err := g.publish.Pin(ctx, c); err != nil {
+		return fmt.Errorf("failed to pin bundle %s: %w", c, err)
+	}
+
+	b := &models.Bundle{CID: c.String(), Refs: bundled, Size: size}
+	for _, hash := range header.Prerequisites {
+		b.Prerequisites = append(b.Prerequisites, hash.String())
+	}
+
+	results := g.pipeline.Pin(context.Background(), []pinner.Job{{
+		Key:  "bundle",
+		Name: pinner.PinName(repo.FullName(), meta.Commit, "bundle"),
+		Hash: b.CID,
+	}})
+	if err := results[0].Err; err != nil {
+		return fmt.Errorf("failed to pin bundle %s: %w", b.CID, err)
+	}
+
+	meta.Bundles = append(append([]*models.Bundle(nil), chain...), b)
+
+	logger.Log().Infof("repository %s bundle %s pinned, %d bundles chained", repo.Name, b.CID, len(meta.Bundles))
+
+	return nil
+}
+
+// bundleCIDs returns CIDs of the metadata bundle chain
+func bundleCIDs(meta *models.RepoMetadata) []string {
+	cids := make([]string, 0, len(meta.Bundles))
+	for _, b := range meta.Bundles {
+		cids = append(cids, b.CID)
+	}
+	return cids
+}
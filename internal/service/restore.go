@@ -0,0 +1,333 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/misnaged/annales/logger"
+
+	"gitsec-backend/internal/models"
+	"gitsec-backend/internal/repository"
+	"gitsec-backend/internal/snapshot"
+	"gitsec-backend/pkg/contract"
+	"gitsec-backend/pkg/multierr"
+)
+
+// errEncryptedSnapshot is returned when restoring an encrypted repository,
+// its snapshot has no git objects to restore it from
+var errEncryptedSnapshot = errors.New("encrypted repository snapshot has no git objects")
+
+// errMissingObjects is returned when reachable objects aren't restored
+// from the snapshot as they were not published
+var errMissingObjects = errors.New("objects are missing in the snapshot")
+
+// Restore restores the repository with the given on-chain ID from its
+// anchored IPFS snapshot. Existing repository is only replaced with
+// force, it's moved aside then. Snapshot missing objects is only
+// restored with allowMissing.
+func (g *GitService) Restore(ctx context.Context, id int, force, allowMissing bool) error {
+	onChain, err := g.contract.GetRepository(&bind.CallOpts{Context: ctx}, big.NewInt(int64(id)))
+	if err != nil {
+		return fmt.Errorf("failed to get repository %d: %w", id, err)
+	}
+
+	repo, err := g.onChainRepo(onChain)
+	if err != nil {
+		return err
+	}
+
+	exists, err := g.exists(repo)
+	if err != nil {
+		return err
+	}
+
+	if exists && !force {
+		return fmt.Errorf("repository %s ID %d already exists", repo.Name, repo.ID)
+	}
+
+	if err := g.restore(ctx, repo, allowMissing); err != nil {
+		return fmt.Errorf("failed to restore repository %s ID %d: %w", repo.Name, repo.ID, err)
+	}
+
+	return g.register(repo)
+}
+
+// Heal registers all on-chain repositories and restores
+// the ones missing on the filesystem from IPFS.
+func (g *GitService) Heal(ctx context.Context) error {
+	repos, err := g.contract.GetAllRepositories(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("failed to get repositories: %w", err)
+	}
+
+	var (
+		errs     []error
+		restored int
+		skipped  int
+	)
+
+	for _, onChain := range repos {
+		repo, err := g.onChainRepo(onChain)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		exists, err := g.exists(repo)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		// repository without anchored metadata has nothing to restore,
+		// it's created empty once it's accessed
+		if !exists && repo.Metadata != "" {
+			err := g.restore(ctx, repo, false)
+			if errors.Is(err, errEncryptedSnapshot) || errors.Is(err, errMissingObjects) {
+				// registered empty repository would be published over its snapshot
+				logger.Log().Warningf("repository %s ID %d is not restored: %s, it's not served until it's restored by hand", repo.Name, repo.ID, err)
+				skipped++
+				continue
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to restore repository %s ID %d: %w", repo.Name, repo.ID, err))
+				continue
+			}
+			restored++
+		}
+
+		if err := g.register(repo); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	logger.Log().Infof("%d repositories healed, %d restored from IPFS, %d skipped", len(repos), restored, skipped)
+
+	return multierr.Join(errs...)
+}
+
+// MigrateLayout moves repositories stored in directories named after them,
+// the layout used before repositories were stored by their on-chain ID,
+// to their ID directories.
+func (g *GitService) MigrateLayout(ctx context.Context) error {
+	onChain, err := g.contract.GetAllRepositories(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("failed to get repositories: %w", err)
+	}
+
+	repos := make([]*models.Repo, 0, len(onChain))
+	for _, r := range onChain {
+		// repositories with invalid names are never served
+		if repo, err := g.onChainRepo(r); err == nil {
+			repos = append(repos, repo)
+		}
+	}
+
+	moved, err := repository.MigrateLayout(g.fileSystem, repos)
+	if moved != 0 {
+		logger.Log().Infof("%d repositories moved to their ID directories", moved)
+	}
+
+	return err
+}
+
+// onChainRepo returns the repository registered in the contract
+func (g *GitService) onChainRepo(onChain contract.GitsecRepository) (*models.Repo, error) {
+	// the fork source isn't set, restored repository is never cloned again
+	repo, err := models.NewRepo(onChain.Name, onChain.Description, g.baseGitPath, "", int(onChain.Id.Int64()), onChain.Owner)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository %s ID %d: %w", onChain.Name, onChain.Id, err)
+	}
+
+	repo.Metadata = onChain.IPFS
+
+	return repo, nil
+}
+
+// register adds the repository to the repositories served, if it's not yet
+func (g *GitService) register(repo *models.Repo) error {
+	if err := g.repository.CreateRepo(repo); err != nil && !errors.Is(err, repository.ErrRepoExists) {
+		return fmt.Errorf("failed to register repository %s ID %d: %w", repo.Name, repo.ID, err)
+	}
+	return nil
+}
+
+// exists checks whether the repository is initialized in the storage
+func (g *GitService) exists(repo *models.Repo) (bool, error) {
+	exists, err := models.RepoExists(g.fileSystem, repo.StoragePath(), g.backend.Open)
+	if err != nil {
+		return false, fmt.Errorf("failed to check repository %s ID %d: %w", repo.Name, repo.ID, err)
+	}
+	return exists, nil
+}
+
+// restore restores the repository from its anchored metadata. The repository
+// is restored and verified in a temporary directory first, so the failed
+// restore leaves nothing behind. The existing repository is moved aside.
+// Restored repository missing objects fails the restore unless allowMissing.
+func (g *GitService) restore(ctx context.Context, repo *models.Repo, allowMissing bool) error {
+	meta, encrypted, err := g.anchoredMeta(repo)
+	if encrypted {
+		return errEncryptedSnapshot
+	}
+	if err != nil {
+		return err
+	}
+
+	tmp := repo.StoragePath() + ".restore"
+
+	if err := util.RemoveAll(g.fileSystem, tmp); err != nil {
+		return fmt.Errorf("failed to clean up %s: %w", tmp, err)
+	}
+
+	res, err := g.restoreTo(ctx, tmp, meta)
+	if err == nil && len(res.Missing) != 0 && !allowMissing {
+		err = fmt.Errorf("%w: %d objects weren't published to IPFS", errMissingObjects, len(res.Missing))
+	}
+	if err != nil {
+		if err := util.RemoveAll(g.fileSystem, tmp); err != nil {
+			logger.Log().Warningf("failed to clean up %s: %s", tmp, err)
+		}
+		return err
+	}
+
+	if len(res.Missing) != 0 {
+		logger.Log().Warningf("repository %s restored without %d objects not published to IPFS", repo.Name, len(res.Missing))
+	}
+
+	// the cached repository is reopened from the restored storage
+	if err := g.handles.Reset(repo.ID, func() error {
+		return g.replace(repo, tmp)
+	}); err != nil {
+		if err := util.RemoveAll(g.fileSystem, tmp); err != nil {
+			logger.Log().Warningf("failed to clean up %s: %s", tmp, err)
+		}
+		return err
+	}
+
+	logger.Log().Infof("repository %s ID %d restored from %s: commit %s, %d bundles applied, %d objects fetched", repo.Name, repo.ID, repo.Metadata, meta.Commit, res.Bundles, res.Fetched)
+
+	return nil
+}
+
+// replace moves the restored repository from the tmp directory to the
+// repository one. The existing directory is moved aside and put back
+// if the restored one can't be moved.
+func (g *GitService) replace(repo *models.Repo, tmp string) error {
+	var backup string
+
+	if _, err := g.fileSystem.Stat(repo.StoragePath()); err == nil {
+		backup = fmt.Sprintf("%s.%d.bak", repo.StoragePath(), time.Now().Unix())
+		if err := g.fileSystem.Rename(repo.StoragePath(), backup); err != nil {
+			return fmt.Errorf("failed to move existing repository aside: %w", err)
+		}
+	}
+
+	if err := g.fileSystem.Rename(tmp, repo.StoragePath()); err != nil {
+		if backup != "" {
+			if err := g.fileSystem.Rename(backup, repo.StoragePath()); err != nil {
+				logger.Log().Errorf("failed to put existing repository %s ID %d back from %s: %s", repo.Name, repo.ID, backup, err)
+			}
+		}
+		return fmt.Errorf("failed to move restored repository: %w", err)
+	}
+
+	if backup != "" {
+		logger.Log().Warningf("existing repository %s ID %d moved to %s", repo.Name, repo.ID, backup)
+	}
+
+	return nil
+}
+
+// anchoredMeta fetches the metadata anchored on-chain, encrypted
+// metadata is decrypted with the service key
+func (g *GitService) anchoredMeta(repo *models.Repo) (*models.RepoMetadata, bool, error) {
+	if repo.Metadata == "" {
+		return nil, false, errors.New("no metadata is anchored")
+	}
+
+	r, err := g.ipfs.Cat(repo.Metadata)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch metadata %s: %w", repo.Metadata, err)
+	}
+	defer r.Close()
+
+	meta, encrypted, err := models.DecodeMetadata(r)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decode metadata %s: %w", repo.Metadata, err)
+	}
+
+	if encrypted == nil {
+		return meta, false, nil
+	}
+
+	keys, err := g.openKeys(encrypted)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to open metadata %s: %w", repo.Metadata, err)
+	}
+
+	meta, err = encrypted.Open(keys.key)
+	return meta, true, err
+}
+
+// restoreTo initializes the repository in the directory, restores
+// the snapshot into it and checks the restored HEAD
+func (g *GitService) restoreTo(ctx context.Context, dir string, meta *models.RepoMetadata) (*snapshot.Restored, error) {
+	fs, err := g.fileSystem.Chroot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	storage, err := g.backend.Open(fs, cache.NewObjectLRUDefault())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s storage: %w", dir, err)
+	}
+
+	if c, ok := storage.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	if _, err := git.Init(storage, nil); err != nil {
+		return nil, fmt.Errorf("failed to init repository: %w", err)
+	}
+
+	res, err := snapshot.Restore(ctx, g.fetcher, g.ipfs, meta, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyHead(storage, meta); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// verifyHead checks HEAD of the restored repository storage
+// is the commit of the metadata
+func verifyHead(s storer.ReferenceStorer, meta *models.RepoMetadata) error {
+	if !plumbing.IsHash(meta.Commit) {
+		return nil
+	}
+
+	head, err := storer.ResolveReference(s, plumbing.HEAD)
+	if err != nil {
+		return fmt.Errorf("failed to resolve restored HEAD: %w", err)
+	}
+
+	if head.Hash().String() != meta.Commit {
+		return fmt.Errorf("restored HEAD %s doesn't match the metadata commit %s", head.Hash(), meta.Commit)
+	}
+
+	return nil
+}
@@ -1,61 +1,159 @@
 package service
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"math/big"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/go-git/go-billy/v5"
-	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
 	"github.com/go-git/go-git/v5/plumbing/format/pktline"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	ipfs "github.com/ipfs/go-ipfs-api"
 	"github.com/misnaged/annales/logger"
 
 	"gitsec-backend/config"
 	"gitsec-backend/internal/models"
 	"gitsec-backend/internal/repository"
+	"gitsec-backend/internal/storage"
 	"gitsec-backend/pkg/contract"
+	"gitsec-backend/pkg/gitraw"
 	"gitsec-backend/pkg/pinner"
+	"gitsec-backend/pkg/protov2"
 	"gitsec-backend/pkg/signer"
+	"gitsec-backend/pkg/unixfs"
 )
 
 // IGitService defines the interface for Git Service
 // that allows to interact with repositories
 type IGitService interface {
 	// UploadPack handles Git "git-upload-pack" command
-	// and returns UploadPackResponse
-	UploadPack(ctx context.Context, req io.Reader, repositoryName string) (*packp.UploadPackResponse, error)
+	// and returns UploadPackResponse. Empty owner resolves
+	// repository by its name only. The repository is kept
+	// acquired until the response is closed.
+	UploadPack(ctx context.Context, req io.Reader, owner, repositoryName string) (*packp.UploadPackResponse, error)
+
+	// InfoRefV2 returns the protocol v2 capability advertisement
+	// of upload-pack. Empty owner resolves repository by its name only.
+	InfoRefV2(ctx context.Context, owner, repositoryName string) (*protov2.Capabilities, error)
+
+	// UploadPackV2 handles the protocol v2 upload-pack command and returns
+	// its response. Empty owner resolves repository by its name only.
+	UploadPackV2(ctx context.Context, req io.Reader, owner, repositoryName string) (protov2.Response, error)
 
 	// ReceivePack handles Git "git-receive-pack" command
-	// and returns ReportStatus
-	ReceivePack(ctx context.Context, req io.Reader, repositoryName string) (*packp.ReportStatus, error)
+	// and returns ReportStatus. Empty owner resolves
+	// repository by its name only.
+	ReceivePack(ctx context.Context, req io.Reader, owner, repositoryName string) (*packp.ReportStatus, error)
 
 	// InfoRef retrieves advertised refs for given repository
-	// and GitSessionType
-	InfoRef(ctx context.Context, repositoryName string, infoRefRequestType models.GitSessionType) (*packp.AdvRefs, error)
+	// and GitSessionType. Empty owner resolves repository by
+	// its name only.
+	InfoRef(ctx context.Context, owner, repositoryName string, infoRefRequestType models.GitSessionType) (*packp.AdvRefs, error)
+
+	// Restore restores the repository with the given on-chain ID from its
+	// anchored IPFS snapshot. Existing repository is only replaced with
+	// force, it's moved aside then. Snapshot missing objects is only
+	// restored with allowMissing.
+	Restore(ctx context.Context, id int, force, allowMissing bool) error
+
+	// Heal registers all on-chain repositories and restores
+	// the ones missing on the filesystem from IPFS.
+	Heal(ctx context.Context) error
+
+	// MigrateLayout moves repositories stored in directories
+	// named after them to their ID directories.
+	MigrateLayout(ctx context.Context) error
+
+	// Health checks the storage repositories are stored in is healthy.
+	Health(ctx context.Context) error
 
 	StartListener()
 
 	Close()
 }
 
+// metadataFetchTimeout is the timeout of fetching
+// previously published metadata from IPFS
+const metadataFetchTimeout = time.Minute
+
+// storageCheckTimeout is the timeout of the storage
+// validation on startup
+const storageCheckTimeout = 30 * time.Second
+
 // GitService is a Git service implementation
 type GitService struct {
 	// baseGitPath is the base path for the Git
 	// repositories on the file system.
 	baseGitPath string
 
-	fs billy.Filesystem
+	// fileSystem is the filesystem repositories are stored on
+	fileSystem billy.Filesystem
+
+	// backend is the storage backend serving the fileSystem
+	backend *storage.Backend
+
+	// handles caches opened repositories
+	// and serialises writes to them.
+	handles *repository.Handles
 
 	pinner pinner.IPinner
 
+	// node unpins content pinned by the local IPFS node while publishing
+	node pinner.IPinner
+
+	// inventory keeps pins of published repository versions
+	inventory *repository.Inventory
+
+	// retain is the number of the last anchored repository
+	// versions kept pinned, not positive keeps all of them
+	retain int
+
+	// timeout is the maximum time a single pinner request takes
+	timeout time.Duration
+
+	// pipeline pins repository content with the pinning timeout
+	pipeline *pinner.Pipeline
+
+	// policy limits repository files published to IPFS
+	policy *models.ContentPolicy
+
+	// encryption selects repositories published encrypted
+	encryption *models.EncryptionPolicy
+
+	// tree publishes repository trees as UnixFS directories
+	tree *unixfs.Builder
+
+	// objects publishes git objects as git-raw blocks
+	objects *gitraw.Publisher
+
+	// publish is the IPFS API repository bundles are added through
+	publish unixfs.API
+
+	// bundleChain is the maximum number of incremental bundles
+	// published after the full one
+	bundleChain int
+
+	// gateway is the IPFS gateway base URL bundles are advertised
+	// at to protocol v2 clients, empty disables bundle-uri
+	gateway string
+
+	// bundles caches bundle chains of the latest repository metadata
+	bundles bundleLists
+
+	// fetcher fetches published git objects to restore repositories
+	fetcher *gitraw.Fetcher
+
+	// ipfs is the IPFS client used to fetch previously published metadata
+	ipfs *ipfs.Shell
+
 	blockchain *ethclient.Client
 
 	repository repository.IRepository
@@ -67,6 +165,9 @@ type GitService struct {
 
 	chainId *big.Int
 
+	// dryRun computes CIDs without pinning and anchoring them
+	dryRun bool
+
 	stop chan struct{}
 }
 
@@ -75,13 +176,15 @@ type GitService struct {
 func NewGitService(cfg *config.Scheme, blockchain *ethclient.Client) (*GitService, error) {
 	stop := make(chan struct{})
 
-	/*fileSystem, err := fs.NewIPFSFilesystem(cfg.Ipfs.Address, stop)
+	ctx, cancel := context.WithTimeout(context.Background(), storageCheckTimeout)
+	defer cancel()
+
+	backend, err := storage.New(ctx, cfg, stop)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create ipfs filesystem: %w", err)
-	}*/
+		return nil, err
+	}
 
-	fileSystem := osfs.New(cfg.Git.Path)
-	//fileSystem := memfs.New()
+	fileSystem := backend.Filesystem
 
 	contractAddress := common.HexToAddress(cfg.Blockchain.Contract)
 
@@ -100,21 +203,65 @@ func NewGitService(cfg *config.Scheme, blockchain *ethclient.Client) (*GitServic
 		return nil, fmt.Errorf("failed to create signer: %w", err)
 	}
 
-	var pinnerService pinner.IPinner
+	pinnerService, err := newPinner(cfg, stop)
+	if err != nil {
+		return nil, err
+	}
+
+	maxFileSize := cfg.Pinning.MaxFileSize << 20
+
+	policy, err := models.NewContentPolicy(maxFileSize, cfg.Pinning.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pinning configuration: %w", err)
+	}
+
+	encryption, err := models.NewEncryptionPolicy(cfg.Encryption.Repositories, cfg.Encryption.Recipients)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption configuration: %w", err)
+	}
+
+	handles := repository.NewHandles(fileSystem, backend.Open, cache.FileSize(cfg.Git.ObjectsCache)*cache.MiByte, cfg.Git.IdleTimeout)
+	go handles.Run(stop)
+
+	ipfsShell := ipfs.NewShell(cfg.Ipfs.Address)
+	ipfsShell.SetTimeout(metadataFetchTimeout)
+
+	skip := func(path string, size int64) bool {
+		return policy.Skip(path, size) != ""
+	}
+
+	publishShell := ipfs.NewShell(cfg.Ipfs.Address)
+	publishShell.SetTimeout(cfg.Pinning.Timeout)
+
+	var publishAPI unixfs.API = unixfs.NewShellAPI(publishShell)
+
+	if cfg.DryRun {
+		logger.Log().Warning("dry run: content CIDs are computed locally, nothing is pinned or anchored")
 
-	switch cfg.Pinner {
-	case "pinata":
-		pinnerService = pinner.NewPinataPinner(cfg.Pinata.Jwt)
-	case "ipfs":
-		pinnerService = pinner.NewIpfsPinner(cfg.Ipfs.Address)
-	default:
-		return nil, fmt.Errorf("unsupported pinner %s", cfg.Pinner)
+		pinnerService = pinner.NewDryRunPinner()
+		publishAPI = unixfs.NewLocalAPI()
 	}
 
 	return &GitService{
 		baseGitPath:     cfg.Git.Path,
-		fs:              fileSystem,
+		fileSystem:      fileSystem,
+		backend:         backend,
+		handles:         handles,
 		pinner:          pinnerService,
+		node:            pinner.NewIpfsPinner(cfg.Ipfs.Address),
+		inventory:       repository.NewInventory(fileSystem),
+		retain:          cfg.Pinning.Retain,
+		timeout:         cfg.Pinning.Timeout,
+		pipeline:        pinner.NewPipeline(pinnerService, cfg.Pinning.Concurrency, cfg.Pinning.Timeout),
+		policy:          policy,
+		encryption:      encryption,
+		tree:            unixfs.NewBuilder(publishAPI, cfg.Pinning.Concurrency, skip),
+		objects:         gitraw.NewPublisher(publishAPI, cfg.Pinning.Concurrency, maxFileSize),
+		publish:         publishAPI,
+		bundleChain:     cfg.Pinning.BundleChain,
+		gateway:         cfg.Ipfs.Gateway,
+		fetcher:         gitraw.NewFetcher(unixfs.NewShellAPI(ipfsShell), cfg.Pinning.Concurrency),
+		ipfs:            ipfsShell,
 		blockchain:      blockchain,
 		contract:        gitSecContract,
 		repository:      repository.NewRepository(),
@@ -122,9 +269,52 @@ func NewGitService(cfg *config.Scheme, blockchain *ethclient.Client) (*GitServic
 		signer:          sig,
 		stop:            stop,
 		chainId:         chainId,
+		dryRun:          cfg.DryRun,
 	}, nil
 }
 
+// newPinner creates the pinner of the configured comma separated list of
+// pinners. Several pinners are replicated with the configured quorum,
+// replicas still running in the background are stopped with the service.
+func newPinner(cfg *config.Scheme, stop <-chan struct{}) (pinner.IPinner, error) {
+	var replicas []pinner.Replica
+
+	httpOptions := pinner.HTTPOptions{
+		RateLimit: cfg.Pinning.RateLimit,
+		Retries:   cfg.Pinning.Retries,
+	}
+
+	for _, name := range strings.Split(cfg.Pinner, ",") {
+		name = strings.TrimSpace(name)
+
+		var p pinner.IPinner
+
+		switch name {
+		case "pinata":
+			p = pinner.NewPinataPinner(cfg.Pinata.Jwt, httpOptions)
+		case "ipfs":
+			p = pinner.NewIpfsPinner(cfg.Ipfs.Address)
+		case "pinning_service":
+			p = pinner.NewPinningServicePinner(cfg.PinningService.Endpoint, cfg.PinningService.Token, cfg.Ipfs.Address, cfg.Pinning.Timeout, httpOptions)
+		default:
+			return nil, fmt.Errorf("unsupported pinner %s", name)
+		}
+
+		replicas = append(replicas, pinner.Replica{Name: name, Pinner: p})
+	}
+
+	if len(replicas) == 1 {
+		return replicas[0].Pinner, nil
+	}
+
+	p, err := pinner.NewReplicatedPinner(replicas, cfg.Pinning.Quorum, cfg.Pinning.Timeout, stop)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create replicated pinner: %w", err)
+	}
+
+	return p, nil
+}
+
 // TODO move events listeners to separate package
 
 func (g *GitService) StartListener() {
@@ -166,7 +356,7 @@ Subscribe:
 		case r := <-repos:
 			logger.Log().Infof("catch repository forks event: repository %s with ID %d forked from %s created with owner %s", r.RepName, r.RepId, r.Url, r.Owner.Hex())
 
-			if err := g.CloneRepo(r.RepName, r.Description, r.Url, int(r.RepId.Int64()), r.Owner); err != nil {
+			if err := g.CloneRepo(r.RepName, r.Description, r.Url, int(r.RepId.Int64()), r.Owner, r.Raw.TxHash); err != nil {
 				logger.Log().Error(fmt.Errorf("error to fork repository: %w", err))
 			}
 		}
@@ -198,20 +388,33 @@ Subscribe:
 		case r := <-repos:
 			logger.Log().Infof("catch repository creation event: repository %s with ID %d created with owner %s", r.RepName, r.RepId, r.Owner.Hex())
 
-			if err := g.CreateRepo(r.RepName, r.Description, int(r.RepId.Int64()), r.Owner); err != nil {
+			if err := g.CreateRepo(r.RepName, r.Description, int(r.RepId.Int64()), r.Owner, r.Raw.TxHash); err != nil {
 				logger.Log().Error(fmt.Errorf("error to create repository: %w", err))
 			}
 		}
 	}
 }
 
-func (g *GitService) CloneRepo(name, description, forkFrom string, id int, owner common.Address) error {
-	repo, err := models.NewRepo(name, description, g.baseGitPath, forkFrom, id, owner, g.fs)
+// CloneRepo creates the forked repository. The transaction the fork
+// is sent with provides the owner public key for encrypted repositories.
+func (g *GitService) CloneRepo(name, description, forkFrom string, id int, owner common.Address, tx common.Hash) error {
+	repo, err := models.NewRepo(name, description, g.baseGitPath, forkFrom, id, owner)
 	if err != nil {
 		return fmt.Errorf("failed to create new repo: %w", err)
 	}
 
-	if err := g.processNewRepo(repo); err != nil {
+	keys, err := g.newContentKeys(repo, tx)
+	if err != nil {
+		return err
+	}
+
+	release, err := g.handles.Acquire(repo, true)
+	if err != nil {
+		return fmt.Errorf("failed to init repo: %w", err)
+	}
+	defer release()
+
+	if err := g.processNewRepo(repo, keys); err != nil {
 		return fmt.Errorf("process new repo: %w", err)
 	}
 
@@ -222,38 +425,51 @@ func (g *GitService) CloneRepo(name, description, forkFrom string, id int, owner
 	return nil
 }
 
-func (g *GitService) CreateRepo(name, description string, id int, owner common.Address) error {
-	repo, err := models.NewRepo(name, description, g.baseGitPath, "", id, owner, g.fs)
+// CreateRepo creates the repository. The transaction the repository is
+// created with provides the owner public key for encrypted repositories.
+func (g *GitService) CreateRepo(name, description string, id int, owner common.Address, tx common.Hash) error {
+	repo, err := models.NewRepo(name, description, g.baseGitPath, "", id, owner)
 	if err != nil {
 		return fmt.Errorf("failed to create new repo: %w", err)
 	}
 
-	if err := g.processNewRepo(repo); err != nil {
+	keys, err := g.newContentKeys(repo, tx)
+	if err != nil {
+		return err
+	}
+
+	release, err := g.handles.Acquire(repo, true)
+	if err != nil {
+		return fmt.Errorf("failed to init repo: %w", err)
+	}
+	defer release()
+
+	if err := g.processNewRepo(repo, keys); err != nil {
 		return fmt.Errorf("process new repo: %w", err)
 	}
 
 	return nil
 }
 
-func (g *GitService) processNewRepo(repo *models.Repo) error {
+// processNewRepo publishes the metadata of the created repository, the
+// metadata is encrypted with the content keys of encrypted repositories
+func (g *GitService) processNewRepo(repo *models.Repo, keys *contentKeys) error {
 	meta, err := repo.GenMeta()
 	if err != nil {
 		return fmt.Errorf("failed to generate repository meta: %w", err)
 	}
 
-	metaJson, err := json.Marshal(meta)
+	hash, err := g.pinMeta(pinner.PinName(repo.FullName(), "created", "meta.json"), meta, keys)
 	if err != nil {
-		return fmt.Errorf("marshal repository metadata: %w", err)
-	}
-
-	hash, err := g.pinner.Pin(repo.Name+"-meta.json", bytes.NewReader(metaJson))
-	if err != nil {
-		return fmt.Errorf("pin repository metadata to ipfs: %w", err)
+		return err
 	}
 
 	logger.Log().Infof("repository %s metadata %s pinned to IPFS", repo.Name, hash)
 
 	repo.Metadata = hash
+	g.bundles.set(repo, meta.Bundles)
+
+	g.recordVersion(repo, repository.Version{Metadata: hash, Pins: []string{hash}})
 
 	if err := g.repository.CreateRepo(repo); err != nil {
 		return fmt.Errorf("failed to create repository: %w", err)
@@ -261,24 +477,13 @@ func (g *GitService) processNewRepo(repo *models.Repo) error {
 
 	logger.Log().Infof("repository %s ID %d created", repo.Name, repo.ID)
 
-	sign, err := g.signer.Sign(g.chainId)
-	if err != nil {
-		return fmt.Errorf("prepare tx signing: %w", err)
-	}
-
-	tx, err := g.contract.UpdateIPFS(sign, big.NewInt(int64(repo.ID)), hash)
-	if err != nil {
-		return fmt.Errorf("failed to send transaction: %w", err)
-	}
-
-	logger.Log().Infof("transaction %s to update repository %s ID %d metadata %s send to blockchan", tx.Hash().Hex(), repo.Name, repo.ID, hash)
-
-	return nil
+	return g.anchor(repo, hash)
 }
 
-// UploadPack handles Git "git-upload-pack" command
-// and returns UploadPackResponse
-func (g *GitService) UploadPack(ctx context.Context, req io.Reader, repositoryName string) (*packp.UploadPackResponse, error) {
+// UploadPack handles Git "git-upload-pack" command and returns
+// UploadPackResponse. The packfile is streamed from the repository,
+// it's kept acquired until the response is closed.
+func (g *GitService) UploadPack(ctx context.Context, req io.Reader, owner, repositoryName string) (*packp.UploadPackResponse, error) {
 	start := time.Now()
 
 	upr := packp.NewUploadPackRequest()
@@ -287,37 +492,50 @@ func (g *GitService) UploadPack(ctx context.Context, req io.Reader, repositoryNa
 		return nil, fmt.Errorf("failed to decode request: %w", err)
 	}
 
-	repo := &models.Repo{Name: repositoryName}
-
-	if err := g.repository.GetRepo(repo); err != nil {
-		return nil, fmt.Errorf("failed to get repo %s: %w", repositoryName, err)
+	repo, err := g.getRepo(owner, repositoryName)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := repo.InitRepo(g.fs); err != nil {
+	release, err := g.handles.Acquire(repo, false)
+	if err != nil {
 		return nil, fmt.Errorf("failed to init repo %s: %w", repositoryName, err)
 	}
 
 	sess, err := repo.NewUploadPackSession()
 	if err != nil {
+		release()
 		return nil, fmt.Errorf("failed to create new upload pack session to git: %w", err)
 	}
-	defer sess.Close()
 
 	logger.Log().Infof("session created in %s", time.Since(start))
 
 	res, err := sess.UploadPack(ctx, upr)
 	if err != nil {
+		sess.Close()
+		release()
 		return nil, fmt.Errorf("failed to upload pack to git: %w", err)
 	}
 
+	// the packfile is encoded while it's read, so the repository
+	// is kept acquired until the response is closed
+	packfile := &packfileCloser{ReadCloser: res, close: func() {
+		sess.Close()
+		release()
+	}}
+
+	streamed := packp.NewUploadPackResponseWithPackfile(upr, packfile)
+	streamed.ShallowUpdate = res.ShallowUpdate
+	streamed.ServerResponse = res.ServerResponse
+
 	logger.Log().Infof("upload pack handled in %s", time.Since(start))
 
-	return res, nil
+	return streamed, nil
 }
 
 // ReceivePack handles Git "git-receive-pack" command
 // and returns ReportStatus
-func (g *GitService) ReceivePack(ctx context.Context, req io.Reader, repositoryName string) (*packp.ReportStatus, error) {
+func (g *GitService) ReceivePack(ctx context.Context, req io.Reader, owner, repositoryName string) (*packp.ReportStatus, error) {
 	start := time.Now()
 
 	upr := packp.NewReferenceUpdateRequest()
@@ -327,15 +545,16 @@ func (g *GitService) ReceivePack(ctx context.Context, req io.Reader, repositoryN
 
 	}
 
-	repo := &models.Repo{Name: repositoryName}
-
-	if err := g.repository.GetRepo(repo); err != nil {
-		return nil, fmt.Errorf("failed to get repo %s: %w", repositoryName, err)
+	repo, err := g.getRepo(owner, repositoryName)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := repo.InitRepo(g.fs); err != nil {
+	release, err := g.handles.Acquire(repo, true)
+	if err != nil {
 		return nil, fmt.Errorf("failed to init repo %s: %w", repositoryName, err)
 	}
+	defer release()
 
 	sess, err := repo.NewReceivePackSession()
 	if err != nil {
@@ -352,119 +571,40 @@ func (g *GitService) ReceivePack(ctx context.Context, req io.Reader, repositoryN
 
 	logger.Log().Infof("recieve pack handled in %s", time.Since(start))
 
-	if err := g.updateRepositoryMeta(repo); err != nil {
-		return nil, fmt.Errorf("failed to update repository meta: %w", err)
-	}
-
-	return res, nil
-}
-
-func (g *GitService) updateRepositoryMeta(repo *models.Repo) error {
-	head, err := repo.Head()
-	if err != nil {
-		return fmt.Errorf("failed to get repo head: %w", err)
-	}
-
-	tree, err := repo.Tree(head.Hash())
-	if err != nil {
-		return fmt.Errorf("failed to get repo tree: %w", err)
-	}
-
-	meta, err := repo.GenMeta()
-	if err != nil {
-		return fmt.Errorf("failed to generate repository meta: %w", err)
-	}
-
-	if err := meta.FillContent(tree); err != nil {
-		return fmt.Errorf("failed to fill metadata content: %w", err)
-	}
-
-	if err := meta.FillCommit(repo); err != nil {
-		return fmt.Errorf("failed to fill metadata tree commits: %w", err)
-	}
-
-	if err := g.StoreMetaTree(meta, repo); err != nil {
-		return fmt.Errorf("failed to store metadata content: %w", err)
-	}
-
-	metaBytes, err := json.Marshal(meta)
-	if err != nil {
-		return fmt.Errorf("marshal repository metadata: %w", err)
-	}
-
-	hash, err := g.pinner.Pin(fmt.Sprintf("%s-%d-meta.json", repo.Name, time.Now().Unix()), bytes.NewReader(metaBytes))
-	if err != nil {
-		return fmt.Errorf("pin repository metadata to ipfs: %w", err)
-	}
-
-	logger.Log().Infof("repository %s metadata %s pinned to IPFS", repo.Name, hash)
-
-	repo.Metadata = hash
-
-	sign, err := g.signer.Sign(g.chainId)
-	if err != nil {
-		return fmt.Errorf("prepare tx signing: %w", err)
+	// the first push points HEAD of the empty repository to the pushed branch
+	pushed := make([]plumbing.ReferenceName, 0, len(upr.Commands))
+	for _, cmd := range upr.Commands {
+		pushed = append(pushed, cmd.Name)
 	}
 
-	tx, err := g.contract.UpdateIPFS(sign, big.NewInt(int64(repo.ID)), hash)
-	if err != nil {
-		return fmt.Errorf("failed to send transaction: %w", err)
+	if err := repo.InitHead(pushed); err != nil {
+		logger.Log().Error(fmt.Errorf("failed to init repository %s head: %w", repo.Name, err))
 	}
 
-	logger.Log().Infof("transaction %s to update repository %s ID %d metadata %s send to blockchan", tx.Hash().Hex(), repo.Name, repo.ID, hash)
-
-	return nil
-}
-
-func (g *GitService) StoreMetaTree(meta *models.RepoMetadata, repo *models.Repo) error {
-	for _, f := range meta.Tree {
-		hash, err := g.pinner.Pin(fmt.Sprintf("%s-%d.json", meta.Name, time.Now().Unix()), bytes.NewReader([]byte(f.Content)))
-		if err != nil {
-			return fmt.Errorf("pin file %s to ipfs: %w", f.Name, err)
-		}
-
-		logger.Log().Infof("file %s %s pinned to IPFS", f.Name, hash)
-
-		fileMeta := &models.RepoFile{
-			Name:      f.Name,
-			Author:    f.Author,
-			Commit:    f.Commit,
-			Hash:      hash,
-			Timestamp: f.Timestamp,
-		}
-
-		fileJson, err := json.Marshal(fileMeta)
-		if err != nil {
-			return fmt.Errorf("failed to marshal file meta: %w", err)
-		}
-
-		metaHash, err := g.pinner.Pin(fmt.Sprintf("%s-%d-meta.json", meta.Name, time.Now().Unix()), bytes.NewReader(fileJson))
-		if err != nil {
-			return fmt.Errorf("pin file %s metadata to ipfs: %w", f.Name, err)
-		}
-
-		logger.Log().Infof("file %s metadata %s pinned to IPFS", f.Name, metaHash)
-
-		f.Hash = metaHash
+	// the push has already been applied, so metadata publishing
+	// failure is reported without failing the push
+	if err := g.updateRepositoryMeta(repo); err != nil {
+		logger.Log().Error(fmt.Errorf("failed to update repository %s meta: %w", repo.Name, err))
 	}
 
-	return nil
+	return res, nil
 }
 
 // InfoRef retrieves advertised refs for given repository
 // and GitSessionType
-func (g *GitService) InfoRef(ctx context.Context, repositoryName string, infoRefRequestType models.GitSessionType) (*packp.AdvRefs, error) {
+func (g *GitService) InfoRef(ctx context.Context, owner, repositoryName string, infoRefRequestType models.GitSessionType) (*packp.AdvRefs, error) {
 	logger.Log().Infof("handling InfoRef request for repo %s", repositoryName)
 
-	repo := &models.Repo{Name: repositoryName}
-
-	if err := g.repository.GetRepo(repo); err != nil {
-		return nil, fmt.Errorf("failed to get repo %s: %w", repositoryName, err)
+	repo, err := g.getRepo(owner, repositoryName)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := repo.InitRepo(g.fs); err != nil {
+	release, err := g.handles.Acquire(repo, false)
+	if err != nil {
 		return nil, fmt.Errorf("failed to init repo %s: %w", repositoryName, err)
 	}
+	defer release()
 
 	sess, err := repo.NewSessionFromType(infoRefRequestType)
 	if err != nil {
@@ -491,6 +631,34 @@ func (g *GitService) InfoRef(ctx context.Context, repositoryName string, infoRef
 	return ar, nil
 }
 
+// getRepo resolves repository by the given owner and name. If owner is empty,
+// repository is resolved by name only.
+func (g *GitService) getRepo(owner, repositoryName string) (*models.Repo, error) {
+	if err := models.ValidateRepoName(repositoryName); err != nil {
+		return nil, fmt.Errorf("%w: %s", repository.ErrRepoNotFound, err)
+	}
+
+	repo := &models.Repo{Name: repositoryName}
+
+	if owner != "" {
+		if !common.IsHexAddress(owner) {
+			return nil, fmt.Errorf("%w: invalid owner address %q", repository.ErrRepoNotFound, owner)
+		}
+		repo.Owner = common.HexToAddress(owner)
+	}
+
+	if err := g.repository.GetRepo(repo); err != nil {
+		return nil, fmt.Errorf("failed to get repo %s: %w", repositoryName, err)
+	}
+
+	return repo, nil
+}
+
+// Health checks the storage backend repositories are stored in.
+func (g *GitService) Health(ctx context.Context) error {
+	return g.backend.Check(ctx)
+}
+
 func (g *GitService) Close() {
 	close(g.stop)
 }
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadPack_KeepsRepositoryWhileStreaming(t *testing.T) {
+	f := newUploadV2Fixture(t)
+
+	req := strings.NewReader("0032want " + f.second.String() + "\n" + "0000" + "0009done\n")
+
+	res, err := f.g.UploadPack(context.Background(), req, "", "api")
+	require.NoError(t, err)
+
+	// the storage isn't replaced under the streamed packfile
+	reset := make(chan error, 1)
+	go func() {
+		reset <- f.g.handles.Reset(1, func() error { return nil })
+	}()
+
+	select {
+	case <-reset:
+		t.Fatal("the repository is reset while the packfile is streamed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, res.Encode(&buf))
+	require.NoError(t, res.Close(), "closing the encoded response again is harmless")
+
+	select {
+	case err := <-reset:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the repository isn't released once the response is encoded")
+	}
+
+	require.True(t, bytes.HasPrefix(buf.Bytes(), []byte("0008NAK\n")))
+
+	objects := memory.NewStorage()
+	require.NoError(t, packfile.UpdateObjectStorage(objects, bytes.NewReader(buf.Bytes()[8:])))
+	assert.NoError(t, objects.HasEncodedObject(f.first))
+	assert.NoError(t, objects.HasEncodedObject(f.second))
+}
@@ -0,0 +1,396 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/misnaged/annales/logger"
+
+	"gitsec-backend/internal/models"
+	"gitsec-backend/pkg/protov2"
+)
+
+// bundleLists caches bundle chains of the latest repository metadata
+type bundleLists struct {
+	mu    sync.Mutex
+	lists map[int]bundleList
+}
+
+// bundleList is the bundle chain of the metadata
+type bundleList struct {
+	metadata string
+	bundles  []*models.Bundle
+}
+
+func (b *bundleLists) get(repo *models.Repo) ([]*models.Bundle, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	list, ok := b.lists[repo.ID]
+	if !ok || list.metadata != repo.Metadata {
+		return nil, false
+	}
+	return list.bundles, true
+}
+
+func (b *bundleLists) set(repo *models.Repo, bundles []*models.Bundle) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.lists == nil {
+		b.lists = make(map[int]bundleList)
+	}
+	b.lists[repo.ID] = bundleList{metadata: repo.Metadata, bundles: bundles}
+}
+
+// InfoRefV2 returns the protocol v2 capability advertisement
+// of upload-pack. Empty owner resolves repository by its name only.
+func (g *GitService) InfoRefV2(ctx context.Context, owner, repositoryName string) (*protov2.Capabilities, error) {
+	logger.Log().Infof("handling protocol v2 InfoRef request for repo %s", repositoryName)
+
+	if _, err := g.getRepo(owner, repositoryName); err != nil {
+		return nil, err
+	}
+
+	return &protov2.Capabilities{
+		Agent:     capability.DefaultAgent,
+		BundleURI: g.gateway != "",
+	}, nil
+}
+
+// UploadPackV2 handles the protocol v2 upload-pack command and returns its
+// response. Empty owner resolves repository by its name only.
+func (g *GitService) UploadPackV2(ctx context.Context, req io.Reader, owner, repositoryName string) (protov2.Response, error) {
+	cmd, err := protov2.DecodeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log().Infof("handling protocol v2 %s request for repo %s", cmd.Command, repositoryName)
+
+	repo, err := g.getRepo(owner, repositoryName)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cmd.Command {
+	case protov2.CommandLsRefs:
+		return g.lsRefs(repo, cmd.Args)
+	case protov2.CommandFetch:
+		return g.fetch(ctx, repo, cmd.Args)
+	case protov2.CommandBundleURI:
+		if g.gateway == "" {
+			break
+		}
+		return g.bundleURIs(repo), nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", protov2.ErrUnknownCommand, cmd.Command)
+}
+
+// lsRefs lists the repository references, HEAD first
+func (g *GitService) lsRefs(repo *models.Repo, args []string) (protov2.Response, error) {
+	req, err := protov2.ParseLsRefs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := g.handles.Acquire(repo, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init repo %s: %w", repo.Name, err)
+	}
+	defer release()
+
+	s := repo.Repocore.Storer
+
+	iter, err := s.IterReferences()
+	if err != nil {
+		return nil, fmt.Errorf("failed to iter references: %w", err)
+	}
+
+	var refs protov2.LsRefsResponse
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() == plumbing.HEAD || !req.Match(ref.Name()) {
+			return nil
+		}
+
+		listed, ok, err := listedRef(s, req, ref)
+		if ok {
+			refs = append(refs, listed)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list references: %w", err)
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+
+	if req.Match(plumbing.HEAD) {
+		head, err := s.Reference(plumbing.HEAD)
+		if err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil, fmt.Errorf("failed to get HEAD: %w", err)
+		}
+
+		if head != nil {
+			listed, ok, err := listedRef(s, req, head)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list HEAD: %w", err)
+			}
+
+			if ok {
+				refs = append(protov2.LsRefsResponse{listed}, refs...)
+			}
+		}
+	}
+
+	return refs, nil
+}
+
+// listedRef resolves the listed reference. Symbolic reference to the
+// branch with no commits is listed only if unborn references are requested.
+func listedRef(s storer.Storer, req *protov2.LsRefsRequest, ref *plumbing.Reference) (protov2.Ref, bool, error) {
+	listed := protov2.Ref{Name: ref.Name()}
+
+	if ref.Type() == plumbing.SymbolicReference {
+		if req.Symrefs || ref.Name() == plumbing.HEAD && req.Unborn {
+			listed.SymrefTarget = ref.Target()
+		}
+
+		resolved, err := storer.ResolveReference(s, ref.Name())
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return listed, ref.Name() == plumbing.HEAD && req.Unborn, nil
+		}
+		if err != nil {
+			return listed, false, err
+		}
+
+		ref = resolved
+	}
+
+	listed.Hash = ref.Hash()
+
+	if req.Peel && ref.Name().IsTag() {
+		if tag, err := object.GetTag(s, ref.Hash()); err == nil {
+			listed.Peeled = tag.Target
+		}
+	}
+
+	return listed, true, nil
+}
+
+// fetch sends the packfile of objects wanted and not reachable from the
+// common haves. The repository is kept acquired until the packfile is sent.
+func (g *GitService) fetch(ctx context.Context, repo *models.Repo, args []string) (protov2.Response, error) {
+	req, err := protov2.ParseFetch(args)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := g.handles.Acquire(repo, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init repo %s: %w", repo.Name, err)
+	}
+
+	upr := packp.NewUploadPackRequest()
+	upr.Wants = req.Wants
+
+	// haves the server doesn't have can't be excluded
+	for _, hash := range req.Haves {
+		if repo.Repocore.Storer.HasEncodedObject(hash) == nil {
+			upr.Haves = append(upr.Haves, hash)
+		}
+	}
+
+	if req.IncludeTag {
+		tags, err := includedTags(repo.Repocore.Storer, upr.Wants, upr.Haves)
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("failed to find included tags: %w", err)
+		}
+
+		upr.Wants = append(upr.Wants, tags...)
+	}
+
+	if req.OFSDelta {
+		if err := upr.Capabilities.Set(capability.OFSDelta); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	sess, err := repo.NewUploadPackSession()
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("failed to create new upload pack session to git: %w", err)
+	}
+
+	res, err := sess.UploadPack(ctx, upr)
+	if err != nil {
+		sess.Close()
+		release()
+		return nil, fmt.Errorf("failed to upload pack to git: %w", err)
+	}
+
+	return &protov2.FetchResponse{
+		Acknowledge: !req.Done,
+		Common:      upr.Haves,
+		Packfile: &packfileCloser{ReadCloser: res, close: func() {
+			sess.Close()
+			release()
+		}},
+	}, nil
+}
+
+// includedTags returns annotated tags of the commits sent, the tag is sent
+// with the commit it points to unless the client wants it already. Tags
+// of trees and blobs aren't included.
+func includedTags(s storer.Storer, wants, haves []plumbing.Hash) ([]plumbing.Hash, error) {
+	tags := make(map[plumbing.Hash][]plumbing.Hash)
+
+	iter, err := s.IterReferences()
+	if err != nil {
+		return nil, fmt.Errorf("failed to iter references: %w", err)
+	}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if !ref.Name().IsTag() || ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		// lightweight tags point to commits
+		tag, err := object.GetTag(s, ref.Hash())
+		if err != nil {
+			return nil
+		}
+
+		tags[tag.Target] = append(tags[tag.Target], tag.Hash)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tags) == 0 {
+		return nil, nil
+	}
+
+	// commits the client has aren't sent, the walk stops at them
+	seen := make(map[plumbing.Hash]bool)
+	for _, hash := range haves {
+		if err := walkCommits(s, hash, seen, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	wanted := make(map[plumbing.Hash]bool, len(wants))
+	for _, hash := range wants {
+		wanted[hash] = true
+	}
+
+	var included []plumbing.Hash
+
+	for _, hash := range wants {
+		err := walkCommits(s, hash, seen, func(c *object.Commit) {
+			for _, tag := range tags[c.Hash] {
+				if !wanted[tag] {
+					wanted[tag] = true
+					included = append(included, tag)
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return included, nil
+}
+
+// walkCommits walks the commits reachable from the commit or the tag
+// of the hash, the seen commits are skipped. Other objects are ignored.
+func walkCommits(s storer.EncodedObjectStorer, hash plumbing.Hash, seen map[plumbing.Hash]bool, fn func(*object.Commit)) error {
+	obj, err := object.GetObject(s, hash)
+	if err != nil {
+		return fmt.Errorf("failed to get object %s: %w", hash, err)
+	}
+
+	for {
+		tag, ok := obj.(*object.Tag)
+		if !ok {
+			break
+		}
+
+		if obj, err = tag.Object(); err != nil {
+			return fmt.Errorf("failed to get tag %s target: %w", tag.Hash, err)
+		}
+	}
+
+	commit, ok := obj.(*object.Commit)
+	if !ok || seen[commit.Hash] {
+		return nil
+	}
+
+	return object.NewCommitPreorderIter(commit, seen, nil).ForEach(func(c *object.Commit) error {
+		seen[c.Hash] = true
+		if fn != nil {
+			fn(c)
+		}
+		return nil
+	})
+}
+
+// packfileCloser releases the repository once the packfile is closed,
+// the repository is released once however many times it's closed
+type packfileCloser struct {
+	io.ReadCloser
+	close func()
+	once  sync.Once
+}
+
+func (p *packfileCloser) Close() error {
+	err := p.ReadCloser.Close()
+	p.once.Do(p.close)
+	return err
+}
+
+// bundleURIs lists gateway URLs of the bundle chain of the latest
+// repository metadata. Failing to fetch the metadata lists nothing,
+// the client fetches everything from the server then.
+func (g *GitService) bundleURIs(repo *models.Repo) protov2.BundleList {
+	bundles, ok := g.bundles.get(repo)
+	if !ok && repo.Metadata != "" {
+		meta, _, err := g.anchoredMeta(repo)
+		if err != nil {
+			logger.Log().Warningf("failed to list repository %s bundles: %s", repo.Name, err)
+			return nil
+		}
+
+		bundles = meta.Bundles
+		g.bundles.set(repo, bundles)
+	}
+
+	list := make(protov2.BundleList, 0, len(bundles))
+	for i, b := range bundles {
+		list = append(list, protov2.Bundle{
+			ID:            b.CID,
+			URI:           strings.TrimSuffix(g.gateway, "/") + "/ipfs/" + b.CID,
+			CreationToken: i + 1,
+		})
+	}
+
+	return list
+}
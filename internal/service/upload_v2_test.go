@@ -0,0 +1,267 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+	ipfs "github.com/ipfs/go-ipfs-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/internal/models"
+	"gitsec-backend/internal/repository"
+	"gitsec-backend/pkg/protov2"
+)
+
+// uploadV2Fixture is the service serving the repository with two
+// commits on main, the annotated tag of the first one and the branch
+// with no commits HEAD points to
+type uploadV2Fixture struct {
+	g *GitService
+	// first and second are the commits on main
+	first, second plumbing.Hash
+	// tag is the annotated tag v1 of the first commit
+	tag plumbing.Hash
+}
+
+func newUploadV2Fixture(t *testing.T) *uploadV2Fixture {
+	fs := memfs.New()
+
+	dot, err := fs.Chroot("1")
+	require.NoError(t, err)
+
+	worktree := memfs.New()
+	core, err := git.Init(filesystem.NewStorage(dot, cache.NewObjectLRUDefault()), worktree)
+	require.NoError(t, err)
+
+	f := &uploadV2Fixture{}
+
+	wt, err := core.Worktree()
+	require.NoError(t, err)
+
+	commit := func(content string) plumbing.Hash {
+		require.NoError(t, util.WriteFile(worktree, "README", []byte(content), 0644))
+		_, err := wt.Add("README")
+		require.NoError(t, err)
+
+		hash, err := wt.Commit(content, &git.CommitOptions{
+			Author: &object.Signature{Name: "gitsec", Email: "gitsec@example.com", When: time.Now()},
+		})
+		require.NoError(t, err)
+		return hash
+	}
+
+	f.first = commit("first")
+
+	tag, err := core.CreateTag("v1", f.first, &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "gitsec", Email: "gitsec@example.com", When: time.Now()},
+		Message: "v1",
+	})
+	require.NoError(t, err)
+	f.tag = tag.Hash()
+
+	f.second = commit("second")
+
+	require.NoError(t, core.Storer.SetReference(plumbing.NewHashReference("refs/heads/main", f.second)))
+	require.NoError(t, core.Storer.RemoveReference(plumbing.Master))
+	require.NoError(t, core.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/unborn")))
+
+	repos := repository.NewRepository()
+	require.NoError(t, repos.CreateRepo(&models.Repo{ID: 1, Name: "api"}))
+
+	f.g = &GitService{
+		fileSystem: fs,
+		handles:    repository.NewHandles(fs, models.FilesystemStorage, cache.MiByte, time.Minute),
+		repository: repos,
+	}
+
+	return f
+}
+
+// encodeRequest encodes the protocol v2 command request
+func encodeRequest(command string, args ...string) *bytes.Buffer {
+	var req bytes.Buffer
+	pkt := func(line string) {
+		fmt.Fprintf(&req, "%04x%s", len(line)+4, line)
+	}
+
+	pkt("command=" + command + "\n")
+	req.WriteString("0001")
+	for _, arg := range args {
+		pkt(arg + "\n")
+	}
+	req.WriteString("0000")
+
+	return &req
+}
+
+// request sends the protocol v2 command and returns the encoded response
+func (f *uploadV2Fixture) request(t *testing.T, command string, args ...string) []byte {
+	res, err := f.g.UploadPackV2(context.Background(), encodeRequest(command, args...), "", "api")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, res.Encode(&buf))
+
+	return buf.Bytes()
+}
+
+// decodePkts decodes the response into its lines, the sideband
+// packfile data is returned separately
+func decodePkts(t *testing.T, data []byte) (lines []string, pack []byte) {
+	for len(data) > 0 {
+		n, err := strconv.ParseUint(string(data[:4]), 16, 16)
+		require.NoError(t, err)
+
+		if n < 4 {
+			lines = append(lines, string(data[:4]))
+			data = data[4:]
+			continue
+		}
+
+		line := data[4:n]
+		data = data[n:]
+
+		if line[0] == 1 {
+			pack = append(pack, line[1:]...)
+			continue
+		}
+
+		lines = append(lines, strings.TrimSuffix(string(line), "\n"))
+	}
+
+	return lines, pack
+}
+
+func TestUploadPackV2_LsRefs(t *testing.T) {
+	f := newUploadV2Fixture(t)
+
+	lines, _ := decodePkts(t, f.request(t, protov2.CommandLsRefs, "symrefs", "peel", "unborn"))
+	assert.Equal(t, []string{
+		"unborn HEAD symref-target:refs/heads/unborn",
+		f.second.String() + " refs/heads/main",
+		f.tag.String() + " refs/tags/v1 peeled:" + f.first.String(),
+		"0000",
+	}, lines)
+
+	// the unborn HEAD is listed only if requested
+	lines, _ = decodePkts(t, f.request(t, protov2.CommandLsRefs, "peel"))
+	assert.Equal(t, []string{
+		f.second.String() + " refs/heads/main",
+		f.tag.String() + " refs/tags/v1 peeled:" + f.first.String(),
+		"0000",
+	}, lines)
+
+	// the prefixes limit the listed references
+	lines, _ = decodePkts(t, f.request(t, protov2.CommandLsRefs, "ref-prefix refs/heads/"))
+	assert.Equal(t, []string{f.second.String() + " refs/heads/main", "0000"}, lines)
+}
+
+func TestUploadPackV2_Fetch(t *testing.T) {
+	f := newUploadV2Fixture(t)
+
+	unknown := plumbing.NewHash("9d2d7f1d6f6b5bd5c0d44b5e3a0b0fb3e3b1c2d4")
+
+	// the common have is acknowledged, the unknown one isn't
+	lines, pack := decodePkts(t, f.request(t, protov2.CommandFetch,
+		"want "+f.second.String(), "have "+f.first.String(), "have "+unknown.String(), "ofs-delta", "thin-pack"))
+	assert.Equal(t, []string{"acknowledgments", "ACK " + f.first.String(), "ready", "0001", "packfile", "0000"}, lines)
+
+	objects := memory.NewStorage()
+	require.NoError(t, packfile.UpdateObjectStorage(objects, bytes.NewReader(pack)))
+
+	assert.NoError(t, objects.HasEncodedObject(f.second))
+	assert.Error(t, objects.HasEncodedObject(f.first), "the common commit isn't sent")
+
+	// without common haves everything is sent
+	lines, pack = decodePkts(t, f.request(t, protov2.CommandFetch, "want "+f.second.String(), "have "+unknown.String()))
+	assert.Equal(t, []string{"acknowledgments", "NAK", "ready", "0001", "packfile", "0000"}, lines)
+
+	objects = memory.NewStorage()
+	require.NoError(t, packfile.UpdateObjectStorage(objects, bytes.NewReader(pack)))
+	assert.NoError(t, objects.HasEncodedObject(f.first))
+	assert.Error(t, objects.HasEncodedObject(f.tag), "the tag isn't included unless requested")
+
+	// the tag of the sent commit is included
+	lines, pack = decodePkts(t, f.request(t, protov2.CommandFetch, "want "+f.second.String(), "include-tag", "done"))
+	assert.Equal(t, []string{"packfile", "0000"}, lines)
+
+	objects = memory.NewStorage()
+	require.NoError(t, packfile.UpdateObjectStorage(objects, bytes.NewReader(pack)))
+	assert.NoError(t, objects.HasEncodedObject(f.tag))
+
+	// and the tag of the commit the client has isn't
+	_, pack = decodePkts(t, f.request(t, protov2.CommandFetch,
+		"want "+f.second.String(), "have "+f.first.String(), "include-tag", "done"))
+
+	objects = memory.NewStorage()
+	require.NoError(t, packfile.UpdateObjectStorage(objects, bytes.NewReader(pack)))
+	assert.Error(t, objects.HasEncodedObject(f.tag))
+}
+
+func TestUploadPackV2_BundleURI(t *testing.T) {
+	f := newUploadV2Fixture(t)
+
+	meta, err := json.Marshal(&models.RepoMetadata{
+		Bundles: []*models.Bundle{{CID: "QmFull"}, {CID: "QmIncremental"}},
+	})
+	require.NoError(t, err)
+
+	var cats int
+
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v0/cat" || r.URL.Query().Get("arg") != "QmMeta" {
+			http.NotFound(w, r)
+			return
+		}
+
+		cats++
+		_, _ = io.Copy(w, bytes.NewReader(meta))
+	}))
+	defer node.Close()
+
+	f.g.ipfs = ipfs.NewShell(strings.TrimPrefix(node.URL, "http://"))
+	f.g.gateway = "https://gateway.example.com/"
+
+	require.NoError(t, f.g.repository.UpdateMetadata(1, "QmMeta"))
+
+	for i := 0; i < 2; i++ {
+		lines, _ := decodePkts(t, f.request(t, protov2.CommandBundleURI))
+		assert.Equal(t, []string{
+			"bundle.version=1",
+			"bundle.mode=all",
+			"bundle.heuristic=creationToken",
+			"bundle.QmFull.uri=https://gateway.example.com/ipfs/QmFull",
+			"bundle.QmFull.creationToken=1",
+			"bundle.QmIncremental.uri=https://gateway.example.com/ipfs/QmIncremental",
+			"bundle.QmIncremental.creationToken=2",
+			"0000",
+		}, lines)
+	}
+
+	assert.Equal(t, 1, cats, "the bundle list of the metadata is cached")
+
+	// bundles aren't listed without the gateway
+	f.g.gateway = ""
+
+	_, err = f.g.UploadPackV2(context.Background(), encodeRequest(protov2.CommandBundleURI), "", "api")
+	assert.ErrorIs(t, err, protov2.ErrUnknownCommand)
+}
@@ -0,0 +1,201 @@
+// Package snapshot restores published repository snapshots from IPFS.
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+
+	"gitsec-backend/internal/models"
+	"gitsec-backend/pkg/envelope"
+)
+
+// MetadataFile is the name of the decrypted metadata file
+const MetadataFile = "metadata.json"
+
+// ErrNotEncrypted is returned when decrypting plain metadata
+var ErrNotEncrypted = errors.New("metadata is not encrypted")
+
+// Fetcher fetches content from IPFS, ipfs.Shell implements it.
+type Fetcher interface {
+	Cat(path string) (io.ReadCloser, error)
+}
+
+// Unwrapper decrypts the content key wrapped to its
+// account, signer.Signer implements it.
+type Unwrapper interface {
+	Unwrap(recipients []envelope.Recipient) ([]byte, error)
+}
+
+// Decrypt fetches the encrypted repository snapshot with the given metadata
+// CID, decrypts it with the content key unwrapped by the wallet key and
+// writes it to the out directory: the metadata to MetadataFile and the
+// files to their paths. Files not published are left out.
+func Decrypt(ipfs Fetcher, wallet Unwrapper, metadata, out string) (*models.RepoMetadata, error) {
+	r, err := ipfs.Cat(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("fetch metadata %s: %w", metadata, err)
+	}
+	defer r.Close()
+
+	_, encrypted, err := models.DecodeMetadata(r)
+	if err != nil {
+		return nil, fmt.Errorf("metadata %s: %w", metadata, err)
+	}
+
+	if encrypted == nil {
+		return nil, fmt.Errorf("%s: %w", metadata, ErrNotEncrypted)
+	}
+
+	key, err := wallet.Unwrap(encrypted.Recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := encrypted.Open(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return nil, fmt.Errorf("create output directory: %w", err)
+	}
+
+	raw, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal metadata: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(out, MetadataFile), raw, 0o644); err != nil {
+		return nil, fmt.Errorf("write metadata: %w", err)
+	}
+
+	// symlinks are created after all other files,
+	// so none of the files is written through them
+	var links []*models.RepoFile
+
+	for _, f := range meta.Tree {
+		if f.Hash == "" {
+			continue
+		}
+
+		if mode, _ := filemode.New(f.Mode); mode == filemode.Symlink {
+			links = append(links, f)
+			continue
+		}
+
+		if err := decryptFile(ipfs, key, f, out); err != nil {
+			return nil, fmt.Errorf("decrypt %s: %w", f.Name, err)
+		}
+	}
+
+	for _, f := range links {
+		if err := decryptFile(ipfs, key, f, out); err != nil {
+			return nil, fmt.Errorf("decrypt %s: %w", f.Name, err)
+		}
+	}
+
+	return meta, nil
+}
+
+// decryptFile fetches, decrypts and writes the file to the out directory
+func decryptFile(ipfs Fetcher, key []byte, f *models.RepoFile, out string) error {
+	target := filepath.Join(out, filepath.FromSlash(f.Name))
+
+	// paths come from the metadata, they must not escape the out directory
+	if !within(out, target) {
+		return fmt.Errorf("invalid path %q", f.Name)
+	}
+
+	if err := checkNoSymlinks(out, target); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	r, err := ipfs.Cat(f.Hash)
+	if err != nil {
+		return fmt.Errorf("fetch %s: %w", f.Hash, err)
+	}
+	defer r.Close()
+
+	content, err := envelope.Decrypt(key, r)
+	if err != nil {
+		return err
+	}
+
+	mode, _ := filemode.New(f.Mode)
+
+	if mode == filemode.Symlink {
+		link, err := io.ReadAll(content)
+		if err != nil {
+			return err
+		}
+
+		dest := filepath.FromSlash(string(link))
+		if filepath.IsAbs(dest) || !within(out, filepath.Join(filepath.Dir(target), dest)) {
+			return fmt.Errorf("symlink target %q is outside the output directory", link)
+		}
+
+		return os.Symlink(dest, target)
+	}
+
+	perm := os.FileMode(0o644)
+	if mode == filemode.Executable {
+		perm = 0o755
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// within checks whether the path is inside the dir
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkNoSymlinks checks none of the existing elements of the
+// target path below the out directory is a symlink
+func checkNoSymlinks(out, target string) error {
+	rel, err := filepath.Rel(out, target)
+	if err != nil {
+		return err
+	}
+
+	path := out
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		path = filepath.Join(path, elem)
+
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink", path)
+		}
+	}
+
+	return nil
+}
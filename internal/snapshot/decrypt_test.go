@@ -0,0 +1,159 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/internal/models"
+	"gitsec-backend/pkg/envelope"
+)
+
+// fakeIPFS serves content by path
+type fakeIPFS map[string][]byte
+
+func (f fakeIPFS) Cat(path string) (io.ReadCloser, error) {
+	data, ok := f[path]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", path)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// wallet unwraps content keys with the private key
+type wallet struct {
+	private *ecdsa.PrivateKey
+}
+
+func (w wallet) Unwrap(recipients []envelope.Recipient) ([]byte, error) {
+	return envelope.Unwrap(recipients, w.private)
+}
+
+func TestDecrypt(t *testing.T) {
+	owner, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	key, err := envelope.NewKey()
+	require.NoError(t, err)
+
+	ipfs := fakeIPFS{}
+
+	seal := func(name, content string) string {
+		sealed, err := envelope.Seal(key, []byte(content))
+		require.NoError(t, err)
+		ipfs[name] = sealed
+		return name
+	}
+
+	meta := &models.RepoMetadata{Name: "secret", Tree: []*models.RepoFile{
+		{Name: "README.md", Mode: "0100644", Hash: seal("readme", "# secret")},
+		{Name: "bin/run.sh", Mode: "0100755", Hash: seal("run", "#!/bin/sh")},
+		{Name: "data.zip", Mode: "0100644", Skipped: models.SkipExcluded},
+	}}
+
+	recipients, err := envelope.Wrap(key, []*ecdsa.PublicKey{&owner.PublicKey})
+	require.NoError(t, err)
+
+	encrypted, err := models.SealMetadata(meta, key, recipients)
+	require.NoError(t, err)
+
+	ipfs["meta"], err = json.Marshal(encrypted)
+	require.NoError(t, err)
+
+	out := t.TempDir()
+
+	decrypted, err := Decrypt(ipfs, wallet{owner}, "meta", out)
+	require.NoError(t, err)
+	assert.Equal(t, meta, decrypted)
+
+	readme, err := os.ReadFile(filepath.Join(out, "README.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "# secret", string(readme))
+
+	info, err := os.Stat(filepath.Join(out, "bin", "run.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o755), info.Mode().Perm())
+
+	assert.FileExists(t, filepath.Join(out, MetadataFile))
+	assert.NoFileExists(t, filepath.Join(out, "data.zip"))
+
+	stranger, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	_, err = Decrypt(ipfs, wallet{stranger}, "meta", t.TempDir())
+	assert.ErrorIs(t, err, envelope.ErrNotRecipient)
+
+	ipfs["plain"], err = json.Marshal(meta)
+	require.NoError(t, err)
+
+	_, err = Decrypt(ipfs, wallet{owner}, "plain", t.TempDir())
+	assert.ErrorIs(t, err, ErrNotEncrypted)
+}
+
+func TestDecrypt_Symlinks(t *testing.T) {
+	owner, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	key, err := envelope.NewKey()
+	require.NoError(t, err)
+
+	recipients, err := envelope.Wrap(key, []*ecdsa.PublicKey{&owner.PublicKey})
+	require.NoError(t, err)
+
+	decrypt := func(out string, files map[string][2]string) error {
+		t.Helper()
+
+		ipfs := fakeIPFS{}
+		meta := &models.RepoMetadata{Name: "secret"}
+
+		for name, f := range files {
+			sealed, err := envelope.Seal(key, []byte(f[1]))
+			require.NoError(t, err)
+
+			ipfs[name] = sealed
+			meta.Tree = append(meta.Tree, &models.RepoFile{Name: name, Mode: f[0], Hash: name})
+		}
+
+		encrypted, err := models.SealMetadata(meta, key, recipients)
+		require.NoError(t, err)
+
+		ipfs["meta"], err = json.Marshal(encrypted)
+		require.NoError(t, err)
+
+		_, err = Decrypt(ipfs, wallet{owner}, "meta", out)
+		return err
+	}
+
+	out := t.TempDir()
+	require.NoError(t, decrypt(out, map[string][2]string{
+		"README.md": {"0100644", "# secret"},
+		"docs/link": {"0120000", "../README.md"},
+	}))
+
+	link, err := os.Readlink(filepath.Join(out, "docs", "link"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("..", "README.md"), link)
+
+	for _, target := range []string{"../../outside", "/etc/passwd"} {
+		err = decrypt(t.TempDir(), map[string][2]string{"link": {"0120000", target}})
+		assert.ErrorContains(t, err, "outside the output directory", target)
+	}
+
+	// files aren't written through symlinks existing in the output directory
+	outside := t.TempDir()
+	out = t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(out, "escape")))
+
+	err = decrypt(out, map[string][2]string{"escape/x": {"0100644", "pwned"}})
+	assert.ErrorContains(t, err, "is a symlink")
+	assert.NoFileExists(t, filepath.Join(outside, "x"))
+}
@@ -0,0 +1,144 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/ipfs/go-cid"
+
+	"gitsec-backend/internal/models"
+	"gitsec-backend/pkg/bundle"
+	"gitsec-backend/pkg/gitraw"
+)
+
+// defaultBranch is the branch restored if the metadata doesn't record it
+const defaultBranch = "main"
+
+// ErrNoObjects is returned when git objects of the snapshot are not
+// published, e.g. for encrypted repositories
+var ErrNoObjects = errors.New("git objects are not published")
+
+// Restored is the result of the snapshot restore.
+type Restored struct {
+	// Bundles is the number of applied bundles
+	Bundles int
+	// Fetched is the number of objects fetched from git-raw blocks
+	Fetched int
+	// Missing are reachable objects restored neither from
+	// bundles nor from git-raw blocks as they were not published
+	Missing []plumbing.Hash
+}
+
+// Restore restores the git repository from its published metadata into
+// the storage. The bundle chain is applied first, it restores the full
+// history and all references. Objects reachable from the commit are then
+// fetched from their git-raw blocks, unless the bundles have already
+// restored them, and verified against their hashes. The branch and HEAD
+// are pointed to the commit. Metadata of the repository without commits
+// restores nothing.
+func Restore(ctx context.Context, fetcher *gitraw.Fetcher, files Fetcher, meta *models.RepoMetadata, s storage.Storer) (*Restored, error) {
+	res := &Restored{}
+
+	if err := restoreBundles(files, meta.Bundles, s); err != nil {
+		return nil, err
+	}
+	res.Bundles = len(meta.Bundles)
+
+	if !plumbing.IsHash(meta.Commit) {
+		return res, nil
+	}
+
+	if meta.CommitCID == "" {
+		return nil, fmt.Errorf("commit %s: %w", meta.Commit, ErrNoObjects)
+	}
+
+	c, err := cid.Decode(meta.CommitCID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid commit CID %q: %w", meta.CommitCID, err)
+	}
+
+	head, err := gitraw.Hash(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if head.String() != meta.Commit {
+		return nil, fmt.Errorf("commit CID %s doesn't match the metadata commit %s", meta.CommitCID, meta.Commit)
+	}
+
+	unpublished := make([]plumbing.Hash, 0, len(meta.SkippedObjects))
+	for _, hash := range meta.SkippedObjects {
+		unpublished = append(unpublished, plumbing.NewHash(hash))
+	}
+
+	fetched, err := fetcher.Fetch(ctx, s, head, unpublished)
+	if err != nil {
+		return nil, fmt.Errorf("fetch objects of %s: %w", head, err)
+	}
+	res.Fetched, res.Missing = fetched.Fetched, fetched.Missing
+
+	commit, err := object.GetCommit(s, head)
+	if err != nil {
+		return nil, fmt.Errorf("restored commit %s: %w", head, err)
+	}
+
+	if _, err := commit.Tree(); err != nil {
+		return nil, fmt.Errorf("restored commit %s tree: %w", head, err)
+	}
+
+	branch := meta.Branch
+	if branch == "" {
+		branch = defaultBranch
+	}
+
+	ref := plumbing.NewBranchReferenceName(branch)
+
+	if err := s.SetReference(plumbing.NewHashReference(ref, head)); err != nil {
+		return nil, fmt.Errorf("set %s: %w", ref, err)
+	}
+
+	if err := s.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, ref)); err != nil {
+		return nil, fmt.Errorf("set HEAD: %w", err)
+	}
+
+	return res, nil
+}
+
+// restoreBundles applies the bundle chain to the storage and sets
+// the bundled references, HEAD is left to be set by the caller
+func restoreBundles(files Fetcher, bundles []*models.Bundle, s storage.Storer) error {
+	var header *bundle.Header
+
+	for _, b := range bundles {
+		r, err := files.Cat(b.CID)
+		if err != nil {
+			return fmt.Errorf("fetch bundle %s: %w", b.CID, err)
+		}
+
+		header, err = bundle.Unbundle(s, r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("apply bundle %s: %w", b.CID, err)
+		}
+	}
+
+	if header == nil {
+		return nil
+	}
+
+	for _, ref := range header.References {
+		if ref.Name() == plumbing.HEAD {
+			continue
+		}
+
+		if err := s.SetReference(ref); err != nil {
+			return fmt.Errorf("set %s: %w", ref.Name(), err)
+		}
+	}
+
+	return nil
+}
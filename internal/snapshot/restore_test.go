@@ -0,0 +1,221 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/internal/models"
+	"gitsec-backend/pkg/bundle"
+	"gitsec-backend/pkg/gitraw"
+)
+
+// blocks is in-memory git-raw blocks API
+type blocks struct {
+	mu     sync.Mutex
+	blocks map[cid.Cid][]byte
+}
+
+func (b *blocks) BlockPut(_ context.Context, data []byte, codec uint64) (cid.Cid, error) {
+	sum := sha1.Sum(data)
+
+	mhash, err := mh.Encode(sum[:], mh.SHA1)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	c := cid.NewCidV1(codec, mhash)
+
+	b.mu.Lock()
+	b.blocks[c] = data
+	b.mu.Unlock()
+
+	return c, nil
+}
+
+func (b *blocks) BlockGet(_ context.Context, c cid.Cid) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, ok := b.blocks[c]
+	if !ok {
+		return nil, fmt.Errorf("block %s not found", c)
+	}
+	return data, nil
+}
+
+func (b *blocks) Pin(context.Context, cid.Cid) error {
+	return nil
+}
+
+func (b *blocks) PinBlock(context.Context, cid.Cid) error {
+	return nil
+}
+
+func TestRestore(t *testing.T) {
+	storage := memory.NewStorage()
+	repo, err := git.Init(storage, memfs.New())
+	require.NoError(t, err)
+
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	var head plumbing.Hash
+	for i, content := range []string{"a", strings.Repeat("x", 1024)} {
+		name := fmt.Sprintf("%d.txt", i)
+		require.NoError(t, util.WriteFile(wt.Filesystem, name, []byte(content), 0644))
+		_, err = wt.Add(name)
+		require.NoError(t, err)
+
+		head, err = wt.Commit("commit", &git.CommitOptions{
+			Author: &object.Signature{Name: "alice", When: time.Unix(1700000000, 0)},
+		})
+		require.NoError(t, err)
+	}
+
+	api := &blocks{blocks: make(map[cid.Cid][]byte)}
+
+	published, err := gitraw.NewPublisher(api, 2, 512).Publish(context.Background(), storage, head, nil, false)
+	require.NoError(t, err)
+
+	meta := &models.RepoMetadata{
+		Commit:         head.String(),
+		CommitCID:      published.Commit.String(),
+		Branch:         "develop",
+		SkippedObjects: []string{published.Skipped[0].String()},
+	}
+
+	restored := memory.NewStorage()
+
+	res, err := Restore(context.Background(), gitraw.NewFetcher(api, 2), fakeIPFS{}, meta, restored)
+	require.NoError(t, err)
+	assert.Equal(t, published.Skipped, res.Missing)
+
+	ref, err := restored.Reference(plumbing.HEAD)
+	require.NoError(t, err)
+	assert.Equal(t, plumbing.NewBranchReferenceName("develop"), ref.Target())
+
+	ref, err = restored.Reference(ref.Target())
+	require.NoError(t, err)
+	assert.Equal(t, head, ref.Hash())
+
+	// commit CID of another commit doesn't restore
+	meta.Commit = plumbing.ZeroHash.String()
+	_, err = Restore(context.Background(), gitraw.NewFetcher(api, 2), fakeIPFS{}, meta, memory.NewStorage())
+	assert.Error(t, err)
+
+	meta.Commit, meta.CommitCID = head.String(), ""
+	_, err = Restore(context.Background(), gitraw.NewFetcher(api, 2), fakeIPFS{}, meta, memory.NewStorage())
+	assert.ErrorIs(t, err, ErrNoObjects)
+
+	// nothing to restore without commits
+	res, err = Restore(context.Background(), gitraw.NewFetcher(api, 2), fakeIPFS{}, &models.RepoMetadata{Commit: "repository created"}, memory.NewStorage())
+	require.NoError(t, err)
+	assert.Zero(t, res.Fetched)
+}
+
+func TestRestore_Bundles(t *testing.T) {
+	storage := memory.NewStorage()
+	repo, err := git.Init(storage, memfs.New())
+	require.NoError(t, err)
+
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	commit := func(name string) plumbing.Hash {
+		require.NoError(t, util.WriteFile(wt.Filesystem, name, []byte(name), 0644))
+		_, err = wt.Add(name)
+		require.NoError(t, err)
+
+		hash, err := wt.Commit(name, &git.CommitOptions{
+			Author: &object.Signature{Name: "alice", When: time.Unix(1700000000, 0)},
+		})
+		require.NoError(t, err)
+		return hash
+	}
+
+	ipfs := fakeIPFS{}
+
+	var chain []*models.Bundle
+	publish := func(name string) {
+		refs, err := bundle.References(storage)
+		require.NoError(t, err)
+
+		var exclude []plumbing.Hash
+		if len(chain) != 0 {
+			for _, hash := range chain[len(chain)-1].Refs {
+				exclude = append(exclude, plumbing.NewHash(hash))
+			}
+		}
+
+		var buf bytes.Buffer
+		_, err = bundle.Create(&buf, storage, refs, exclude)
+		require.NoError(t, err)
+
+		b := &models.Bundle{CID: name, Refs: make(map[string]string)}
+		for _, ref := range refs {
+			b.Refs[ref.Name().String()] = ref.Hash().String()
+		}
+
+		ipfs[name] = buf.Bytes()
+		chain = append(chain, b)
+	}
+
+	first := commit("a.txt")
+	publish("full")
+
+	require.NoError(t, wt.Checkout(&git.CheckoutOptions{Branch: "refs/heads/feature", Create: true}))
+	feature := commit("b.txt")
+
+	require.NoError(t, wt.Checkout(&git.CheckoutOptions{Branch: plumbing.Master}))
+	head := commit("c.txt")
+	publish("incremental")
+
+	api := &blocks{blocks: make(map[cid.Cid][]byte)}
+
+	published, err := gitraw.NewPublisher(api, 2, 0).Publish(context.Background(), storage, head, []plumbing.Hash{first}, false)
+	require.NoError(t, err)
+
+	meta := &models.RepoMetadata{
+		Commit:    head.String(),
+		CommitCID: published.Commit.String(),
+		Branch:    "master",
+		Bundles:   chain,
+	}
+
+	restored := memory.NewStorage()
+
+	res, err := Restore(context.Background(), gitraw.NewFetcher(api, 2), ipfs, meta, restored)
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.Bundles)
+	// everything is restored from bundles
+	assert.Zero(t, res.Fetched)
+
+	ref, err := restored.Reference("refs/heads/feature")
+	require.NoError(t, err)
+	assert.Equal(t, feature, ref.Hash())
+
+	_, err = object.GetCommit(restored, first)
+	assert.NoError(t, err)
+
+	// broken chain fails the restore
+	meta.Bundles = chain[1:]
+	_, err = Restore(context.Background(), gitraw.NewFetcher(api, 2), ipfs, meta, memory.NewStorage())
+	assert.ErrorIs(t, err, bundle.ErrMissingPrerequisite)
+}
@@ -0,0 +1,356 @@
+// Package storage builds the filesystem repositories are stored in
+// from the git.storage configuration and checks the backend serving
+// it is healthy, on startup and while the service runs.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	gitstorage "github.com/go-git/go-git/v5/storage"
+	"github.com/misnaged/annales/logger"
+
+	"gitsec-backend/config"
+	"gitsec-backend/internal/models"
+	fs "gitsec-backend/pkg/fs-ipfs"
+	"gitsec-backend/pkg/gitstore"
+	"gitsec-backend/pkg/ipfsclient"
+)
+
+// storage backends selected by git.storage
+const (
+	// OS stores repositories in the local directory
+	OS = "os"
+	// Memory keeps repositories in memory, they are lost on restart
+	Memory = "memory"
+	// IPFS adds file contents to the IPFS node and journals
+	// the file index in the local directory
+	IPFS = "ipfs"
+	// MFS stores repositories in the IPFS node Mutable File System
+	MFS = "mfs"
+)
+
+// repository storers selected by git.storer
+const (
+	// Files stores repositories as files the way git stores them
+	Files = "filesystem"
+	// Blocks stores objects as blocks in the blocks directory
+	// of the filesystem shared by repositories
+	Blocks = "blocks"
+	// IPFSBlocks stores objects as git-raw blocks in the IPFS node
+	IPFSBlocks = "ipfs"
+)
+
+// blocksDir is the directory of the filesystem blocks are stored in
+const blocksDir = ".blocks"
+
+// probeFile is the prefix of the files written to check
+// the local directory is writable
+const probeFile = ".health-"
+
+// storerFile records the storer repositories of the filesystem are stored with
+const storerFile = ".storer"
+
+// Backend is the filesystem repositories are stored in
+// together with the health check of the backend serving it.
+type Backend struct {
+	// Name is the git.storage backend name
+	Name string
+	// Filesystem is the filesystem repositories are stored in
+	Filesystem billy.Filesystem
+	// Open opens storages of repositories stored in the Filesystem
+	Open models.OpenStorage
+
+	// check checks the backend is healthy
+	check func(ctx context.Context) error
+}
+
+// New validates the git.storage configuration, builds the configured
+// backend and checks it's healthy, so the misconfigured or unreachable
+// backend fails the startup instead of the first push. The IPFS
+// filesystem index is saved once stop is closed.
+func New(ctx context.Context, cfg *config.Scheme, stop chan struct{}) (*Backend, error) {
+	var (
+		b   *Backend
+		err error
+	)
+
+	switch cfg.Git.Storage {
+	case OS:
+		b, err = newOS(cfg.Git.Path)
+	case Memory:
+		b, err = newMemory()
+	case IPFS:
+		if cfg.Ipfs.Address == "" {
+			return nil, errors.New("ipfs address is required by ipfs git storage")
+		}
+		b, err = newIPFS(ctx, ipfsclient.NewShell(cfg.Ipfs.Address), cfg.Git.Path, cfg.Ipfs.CacheSize<<20, stop)
+	case MFS:
+		if cfg.Ipfs.Address == "" {
+			return nil, errors.New("ipfs address is required by mfs git storage")
+		}
+		b, err = newMFS(ctx, ipfsclient.NewShell(cfg.Ipfs.Address), cfg.Git.Path)
+	default:
+		return nil, fmt.Errorf("unsupported git storage %s", cfg.Git.Storage)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s git storage: %w", cfg.Git.Storage, err)
+	}
+
+	if err := b.setStorer(ctx, cfg); err != nil {
+		return nil, fmt.Errorf("invalid git storer: %w", err)
+	}
+
+	if err := checkStorer(b.Filesystem, cfg.Git.Storer); err != nil {
+		return nil, fmt.Errorf("invalid git storer: %w", err)
+	}
+
+	if err := b.Check(ctx); err != nil {
+		return nil, err
+	}
+
+	logger.Log().Infof("git storage %s with %s storer is ready", b.Name, cfg.Git.Storer)
+
+	return b, nil
+}
+
+// Check checks the backend is healthy: the local directory is
+// writable and the IPFS node is reachable.
+func (b *Backend) Check(ctx context.Context) error {
+	if err := b.check(ctx); err != nil {
+		return fmt.Errorf("git storage %s is unhealthy: %w", b.Name, err)
+	}
+	return nil
+}
+
+// setStorer sets the storer repositories are opened with, the
+// IPFS node objects are stored in is checked with the backend
+func (b *Backend) setStorer(ctx context.Context, cfg *config.Scheme) error {
+	switch cfg.Git.Storer {
+	case Files:
+		b.Open = models.FilesystemStorage
+		return nil
+	case Blocks:
+		blocks, err := b.Filesystem.Chroot(blocksDir)
+		if err != nil {
+			return fmt.Errorf("failed to open blocks directory: %w", err)
+		}
+		b.Open = blocksStorage(gitstore.NewFSBlockstore(blocks))
+		return nil
+	case IPFSBlocks:
+		if cfg.Ipfs.Address == "" {
+			return errors.New("ipfs address is required by ipfs git storer")
+		}
+
+		client := ipfsclient.NewShell(cfg.Ipfs.Address)
+		if err := checkNode(ctx, client); err != nil {
+			return err
+		}
+
+		check := b.check
+		b.check = func(ctx context.Context) error {
+			if err := checkNode(ctx, client); err != nil {
+				return err
+			}
+			return check(ctx)
+		}
+
+		b.Open = blocksStorage(gitstore.NewIPFSBlockstore(client))
+		return nil
+	default:
+		return fmt.Errorf("unsupported git storer %s", cfg.Git.Storer)
+	}
+}
+
+// checkStorer checks repositories of the filesystem are stored with the
+// storer, the storer is recorded once the filesystem is used first time.
+// Repositories stored with another storer aren't found by it, they'd be
+// initialized empty over the existing ones.
+func checkStorer(fs billy.Filesystem, storer string) error {
+	recorded, err := util.ReadFile(fs, storerFile)
+	if err == nil {
+		if used := strings.TrimSpace(string(recorded)); used != storer {
+			return fmt.Errorf("repositories are stored with the %s storer, %s can't open them", used, storer)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", storerFile, err)
+	}
+
+	// repositories stored before the storer is recorded are stored as files
+	if storer != Files {
+		dir, err := filesRepository(fs)
+		if err != nil {
+			return err
+		}
+		if dir != "" {
+			return fmt.Errorf("repository %s is stored with the %s storer, %s can't open it", dir, Files, storer)
+		}
+	}
+
+	if err := util.WriteFile(fs, storerFile, []byte(storer+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to record git storer: %w", err)
+	}
+
+	return nil
+}
+
+// filesRepository returns the first directory of the filesystem
+// holding a repository stored as files, the one with HEAD file
+func filesRepository(fs billy.Filesystem) (string, error) {
+	entries, err := fs.ReadDir("")
+	if err != nil {
+		return "", fmt.Errorf("failed to list repositories: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		if _, err := fs.Stat(fs.Join(entry.Name(), "HEAD")); err == nil {
+			return entry.Name(), nil
+		} else if !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to check repository %s: %w", entry.Name(), err)
+		}
+	}
+
+	return "", nil
+}
+
+// blocksStorage opens storages of repositories keeping
+// objects in the blockstore
+func blocksStorage(blocks gitstore.Blockstore) models.OpenStorage {
+	return func(fs billy.Filesystem, objects cache.Object) (gitstorage.Storer, error) {
+		return gitstore.NewStorage(blocks, fs, objects)
+	}
+}
+
+// newOS creates the backend of the local directory, the directory
+// is created if it's missing
+func newOS(dir string) (*Backend, error) {
+	if dir == "" {
+		return nil, errors.New("git path is required")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create git path: %w", err)
+	}
+
+	return &Backend{
+		Name:       OS,
+		Filesystem: osfs.New(dir),
+		check: func(context.Context) error {
+			return checkDir(dir)
+		},
+	}, nil
+}
+
+// newMemory creates the in-memory backend, it's always healthy
+func newMemory() (*Backend, error) {
+	logger.Log().Warning("git repositories are stored in memory, they are lost on restart")
+
+	return &Backend{
+		Name:       Memory,
+		Filesystem: memfs.New(),
+		check: func(context.Context) error {
+			return nil
+		},
+	}, nil
+}
+
+// newIPFS creates the backend of the IPFS filesystem, its index and
+// contents cache are kept in the local directory. The node is checked
+// before the index is restored, since the index may be fetched from it.
+func newIPFS(ctx context.Context, client ipfsclient.Client, dir string, cacheSize int64, stop chan struct{}) (*Backend, error) {
+	if dir == "" {
+		return nil, errors.New("git path is required for the filesystem index")
+	}
+
+	if cacheSize < 0 {
+		return nil, fmt.Errorf("negative ipfs cache size %d", cacheSize)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create git path: %w", err)
+	}
+
+	check := func(ctx context.Context) error {
+		if err := checkNode(ctx, client); err != nil {
+			return err
+		}
+		return checkDir(dir)
+	}
+
+	if err := check(ctx); err != nil {
+		return nil, err
+	}
+
+	fileSystem, err := fs.NewIPFSFilesystem(client, dir, cacheSize, stop)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ipfs filesystem: %w", err)
+	}
+
+	return &Backend{Name: IPFS, Filesystem: fileSystem, check: check}, nil
+}
+
+// newMFS creates the backend of the MFS filesystem rooted at the
+// directory within the node MFS
+func newMFS(ctx context.Context, client ipfsclient.Client, dir string) (*Backend, error) {
+	if err := checkNode(ctx, client); err != nil {
+		return nil, err
+	}
+
+	fileSystem, err := fs.NewMFSFilesystem(client, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mfs filesystem: %w", err)
+	}
+
+	root := path.Join("/", filepath.ToSlash(dir))
+
+	return &Backend{
+		Name:       MFS,
+		Filesystem: fileSystem,
+		check: func(ctx context.Context) error {
+			if _, err := client.FilesStat(ctx, root); err != nil {
+				return fmt.Errorf("failed to stat mfs root %s: %w", root, err)
+			}
+			return nil
+		},
+	}, nil
+}
+
+// checkNode checks the IPFS node API is reachable
+func checkNode(ctx context.Context, client ipfsclient.Client) error {
+	if _, err := client.FilesStat(ctx, "/"); err != nil {
+		return fmt.Errorf("ipfs node is unreachable: %w", err)
+	}
+	return nil
+}
+
+// checkDir checks the local directory is writable
+func checkDir(dir string) error {
+	f, err := os.CreateTemp(dir, probeFile)
+	if err != nil {
+		return fmt.Errorf("git path %s is not writable: %w", dir, err)
+	}
+
+	name := f.Name()
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("git path %s is not writable: %w", dir, err)
+	}
+
+	return os.Remove(name)
+}
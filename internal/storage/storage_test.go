@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/config"
+	"gitsec-backend/pkg/ipfsclient"
+	"gitsec-backend/pkg/ipfsclient/fakeipfs"
+)
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+
+	for _, name := range []string{OS, Memory} {
+		cfg := &config.Scheme{Git: &config.Git{Path: filepath.Join(t.TempDir(), "repos"), Storage: name, Storer: Files}}
+
+		b, err := New(ctx, cfg, make(chan struct{}))
+		require.NoError(t, err, name)
+		assert.Equal(t, name, b.Name)
+		assert.NoError(t, b.Check(ctx))
+
+		require.NoError(t, util.WriteFile(b.Filesystem, "1/HEAD", []byte("ref: refs/heads/main\n"), 0644))
+		entries, err := b.Filesystem.ReadDir("")
+		require.NoError(t, err)
+
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		assert.ElementsMatch(t, []string{"1", storerFile}, names, "%s probe files are removed", name)
+	}
+
+	// objects are stored in the blocks directory
+	cfg := &config.Scheme{Git: &config.Git{Path: t.TempDir(), Storage: Memory, Storer: Blocks}}
+	b, err := New(ctx, cfg, nil)
+	require.NoError(t, err)
+
+	fs, err := b.Filesystem.Chroot("1")
+	require.NoError(t, err)
+	s, err := b.Open(fs, cache.NewObjectLRUDefault())
+	require.NoError(t, err)
+
+	hash, err := s.SetEncodedObject(blob("content"))
+	require.NoError(t, err)
+	_, err = b.Filesystem.Stat(b.Filesystem.Join(blocksDir, hash.String()[:2], hash.String()[2:]))
+	assert.NoError(t, err)
+
+	_, err = New(ctx, &config.Scheme{Git: &config.Git{Path: t.TempDir(), Storage: Memory, Storer: "sql"}}, nil)
+	assert.EqualError(t, err, "invalid git storer: unsupported git storer sql")
+
+	_, err = New(ctx, &config.Scheme{Git: &config.Git{Path: t.TempDir(), Storage: "s3"}}, nil)
+	assert.EqualError(t, err, "unsupported git storage s3")
+
+	_, err = New(ctx, &config.Scheme{Git: &config.Git{Storage: OS, Storer: Files}}, nil)
+	assert.Error(t, err, "git path is required")
+
+	_, err = New(ctx, &config.Scheme{Git: &config.Git{Path: t.TempDir(), Storage: IPFS, Storer: Files}, Ipfs: &config.Ipfs{}}, nil)
+	assert.Error(t, err, "ipfs address is required")
+}
+
+func TestNew_StorerMismatch(t *testing.T) {
+	ctx := context.Background()
+
+	// the storer is recorded on the first start
+	dir := t.TempDir()
+	_, err := New(ctx, &config.Scheme{Git: &config.Git{Path: dir, Storage: OS, Storer: Blocks}}, nil)
+	require.NoError(t, err)
+
+	_, err = New(ctx, &config.Scheme{Git: &config.Git{Path: dir, Storage: OS, Storer: Blocks}}, nil)
+	require.NoError(t, err)
+
+	_, err = New(ctx, &config.Scheme{Git: &config.Git{Path: dir, Storage: OS, Storer: Files}}, nil)
+	assert.EqualError(t, err, "invalid git storer: repositories are stored with the blocks storer, filesystem can't open them")
+
+	// repositories stored before the storer is recorded are stored as files
+	dir = t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "7"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "7", "HEAD"), []byte("ref: refs/heads/main\n"), 0644))
+
+	_, err = New(ctx, &config.Scheme{Git: &config.Git{Path: dir, Storage: OS, Storer: Blocks}}, nil)
+	assert.EqualError(t, err, "invalid git storer: repository 7 is stored with the filesystem storer, blocks can't open it")
+
+	_, err = New(ctx, &config.Scheme{Git: &config.Git{Path: dir, Storage: OS, Storer: Files}}, nil)
+	require.NoError(t, err)
+}
+
+func TestBackend_Check(t *testing.T) {
+	ctx := context.Background()
+
+	// the removed directory fails the check
+	dir := filepath.Join(t.TempDir(), "repos")
+	b, err := newOS(dir)
+	require.NoError(t, err)
+	require.NoError(t, b.Check(ctx))
+
+	require.NoError(t, os.RemoveAll(dir))
+	assert.Error(t, b.Check(ctx))
+
+	// IPFS backends check the node
+	node := fakeipfs.NewNode()
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	b, err = newIPFS(ctx, node, t.TempDir(), 1<<20, stop)
+	require.NoError(t, err)
+	assert.NoError(t, b.Check(ctx))
+
+	b, err = newMFS(ctx, node, ".repos")
+	require.NoError(t, err)
+	assert.NoError(t, b.Check(ctx))
+
+	require.NoError(t, node.FilesRm(ctx, "/.repos", true))
+	assert.Error(t, b.Check(ctx), "the mfs root is removed")
+
+	// the unreachable node fails the startup
+	unreachable := ipfsclient.NewShell("127.0.0.1:1")
+
+	_, err = newIPFS(ctx, unreachable, t.TempDir(), 0, stop)
+	assert.ErrorContains(t, err, "ipfs node is unreachable")
+
+	_, err = newMFS(ctx, unreachable, ".repos")
+	assert.ErrorContains(t, err, "ipfs node is unreachable")
+}
+
+func blob(content string) plumbing.EncodedObject {
+	obj := &plumbing.MemoryObject{}
+	obj.SetType(plumbing.BlobObject)
+	_, _ = obj.Write([]byte(content))
+	return obj
+}
@@ -0,0 +1,235 @@
+// Package bundle writes and reads git bundles. A bundle is a header listing
+// references and prerequisite commits followed by a packfile, incremental
+// bundles only carry objects not reachable from their prerequisites, so a
+// chain of them applied in order rebuilds the full repository.
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// signature is the first line of the v2 bundle
+const signature = "# v2 git bundle"
+
+// packWindow is the delta compression window of the bundle packfile
+const packWindow = 10
+
+var (
+	// ErrInvalid is returned when the bundle can't be parsed
+	ErrInvalid = errors.New("invalid git bundle")
+
+	// ErrNoReferences is returned when there is nothing to bundle
+	ErrNoReferences = errors.New("no references to bundle")
+
+	// ErrMissingPrerequisite is returned when the bundle is applied to
+	// the storage without the commits the bundle requires
+	ErrMissingPrerequisite = errors.New("missing bundle prerequisite")
+)
+
+// Header is the bundle header.
+type Header struct {
+	// Prerequisites are commits the bundle requires
+	Prerequisites []plumbing.Hash
+	// References are the bundled references, HEAD included
+	References []*plumbing.Reference
+}
+
+// References returns the references worth bundling: branches and tags
+// and the commit HEAD resolves to.
+func References(s storer.ReferenceStorer) ([]*plumbing.Reference, error) {
+	iter, err := s.IterReferences()
+	if err != nil {
+		return nil, fmt.Errorf("iter references: %w", err)
+	}
+
+	var refs []*plumbing.Reference
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && (ref.Name().IsBranch() || ref.Name().IsTag()) {
+			refs = append(refs, ref)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("iter references: %w", err)
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name() < refs[j].Name()
+	})
+
+	head, err := storer.ResolveReference(s, plumbing.HEAD)
+	if err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, fmt.Errorf("resolve HEAD: %w", err)
+	}
+
+	if head != nil {
+		refs = append([]*plumbing.Reference{plumbing.NewHashReference(plumbing.HEAD, head.Hash())}, refs...)
+	}
+
+	return refs, nil
+}
+
+// Create writes the bundle of the references. Objects reachable from
+// the excluded hashes are left out, commits among them, tags peeled,
+// are listed as the bundle prerequisites.
+func Create(w io.Writer, s storer.EncodedObjectStorer, refs []*plumbing.Reference, exclude []plumbing.Hash) (*Header, error) {
+	if len(refs) == 0 {
+		return nil, ErrNoReferences
+	}
+
+	header := &Header{References: refs}
+
+	seen := make(map[plumbing.Hash]bool)
+	for _, hash := range exclude {
+		commit, ok := peel(s, hash)
+		if ok && !seen[commit] {
+			seen[commit] = true
+			header.Prerequisites = append(header.Prerequisites, commit)
+		}
+	}
+
+	tips := make([]plumbing.Hash, 0, len(refs))
+	for _, ref := range refs {
+		tips = append(tips, ref.Hash())
+	}
+
+	hashes, err := revlist.Objects(s, tips, exclude)
+	if err != nil {
+		return nil, fmt.Errorf("list objects: %w", err)
+	}
+
+	bw := bufio.NewWriter(w)
+
+	if err := header.write(bw); err != nil {
+		return nil, err
+	}
+
+	if _, err := packfile.NewEncoder(bw, s, false).Encode(hashes, packWindow); err != nil {
+		return nil, fmt.Errorf("encode packfile: %w", err)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	return header, nil
+}
+
+// peel returns the commit the object is, or the annotated tag points to
+func peel(s storer.EncodedObjectStorer, hash plumbing.Hash) (plumbing.Hash, bool) {
+	for {
+		obj, err := object.GetObject(s, hash)
+		if err != nil {
+			return plumbing.ZeroHash, false
+		}
+
+		switch o := obj.(type) {
+		case *object.Commit:
+			return o.Hash, true
+		case *object.Tag:
+			hash = o.Target
+		default:
+			return plumbing.ZeroHash, false
+		}
+	}
+}
+
+func (h *Header) write(w io.Writer) error {
+	var buf bytes.Buffer
+
+	buf.WriteString(signature + "\n")
+
+	for _, hash := range h.Prerequisites {
+		fmt.Fprintf(&buf, "-%s\n", hash)
+	}
+
+	for _, ref := range h.References {
+		fmt.Fprintf(&buf, "%s %s\n", ref.Hash(), ref.Name())
+	}
+
+	buf.WriteString("\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ReadHeader reads the bundle header, the reader is left at the packfile.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+
+	if strings.TrimSuffix(line, "\n") != signature {
+		return nil, fmt.Errorf("%w: unsupported signature %q", ErrInvalid, strings.TrimSpace(line))
+	}
+
+	header := &Header{}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalid, err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return header, nil
+		}
+
+		if prerequisite := strings.TrimPrefix(line, "-"); prerequisite != line {
+			// prerequisite may be followed by the commit subject
+			hash, _, _ := strings.Cut(prerequisite, " ")
+			if !plumbing.IsHash(hash) {
+				return nil, fmt.Errorf("%w: invalid prerequisite %q", ErrInvalid, line)
+			}
+
+			header.Prerequisites = append(header.Prerequisites, plumbing.NewHash(hash))
+			continue
+		}
+
+		hash, name, ok := strings.Cut(line, " ")
+		if !ok || !plumbing.IsHash(hash) || name == "" {
+			return nil, fmt.Errorf("%w: invalid reference %q", ErrInvalid, line)
+		}
+
+		header.References = append(header.References, plumbing.NewHashReference(plumbing.ReferenceName(name), plumbing.NewHash(hash)))
+	}
+}
+
+// Unbundle reads the bundle objects into the storage. The storage must
+// contain the bundle prerequisites. References are returned in the
+// header, they are not set.
+func Unbundle(s storer.Storer, r io.Reader) (*Header, error) {
+	br := bufio.NewReader(r)
+
+	header, err := ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hash := range header.Prerequisites {
+		if err := s.HasEncodedObject(hash); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrMissingPrerequisite, hash)
+		}
+	}
+
+	if err := packfile.UpdateObjectStorage(s, br); err != nil {
+		return nil, fmt.Errorf("read packfile: %w", err)
+	}
+
+	return header, nil
+}
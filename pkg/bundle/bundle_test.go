@@ -0,0 +1,135 @@
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func commit(t *testing.T, repo *git.Repository, name string) plumbing.Hash {
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	require.NoError(t, util.WriteFile(wt.Filesystem, name, []byte(name), 0644))
+	_, err = wt.Add(name)
+	require.NoError(t, err)
+
+	hash, err := wt.Commit(name, &git.CommitOptions{
+		Author: &object.Signature{Name: "alice", When: time.Unix(1700000000, 0)},
+	})
+	require.NoError(t, err)
+
+	return hash
+}
+
+func TestCreateUnbundle(t *testing.T) {
+	storage := memory.NewStorage()
+	repo, err := git.Init(storage, memfs.New())
+	require.NoError(t, err)
+
+	first := commit(t, repo, "a.txt")
+
+	_, err = repo.CreateTag("v1", first, &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "alice", When: time.Unix(1700000000, 0)},
+		Message: "v1",
+	})
+	require.NoError(t, err)
+
+	refs, err := References(storage)
+	require.NoError(t, err)
+	require.Len(t, refs, 3)
+	assert.Equal(t, plumbing.NewHashReference(plumbing.HEAD, first), refs[0])
+	assert.Equal(t, plumbing.Master, refs[1].Name())
+
+	var full bytes.Buffer
+	header, err := Create(&full, storage, refs, nil)
+	require.NoError(t, err)
+	assert.Empty(t, header.Prerequisites)
+
+	read, err := ReadHeader(bufio.NewReader(bytes.NewReader(full.Bytes())))
+	require.NoError(t, err)
+	assert.Equal(t, header, read)
+
+	second := commit(t, repo, "b.txt")
+
+	next, err := References(storage)
+	require.NoError(t, err)
+
+	var exclude []plumbing.Hash
+	for _, ref := range refs {
+		exclude = append(exclude, ref.Hash())
+	}
+
+	var incremental bytes.Buffer
+	header, err = Create(&incremental, storage, next, exclude)
+	require.NoError(t, err)
+	// the annotated tag is peeled to its commit
+	assert.Equal(t, []plumbing.Hash{first}, header.Prerequisites)
+	assert.Less(t, incremental.Len(), full.Len())
+
+	restored := filesystem.NewStorage(memfs.New(), cache.NewObjectLRUDefault())
+
+	_, err = Unbundle(restored, bytes.NewReader(incremental.Bytes()))
+	assert.ErrorIs(t, err, ErrMissingPrerequisite)
+
+	_, err = Unbundle(restored, bytes.NewReader(full.Bytes()))
+	require.NoError(t, err)
+
+	header, err = Unbundle(restored, bytes.NewReader(incremental.Bytes()))
+	require.NoError(t, err)
+
+	for _, ref := range header.References {
+		require.NoError(t, restored.SetReference(ref))
+	}
+
+	restoredRepo, err := git.Open(restored, nil)
+	require.NoError(t, err)
+
+	commits, err := restoredRepo.Log(&git.LogOptions{From: second})
+	require.NoError(t, err)
+
+	var n int
+	require.NoError(t, commits.ForEach(func(c *object.Commit) error {
+		n++
+		_, err := c.Tree()
+		return err
+	}))
+	assert.Equal(t, 2, n)
+
+	tag, err := restoredRepo.Tag("v1")
+	require.NoError(t, err)
+	_, err = restoredRepo.TagObject(tag.Hash())
+	assert.NoError(t, err)
+}
+
+func TestReadHeader_Invalid(t *testing.T) {
+	hash := plumbing.NewHash("9d2d7f1d6f6b5bd5c0d44b5e3a0b0fb3e3b1c2d4")
+
+	for _, header := range []string{
+		"# v3 git bundle\n\n",
+		signature + "\n",
+		signature + "\n-xyz\n\n",
+		fmt.Sprintf("%s\n%s\n\n", signature, hash),
+	} {
+		_, err := ReadHeader(bufio.NewReader(bytes.NewReader([]byte(header))))
+		assert.ErrorIs(t, err, ErrInvalid, header)
+	}
+
+	header, err := ReadHeader(bufio.NewReader(bytes.NewReader([]byte(fmt.Sprintf("%s\n-%s subject\n%s refs/heads/main\n\n", signature, hash, hash)))))
+	require.NoError(t, err)
+	assert.Equal(t, []plumbing.Hash{hash}, header.Prerequisites)
+	assert.Equal(t, plumbing.ReferenceName("refs/heads/main"), header.References[0].Name())
+}
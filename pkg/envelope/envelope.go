@@ -0,0 +1,244 @@
+// Package envelope implements envelope encryption of repository content.
+// Content is encrypted with a random symmetric content key, which is
+// wrapped to the secp256k1 public keys of its recipients with ECIES, so
+// any of them can decrypt it with their wallet key.
+package envelope
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// KeySize is the size of content keys, AES-256 keys
+	KeySize = 32
+
+	// Cipher is the name of the content encryption scheme
+	Cipher = "aes-256-gcm-hkdf-stream-64k"
+
+	// segmentSize is the size of plaintext segments sealed separately,
+	// so content is encrypted and decrypted in constant memory
+	segmentSize = 64 << 10
+
+	// saltSize is the size of the random salt written at the start of the
+	// stream. Every stream is sealed with its own key derived from the
+	// content key and the salt, so nonces of streams sealed with the same
+	// content key never collide. The nonce is the segment counter and
+	// the last segment flag.
+	saltSize = 32
+)
+
+// streamKeyInfo binds stream keys derived from content keys to this scheme
+var streamKeyInfo = []byte("gitsec envelope stream key")
+
+// ErrCorrupted is returned when encrypted content is
+// tampered with, truncated or decrypted with a wrong key
+var ErrCorrupted = errors.New("encrypted content is corrupted")
+
+// NewKey generates a new random content key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("generate content key: %w", err)
+	}
+	return key, nil
+}
+
+// Encrypt returns the reader of the content encrypted with the key. The
+// content is split into segments sealed with AES-GCM under a key derived
+// for the stream, the last segment is marked, so the truncated content
+// doesn't decrypt.
+func Encrypt(key []byte, r io.Reader) (io.Reader, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("generate salt: %w", err)
+	}
+
+	aead, err := newAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &stream{r: r, aead: aead, buf: make([]byte, segmentSize)}
+
+	// the salt is sent first, the decrypting side needs it
+	s.out = salt
+	s.next = s.seal
+
+	return s, nil
+}
+
+// Decrypt returns the reader of the content encrypted by Encrypt.
+// Reading fails with ErrCorrupted if the content isn't authentic.
+func Decrypt(key []byte, r io.Reader) (io.Reader, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid content key size %d", len(key))
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return nil, fmt.Errorf("%w: read salt: %s", ErrCorrupted, err)
+	}
+
+	aead, err := newAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &stream{r: r, aead: aead, buf: make([]byte, segmentSize+aead.Overhead())}
+	s.next = s.open
+
+	return s, nil
+}
+
+// Seal encrypts the data with the key.
+func Seal(key, data []byte) ([]byte, error) {
+	r, err := Encrypt(key, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// Open decrypts the data sealed with the key.
+func Open(key, data []byte) ([]byte, error) {
+	r, err := Decrypt(key, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// stream seals or opens content segment by segment
+type stream struct {
+	r    io.Reader
+	aead cipher.AEAD
+	// nonce is the big endian segment counter and the last segment flag
+	nonce [12]byte
+	// counter is the number of the next segment
+	counter uint32
+	// buf is the segment read, seg is the segment processed
+	buf []byte
+	seg []byte
+
+	// out is the processed data not read yet
+	out []byte
+	// next processes the next segment into out
+	next func() error
+	// done is set once the last segment is processed
+	done bool
+	err  error
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+
+		if s.done {
+			return 0, io.EOF
+		}
+
+		s.err = s.next()
+	}
+
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+
+	return n, nil
+}
+
+// seal reads and seals the next plaintext segment. The last segment is the
+// one shorter than the segment size, the empty one for aligned content.
+func (s *stream) seal() error {
+	n, err := io.ReadFull(s.r, s.buf)
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		s.done = true
+	case err != nil:
+		return fmt.Errorf("read content: %w", err)
+	}
+
+	nonce, err := s.nonceFor(s.done)
+	if err != nil {
+		return err
+	}
+
+	s.seg = s.aead.Seal(s.seg[:0], nonce, s.buf[:n], nil)
+	s.out = s.seg
+
+	return nil
+}
+
+// open reads and opens the next sealed segment, the shorter one is the last
+func (s *stream) open() error {
+	n, err := io.ReadFull(s.r, s.buf)
+	switch {
+	case errors.Is(err, io.EOF):
+		return fmt.Errorf("%w: last segment is missing", ErrCorrupted)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		s.done = true
+	case err != nil:
+		return fmt.Errorf("read encrypted content: %w", err)
+	}
+
+	nonce, err := s.nonceFor(s.done)
+	if err != nil {
+		return err
+	}
+
+	if s.seg, err = s.aead.Open(s.seg[:0], nonce, s.buf[:n], nil); err != nil {
+		return fmt.Errorf("%w: segment %d: %s", ErrCorrupted, s.counter-1, err)
+	}
+
+	s.out = s.seg
+
+	return nil
+}
+
+// nonceFor returns the nonce of the next segment and advances the counter
+func (s *stream) nonceFor(last bool) ([]byte, error) {
+	if s.counter == ^uint32(0) {
+		return nil, errors.New("content is too large to encrypt")
+	}
+
+	binary.BigEndian.PutUint32(s.nonce[len(s.nonce)-5:], s.counter)
+	s.nonce[len(s.nonce)-1] = 0
+	if last {
+		s.nonce[len(s.nonce)-1] = 1
+	}
+
+	s.counter++
+
+	return s.nonce[:], nil
+}
+
+// newAEAD creates AES-GCM with the stream key derived
+// from the content key and the stream salt with HKDF
+func newAEAD(key, salt []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid content key size %d", len(key))
+	}
+
+	streamKey := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, streamKeyInfo), streamKey); err != nil {
+		return nil, fmt.Errorf("derive stream key: %w", err)
+	}
+
+	block, err := aes.NewCipher(streamKey)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
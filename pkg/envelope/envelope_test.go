@@ -0,0 +1,153 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := NewKey()
+	require.NoError(t, err)
+
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 100} {
+		data := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(data)
+
+		sealed, err := Seal(key, data)
+		require.NoError(t, err)
+		assert.Len(t, sealed, saltSize+size+(size/segmentSize+1)*16)
+
+		opened, err := Open(key, sealed)
+		require.NoError(t, err, "size %d", size)
+		assert.True(t, bytes.Equal(data, opened), "size %d", size)
+	}
+}
+
+func TestSeal_StreamKeys(t *testing.T) {
+	key, err := NewKey()
+	require.NoError(t, err)
+
+	data := []byte("gitsec")
+
+	first, err := Seal(key, data)
+	require.NoError(t, err)
+
+	second, err := Seal(key, data)
+	require.NoError(t, err)
+
+	// streams sealed with the same content key don't share keystream
+	assert.NotEqual(t, first[:saltSize], second[:saltSize])
+	assert.NotEqual(t, first[saltSize:], second[saltSize:])
+
+	// salt is authenticated through the derived key
+	tampered := append([]byte{}, first...)
+	tampered[0] ^= 1
+	_, err = Open(key, tampered)
+	assert.ErrorIs(t, err, ErrCorrupted)
+}
+
+func TestOpen_Corrupted(t *testing.T) {
+	key, err := NewKey()
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte("gitsec"), segmentSize)
+
+	sealed, err := Seal(key, data)
+	require.NoError(t, err)
+
+	t.Run("tampered", func(t *testing.T) {
+		tampered := append([]byte{}, sealed...)
+		tampered[len(tampered)/2] ^= 1
+
+		_, err := Open(key, tampered)
+		assert.ErrorIs(t, err, ErrCorrupted)
+	})
+
+	t.Run("truncated at segment boundary", func(t *testing.T) {
+		_, err := Open(key, sealed[:saltSize+segmentSize+16])
+		assert.ErrorIs(t, err, ErrCorrupted)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		other, err := NewKey()
+		require.NoError(t, err)
+
+		_, err = Open(other, sealed)
+		assert.ErrorIs(t, err, ErrCorrupted)
+	})
+}
+
+func TestEncrypt_Streaming(t *testing.T) {
+	key, err := NewKey()
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte("0123456789"), segmentSize)
+
+	enc, err := Encrypt(key, bytes.NewReader(data))
+	require.NoError(t, err)
+
+	dec, err := Decrypt(key, enc)
+	require.NoError(t, err)
+
+	// small reads cross segment boundaries
+	var out bytes.Buffer
+	_, err = io.CopyBuffer(&out, struct{ io.Reader }{dec}, make([]byte, 1000))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, out.Bytes()))
+}
+
+func TestWrapUnwrap(t *testing.T) {
+	owner, collaborator, stranger := newPrivateKey(t), newPrivateKey(t), newPrivateKey(t)
+
+	key, err := NewKey()
+	require.NoError(t, err)
+
+	recipients, err := Wrap(key, []*ecdsa.PublicKey{&owner.PublicKey, &collaborator.PublicKey, &owner.PublicKey})
+	require.NoError(t, err)
+	require.Len(t, recipients, 2)
+	assert.Equal(t, crypto.PubkeyToAddress(owner.PublicKey), recipients[0].Address)
+
+	for _, private := range []*ecdsa.PrivateKey{owner, collaborator} {
+		unwrapped, err := Unwrap(recipients, private)
+		require.NoError(t, err)
+		assert.Equal(t, key, unwrapped)
+	}
+
+	_, err = Unwrap(recipients, stranger)
+	assert.ErrorIs(t, err, ErrNotRecipient)
+
+	pubs, err := PublicKeys(recipients)
+	require.NoError(t, err)
+	assert.True(t, owner.PublicKey.Equal(pubs[0]))
+
+	recipients[1].Address = crypto.PubkeyToAddress(stranger.PublicKey)
+	_, err = PublicKeys(recipients)
+	assert.Error(t, err)
+}
+
+func TestParsePublicKey(t *testing.T) {
+	private := newPrivateKey(t)
+
+	for _, raw := range [][]byte{crypto.CompressPubkey(&private.PublicKey), crypto.FromECDSAPub(&private.PublicKey)} {
+		pub, err := ParsePublicKey(hex.EncodeToString(raw))
+		require.NoError(t, err)
+		assert.True(t, private.PublicKey.Equal(pub))
+	}
+
+	_, err := ParsePublicKey("0x1234")
+	assert.Error(t, err)
+}
+
+func newPrivateKey(t *testing.T) *ecdsa.PrivateKey {
+	private, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	return private
+}
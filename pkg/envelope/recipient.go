@@ -0,0 +1,115 @@
+package envelope
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+)
+
+// ErrNotRecipient is returned when the content key
+// isn't wrapped to the given private key
+var ErrNotRecipient = errors.New("not a recipient of the content key")
+
+// Recipient is the content key wrapped to the recipient public key.
+type Recipient struct {
+	// Address is the recipient account address
+	Address common.Address `json:"address"`
+	// PublicKey is the compressed secp256k1 public key
+	PublicKey hexutil.Bytes `json:"public_key"`
+	// Key is the content key encrypted with ECIES
+	Key hexutil.Bytes `json:"key"`
+}
+
+// Wrap wraps the content key to every public key,
+// duplicated keys are wrapped once.
+func Wrap(key []byte, pubs []*ecdsa.PublicKey) ([]Recipient, error) {
+	var recipients []Recipient
+	seen := make(map[common.Address]bool, len(pubs))
+
+	for _, pub := range pubs {
+		address := crypto.PubkeyToAddress(*pub)
+		if seen[address] {
+			continue
+		}
+		seen[address] = true
+
+		wrapped, err := ecies.Encrypt(rand.Reader, ecies.ImportECDSAPublic(pub), key, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("wrap content key to %s: %w", address.Hex(), err)
+		}
+
+		recipients = append(recipients, Recipient{
+			Address:   address,
+			PublicKey: crypto.CompressPubkey(pub),
+			Key:       wrapped,
+		})
+	}
+
+	return recipients, nil
+}
+
+// Unwrap decrypts the content key wrapped to the private key.
+func Unwrap(recipients []Recipient, private *ecdsa.PrivateKey) ([]byte, error) {
+	address := crypto.PubkeyToAddress(private.PublicKey)
+
+	for _, r := range recipients {
+		if r.Address != address {
+			continue
+		}
+
+		key, err := ecies.ImportECDSA(private).Decrypt(r.Key, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unwrap content key: %w", err)
+		}
+
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("unwrap content key: invalid key size %d", len(key))
+		}
+
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNotRecipient, address.Hex())
+}
+
+// PublicKeys returns public keys of the recipients.
+func PublicKeys(recipients []Recipient) ([]*ecdsa.PublicKey, error) {
+	pubs := make([]*ecdsa.PublicKey, 0, len(recipients))
+
+	for _, r := range recipients {
+		pub, err := crypto.DecompressPubkey(r.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("recipient %s: invalid public key: %w", r.Address.Hex(), err)
+		}
+
+		if crypto.PubkeyToAddress(*pub) != r.Address {
+			return nil, fmt.Errorf("recipient %s: public key doesn't match the address", r.Address.Hex())
+		}
+
+		pubs = append(pubs, pub)
+	}
+
+	return pubs, nil
+}
+
+// ParsePublicKey parses hex encoded secp256k1 public
+// key, either compressed or uncompressed.
+func ParsePublicKey(s string) (*ecdsa.PublicKey, error) {
+	raw, err := hexutil.Decode("0x" + strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("decode public key: %w", err)
+	}
+
+	if len(raw) == 33 {
+		return crypto.DecompressPubkey(raw)
+	}
+
+	return crypto.UnmarshalPubkey(raw)
+}
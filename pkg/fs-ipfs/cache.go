@@ -0,0 +1,203 @@
+package fs
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// cacheDir is the directory of the content cache within the index directory
+const cacheDir = ".ipfs-cache"
+
+// diskCache is the size-bounded local disk cache of IPFS contents keyed by
+// their IPFS paths. IPFS paths address the content, so cached contents are
+// never invalidated, the least recently used are evicted once the cache
+// is over its limit. Cached files are read in ranges, so contents read
+// partially are never loaded into memory entirely.
+type diskCache struct {
+	dir string
+	// limit is the maximum size of cached contents in bytes
+	limit int64
+
+	mu sync.Mutex
+	// size is the size of cached contents in bytes
+	size int64
+	// lru orders cached contents from the most recently used
+	lru *list.List
+	// entries are elements of lru by their keys
+	entries map[string]*list.Element
+}
+
+// cacheEntry is the cached content
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+// newDiskCache opens the cache in the directory, contents cached
+// before are kept in the order of their modification times.
+func newDiskCache(dir string, limit int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	c := &diskCache{
+		dir:     dir,
+		limit:   limit,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	infos := make([]os.FileInfo, 0, len(dirEntries))
+	for _, entry := range dirEntries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		// contents fetched when the process stopped are incomplete
+		if strings.HasPrefix(info.Name(), ".fetch-") {
+			_ = os.Remove(filepath.Join(dir, info.Name()))
+			continue
+		}
+
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+
+	for _, info := range infos {
+		c.entries[info.Name()] = c.lru.PushBack(&cacheEntry{key: info.Name(), size: info.Size()})
+		c.size += info.Size()
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+
+	return c, nil
+}
+
+// Open opens the cached content of the IPFS path, the content missing
+// in the cache is fetched with fetch and streamed to the disk.
+func (c *diskCache) Open(ipfsPath string, fetch func() (io.ReadCloser, error)) (*os.File, error) {
+	key := cacheKey(ipfsPath)
+
+	c.mu.Lock()
+	f, err := c.open(key)
+	c.mu.Unlock()
+	if err == nil {
+		return f, nil
+	}
+
+	tmp, size, err := c.fetch(fetch)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the content fetched concurrently is the same
+	if _, ok := c.entries[key]; ok {
+		_ = os.Remove(tmp)
+		return c.open(key)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(c.dir, key)); err != nil {
+		_ = os.Remove(tmp)
+		return nil, fmt.Errorf("failed to cache %s: %w", ipfsPath, err)
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, size: size})
+	c.size += size
+
+	// the opened content stays readable when it's evicted
+	f, err = c.open(key)
+	c.evict()
+
+	return f, err
+}
+
+// open opens the cached content and marks it as recently used.
+// The cache must be locked.
+func (c *diskCache) open(key string) (*os.File, error) {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	f, err := os.Open(filepath.Join(c.dir, key))
+	if err != nil {
+		// the content removed from the disk is fetched again
+		c.remove(e)
+		return nil, err
+	}
+
+	c.lru.MoveToFront(e)
+
+	return f, nil
+}
+
+// fetch streams the content to the temporary file in the cache directory
+func (c *diskCache) fetch(fetch func() (io.ReadCloser, error)) (string, int64, error) {
+	r, err := fetch()
+	if err != nil {
+		return "", 0, err
+	}
+	defer r.Close()
+
+	tmp, err := os.CreateTemp(c.dir, ".fetch-")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create cache file: %w", err)
+	}
+
+	size, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", 0, fmt.Errorf("failed to fetch content: %w", err)
+	}
+
+	return tmp.Name(), size, nil
+}
+
+// evict removes the least recently used contents until the cache fits
+// its limit, the most recently used content is kept even if it's over
+// the limit alone. The cache must be locked.
+func (c *diskCache) evict() {
+	for c.size > c.limit && c.lru.Len() > 1 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes the content from the cache. The cache must be locked.
+func (c *diskCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+
+	_ = os.Remove(filepath.Join(c.dir, entry.key))
+}
+
+// cacheKey is the file name of the cached content of the IPFS path
+func cacheKey(ipfsPath string) string {
+	sum := sha256.Sum256([]byte(strings.TrimPrefix(ipfsPath, "/ipfs/")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,305 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/pkg/ipfsclient/fakeipfs"
+)
+
+// conformance checks the filesystem behaves the way go-git expects
+// billy filesystems to behave, errors are checked the way go-git does
+func conformance(t *testing.T, newFS func(t *testing.T) billy.Filesystem) {
+	t.Run("CreateWriteRead", func(t *testing.T) {
+		fs := newFS(t)
+
+		f, err := fs.Create("objects/info/packs")
+		require.NoError(t, err)
+		assert.Equal(t, "objects/info/packs", f.Name())
+
+		n, err := f.Write([]byte("P pack-1.pack\n"))
+		require.NoError(t, err)
+		assert.Equal(t, 14, n)
+		require.NoError(t, f.Close())
+
+		data, err := util.ReadFile(fs, "objects/info/packs")
+		require.NoError(t, err)
+		assert.Equal(t, "P pack-1.pack\n", string(data))
+
+		// Create truncates the existing file
+		require.NoError(t, util.WriteFile(fs, "objects/info/packs", []byte("P\n"), 0644))
+		data, err = util.ReadFile(fs, "objects/info/packs")
+		require.NoError(t, err)
+		assert.Equal(t, "P\n", string(data))
+	})
+
+	t.Run("OpenFlags", func(t *testing.T) {
+		fs := newFS(t)
+
+		_, err := fs.Open("missing")
+		assert.True(t, os.IsNotExist(err), "%v", err)
+
+		require.NoError(t, util.WriteFile(fs, "HEAD", []byte("ref: refs/heads/main\n"), 0644))
+
+		_, err = fs.OpenFile("HEAD", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		assert.True(t, os.IsExist(err), "%v", err)
+
+		f, err := fs.OpenFile("HEAD", os.O_WRONLY|os.O_APPEND, 0644)
+		require.NoError(t, err)
+		_, err = f.Write([]byte("x"))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		data, err := util.ReadFile(fs, "HEAD")
+		require.NoError(t, err)
+		assert.Equal(t, "ref: refs/heads/main\nx", string(data))
+
+		// the read-only file isn't written
+		f, err = fs.Open("HEAD")
+		require.NoError(t, err)
+		_, err = f.Write([]byte("y"))
+		assert.Error(t, err)
+		require.NoError(t, f.Close())
+
+		// closed files are not read
+		_, err = f.Read(make([]byte, 1))
+		assert.Error(t, err)
+	})
+
+	t.Run("SeekReadAt", func(t *testing.T) {
+		fs := newFS(t)
+		require.NoError(t, util.WriteFile(fs, "pack.idx", []byte("0123456789"), 0644))
+
+		f, err := fs.Open("pack.idx")
+		require.NoError(t, err)
+		defer f.Close()
+
+		b := make([]byte, 3)
+		n, err := f.ReadAt(b, 4)
+		require.NoError(t, err)
+		assert.Equal(t, "456", string(b[:n]))
+
+		// short read at the end reports io.EOF
+		n, err = f.ReadAt(b, 8)
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, "89", string(b[:n]))
+
+		pos, err := f.Seek(-2, io.SeekEnd)
+		require.NoError(t, err)
+		assert.Equal(t, int64(8), pos)
+
+		rest, err := io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, "89", string(rest))
+
+		n, err = f.Read(b)
+		assert.Equal(t, io.EOF, err)
+		assert.Zero(t, n)
+
+		pos, err = f.Seek(1, io.SeekStart)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), pos)
+
+		pos, err = f.Seek(2, io.SeekCurrent)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), pos)
+
+		n, err = f.Read(b)
+		require.NoError(t, err)
+		assert.Equal(t, "345", string(b[:n]))
+	})
+
+	t.Run("Truncate", func(t *testing.T) {
+		fs := newFS(t)
+		require.NoError(t, util.WriteFile(fs, "index", []byte("0123456789"), 0644))
+
+		f, err := fs.OpenFile("index", os.O_RDWR, 0644)
+		require.NoError(t, err)
+		require.NoError(t, f.Truncate(4))
+		require.NoError(t, f.Close())
+
+		data, err := util.ReadFile(fs, "index")
+		require.NoError(t, err)
+		assert.Equal(t, "0123", string(data))
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		fs := newFS(t)
+		require.NoError(t, util.WriteFile(fs, "refs/heads/main", []byte("0123456789"), 0644))
+
+		fi, err := fs.Stat("refs/heads/main")
+		require.NoError(t, err)
+		assert.Equal(t, "main", fi.Name())
+		assert.Equal(t, int64(10), fi.Size())
+		assert.False(t, fi.IsDir())
+
+		fi, err = fs.Stat("refs/heads")
+		require.NoError(t, err)
+		assert.Equal(t, "heads", fi.Name())
+		assert.True(t, fi.IsDir())
+
+		_, err = fs.Stat("refs/tags/v1")
+		assert.True(t, os.IsNotExist(err), "%v", err)
+
+		fi, err = fs.Lstat("refs/heads/main")
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), fi.Size())
+	})
+
+	t.Run("ReadDirMkdirAll", func(t *testing.T) {
+		fs := newFS(t)
+
+		require.NoError(t, fs.MkdirAll("refs/tags", 0755))
+		require.NoError(t, fs.MkdirAll("refs/tags", 0755))
+		require.NoError(t, util.WriteFile(fs, "refs/heads/main", nil, 0644))
+		require.NoError(t, util.WriteFile(fs, "refs/heads/dev", []byte("dev"), 0644))
+
+		entries, err := fs.ReadDir("refs")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"heads", "tags"}, names(entries))
+		assert.True(t, entries[0].IsDir())
+
+		entries, err = fs.ReadDir("refs/heads")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"dev", "main"}, names(entries))
+		assert.Equal(t, int64(3), entries[0].Size())
+
+		entries, err = fs.ReadDir("refs/tags")
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		fs := newFS(t)
+
+		require.NoError(t, util.WriteFile(fs, "refs/heads/main.lock", []byte("new"), 0644))
+		require.NoError(t, util.WriteFile(fs, "refs/heads/main", []byte("old"), 0644))
+
+		// the existing file is replaced
+		require.NoError(t, fs.Rename("refs/heads/main.lock", "refs/heads/main"))
+
+		data, err := util.ReadFile(fs, "refs/heads/main")
+		require.NoError(t, err)
+		assert.Equal(t, "new", string(data))
+
+		_, err = fs.Stat("refs/heads/main.lock")
+		assert.True(t, os.IsNotExist(err), "%v", err)
+
+		// directories are moved with their children
+		require.NoError(t, fs.Rename("refs/heads", "refs/remotes/origin"))
+
+		data, err = util.ReadFile(fs, "refs/remotes/origin/main")
+		require.NoError(t, err)
+		assert.Equal(t, "new", string(data))
+
+		entries, err := fs.ReadDir("refs")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"remotes"}, names(entries))
+
+		err = fs.Rename("missing", "other")
+		assert.True(t, os.IsNotExist(err) || os.IsNotExist(unwrapLink(err)), "%v", err)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		fs := newFS(t)
+
+		require.NoError(t, util.WriteFile(fs, "objects/ab/cdef", []byte("blob"), 0644))
+
+		// non-empty directories are not removed
+		assert.Error(t, fs.Remove("objects/ab"))
+
+		require.NoError(t, fs.Remove("objects/ab/cdef"))
+		_, err := fs.Stat("objects/ab/cdef")
+		assert.True(t, os.IsNotExist(err), "%v", err)
+
+		require.NoError(t, fs.Remove("objects/ab"))
+		_, err = fs.Stat("objects/ab")
+		assert.True(t, os.IsNotExist(err), "%v", err)
+
+		err = fs.Remove("objects/ab")
+		assert.True(t, os.IsNotExist(err), "%v", err)
+	})
+
+	t.Run("TempFile", func(t *testing.T) {
+		fs := newFS(t)
+		require.NoError(t, fs.MkdirAll("objects/pack", 0755))
+
+		f1, err := fs.TempFile("objects/pack", "tmp_pack_")
+		require.NoError(t, err)
+		f2, err := fs.TempFile("objects/pack", "tmp_pack_")
+		require.NoError(t, err)
+
+		assert.NotEqual(t, f1.Name(), f2.Name())
+		assert.True(t, strings.HasPrefix(fs.Join("objects/pack", "tmp_pack_"), "objects/pack/tmp_pack_"))
+		assert.True(t, strings.HasPrefix(f1.Name(), "objects/pack/tmp_pack_"), f1.Name())
+
+		_, err = f1.Write([]byte("PACK"))
+		require.NoError(t, err)
+		require.NoError(t, f1.Close())
+		require.NoError(t, f2.Close())
+
+		require.NoError(t, fs.Rename(f1.Name(), "objects/pack/pack-1.pack"))
+
+		data, err := util.ReadFile(fs, "objects/pack/pack-1.pack")
+		require.NoError(t, err)
+		assert.Equal(t, "PACK", string(data))
+	})
+}
+
+func names(entries []os.FileInfo) []string {
+	res := make([]string, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, e.Name())
+	}
+	sort.Strings(res)
+	return res
+}
+
+// unwrapLink returns the error of the rename
+func unwrapLink(err error) error {
+	if le, ok := err.(*os.LinkError); ok {
+		return le.Err
+	}
+	return err
+}
+
+func TestConformance_Memfs(t *testing.T) {
+	// the reference the suite is checked against
+	conformance(t, func(*testing.T) billy.Filesystem {
+		return memfs.New()
+	})
+}
+
+func TestConformance_IPFSFilesystem(t *testing.T) {
+	for name, cacheSize := range map[string]int64{"memory": 0, "cache": 1 << 20} {
+		cacheSize := cacheSize
+
+		t.Run(name, func(t *testing.T) {
+			conformance(t, func(t *testing.T) billy.Filesystem {
+				stop := make(chan struct{})
+				t.Cleanup(func() { close(stop) })
+
+				fs, err := NewIPFSFilesystem(fakeipfs.NewNode(), t.TempDir(), cacheSize, stop)
+				require.NoError(t, err)
+				return fs
+			})
+		})
+	}
+}
+
+func TestConformance_MFSFilesystem(t *testing.T) {
+	conformance(t, func(t *testing.T) billy.Filesystem {
+		fs, err := NewMFSFilesystem(fakeipfs.NewNode(), ".repos")
+		require.NoError(t, err)
+		return fs
+	})
+}
@@ -2,15 +2,18 @@ package fs
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/go-git/go-billy/v5"
-	ipfs "github.com/ipfs/go-ipfs-api"
+
+	"gitsec-backend/pkg/ipfsclient"
 )
 
 // IPFSFile is a go-billy file that stores data on IPFS.
@@ -26,6 +29,9 @@ type IPFSFile struct {
 	// Mode is the file mode, such as os.FileMode.
 	Mode os.FileMode
 
+	// modTime is the time the content was last stored.
+	modTime time.Time
+
 	// isClosed indicates whether the file has been closed.
 	isClosed bool
 
@@ -35,61 +41,105 @@ type IPFSFile struct {
 	mu sync.Mutex
 
 	// client is the IPFS client used to store and retrieve file data.
-	client *ipfs.Shell
-
-	// isDuplicate indicates whether this file is a duplicate of another file.
-	isDuplicate bool
-	// updateOriginal is a function that updates the original file when this file is a duplicate.
-	updateOriginal func(ipfsPath string)
+	client ipfsclient.Client
+	// cache is the local disk cache the content is read through,
+	// it's nil if contents are read into memory.
+	cache *diskCache
+	// cached is the cached content the file is read from
+	// until it's written.
+	cached *os.File
+
+	// dirty indicates the content was written and
+	// not stored on IPFS yet.
+	dirty bool
+
+	// original is the file in the storage this file is a duplicate of,
+	// it's nil for the original file.
+	original *IPFSFile
+
+	// lock is the advisory lock shared by the original file and its
+	// duplicates, it holds a value while the file is locked.
+	lock chan struct{}
+	// locked indicates the lock is held through this file.
+	locked bool
+
+	// path is the path of the original file in the storage.
+	path string
+	// size is the size of the content not fetched from IPFS yet.
+	size int64
+	// publish replaces the content of the original file with the
+	// content stored by its duplicate and persists it.
+	publish func(f *IPFSFile, ipfsPath string, data []byte)
 }
 
 // MarshalJSON marshals the storage instance
 // into a JSON representation.
 func (f *IPFSFile) MarshalJSON() ([]byte, error) {
-	storageJson := struct {
-		FileName string
-		IpfsPath string
-		Position int64
-		Flag     int
-		Mode     uint32
-	}{
+	return json.Marshal(fileJson{
 		FileName: f.FileName,
 		IpfsPath: f.IpfsPath,
 		Position: f.Position,
 		Flag:     f.Flag,
 		Mode:     uint32(f.Mode),
-	}
-
-	return json.Marshal(storageJson)
+		Size:     f.Size(),
+		ModTime:  f.modTime,
+	})
 }
 
+// UnmarshalJSON unmarshals a JSON representation
+// into a file instance. Its content is not fetched.
 func (f *IPFSFile) UnmarshalJSON(i []byte) error {
-	mm := make(map[string]interface{})
+	var fj fileJson
 
-	err := json.Unmarshal(i, &mm)
-	if err != nil {
+	if err := json.Unmarshal(i, &fj); err != nil {
 		return err
 	}
 
-	f.FileName = mm["FileName"].(string)
-	f.IpfsPath = mm["IpfsPath"].(string)
-	f.Position = int64(mm["Position"].(float64))
-	f.Flag = int(mm["Flag"].(float64))
-	f.Mode = os.FileMode(mm["Mode"].(float64))
+	f.FileName = fj.FileName
+	f.IpfsPath = fj.IpfsPath
+	f.Position = fj.Position
+	f.Flag = fj.Flag
+	f.Mode = os.FileMode(fj.Mode)
+	f.size = fj.Size
+	f.modTime = fj.ModTime
 
 	return nil
 }
 
+// fileJson is the JSON representation of the file
+type fileJson struct {
+	FileName string
+	IpfsPath string
+	Position int64
+	Flag     int
+	Mode     uint32
+	Size     int64
+	ModTime  time.Time
+}
+
+// Size returns the size of the file content, fetched or not.
+func (f *IPFSFile) Size() int64 {
+	if f.content == nil {
+		return f.size
+	}
+	return int64(f.content.Len())
+}
+
 // Name returns the name of the file.
 func (f *IPFSFile) Name() string {
 	return f.FileName
 }
 
 func (f *IPFSFile) fillContent() error {
-	// Fetch file from IPFS and store in memory.
-	ipfsFileReader, err := f.client.Cat(f.IpfsPath)
+	if f.IpfsPath == "" {
+		f.content = &content{name: f.FileName}
+		return nil
+	}
+
+	// Fetch file from IPFS or the cache and store in memory.
+	ipfsFileReader, err := f.open()
 	if err != nil {
-		return fmt.Errorf("can't find file content on %s path", f.IpfsPath)
+		return err
 	}
 	defer ipfsFileReader.Close()
 
@@ -105,20 +155,34 @@ func (f *IPFSFile) fillContent() error {
 	return nil
 }
 
+// open opens the content from the cache or fetches it from IPFS
+func (f *IPFSFile) open() (io.ReadCloser, error) {
+	if f.cache != nil {
+		return f.cache.Open(f.IpfsPath, f.cat)
+	}
+
+	return f.cat()
+}
+
+// cat fetches the content from IPFS
+func (f *IPFSFile) cat() (io.ReadCloser, error) {
+	r, err := f.client.Cat(context.Background(), f.IpfsPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't find file content on %s path", f.IpfsPath)
+	}
+
+	return r, nil
+}
+
 // Read reads data from the file. If the file has not yet been loaded from IPFS, it will be
-// fetched and stored in memory. The file's position is then advanced by the number of bytes
-// read. If the end of the file is reached and some data was still read, io.EOF is returned.
+// read from the cache or fetched and stored in memory. The file's position is then advanced
+// by the number of bytes read. If the end of the file is reached and some data was still
+// read, io.EOF is returned.
 func (f *IPFSFile) Read(b []byte) (int, error) {
 	defer f.mu.Unlock()
 	f.mu.Lock()
 
-	if f.content == nil && f.IpfsPath != "" {
-		if err := f.fillContent(); err != nil {
-			return 0, fmt.Errorf("failed to fill IPFSFile content: %w", err)
-		}
-	}
-
-	n, err := f.ReadAt(b, f.Position)
+	n, err := f.readAt(b, f.Position)
 	f.Position += int64(n)
 
 	if err == io.EOF && n != 0 {
@@ -129,12 +193,11 @@ func (f *IPFSFile) Read(b []byte) (int, error) {
 }
 
 // Write writes data to the file.
-// It stores the data in a bytes.Buffer and stores the data on
-// IPFS using the ipfs.Add function.
+// The data is buffered in the file content, which is stored on
+// IPFS once the file is synced or closed, so many small writes
+// produce a single IPFS object.
 // If the file was opened for writing or appending and the Flag value
 // is os.O_TRUNC, the file is truncated before writing.
-// If the file is a duplicate, the original file is updated with the
-// new IPFS hash using the updateOriginal function.
 func (f *IPFSFile) Write(b []byte) (int, error) {
 	defer f.mu.Unlock()
 	f.mu.Lock()
@@ -147,31 +210,77 @@ func (f *IPFSFile) Write(b []byte) (int, error) {
 		return 0, errors.New("write not supported")
 	}
 
+	if err := f.materialize(); err != nil {
+		return 0, err
+	}
+
 	n, err := f.content.WriteAt(b, f.Position)
 	if err != nil {
-		fmt.Println(err.Error())
+		return 0, err
 	}
 
 	f.Position += int64(n)
+	f.dirty = true
 
-	hash, err := f.client.Add(bytes.NewReader(b))
-	if err != nil {
-		return 0, fmt.Errorf("failed to add new file to ipfs: %w", err)
+	return n, nil
+}
+
+// Sync stores the buffered content on IPFS.
+func (f *IPFSFile) Sync() error {
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	return f.flush()
+}
+
+// flush adds the content written since the last flush to IPFS.
+// If the file is a duplicate, the content is published to the original
+// file, so the files opened afterwards read it. Empty content isn't
+// stored, it has no IPFS path.
+func (f *IPFSFile) flush() error {
+	if !f.dirty {
+		return nil
+	}
+
+	var hash string
+
+	if f.content.Len() != 0 {
+		var err error
+		hash, err = f.client.Add(context.Background(), bytes.NewReader(f.content.bytes), true)
+		if err != nil {
+			return fmt.Errorf("failed to add new file to ipfs: %w", err)
+		}
 	}
 
 	f.IpfsPath = hash
+	f.modTime = time.Now()
+	f.dirty = false
 
-	if f.isDuplicate {
-		f.updateOriginal(hash)
+	if f.original != nil && f.original.publish != nil {
+		// the duplicate keeps writing its own content
+		data := append([]byte(nil), f.content.bytes...)
+		f.original.publish(f.original, hash, data)
 	}
 
-	return n, err
+	return nil
 }
 
 // ReadAt reads data from the file at a specific offset.
 // It returns an error if the file is closed or if the file was not opened for reading or reading and writing.
 // It also returns an error if the requested offset is out of bounds.
+// Content not written is read in ranges from the cache.
 func (f *IPFSFile) ReadAt(b []byte, offset int64) (int, error) {
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
+	return f.readAt(b, offset)
+}
+
+func (f *IPFSFile) readAt(b []byte, offset int64) (int, error) {
 	if f.isClosed {
 		return 0, os.ErrClosed
 	}
@@ -180,6 +289,28 @@ func (f *IPFSFile) ReadAt(b []byte, offset int64) (int, error) {
 		return 0, errors.New("read not supported")
 	}
 
+	if f.content == nil && f.cache != nil && f.IpfsPath != "" {
+		if offset < 0 {
+			return 0, errors.New("offset out of bounds")
+		}
+
+		if f.cached == nil {
+			r, err := f.cache.Open(f.IpfsPath, f.cat)
+			if err != nil {
+				return 0, fmt.Errorf("failed to open cached IPFSFile content: %w", err)
+			}
+			f.cached = r
+		}
+
+		return f.cached.ReadAt(b, offset)
+	}
+
+	if f.content == nil {
+		if err := f.fillContent(); err != nil {
+			return 0, fmt.Errorf("failed to fill IPFSFile content: %w", err)
+		}
+	}
+
 	if offset < 0 || offset > int64(f.content.Len()) {
 		return 0, errors.New("offset out of bounds")
 	}
@@ -197,6 +328,9 @@ func (f *IPFSFile) ReadAt(b []byte, offset int64) (int, error) {
 //
 // It returns the new position.
 func (f *IPFSFile) Seek(offset int64, whence int) (int64, error) {
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
 	if f.isClosed {
 		return 0, os.ErrClosed
 	}
@@ -207,7 +341,7 @@ func (f *IPFSFile) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekStart:
 		f.Position = offset
 	case io.SeekEnd:
-		f.Position = int64(f.content.Len()) + offset
+		f.Position = f.Size() + offset
 	default:
 		return 0, errors.New("invalid whence value")
 	}
@@ -215,24 +349,80 @@ func (f *IPFSFile) Seek(offset int64, whence int) (int64, error) {
 	return f.Position, nil
 }
 
-// Lock locks the file for writing.
-// This method is a no-op and always returns nil.
+// Lock locks the file exclusively, it blocks until the lock held through
+// another file opened with the same path is released. The lock is advisory,
+// it only excludes other lockers. Once locked, the file reads the content
+// stored by the previous lock holder, unless it was written already.
 func (f *IPFSFile) Lock() error {
+	f.mu.Lock()
+	if f.isClosed {
+		f.mu.Unlock()
+		return os.ErrClosed
+	}
+	if f.locked {
+		f.mu.Unlock()
+		return nil
+	}
+	f.mu.Unlock()
+
+	f.lock <- struct{}{}
+
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
+	f.locked = true
+
+	if f.original != nil && !f.dirty {
+		f.original.mu.Lock()
+		f.take(f.original)
+		f.original.mu.Unlock()
+	}
+
 	return nil
 }
 
-// Unlock unlocks the file for writing.
-// This method is a no-op and always returns nil.
+// Unlock stores the buffered content on IPFS and releases the lock,
+// so the next lock holder reads the content. The lock is released
+// even if the content failed to be stored.
 func (f *IPFSFile) Unlock() error {
-	return nil
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
+	if !f.locked {
+		return errors.New("file is not locked")
+	}
+
+	err := f.flush()
+	f.unlock()
+
+	return err
+}
+
+// unlock releases the lock held through the file
+func (f *IPFSFile) unlock() {
+	if !f.locked {
+		return
+	}
+
+	f.locked = false
+	<-f.lock
 }
 
 // Truncate changes the size of the file.
 func (f *IPFSFile) Truncate(size int64) error {
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
 	if f.isClosed {
 		return os.ErrClosed
 	}
 
+	if err := f.materialize(); err != nil {
+		return err
+	}
+
+	f.dirty = true
+
 	if size < int64(len(f.content.bytes)) {
 		f.content.bytes = f.content.bytes[:size]
 	} else if more := int(size) - len(f.content.bytes); more > 0 {
@@ -244,53 +434,115 @@ func (f *IPFSFile) Truncate(size int64) error {
 
 // Stat returns the FileInfo structure describing file.
 func (f *IPFSFile) Stat() (os.FileInfo, error) {
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
 	if f.isClosed {
 		return nil, os.ErrClosed
 	}
 
 	return &fileInfo{
-		name: f.Name(),
-		mode: f.Mode,
-		size: f.content.Len(),
+		name:    f.Name(),
+		mode:    f.Mode,
+		size:    int(f.Size()),
+		modTime: f.modTime,
 	}, nil
 }
 
 // Duplicate returns a new instance of the file with the same file name, mode, and flag.
-// The new instance shares the same IPFS client and content as the original file.
+// The new instance reads the current content of the original file, files opened
+// for writing get a copy of it, which is published to the original file once
+// it's stored. The files opened before keep reading the content they got, so
+// they never see the content partially written.
 func (f *IPFSFile) Duplicate(filename string, mode os.FileMode, flag int) billy.File {
-	updateIpfsPath := func(ipfsPath string) {
-		f.IpfsPath = ipfsPath
-	}
+	defer f.mu.Unlock()
+	f.mu.Lock()
 
 	new := &IPFSFile{
-		FileName:       filename,
-		content:        f.content,
-		Mode:           mode,
-		Flag:           flag,
-		client:         f.client,
-		IpfsPath:       f.IpfsPath,
-		isDuplicate:    true,
-		updateOriginal: updateIpfsPath,
+		FileName: filename,
+		Mode:     mode,
+		Flag:     flag,
+		client:   f.client,
+		cache:    f.cache,
+		original: f,
+		lock:     f.lock,
 	}
 
+	new.take(f)
+
 	if isAppend(flag) {
 		new.Position = int64(new.content.Len())
 	}
 
 	if isTruncate(flag) {
-		new.content.Truncate()
+		new.content = &content{name: filename}
+		new.dirty = true
 	}
 
 	return new
 }
 
-// Close closes the file.
+// take takes the current content of the original file,
+// the file opened for writing gets its own copy of it
+func (f *IPFSFile) take(original *IPFSFile) {
+	f.IpfsPath = original.IpfsPath
+	f.size = original.Size()
+	f.modTime = original.modTime
+	f.content = original.content
+
+	if f.content != nil && !isReadOnly(f.Flag) {
+		f.content = &content{
+			name:  f.FileName,
+			bytes: append([]byte(nil), original.content.bytes...),
+		}
+	}
+
+	if f.cached != nil {
+		_ = f.cached.Close()
+		f.cached = nil
+	}
+}
+
+// Close stores the buffered content on IPFS, releases the lock
+// held through the file and closes the file. The file is closed
+// even if the content failed to be stored.
 func (f *IPFSFile) Close() error {
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
 	if f.isClosed {
 		return os.ErrClosed
 	}
 
 	f.isClosed = true
+
+	if f.cached != nil {
+		_ = f.cached.Close()
+		f.cached = nil
+	}
+
+	err := f.flush()
+	f.unlock()
+
+	return err
+}
+
+// materialize loads the content read from the cache into memory,
+// so it's written
+func (f *IPFSFile) materialize() error {
+	if f.content != nil {
+		return nil
+	}
+
+	if err := f.fillContent(); err != nil {
+		return fmt.Errorf("failed to fill IPFSFile content: %w", err)
+	}
+
+	if f.cached != nil {
+		_ = f.cached.Close()
+		f.cached = nil
+	}
+
 	return nil
 }
 
@@ -0,0 +1,234 @@
+package fs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/pkg/ipfsclient/fakeipfs"
+)
+
+func TestIPFSFile_WriteBuffered(t *testing.T) {
+	node := fakeipfs.NewNode()
+	fs := &IPFSFilesystem{s: newStorage(node)}
+
+	f, err := fs.Create("objects/pack/pack-1.pack")
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		_, err := f.Write([]byte("chunk"))
+		require.NoError(t, err)
+	}
+	assert.Zero(t, node.Adds(), "writes are buffered")
+
+	require.NoError(t, f.(*IPFSFile).Sync())
+	assert.Equal(t, 1, node.Adds())
+
+	// nothing new is written since the sync
+	require.NoError(t, f.Close())
+	assert.Equal(t, 1, node.Adds())
+
+	original := fs.s.MustGet("objects/pack/pack-1.pack")
+	require.NotEmpty(t, original.IpfsPath)
+
+	// the content is fetched by the final path
+	original.content = nil
+	data, err := util.ReadFile(fs, "objects/pack/pack-1.pack")
+	require.NoError(t, err)
+	assert.Len(t, data, 500)
+
+	// truncated empty file has no content to add
+	f, err = fs.OpenFile("objects/pack/pack-1.pack", os.O_WRONLY|os.O_TRUNC, 0644)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, 1, node.Adds())
+	assert.Empty(t, original.IpfsPath)
+}
+
+func TestIPFSFile_ReadCached(t *testing.T) {
+	node := fakeipfs.NewNode()
+
+	cache, err := newDiskCache(t.TempDir(), 1024)
+	require.NoError(t, err)
+
+	fs := &IPFSFilesystem{s: newStorage(node)}
+	fs.s.cache = cache
+
+	data := make([]byte, 600)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	require.NoError(t, util.WriteFile(fs, "pack.idx", data, 0644))
+
+	original := fs.s.MustGet("pack.idx")
+	original.content = nil
+	original.size = int64(len(data))
+
+	f, err := fs.Open("pack.idx")
+	require.NoError(t, err)
+
+	// ranges are read from the disk, the content isn't loaded
+	b := make([]byte, 10)
+	n, err := f.ReadAt(b, 300)
+	require.NoError(t, err)
+	assert.Equal(t, data[300:310], b[:n])
+
+	end, err := f.Seek(-4, io.SeekEnd)
+	require.NoError(t, err)
+	assert.Equal(t, int64(596), end)
+
+	rest, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, data[596:], rest)
+	assert.Nil(t, f.(*IPFSFile).content)
+	require.NoError(t, f.Close())
+
+	// duplicates share the cached content
+	node.Forget()
+
+	read, err := util.ReadFile(fs, "pack.idx")
+	require.NoError(t, err)
+	assert.Equal(t, data, read)
+
+	// the cached content is loaded once it's written
+	f, err = fs.OpenFile("pack.idx", os.O_RDWR, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0xff})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, append([]byte{0xff}, data[1:]...), original.content.bytes)
+
+	// the least recently used content is evicted over the limit
+	assert.Equal(t, int64(600), cache.size)
+	other, err := cache.Open("/ipfs/other", func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(make([]byte, 500))), nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, other.Close())
+	assert.Equal(t, int64(500), cache.size)
+	assert.Equal(t, 1, cache.lru.Len())
+
+	// cached contents are kept across restarts
+	reopened, err := newDiskCache(cache.dir, 1024)
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), reopened.size)
+}
+
+func TestIPFSFile_Lock(t *testing.T) {
+	fs := &IPFSFilesystem{s: newStorage(fakeipfs.NewNode())}
+	require.NoError(t, util.WriteFile(fs, "refs/heads/main", []byte("0"), 0644))
+
+	// the locked read-modify-write of the ref is not lost
+	// the way go-git updates refs
+	update := func() error {
+		f, err := fs.OpenFile("refs/heads/main", os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := f.Lock(); err != nil {
+			return err
+		}
+		defer f.Unlock()
+
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(string(data))
+		if err != nil {
+			return err
+		}
+
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err = f.Write([]byte(strconv.Itoa(n + 1)))
+		return err
+	}
+
+	const updates = 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < updates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, update())
+		}()
+	}
+	wg.Wait()
+
+	data, err := util.ReadFile(fs, "refs/heads/main")
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(updates), string(data))
+
+	// the lock is released on close
+	f, err := fs.Open("refs/heads/main")
+	require.NoError(t, err)
+	require.NoError(t, f.Lock())
+	require.NoError(t, f.Close())
+
+	f, err = fs.Open("refs/heads/main")
+	require.NoError(t, err)
+	assert.Error(t, f.Unlock(), "the file isn't locked")
+
+	locked := make(chan struct{})
+	require.NoError(t, f.Lock())
+	go func() {
+		defer close(locked)
+
+		other, err := fs.Open("refs/heads/main")
+		if assert.NoError(t, err) {
+			assert.NoError(t, other.Lock())
+			assert.NoError(t, other.Close())
+		}
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("the lock is held twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, f.Unlock())
+	<-locked
+}
+
+func TestIPFSFile_ModTime(t *testing.T) {
+	fs := &IPFSFilesystem{s: newStorage(fakeipfs.NewNode())}
+	require.NoError(t, util.WriteFile(fs, "HEAD", []byte("ref: refs/heads/main\n"), 0644))
+
+	fi, err := fs.Stat("HEAD")
+	require.NoError(t, err)
+	written := fi.ModTime()
+
+	// the time is kept until the content is stored again
+	time.Sleep(10 * time.Millisecond)
+	fi, err = fs.Stat("HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, written, fi.ModTime())
+
+	_, err = util.ReadFile(fs, "HEAD")
+	require.NoError(t, err)
+	fi, err = fs.Stat("HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, written, fi.ModTime())
+
+	require.NoError(t, util.WriteFile(fs, "HEAD", []byte("ref: refs/heads/dev\n"), 0644))
+	fi, err = fs.Stat("HEAD")
+	require.NoError(t, err)
+	assert.True(t, fi.ModTime().After(written))
+}
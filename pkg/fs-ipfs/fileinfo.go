@@ -14,6 +14,9 @@ type fileInfo struct {
 	size int
 	// mode is the file mode of the file.
 	mode os.FileMode
+	// modTime is the time the file was last modified,
+	// it's zero if the time isn't known.
+	modTime time.Time
 }
 
 // Name returns the name of the file.
@@ -32,10 +35,13 @@ func (fi *fileInfo) Mode() os.FileMode {
 }
 
 // ModTime returns the modification time of the file.
-// In this implementation, the modification time is
-// always the current time.
-func (*fileInfo) ModTime() time.Time {
-	return time.Now()
+// Files without the known modification time report
+// the current time.
+func (fi *fileInfo) ModTime() time.Time {
+	if fi.modTime.IsZero() {
+		return time.Now()
+	}
+	return fi.modTime
 }
 
 // IsDir returns whether the file is a directory.
@@ -1,6 +1,7 @@
 package fs
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,7 +11,9 @@ import (
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/helper/chroot"
 	"github.com/go-git/go-billy/v5/util"
-	ipfs "github.com/ipfs/go-ipfs-api"
+	"github.com/misnaged/annales/logger"
+
+	"gitsec-backend/pkg/ipfsclient"
 )
 
 // IPFSFilesystem is a filesystem implementation
@@ -23,16 +26,31 @@ type IPFSFilesystem struct {
 	stop chan struct{}
 }
 
-// NewIPFSFilesystem creates a new IPFSFilesystem instance.
-func NewIPFSFilesystem(clientAddr string, stop chan struct{}) (billy.Filesystem, error) {
+// NewIPFSFilesystem creates a new IPFSFilesystem instance. The storage
+// index is persisted in the indexDir directory and restored from it,
+// file contents are fetched from IPFS once files are opened. Contents
+// read are cached on the disk within indexDir up to cacheSize bytes,
+// zero cacheSize reads them into memory. Empty indexDir keeps the
+// index in memory only and disables the cache.
+func NewIPFSFilesystem(client ipfsclient.Client, indexDir string, cacheSize int64, stop chan struct{}) (billy.Filesystem, error) {
 	fs := &IPFSFilesystem{
-		s:    newStorage(ipfs.NewShell(clientAddr)),
+		s:    newStorage(client),
 		stop: stop,
 	}
 
-	/*if err := fs.s.LoadStorage(); err != nil {
-		return nil, fmt.Errorf("failed to load storage: %w", err)
-	}*/
+	if indexDir != "" && cacheSize > 0 {
+		cache, err := newDiskCache(filepath.Join(indexDir, cacheDir), cacheSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open content cache: %w", err)
+		}
+		fs.s.cache = cache
+	}
+
+	if indexDir != "" {
+		if err := fs.s.openIndex(indexDir); err != nil {
+			return nil, fmt.Errorf("failed to load storage: %w", err)
+		}
+	}
 
 	go fs.waitStop()
 
@@ -77,6 +95,13 @@ func (fs *IPFSFilesystem) OpenFile(filename string, flag int, perm os.FileMode)
 			// for writing or reading and writing
 			var err error
 			f, err = fs.s.New(filename, perm, flag)
+			if errors.Is(err, os.ErrExist) {
+				// the file is created concurrently
+				if isExclusive(flag) {
+					return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+				}
+				return fs.OpenFile(filename, flag, perm)
+			}
 			if err != nil {
 				return nil, fmt.Errorf("failed to create new file in filesystem: %w", err)
 			}
@@ -98,6 +123,14 @@ func (fs *IPFSFilesystem) OpenFile(filename string, flag int, perm os.FileMode)
 		return nil, fmt.Errorf("cannot open directory: %s", filename)
 	}
 
+	// files opened for reading are read through the cache, the content
+	// of files opened for writing is shared with their duplicates
+	if fs.s.cache == nil || !isReadOnly(flag) {
+		if _, err := fs.s.fill(f); err != nil {
+			return nil, err
+		}
+	}
+
 	// return a duplicate of the file with the specified filename, permission, and flag
 	return f.Duplicate(filename, perm, flag), nil
 }
@@ -141,18 +174,13 @@ func (fs *IPFSFilesystem) Rename(oldpath, newpath string) error {
 	return fs.s.Rename(oldpath, newpath)
 }
 
-// Remove removes the named file or directory.
+// Remove removes the named file or empty directory.
 func (fs *IPFSFilesystem) Remove(filename string) error {
 	// retrieve the file from the storage
 	f, has := fs.s.Get(filename)
 	if !has {
 		// file does not exist in the storage, return an error
-		return fmt.Errorf("failed to remove file: %w", os.ErrNotExist)
-	}
-
-	if f.Mode.IsDir() {
-		// file is a directory, return an error
-		return fmt.Errorf("cannot remove directory: %s", filename)
+		return &os.PathError{Op: "remove", Path: filename, Err: os.ErrNotExist}
 	}
 
 	if target, isLink := fs.resolveLink(filename, f); isLink {
@@ -269,7 +297,12 @@ func (fs *IPFSFilesystem) Readlink(link string) (string, error) {
 		}
 	}
 
-	return string(f.content.bytes), nil
+	c, err := fs.s.fill(f)
+	if err != nil {
+		return "", err
+	}
+
+	return string(c.bytes), nil
 }
 
 // Capabilities implements the Capable interface.
@@ -285,7 +318,13 @@ func (fs *IPFSFilesystem) resolveLink(fullpath string, f *IPFSFile) (target stri
 		return fullpath, false
 	}
 
-	target = string(f.content.bytes)
+	c, err := fs.s.fill(f)
+	if err != nil {
+		logger.Log().Errorf("failed to read link %s: %s", fullpath, err)
+		return fullpath, false
+	}
+
+	target = string(c.bytes)
 	if !isAbs(target) {
 		target = fs.Join(filepath.Dir(fullpath), target)
 	}
@@ -294,12 +333,12 @@ func (fs *IPFSFilesystem) resolveLink(fullpath string, f *IPFSFile) (target stri
 }
 
 // waitStop waits for the stop channel to be closed
-// and saves the storage to the filesystem.
+// and saves the storage index.
 func (fs *IPFSFilesystem) waitStop() {
 	<-fs.stop
-	/*if err := fs.s.SaveStorage(); err != nil {
+	if err := fs.s.Save(); err != nil {
 		logger.Log().Errorf("failed to save storage: %v", err)
-	}*/
+	}
 }
 
 // On Windows OS, IsAbs validates if a path is valid based on if stars with a
@@ -0,0 +1,389 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/misnaged/annales/logger"
+)
+
+const (
+	// indexFile is the snapshot of the storage index
+	indexFile = ".ipfs-index.json"
+	// journalFile journals index changes made after the snapshot
+	journalFile = ".ipfs-index.journal"
+	// cidFile records the CID of the last snapshot pinned to IPFS
+	cidFile = ".ipfs-index.cid"
+
+	// journalLimit is the number of journaled changes
+	// after which the snapshot is written and pinned
+	journalLimit = 10000
+)
+
+// journal operations
+const (
+	opPut    = "put"
+	opRename = "rename"
+	opRemove = "remove"
+)
+
+// record is the journaled index change.
+type record struct {
+	// Seq is the sequence number of the change
+	Seq uint64 `json:"seq"`
+	Op  string `json:"op"`
+	// Path is the changed file path
+	Path string `json:"path"`
+	// To is the path the file is renamed to
+	To string `json:"to,omitempty"`
+	// File is the put file
+	File *IPFSFile `json:"file,omitempty"`
+}
+
+// snapshot is the persisted storage index.
+type snapshot struct {
+	// Seq is the sequence number of the last change in the snapshot,
+	// journaled changes up to it are not replayed
+	Seq     uint64   `json:"seq"`
+	Storage *storage `json:"storage"`
+}
+
+// index persists the storage index in the local directory. Every change
+// is appended to the journal and synced before it's applied, the snapshot
+// is written atomically, compacts the journal and is pinned to IPFS.
+type index struct {
+	dir string
+	// journal is the journal opened for appending
+	journal *os.File
+	// seq is the sequence number of the last change
+	seq uint64
+	// journaled is the number of changes journaled since the snapshot
+	journaled int
+	// compactAt is the number of journaled changes
+	// the next snapshot is written at
+	compactAt int
+}
+
+// openIndex restores the storage index from the snapshot and the journal
+// in the directory. Snapshot missing locally is fetched from IPFS by its
+// recorded CID. File contents are not fetched, they are read lazily.
+func (s *storage) openIndex(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create index directory: %w", err)
+	}
+
+	idx := &index{dir: dir, compactAt: journalLimit}
+
+	data, err := s.readSnapshot(dir)
+	if err != nil {
+		return err
+	}
+
+	if data != nil {
+		snap := &snapshot{Storage: s}
+		if err := json.Unmarshal(data, snap); err != nil {
+			return fmt.Errorf("failed to decode index snapshot: %w", err)
+		}
+		idx.seq = snap.Seq
+	}
+
+	replayed, end, err := s.replay(filepath.Join(dir, journalFile), idx)
+	if err != nil {
+		return err
+	}
+
+	idx.journal, err = os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open index journal: %w", err)
+	}
+	idx.journaled = replayed
+
+	// the torn record is cut off, otherwise changes
+	// appended after it would never be replayed
+	if err := idx.truncateJournal(end); err != nil {
+		idx.journal.Close()
+		return err
+	}
+
+	s.index = idx
+
+	logger.Log().Infof("ipfs filesystem index restored: %d files, %d journaled changes replayed", len(s.Files), replayed)
+
+	return nil
+}
+
+// readSnapshot reads the local snapshot or the snapshot pinned to IPFS
+func (s *storage) readSnapshot(dir string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if err == nil {
+		return data, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read index snapshot: %w", err)
+	}
+
+	cid, err := os.ReadFile(filepath.Join(dir, cidFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index CID: %w", err)
+	}
+
+	r, err := s.client.Cat(context.Background(), strings.TrimSpace(string(cid)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch index snapshot %s: %w", cid, err)
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// replay applies journaled changes made after the snapshot and returns
+// their number and the journal size up to the last whole record. Replay
+// stops at the torn record the crash left, changes after it were never
+// applied. The journal that doesn't continue the snapshot fails the replay:
+// changes between them are lost, so the index can't be restored.
+func (s *storage) replay(path string, idx *index) (int, int64, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open index journal: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	var (
+		replayed int
+		end      int64
+	)
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// the record is journaled with its line end,
+			// the record without it is torn
+			if len(line) > 0 {
+				logger.Log().Warningf("ipfs filesystem index journal is torn after %d changes: %d bytes of the unfinished record", replayed, len(line))
+			}
+			return replayed, end, nil
+		}
+		if err != nil {
+			return replayed, end, fmt.Errorf("failed to read index journal: %w", err)
+		}
+
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			logger.Log().Warningf("ipfs filesystem index journal is torn after %d changes: %s", replayed, err)
+			return replayed, end, nil
+		}
+
+		if rec.Seq > idx.seq {
+			if rec.Seq != idx.seq+1 {
+				return replayed, end, fmt.Errorf("index journal continues at change %d, but the snapshot ends at change %d", rec.Seq, idx.seq)
+			}
+
+			if err := s.apply(rec); err != nil {
+				return replayed, end, fmt.Errorf("failed to replay index change %d: %w", rec.Seq, err)
+			}
+
+			idx.seq = rec.Seq
+			replayed++
+		}
+
+		end += int64(len(line))
+	}
+}
+
+// truncateJournal cuts the journal off at the given size if it's longer
+func (idx *index) truncateJournal(size int64) error {
+	info, err := idx.journal.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat index journal: %w", err)
+	}
+
+	if info.Size() <= size {
+		return nil
+	}
+
+	if err := idx.journal.Truncate(size); err != nil {
+		return fmt.Errorf("failed to truncate torn index journal: %w", err)
+	}
+
+	if err := idx.journal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync index journal: %w", err)
+	}
+
+	logger.Log().Warningf("ipfs filesystem index journal torn tail of %d bytes is cut off", info.Size()-size)
+
+	return nil
+}
+
+// apply applies the journaled change
+func (s *storage) apply(rec record) error {
+	switch rec.Op {
+	case opPut:
+		if rec.File == nil {
+			return errors.New("put without file")
+		}
+		return s.put(rec.Path, rec.File)
+	case opRename:
+		return s.rename(clean(rec.Path), clean(rec.To))
+	case opRemove:
+		err := s.remove(clean(rec.Path))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown operation %q", rec.Op)
+	}
+}
+
+// journal appends the change to the journal and syncs it. The snapshot
+// is written once enough changes are journaled. In-memory storage
+// journals nothing. The storage must be locked.
+func (s *storage) journal(rec record) error {
+	if s.index == nil {
+		return nil
+	}
+
+	rec.Seq = s.index.seq + 1
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode index change: %w", err)
+	}
+
+	if _, err := s.index.journal.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to journal index change: %w", err)
+	}
+
+	if err := s.index.journal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync index journal: %w", err)
+	}
+
+	s.index.seq = rec.Seq
+	s.index.journaled++
+
+	if s.index.journaled >= s.index.compactAt {
+		if _, err := s.snapshot(); err != nil {
+			// changes are still journaled
+			logError(err)
+		}
+	}
+
+	return nil
+}
+
+// snapshot compacts the journal into the local snapshot, then pins the
+// snapshot to IPFS and records its CID. Failing IPFS node doesn't stop
+// the compaction, the pin is retried with the next snapshot, so changes
+// don't rewrite the whole index each. Until the pin succeeds the index is
+// restored from the local snapshot only, the pinned one isn't continued
+// by the journal. The storage must be locked.
+func (s *storage) snapshot() (string, error) {
+	data, err := s.compact()
+	if err != nil {
+		// the snapshot is retried once as many changes are journaled again
+		s.index.compactAt = s.index.journaled + journalLimit
+		return "", err
+	}
+
+	cid, err := s.client.Add(context.Background(), bytes.NewReader(data), true)
+	if err != nil {
+		return "", fmt.Errorf("failed to pin index snapshot to IPFS: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(s.index.dir, cidFile), []byte(cid+"\n")); err != nil {
+		return "", fmt.Errorf("failed to record index CID: %w", err)
+	}
+
+	return cid, nil
+}
+
+// compact writes the index snapshot atomically and truncates the journal.
+// It returns the written snapshot. The storage must be locked.
+func (s *storage) compact() ([]byte, error) {
+	data, err := json.Marshal(&snapshot{Seq: s.index.seq, Storage: s})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode index snapshot: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(s.index.dir, indexFile), data); err != nil {
+		return nil, fmt.Errorf("failed to write index snapshot: %w", err)
+	}
+
+	// changes up to the snapshot are skipped on replay,
+	// so the crash before truncating loses nothing
+	if err := s.index.journal.Truncate(0); err != nil {
+		return nil, fmt.Errorf("failed to truncate index journal: %w", err)
+	}
+
+	s.index.journaled = 0
+	s.index.compactAt = journalLimit
+
+	return data, nil
+}
+
+// Save writes the index snapshot and pins it to IPFS, the CID of the
+// pinned snapshot is recorded, so the index is restored from IPFS if
+// the local snapshot is lost.
+func (s *storage) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.index == nil {
+		return nil
+	}
+
+	cid, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+
+	logger.Log().Infof("ipfs filesystem index saved: %d files, snapshot %s", len(s.Files), cid)
+
+	return nil
+}
+
+// writeFileAtomic writes the file synced to the disk through
+// the temporary file, so it's either replaced or left intact
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// logError logs the error of the background index operation
+func logError(err error) {
+	logger.Log().Errorf("ipfs filesystem index: %s", err)
+}
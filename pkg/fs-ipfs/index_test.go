@@ -0,0 +1,219 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/pkg/ipfsclient/fakeipfs"
+)
+
+func TestIndex_Restore(t *testing.T) {
+	dir := t.TempDir()
+
+	s := newStorage(nil)
+	require.NoError(t, s.openIndex(dir))
+
+	f, err := s.New("/repo/objects/pack/a.pack", 0644, os.O_RDWR)
+	require.NoError(t, err)
+	s.publish(f, "/ipfs/QmPack", make([]byte, 42))
+
+	_, err = s.New("/repo/HEAD", 0644, os.O_RDWR)
+	require.NoError(t, err)
+	_, err = s.New("/repo/config", 0644, os.O_RDWR)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Rename("/repo/objects", "/repo/store"))
+	require.NoError(t, s.Remove("/repo/config"))
+
+	// the journal is replayed without the snapshot
+	restored := newStorage(fakeipfs.NewNode())
+	require.NoError(t, restored.openIndex(dir))
+
+	pack, ok := restored.Get("/repo/store/pack/a.pack")
+	require.True(t, ok)
+	assert.Equal(t, "/ipfs/QmPack", pack.IpfsPath)
+	assert.Equal(t, int64(42), pack.Size())
+	assert.Equal(t, f.modTime.UnixNano(), pack.modTime.UnixNano(), "the modification time is persisted")
+	assert.Nil(t, pack.content, "content is fetched lazily")
+
+	assert.True(t, restored.Has("/repo/HEAD"))
+	assert.False(t, restored.Has("/repo/config"))
+	assert.False(t, restored.Has("/repo/objects"))
+	assert.Len(t, restored.Childrens("/repo"), 2)
+
+	// changes journaled after the snapshot are replayed over it
+	_, err = restored.snapshot()
+	require.NoError(t, err)
+	require.NoError(t, restored.Remove("/repo/HEAD"))
+
+	// the torn record is dropped
+	journal, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = journal.WriteString(`{"seq":100,"op":"rem`)
+	require.NoError(t, err)
+	require.NoError(t, journal.Close())
+
+	again := newStorage(nil)
+	require.NoError(t, again.openIndex(dir))
+	assert.True(t, again.Has("/repo/store/pack/a.pack"))
+	assert.False(t, again.Has("/repo/HEAD"))
+}
+
+func TestIndex_TornJournal(t *testing.T) {
+	dir := t.TempDir()
+
+	s := newStorage(nil)
+	require.NoError(t, s.openIndex(dir))
+
+	_, err := s.New("/repo/HEAD", 0644, os.O_RDWR)
+	require.NoError(t, err)
+
+	journal, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = journal.WriteString(`{"seq":4,"op":"rem`)
+	require.NoError(t, err)
+	require.NoError(t, journal.Close())
+
+	// changes made after the restart aren't journaled behind the torn record
+	restarted := newStorage(nil)
+	require.NoError(t, restarted.openIndex(dir))
+
+	_, err = restarted.New("/repo/config", 0644, os.O_RDWR)
+	require.NoError(t, err)
+	require.NoError(t, restarted.Remove("/repo/HEAD"))
+
+	again := newStorage(nil)
+	require.NoError(t, again.openIndex(dir))
+	assert.True(t, again.Has("/repo/config"))
+	assert.False(t, again.Has("/repo/HEAD"))
+}
+
+func TestIndex_JournalGap(t *testing.T) {
+	dir := t.TempDir()
+
+	s := newStorage(fakeipfs.NewNode())
+	require.NoError(t, s.openIndex(dir))
+
+	_, err := s.New("/repo/HEAD", 0644, os.O_RDWR)
+	require.NoError(t, err)
+	require.NoError(t, s.Save())
+
+	_, err = s.New("/repo/config", 0644, os.O_RDWR)
+	require.NoError(t, err)
+
+	// the journal compacted by the snapshot that was lost
+	// doesn't continue the older one, the changes between them are lost
+	journal, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = journal.WriteString(`{"seq":100,"op":"remove","path":"/repo/config"}` + "\n")
+	require.NoError(t, err)
+	require.NoError(t, journal.Close())
+
+	restored := newStorage(nil)
+	assert.ErrorContains(t, restored.openIndex(dir), "index journal continues at change 100")
+}
+
+func TestIndex_JournalFirst(t *testing.T) {
+	dir := t.TempDir()
+
+	s := newStorage(nil)
+	require.NoError(t, s.openIndex(dir))
+
+	_, err := s.New("/repo/HEAD", 0644, os.O_RDWR)
+	require.NoError(t, err)
+
+	// changes that failed to be journaled aren't applied
+	require.NoError(t, s.index.journal.Close())
+
+	_, err = s.New("/repo/config", 0644, os.O_RDWR)
+	assert.Error(t, err)
+	assert.False(t, s.Has("/repo/config"))
+
+	assert.Error(t, s.Remove("/repo/HEAD"))
+	assert.True(t, s.Has("/repo/HEAD"))
+
+	assert.Error(t, s.Rename("/repo/HEAD", "/repo/ORIG_HEAD"))
+	assert.True(t, s.Has("/repo/HEAD"))
+	assert.False(t, s.Has("/repo/ORIG_HEAD"))
+}
+
+// failingAdd is the node adding fails on
+type failingAdd struct {
+	*fakeipfs.Node
+	adds int
+}
+
+func (n *failingAdd) Add(context.Context, io.Reader, bool) (string, error) {
+	n.adds++
+	return "", errors.New("add failed")
+}
+
+func TestIndex_SnapshotPinFailure(t *testing.T) {
+	dir := t.TempDir()
+	node := &failingAdd{Node: fakeipfs.NewNode()}
+
+	s := newStorage(node)
+	require.NoError(t, s.openIndex(dir))
+	s.index.compactAt = 2
+
+	for _, name := range []string{"HEAD", "config", "description"} {
+		_, err := s.New("/repo/"+name, 0644, os.O_RDWR)
+		require.NoError(t, err)
+	}
+
+	// the journal is compacted even though the pin failed
+	assert.Equal(t, 1, node.adds)
+	assert.FileExists(t, filepath.Join(dir, indexFile))
+	assert.NoFileExists(t, filepath.Join(dir, cidFile))
+
+	// and the pin isn't retried with every change
+	_, err := s.New("/repo/packed-refs", 0644, os.O_RDWR)
+	require.NoError(t, err)
+	assert.Equal(t, 1, node.adds)
+
+	restarted := newStorage(nil)
+	require.NoError(t, restarted.openIndex(dir))
+	assert.True(t, restarted.Has("/repo/HEAD"))
+	assert.True(t, restarted.Has("/repo/packed-refs"))
+}
+
+func TestIPFSFilesystem_Restart(t *testing.T) {
+	node := fakeipfs.NewNode()
+	dir := t.TempDir()
+
+	fs := &IPFSFilesystem{s: newStorage(node)}
+	require.NoError(t, fs.s.openIndex(dir))
+	require.NoError(t, util.WriteFile(fs, "repo/HEAD", []byte("ref: refs/heads/main\n"), 0644))
+
+	// the journal restores the index
+	restarted, err := NewIPFSFilesystem(node, dir, 0, make(chan struct{}))
+	require.NoError(t, err)
+
+	data, err := util.ReadFile(restarted, "repo/HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "ref: refs/heads/main\n", string(data))
+
+	// the snapshot pinned to IPFS restores the index lost locally
+	require.NoError(t, fs.s.Save())
+	require.NoError(t, os.Remove(filepath.Join(dir, indexFile)))
+	require.NoError(t, os.Truncate(filepath.Join(dir, journalFile), 0))
+
+	pins, err := node.Pins(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, pins, 2, "the content and the index snapshot are pinned")
+
+	restarted, err = NewIPFSFilesystem(node, dir, 0, make(chan struct{}))
+	require.NoError(t, err)
+
+	data, err = util.ReadFile(restarted, "repo/HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "ref: refs/heads/main\n", string(data))
+}
@@ -0,0 +1,374 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/helper/chroot"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/misnaged/annales/logger"
+
+	"gitsec-backend/pkg/ipfsclient"
+)
+
+// MFSFilesystem is a filesystem implementation on top of the IPFS
+// Mutable File System. The directory tree lives in the IPFS node
+// under the root directory, so the CID of the tree is known at any
+// moment. Symbolic links aren't supported by MFS.
+//
+// MFS has no atomic replace and no file locks, both are provided within
+// the process: the filesystem must be the only writer of the root, other
+// clients of the IPFS node may see the file replaced by Rename missing
+// and aren't excluded by the file locks.
+type MFSFilesystem struct {
+	// client is the IPFS client the files API is called with.
+	client ipfsclient.Client
+	// root is the MFS directory the filesystem is rooted at.
+	root string
+
+	// mu orders lookups and writes after renames and removals,
+	// so the file replaced by Rename is never seen missing
+	mu sync.RWMutex
+
+	// locks are the advisory locks of the files by their MFS
+	// paths, a lock holds a value while the file is locked
+	locks   map[string]chan struct{}
+	locksMu sync.Mutex
+}
+
+// NewMFSFilesystem creates a new MFSFilesystem instance rooted
+// at the root MFS directory, the directory is created if it
+// doesn't exist.
+func NewMFSFilesystem(client ipfsclient.Client, root string) (billy.Filesystem, error) {
+	fs := &MFSFilesystem{
+		client: client,
+		root:   path.Join("/", filepath.ToSlash(root)),
+		locks:  make(map[string]chan struct{}),
+	}
+
+	if err := fs.client.FilesMkdir(context.Background(), fs.root); err != nil {
+		return nil, fmt.Errorf("failed to create MFS root %s: %w", fs.root, err)
+	}
+
+	return chroot.New(fs, "/"), nil
+}
+
+// RootCID returns the CID of the directory tree under the root.
+func (fs *MFSFilesystem) RootCID(ctx context.Context) (string, error) {
+	stat, err := fs.client.FilesStat(ctx, fs.root)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat MFS root %s: %w", fs.root, err)
+	}
+
+	return stat.Hash, nil
+}
+
+// Create creates a new file with the specified filename, truncating
+// it if it already exists.
+func (fs *MFSFilesystem) Create(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// Open opens the named file for reading.
+func (fs *MFSFilesystem) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the named file with specified flag. Missing parent
+// directories of the created file are created. Contents of files opened
+// for writing are buffered and written to MFS once they are closed.
+func (fs *MFSFilesystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	ctx := context.Background()
+	p := fs.path(filename)
+
+	var created bool
+
+	stat, err := fs.stat("open", filename)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if !isCreate(flag) {
+			return nil, err
+		}
+
+		if isReadOnly(flag) {
+			return nil, fmt.Errorf("creating file without write permissions is not allowed")
+		}
+
+		// the created file exists before it's written
+		if err := fs.client.FilesWrite(ctx, p, strings.NewReader("")); err != nil {
+			return nil, fs.error("open", filename, err)
+		}
+
+		stat = &ipfsclient.FileStat{}
+		created = true
+	case err != nil:
+		return nil, err
+	case isCreate(flag) && isExclusive(flag):
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+	}
+
+	if stat.Dir {
+		return nil, fmt.Errorf("cannot open directory: %s", filename)
+	}
+
+	f := &MFSFile{
+		fs:       fs,
+		FileName: filename,
+		Flag:     flag,
+		path:     p,
+		size:     int64(stat.Size),
+	}
+
+	if isReadOnly(flag) {
+		return f, nil
+	}
+
+	if err := f.load(isTruncate(flag) || created); err != nil {
+		return nil, err
+	}
+
+	if isAppend(flag) {
+		f.Position = int64(f.content.Len())
+	}
+
+	return f, nil
+}
+
+// Stat returns the FileInfo structure describing file.
+func (fs *MFSFilesystem) Stat(filename string) (os.FileInfo, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	stat, err := fs.stat("stat", filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return mfsFileInfo(filepath.Base(filename), stat.Dir, stat.Size), nil
+}
+
+// Rename renames (moves) oldpath to newpath. If newpath already exists and
+// is not a directory, Rename replaces it. Missing parent directories of the
+// newpath are created.
+//
+// MFS moves into existing directories only, so the replaced file is moved
+// aside to the backup name first and moved back if oldpath fails to be
+// moved. The replace is atomic for this filesystem only, the lookups wait
+// for it. Other clients of the node may see newpath missing in between,
+// and the crash in between leaves the replaced file under the backup name.
+func (fs *MFSFilesystem) Rename(oldpath, newpath string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	ctx := context.Background()
+
+	if _, err := fs.stat("rename", oldpath); err != nil {
+		return err
+	}
+
+	stat, err := fs.stat("rename", newpath)
+	switch {
+	case err == nil && stat.Dir:
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errors.New("file exists")}
+	case err == nil:
+		return fs.replace(ctx, oldpath, newpath)
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	default:
+		if err := fs.MkdirAll(filepath.Dir(newpath), 0755); err != nil {
+			return err
+		}
+	}
+
+	if err := fs.client.FilesMv(ctx, fs.path(oldpath), fs.path(newpath)); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+
+	return nil
+}
+
+// replace moves oldpath over the existing newpath through the backup
+// of the replaced file. The filesystem must be locked.
+func (fs *MFSFilesystem) replace(ctx context.Context, oldpath, newpath string) error {
+	target := fs.path(newpath)
+	backup := path.Join(path.Dir(target), fmt.Sprintf(".%s.%d.replaced", path.Base(target), time.Now().UnixNano()))
+
+	if err := fs.client.FilesMv(ctx, target, backup); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+
+	if err := fs.client.FilesMv(ctx, fs.path(oldpath), target); err != nil {
+		if rollback := fs.client.FilesMv(ctx, backup, target); rollback != nil {
+			err = fmt.Errorf("%w, failed to restore the replaced file from %s: %s", err, backup, rollback)
+		}
+
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+
+	// the file is renamed already, the backup is only left behind
+	if err := fs.client.FilesRm(ctx, backup, false); err != nil {
+		logger.Log().Warningf("failed to remove the replaced file %s: %s", backup, err)
+	}
+
+	return nil
+}
+
+// Remove removes the named file or empty directory.
+func (fs *MFSFilesystem) Remove(filename string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	ctx := context.Background()
+
+	stat, err := fs.stat("remove", filename)
+	if err != nil {
+		return err
+	}
+
+	if stat.Dir {
+		entries, err := fs.client.FilesLs(ctx, fs.path(filename))
+		if err != nil {
+			return fs.error("remove", filename, err)
+		}
+
+		if len(entries) != 0 {
+			return fmt.Errorf("dir: %s contains Files", filename)
+		}
+	}
+
+	// directories are removed recursively, they are empty
+	if err := fs.client.FilesRm(ctx, fs.path(filename), stat.Dir); err != nil {
+		return fs.error("remove", filename, err)
+	}
+
+	return nil
+}
+
+// Join joins any number of path elements into a single path.
+func (fs *MFSFilesystem) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+// TempFile creates a new temporary file in the directory dir with a name
+// beginning with prefix and opens it for reading and writing.
+func (fs *MFSFilesystem) TempFile(dir, prefix string) (billy.File, error) {
+	return util.TempFile(fs, dir, prefix)
+}
+
+// ReadDir reads the directory named by dirname and returns a list of
+// directory entries sorted by filename.
+func (fs *MFSFilesystem) ReadDir(dirname string) ([]os.FileInfo, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	entries, err := fs.client.FilesLs(context.Background(), fs.path(dirname))
+	if err != nil {
+		return nil, fs.error("readdir", dirname, err)
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		infos = append(infos, mfsFileInfo(e.Name, e.Dir, e.Size))
+	}
+
+	sort.Sort(ByName(infos))
+
+	return infos, nil
+}
+
+// MkdirAll creates a directory named path, along with any necessary
+// parents. If path is already a directory, MkdirAll does nothing.
+func (fs *MFSFilesystem) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.client.FilesMkdir(context.Background(), fs.path(filename)); err != nil {
+		return fs.error("mkdir", filename, err)
+	}
+
+	return nil
+}
+
+// Lstat returns a FileInfo describing the named file, there are
+// no symbolic links to not follow.
+func (fs *MFSFilesystem) Lstat(filename string) (os.FileInfo, error) {
+	return fs.Stat(filename)
+}
+
+// Symlink isn't supported by MFS.
+func (fs *MFSFilesystem) Symlink(target, link string) error {
+	return &os.LinkError{Op: "symlink", Old: target, New: link, Err: billy.ErrNotSupported}
+}
+
+// Readlink isn't supported by MFS.
+func (fs *MFSFilesystem) Readlink(link string) (string, error) {
+	return "", &os.PathError{Op: "readlink", Path: link, Err: billy.ErrNotSupported}
+}
+
+// Capabilities implements the Capable interface. Files
+// are locked within the process only.
+func (fs *MFSFilesystem) Capabilities() billy.Capability {
+	return billy.WriteCapability |
+		billy.ReadCapability |
+		billy.ReadAndWriteCapability |
+		billy.SeekCapability |
+		billy.TruncateCapability |
+		billy.LockCapability
+}
+
+// path returns the MFS path of the file
+func (fs *MFSFilesystem) path(filename string) string {
+	return path.Join(fs.root, filepath.ToSlash(clean(filename)))
+}
+
+// lock returns the lock of the file by its MFS path
+func (fs *MFSFilesystem) lock(p string) chan struct{} {
+	fs.locksMu.Lock()
+	defer fs.locksMu.Unlock()
+
+	lock, ok := fs.locks[p]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		fs.locks[p] = lock
+	}
+
+	return lock
+}
+
+// stat stats the file in MFS
+func (fs *MFSFilesystem) stat(op, filename string) (*ipfsclient.FileStat, error) {
+	stat, err := fs.client.FilesStat(context.Background(), fs.path(filename))
+	if err != nil {
+		return nil, fs.error(op, filename, err)
+	}
+
+	return stat, nil
+}
+
+// error wraps the files API error with the file name,
+// missing files are reported as os.ErrNotExist
+func (fs *MFSFilesystem) error(op, filename string, err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.ErrNotExist
+	}
+
+	return &os.PathError{Op: op, Path: filename, Err: err}
+}
+
+// mfsFileInfo describes the MFS entry
+func mfsFileInfo(name string, dir bool, size uint64) os.FileInfo {
+	if dir {
+		return &fileInfo{name: name, mode: os.ModeDir | 0755}
+	}
+
+	return &fileInfo{name: name, mode: 0644, size: int(size)}
+}
@@ -0,0 +1,353 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// MFSFile is a go-billy file stored in the IPFS Mutable File System.
+// Files opened for reading are read in ranges from MFS, contents of
+// files opened for writing are buffered in memory and written to MFS
+// once the file is synced or closed.
+type MFSFile struct {
+	// FileName is the name the file is opened with.
+	FileName string
+	// Position is the current position in the file.
+	Position int64
+	// Flag is the file mode flag, such as os.O_RDONLY, os.O_WRONLY, os.O_RDWR, etc.
+	Flag int
+
+	mu sync.Mutex
+
+	fs *MFSFilesystem
+	// path is the MFS path of the file.
+	path string
+	// size is the size of the file opened for reading.
+	size int64
+	// content is the buffered content of the file opened for writing.
+	content *content
+	// dirty indicates the content was written and not stored in MFS yet.
+	dirty bool
+	// locked indicates the lock is held through this file.
+	locked bool
+
+	isClosed bool
+}
+
+// load reads the content of the file opened for writing into
+// memory, the truncated file starts empty
+func (f *MFSFile) load(truncate bool) error {
+	f.content = &content{name: f.FileName}
+
+	if truncate {
+		f.dirty = f.size != 0
+		return nil
+	}
+
+	r, err := f.fs.client.FilesRead(context.Background(), f.path, 0, -1)
+	if err != nil {
+		return f.fs.error("open", f.FileName, err)
+	}
+	defer r.Close()
+
+	var buffer bytes.Buffer
+	if _, err := buffer.ReadFrom(r); err != nil {
+		return fmt.Errorf("error to read file: %w", err)
+	}
+
+	f.content.bytes = buffer.Bytes()
+
+	return nil
+}
+
+// Name returns the name of the file.
+func (f *MFSFile) Name() string {
+	return f.FileName
+}
+
+// Read reads data from the current position of the file.
+func (f *MFSFile) Read(b []byte) (int, error) {
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
+	n, err := f.readAt(b, f.Position)
+	f.Position += int64(n)
+
+	if err == io.EOF && n != 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// ReadAt reads data from the file at a specific offset. Files opened
+// for reading read only the requested range from MFS.
+func (f *MFSFile) ReadAt(b []byte, offset int64) (int, error) {
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
+	return f.readAt(b, offset)
+}
+
+func (f *MFSFile) readAt(b []byte, offset int64) (int, error) {
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if !isReadAndWrite(f.Flag) && !isReadOnly(f.Flag) {
+		return 0, errors.New("read not supported")
+	}
+
+	if f.content != nil {
+		return f.content.ReadAt(b, offset)
+	}
+
+	if offset < 0 {
+		return 0, errors.New("offset out of bounds")
+	}
+
+	if offset >= f.size || len(b) == 0 {
+		return 0, io.EOF
+	}
+
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+
+	r, err := f.fs.client.FilesRead(context.Background(), f.path, offset, int64(len(b)))
+	if err != nil {
+		return 0, f.fs.error("read", f.FileName, err)
+	}
+	defer r.Close()
+
+	n, err := io.ReadFull(r, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// Write writes data to the buffered content of the file.
+func (f *MFSFile) Write(b []byte) (int, error) {
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	if !isReadAndWrite(f.Flag) && !isWriteOnly(f.Flag) {
+		return 0, errors.New("write not supported")
+	}
+
+	n, err := f.content.WriteAt(b, f.Position)
+	if err != nil {
+		return 0, err
+	}
+
+	f.Position += int64(n)
+	f.dirty = true
+
+	return n, nil
+}
+
+// Seek sets the offset for the next read or write to the file.
+func (f *MFSFile) Seek(offset int64, whence int) (int64, error) {
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
+	if f.isClosed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		f.Position += offset
+	case io.SeekStart:
+		f.Position = offset
+	case io.SeekEnd:
+		f.Position = f.Size() + offset
+	default:
+		return 0, errors.New("invalid whence value")
+	}
+
+	return f.Position, nil
+}
+
+// Size returns the size of the file content.
+func (f *MFSFile) Size() int64 {
+	if f.content != nil {
+		return int64(f.content.Len())
+	}
+
+	return f.size
+}
+
+// Truncate changes the size of the file opened for writing.
+func (f *MFSFile) Truncate(size int64) error {
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	if f.content == nil {
+		return errors.New("truncate not supported")
+	}
+
+	if size < int64(len(f.content.bytes)) {
+		f.content.bytes = f.content.bytes[:size]
+	} else if more := int(size) - len(f.content.bytes); more > 0 {
+		f.content.bytes = append(f.content.bytes, make([]byte, more)...)
+	}
+
+	f.dirty = true
+
+	return nil
+}
+
+// Lock locks the file exclusively, it blocks until the lock held through
+// another file opened with the same path is released. The lock is advisory
+// and process-local, MFS has no locks: it only excludes other lockers of
+// the filesystem. Once locked, the file reads the content stored by the
+// previous lock holder, unless it was written already.
+func (f *MFSFile) Lock() error {
+	f.mu.Lock()
+	if f.isClosed {
+		f.mu.Unlock()
+		return os.ErrClosed
+	}
+	if f.locked {
+		f.mu.Unlock()
+		return nil
+	}
+	f.mu.Unlock()
+
+	f.fs.lock(f.path) <- struct{}{}
+
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
+	f.locked = true
+
+	if err := f.reload(); err != nil {
+		f.unlock()
+		return err
+	}
+
+	return nil
+}
+
+// Unlock writes the buffered content to MFS and releases the lock,
+// so the next lock holder reads the content. The lock is released
+// even if the content failed to be written.
+func (f *MFSFile) Unlock() error {
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
+	if !f.locked {
+		return errors.New("file is not locked")
+	}
+
+	err := f.flush()
+	f.unlock()
+
+	return err
+}
+
+// unlock releases the lock held through the file
+func (f *MFSFile) unlock() {
+	<-f.fs.lock(f.path)
+	f.locked = false
+}
+
+// reload reads the content stored while the file was waiting for the
+// lock, the written content is kept
+func (f *MFSFile) reload() error {
+	if f.dirty {
+		return nil
+	}
+
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+
+	if f.content != nil {
+		return f.load(false)
+	}
+
+	stat, err := f.fs.stat("lock", f.FileName)
+	if err != nil {
+		return err
+	}
+
+	f.size = int64(stat.Size)
+
+	return nil
+}
+
+// Stat returns the FileInfo structure describing file.
+func (f *MFSFile) Stat() (os.FileInfo, error) {
+	if f.isClosed {
+		return nil, os.ErrClosed
+	}
+
+	return mfsFileInfo(f.FileName, false, uint64(f.Size())), nil
+}
+
+// Sync writes the buffered content to MFS.
+func (f *MFSFile) Sync() error {
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	return f.flush()
+}
+
+// flush replaces the MFS file content with the content
+// written since the last flush
+func (f *MFSFile) flush() error {
+	if !f.dirty {
+		return nil
+	}
+
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+
+	if err := f.fs.client.FilesWrite(context.Background(), f.path, bytes.NewReader(f.content.bytes)); err != nil {
+		return f.fs.error("write", f.FileName, err)
+	}
+
+	f.dirty = false
+
+	return nil
+}
+
+// Close writes the buffered content to MFS, releases the lock and
+// closes the file. The file is closed even if the content failed to
+// be written.
+func (f *MFSFile) Close() error {
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
+	if f.isClosed {
+		return os.ErrClosed
+	}
+
+	f.isClosed = true
+
+	err := f.flush()
+	if f.locked {
+		f.unlock()
+	}
+
+	return err
+}
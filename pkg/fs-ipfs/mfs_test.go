@@ -0,0 +1,145 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/pkg/ipfsclient/fakeipfs"
+)
+
+// failingMv is the node the moves from the path fail on
+type failingMv struct {
+	*fakeipfs.Node
+	from string
+}
+
+func (n *failingMv) FilesMv(ctx context.Context, from, to string) error {
+	if strings.HasSuffix(from, n.from) {
+		return errors.New("mv failed")
+	}
+
+	return n.Node.FilesMv(ctx, from, to)
+}
+
+func TestMFSFilesystem_RenameAtomic(t *testing.T) {
+	fs, err := NewMFSFilesystem(fakeipfs.NewNode(), ".repos")
+	require.NoError(t, err)
+	require.NoError(t, util.WriteFile(fs, "refs/heads/main", []byte("0"), 0644))
+
+	// readers see the old or the new ref while it's replaced
+	// through the lock file, never the missing one
+	const updates = 50
+
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				data, err := util.ReadFile(fs, "refs/heads/main")
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.NotEmpty(t, data)
+			}
+		}()
+	}
+
+	for i := 1; i <= updates; i++ {
+		require.NoError(t, util.WriteFile(fs, "refs/heads/main.lock", []byte(fmt.Sprint(i)), 0644))
+		require.NoError(t, fs.Rename("refs/heads/main.lock", "refs/heads/main"))
+	}
+
+	close(done)
+	wg.Wait()
+
+	data, err := util.ReadFile(fs, "refs/heads/main")
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(updates), string(data))
+
+	// the replaced files aren't left behind
+	entries, err := fs.ReadDir("refs/heads")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "main", entries[0].Name())
+}
+
+func TestMFSFilesystem_RenameRollback(t *testing.T) {
+	fs, err := NewMFSFilesystem(&failingMv{Node: fakeipfs.NewNode(), from: "/main.lock"}, ".repos")
+	require.NoError(t, err)
+	require.NoError(t, util.WriteFile(fs, "refs/heads/main", []byte("old"), 0644))
+	require.NoError(t, util.WriteFile(fs, "refs/heads/main.lock", []byte("new"), 0644))
+
+	err = fs.Rename("refs/heads/main.lock", "refs/heads/main")
+	assert.ErrorContains(t, err, "mv failed")
+	assert.IsType(t, &os.LinkError{}, err)
+
+	// the replaced file is moved back
+	data, err := util.ReadFile(fs, "refs/heads/main")
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(data))
+
+	entries, err := fs.ReadDir("refs/heads")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestMFSFile_Lock(t *testing.T) {
+	fs, err := NewMFSFilesystem(fakeipfs.NewNode(), ".repos")
+	require.NoError(t, err)
+	require.NoError(t, util.WriteFile(fs, "config", []byte("0"), 0644))
+
+	first, err := fs.OpenFile("config", os.O_RDWR, 0644)
+	require.NoError(t, err)
+	second, err := fs.OpenFile("config", os.O_RDWR, 0644)
+	require.NoError(t, err)
+
+	require.NoError(t, first.Lock())
+
+	locked := make(chan struct{})
+	go func() {
+		defer close(locked)
+		assert.NoError(t, second.Lock())
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("the lock is held by the first file")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_, err = first.Write([]byte("1"))
+	require.NoError(t, err)
+	require.NoError(t, first.Unlock())
+	<-locked
+
+	// the lock holder reads the content stored by the previous one
+	data := make([]byte, 1)
+	_, err = second.ReadAt(data, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(data))
+
+	// closing the file releases the lock
+	require.NoError(t, second.Close())
+	require.NoError(t, first.Lock())
+	require.NoError(t, first.Close())
+}
@@ -6,14 +6,23 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
-	ipfs "github.com/ipfs/go-ipfs-api"
+	"gitsec-backend/pkg/ipfsclient"
 )
 
 const separator = filepath.Separator
 
 // storage is a type that represents a file storage.
 type storage struct {
+	// mu guards the Files and Children maps and
+	// keeps the index journal in their order. The
+	// stored files are changed with both the storage
+	// and the file locked, so either is held to read
+	// them.
+	mu sync.RWMutex
+
 	// Files is a map that stores the Files in the
 	// storage by their path.
 	Files map[string]*IPFSFile
@@ -23,11 +32,19 @@ type storage struct {
 	Children map[string]map[string]*IPFSFile
 
 	// client is an instance of the IPFS client.
-	client *ipfs.Shell
+	client ipfsclient.Client
+
+	// cache is the local disk cache file contents are
+	// read through, it's nil if there is no cache.
+	cache *diskCache
+
+	// index persists the Files, it's nil for
+	// in-memory storage.
+	index *index
 }
 
 // newStorage creates a new storage instance.
-func newStorage(client *ipfs.Shell) *storage {
+func newStorage(client ipfsclient.Client) *storage {
 	return &storage{
 		Files:    make(map[string]*IPFSFile),
 		Children: make(map[string]map[string]*IPFSFile),
@@ -35,15 +52,13 @@ func newStorage(client *ipfs.Shell) *storage {
 	}
 }
 
-// MarshalJSON marshals the storage instance
-// into a JSON representation.
+// MarshalJSON marshals the storage instance into a JSON representation.
+// Only Files are marshaled, Children are rebuilt from them.
 func (s *storage) MarshalJSON() ([]byte, error) {
 	storageJson := struct {
-		Files    map[string]*IPFSFile
-		Children map[string]map[string]*IPFSFile
+		Files map[string]*IPFSFile
 	}{
-		Files:    s.Files,
-		Children: s.Children,
+		Files: s.Files,
 	}
 
 	return json.Marshal(storageJson)
@@ -52,18 +67,25 @@ func (s *storage) MarshalJSON() ([]byte, error) {
 // UnmarshalJSON unmarshals a JSON representation
 // into a storage instance.
 func (s *storage) UnmarshalJSON(bytes []byte) error {
-	var objmap map[string]*json.RawMessage
-
-	if err := json.Unmarshal(bytes, &objmap); err != nil {
-		return fmt.Errorf("failed to unmarshal given data to temp map: %w", err)
+	var storageJson struct {
+		Files map[string]*IPFSFile
 	}
 
-	if err := json.Unmarshal(*objmap["Files"], &s.Files); err != nil {
+	if err := json.Unmarshal(bytes, &storageJson); err != nil {
 		return fmt.Errorf("failed to unmarshal Files data to storage: %w", err)
 	}
 
-	if err := json.Unmarshal(*objmap["Children"], &s.Children); err != nil {
-		return fmt.Errorf("failed to unmarshal Children data to storage: %w", err)
+	s.Files = make(map[string]*IPFSFile, len(storageJson.Files))
+	s.Children = make(map[string]map[string]*IPFSFile)
+
+	for path, f := range storageJson.Files {
+		if f == nil {
+			continue
+		}
+
+		if err := s.put(path, f); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -72,6 +94,13 @@ func (s *storage) UnmarshalJSON(bytes []byte) error {
 // Has checks if a file or directory with the given path exists in the storage.
 // It returns a boolean value indicating whether the file or directory exists.
 func (s *storage) Has(path string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.has(path)
+}
+
+func (s *storage) has(path string) bool {
 	path = clean(path)
 
 	_, ok := s.Files[path]
@@ -81,21 +110,34 @@ func (s *storage) Has(path string) bool {
 // New creates a new file at the specified path. If the file already exists, it
 // returns an error. If the file is a directory, it returns nil.
 func (s *storage) New(path string, mode os.FileMode, flag int) (*IPFSFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.new(path, mode, flag)
+}
+
+func (s *storage) new(path string, mode os.FileMode, flag int) (*IPFSFile, error) {
 	// Clean the path by removing any leading or trailing whitespace
 	// and resolving any relative path elements.
 	path = clean(path)
 
 	// Check if the file already exists in the storage.
-	if s.Has(path) {
+	if s.has(path) {
 		// If the file is not a directory, return an error.
-		if !s.MustGet(path).Mode.IsDir() {
-			return nil, fmt.Errorf("file already exists %q", path)
+		if !s.Files[path].Mode.IsDir() {
+			return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrExist}
 		}
 
 		// Otherwise, return nil as the file is a directory.
 		return nil, nil
 	}
 
+	// The file is journaled before it's added, so the parents it's created
+	// under are checked first and the journaled file is always replayed.
+	if err := s.checkParents(path); err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
 	// Extract the base name of the file from the path.
 	name := filepath.Base(path)
 
@@ -105,7 +147,16 @@ func (s *storage) New(path string, mode os.FileMode, flag int) (*IPFSFile, error
 		content:  &content{name: name},
 		Mode:     mode,
 		Flag:     flag,
+		modTime:  time.Now(),
 		client:   s.client,
+		cache:    s.cache,
+		lock:     make(chan struct{}, 1),
+		path:     path,
+		publish:  s.publish,
+	}
+
+	if err := s.journal(record{Op: opPut, Path: path, File: f}); err != nil {
+		return nil, err
 	}
 
 	// Add the new file to the storage.
@@ -116,12 +167,85 @@ func (s *storage) New(path string, mode os.FileMode, flag int) (*IPFSFile, error
 		return nil, fmt.Errorf("failed to create parent directory for file %q: %w", path, err)
 	}
 
-	if err := f.fillContent(); err != nil {
-		return nil, fmt.Errorf("failed to fill content: %w", err)
-	}
 	return f, nil
 }
 
+// put adds the file restored from the index at the given path, parent
+// directories missing in the index are created. An existing file is
+// updated in place, so it stays shared with its opened duplicates.
+func (s *storage) put(path string, f *IPFSFile) error {
+	path = clean(path)
+
+	if existing, ok := s.Files[path]; ok {
+		existing.mu.Lock()
+		defer existing.mu.Unlock()
+
+		existing.IpfsPath = f.IpfsPath
+		existing.Mode = f.Mode
+		existing.size = f.size
+		existing.modTime = f.modTime
+		existing.content = nil
+		return nil
+	}
+
+	f.FileName = filepath.Base(path)
+	f.client = s.client
+	f.cache = s.cache
+	f.lock = make(chan struct{}, 1)
+	f.path = path
+	f.publish = s.publish
+
+	s.Files[path] = f
+
+	if err := s.createParent(path, f.Mode, f); err != nil {
+		return fmt.Errorf("failed to create parent directory for file %q: %w", path, err)
+	}
+
+	return nil
+}
+
+// fill fetches the content of the file restored from the index,
+// so it's shared with the duplicates of the file, and returns it
+func (s *storage) fill(f *IPFSFile) (*content, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.content == nil {
+		if err := f.fillContent(); err != nil {
+			return nil, err
+		}
+	}
+
+	return f.content, nil
+}
+
+// publish replaces the content of the file with the content stored by
+// its duplicate and journals it, files removed or replaced in the
+// meantime are not journaled
+func (s *storage) publish(f *IPFSFile, ipfsPath string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f.mu.Lock()
+	f.IpfsPath = ipfsPath
+	f.content = &content{name: f.FileName, bytes: data}
+	f.size = int64(len(data))
+	f.modTime = time.Now()
+	f.mu.Unlock()
+
+	if s.Files[f.path] != f {
+		return
+	}
+
+	if err := s.journal(record{Op: opPut, Path: f.path, File: f}); err != nil {
+		// the content stays in memory, it's journaled again with its next update
+		logError(err)
+	}
+}
+
 // createParent creates the parent directory for the given path if it does not already exist.
 // It also adds the file to the Children map for the parent directory.
 func (s *storage) createParent(path string, mode os.FileMode, f *IPFSFile) error {
@@ -131,7 +255,7 @@ func (s *storage) createParent(path string, mode os.FileMode, f *IPFSFile) error
 		return nil
 	}
 
-	if _, err := s.New(base, mode.Perm()|os.ModeDir, 0); err != nil {
+	if _, err := s.new(base, mode.Perm()|os.ModeDir, 0); err != nil {
 		return fmt.Errorf("failed to create parent directory %q: %w", base, err)
 	}
 
@@ -146,6 +270,9 @@ func (s *storage) createParent(path string, mode os.FileMode, f *IPFSFile) error
 // Children returns a slice of IPFSFiles that are Children of the specified directory path.
 // If the path does not exist, or is not a directory, an empty slice is returned.
 func (s *storage) Childrens(path string) []*IPFSFile {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	// Clean the path to remove any leading or trailing whitespace, and ensure that
 	// it uses the correct separator for the current operating system.
 	path = clean(path)
@@ -183,12 +310,14 @@ func (s *storage) MustGet(path string) *IPFSFile {
 // Get retrieves a file from the storage by its path.
 // If the file doesn't exist, it returns a nil value and a false boolean value.
 func (s *storage) Get(path string) (*IPFSFile, bool) {
-	path = clean(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	// Check if the file exists in the storage.
-	if !s.Has(path) {
-		return nil, false
-	}
+	return s.get(path)
+}
+
+func (s *storage) get(path string) (*IPFSFile, bool) {
+	path = clean(path)
 
 	// Retrieve the file from the storage.
 	file, ok := s.Files[path]
@@ -196,28 +325,82 @@ func (s *storage) Get(path string) (*IPFSFile, bool) {
 		return nil, false
 	}
 
-	// Reset the file's state to open and assign the client to it.
-	file.client = s.client
-	file.isClosed = false
-
 	return file, ok
 }
 
 // Rename renames a file or directory located at `from` path to `to` path. If `from` path refers to a
 // directory, all its Children will be also renamed to keep the directory tree structure.
-// If a file or directory with the same name as `to` path already exists, the function will return an error.
+// An existing file at `to` path is replaced, an existing empty directory is replaced by
+// the directory. The rename is checked before anything is changed and it's journaled as
+// a single change, so it's either applied whole or not at all, and concurrent readers see
+// either the old or the new file at `to` path, never a missing one.
 func (s *storage) Rename(from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	from = clean(from)
 	to = clean(to)
 
-	if !s.Has(from) {
+	if err := s.checkRename(from, to); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	if from == to {
+		return nil
+	}
+
+	if err := s.journal(record{Op: opRename, Path: from, To: to}); err != nil {
+		return err
+	}
+
+	return s.rename(from, to)
+}
+
+// checkRename checks the rename can be applied whole
+func (s *storage) checkRename(from, to string) error {
+	src, ok := s.Files[from]
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	if strings.HasPrefix(to, from+string(separator)) {
+		return fmt.Errorf("cannot move %q into itself", from)
+	}
+
+	if dst, ok := s.Files[to]; ok && from != to {
+		switch {
+		case dst.Mode.IsDir() && !src.Mode.IsDir():
+			return fmt.Errorf("%q is a directory", to)
+		case !dst.Mode.IsDir() && src.Mode.IsDir():
+			return fmt.Errorf("%q is not a directory", to)
+		case dst.Mode.IsDir() && len(s.Children[to]) != 0:
+			return fmt.Errorf("directory %q is not empty", to)
+		}
+	}
+
+	return s.checkParents(to)
+}
+
+// checkParents checks the parents of the path can be created as directories
+func (s *storage) checkParents(path string) error {
+	for dir := filepath.Dir(path); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if f, ok := s.Files[dir]; ok && !f.Mode.IsDir() {
+			return fmt.Errorf("%q is not a directory", dir)
+		}
+	}
+
+	return nil
+}
+
+func (s *storage) rename(from, to string) error {
+	if !s.has(from) {
 		return os.ErrNotExist
 	}
 
 	move := [][2]string{{from, to}}
 
 	for pathFrom := range s.Files {
-		if pathFrom == from || !strings.HasPrefix(pathFrom, from) {
+		if pathFrom == from || !strings.HasPrefix(pathFrom, from+string(separator)) {
 			continue
 		}
 
@@ -242,10 +425,15 @@ func (s *storage) Rename(from, to string) error {
 // move renames a file or directory from `from` to `to`.
 // If `to` exists and is not a directory, it is replaced.
 func (s *storage) move(from, to string) error {
+	f := s.Files[from]
+
 	// move the file from `from` to `to` in the `Files` map
-	s.Files[to] = s.Files[from]
+	s.Files[to] = f
 	// update the file's name to the new name
-	s.Files[to].FileName = filepath.Base(to)
+	f.mu.Lock()
+	f.FileName = filepath.Base(to)
+	f.path = to
+	f.mu.Unlock()
 	// move the file's Children from `from` to `to` in the `Children` map
 	s.Children[to] = s.Children[from]
 
@@ -259,195 +447,56 @@ func (s *storage) move(from, to string) error {
 	}()
 
 	// create the parent directories for the new location of the file
-	return s.createParent(to, 0644, s.Files[to])
+	return s.createParent(to, 0644, f)
 }
 
 // Remove removes a file or directory from the storage.
 // If the path does not exist, os.ErrNotExist is returned.
 // If the path refers to a non-empty directory, an error is returned.
+// The removal is checked and journaled before it's applied.
 func (s *storage) Remove(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	path = clean(path)
 
-	f, has := s.Get(path)
-	if !has {
-		return os.ErrNotExist
+	if err := s.checkRemove(path); err != nil {
+		return err
 	}
 
-	if f.Mode.IsDir() && len(s.Children[path]) != 0 {
-		return fmt.Errorf("dir: %s contains Files", path)
+	if err := s.journal(record{Op: opRemove, Path: path}); err != nil {
+		return err
 	}
 
-	base, file := filepath.Split(path)
-	base = filepath.Clean(base)
-
-	delete(s.Children[base], file)
-	delete(s.Files, path)
-	return nil
+	return s.remove(path)
 }
 
-//const fileSystemBackupPath = ".fs.json"
-
-/*
-func (s *storage) LoadStorage() error {
-	// Open a RO file
-	decodeFile, err := os.Open("map.gob")
-	if err != nil {
-		fmt.Println(err)
-		fmt.Printf("%T\n", err)
-		return nil
-	}
-
-	if decodeFile != nil {
-		defer decodeFile.Close()
-	}
-
-	// Create a decoder
-	decoder := gob.NewDecoder(decodeFile)
-
-	// Decode -- We need to pass a pointer otherwise accounts2 isn't modified
-	if err := decoder.Decode(&s); err != nil {
-		panic(err)
-	}
-
-	for _, f := range s.Files {
-		if f == nil {
-			continue
-		}
-
-		f.content = &content{name: f.Name()}
-
-		if f.IpfsPath == "" {
-			continue
-		}
-
-		f.client = s.client
-
-		if err := f.fillContent(); err != nil {
-			return fmt.Errorf("failed to fill content for file: %w", err)
-		}
-
-	}
-
-	fmt.Println(s)
-
-	logger.Log().Info("opening fs storage...")
-	return nil
-
-	fsFile, err := os.Open(fileSystemBackupPath)
-	if err != nil {
-
-		if os.IsNotExist(err) {
-			//return fmt.Errorf("failed to open fs file: %w", err)
-			return nil
-		}
-
-		fsFile, err = os.Create(fileSystemBackupPath)
-		if err != nil {
-			return fmt.Errorf("failed to create fs file: %w", err)
-		}
-
-	}
-
-	defer fsFile.Close()
-
-	readedFs, err := io.ReadAll(fsFile)
-	if err != nil {
-		return fmt.Errorf("failed to read fs file: %w", err)
-	}
-
-	if err := json.Unmarshal(readedFs, &s); err != nil {
-		return fmt.Errorf("failed to unmarshal fs file in storage: %w", err)
-	}
-
-	for _, f := range s.Files {
-		if f == nil {
-			continue
-		}
-
-		f.content = &content{name: f.Name()}
-
-		if f.IpfsPath == "" {
-			continue
-		}
-
-		f.client = s.client
-
-		if err := f.fillContent(); err != nil {
-			return fmt.Errorf("failed to fill content for file: %w", err)
-		}
-	}
-
-	for k, c := range s.Children {
-		fmt.Println(k)
-
-		for k2, d := range c {
-			fmt.Println(k2)
-
-			fmt.Println(d.IpfsPath)
-		}
-
-
-	logger.Log().Info("storage opened")
-
-	fmt.Println(s)
-
-	for _, f := range s.Files {
-		if f == nil {
-			continue
-		}
-		logger.Log().Infof("Name: %s, IPFS: %s, Position: %d, Flag: %d, Mode: %s", f.Name(), f.IpfsPath, f.Position, f.Flag, f.Mode)
-	}
-	return nil
-}*/
-
-/*func (s *storage) SaveStorage() error {
-	encodeFile, err := os.Create("map.gob")
-	if err != nil {
-		panic(err)
-	}
-
-	encoder := gob.NewEncoder(encodeFile)
-
-	// Write to the file
-	if err := encoder.Encode(s); err != nil {
-
-		fmt.Println(err)
-		panic(err)
+// checkRemove checks the path can be removed
+func (s *storage) checkRemove(path string) error {
+	f, has := s.get(path)
+	if !has {
+		return os.ErrNotExist
 	}
 
-	if err := encodeFile.Close(); err != nil {
-		panic(err)
+	if f.Mode.IsDir() && len(s.Children[path]) != 0 {
+		return fmt.Errorf("dir: %s contains Files", path)
 	}
 
-	logger.Log().Info("saving storage...")
-	fmt.Println(s)
 	return nil
+}
 
-	storageJson, err := json.Marshal(s)
-	if err != nil {
-		return fmt.Errorf("error marshaling: %w", err)
-	}
-
-	fsFile, err := os.Create(fileSystemBackupPath)
-	if err != nil {
-		return fmt.Errorf("failed to create fs file: %w", err)
-	}
-	defer fsFile.Close()
-
-	if _, err := fsFile.Write(storageJson); err != nil {
-		return fmt.Errorf("failed to write fs file: %w", err)
+func (s *storage) remove(path string) error {
+	if err := s.checkRemove(path); err != nil {
+		return err
 	}
 
-	logger.Log().Info("storage saved")
-
-	fmt.Println(s)
-
-	for _, f := range s.Files {
-		logger.Log().Infof("Name: %s, IPFS: %s, Position: %d, Flag: %d, Mode: %s", f.Name(), f.IpfsPath, f.Position, f.Flag, f.Mode)
-	}
+	base, file := filepath.Split(path)
+	base = filepath.Clean(base)
 
+	delete(s.Children[base], file)
+	delete(s.Files, path)
 	return nil
-}*/
+}
 
 // clean is a helper function that converts the given path to a cleaned and consistent form.
 // It converts all slashes to the local file system's separator and removes any trailing separator.
@@ -0,0 +1,98 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/pkg/ipfsclient/fakeipfs"
+)
+
+func TestStorage_RenameAtomic(t *testing.T) {
+	fs := &IPFSFilesystem{s: newStorage(fakeipfs.NewNode())}
+	require.NoError(t, util.WriteFile(fs, "refs/heads/main", []byte("0"), 0644))
+
+	// readers see the old or the new ref while it's replaced
+	// through the lock file, never the missing or partial one
+	const updates = 100
+
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				data, err := util.ReadFile(fs, "refs/heads/main")
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.NotEmpty(t, data)
+			}
+		}()
+	}
+
+	for i := 1; i <= updates; i++ {
+		f, err := fs.OpenFile("refs/heads/main.lock", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(fmt.Sprint(i)))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		require.NoError(t, fs.Rename("refs/heads/main.lock", "refs/heads/main"))
+	}
+
+	close(done)
+	wg.Wait()
+
+	data, err := util.ReadFile(fs, "refs/heads/main")
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(updates), string(data))
+}
+
+func TestStorage_RenameChecked(t *testing.T) {
+	dir := t.TempDir()
+
+	s := newStorage(nil)
+	require.NoError(t, s.openIndex(dir))
+
+	_, err := s.New("/repo/objects/pack/a.pack", 0644, os.O_RDWR)
+	require.NoError(t, err)
+	_, err = s.New("/repo/refs/heads/main", 0644, os.O_RDWR)
+	require.NoError(t, err)
+
+	// the failed renames change nothing
+	for _, rename := range [][2]string{
+		{"/repo/refs/heads/main", "/repo/refs/heads"},        // the file over the directory
+		{"/repo/refs/heads/main", "/repo/refs/heads/main/x"}, // the file under the file
+		{"/repo/objects", "/repo/objects/pack/x"},            // the directory into itself
+	} {
+		err := s.Rename(rename[0], rename[1])
+		assert.Error(t, err, rename[1])
+		assert.IsType(t, &os.LinkError{}, err)
+	}
+
+	err = s.Rename("/repo/objects", "/repo/refs")
+	assert.Error(t, err, "the directory isn't empty")
+
+	assert.True(t, s.Has("/repo/objects/pack/a.pack"))
+	assert.True(t, s.Has("/repo/refs/heads/main"))
+
+	// and they aren't journaled
+	restored := newStorage(nil)
+	require.NoError(t, restored.openIndex(dir))
+	assert.Len(t, restored.Files, len(s.Files))
+}
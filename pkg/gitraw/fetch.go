@@ -0,0 +1,228 @@
+package gitraw
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/ipfs/go-cid"
+
+	"gitsec-backend/pkg/multierr"
+)
+
+// BlockGetter is the part of IPFS API git objects are fetched through.
+type BlockGetter interface {
+	// BlockGet returns the raw block
+	BlockGet(ctx context.Context, c cid.Cid) ([]byte, error)
+}
+
+// Decode decodes the git-raw block of the object with the given hash. The
+// block is verified to hash to it, so blocks fetched from IPFS are trusted.
+func Decode(block []byte, obj plumbing.EncodedObject, hash plumbing.Hash) error {
+	header, content, ok := bytes.Cut(block, []byte{0})
+	if !ok {
+		return fmt.Errorf("decode object %s: no header", hash)
+	}
+
+	typ, size, ok := bytes.Cut(header, []byte{' '})
+	if !ok {
+		return fmt.Errorf("decode object %s: invalid header %q", hash, header)
+	}
+
+	objType, err := plumbing.ParseObjectType(string(typ))
+	if err != nil {
+		return fmt.Errorf("decode object %s: %w", hash, err)
+	}
+
+	if n, err := strconv.Atoi(string(size)); err != nil || n != len(content) {
+		return fmt.Errorf("decode object %s: invalid size %q", hash, size)
+	}
+
+	if actual := plumbing.ComputeHash(objType, content); actual != hash {
+		return fmt.Errorf("decode object %s: hash mismatch %s", hash, actual)
+	}
+
+	obj.SetType(objType)
+	obj.SetSize(int64(len(content)))
+
+	w, err := obj.Writer()
+	if err != nil {
+		return fmt.Errorf("decode object %s: %w", hash, err)
+	}
+
+	if _, err := w.Write(content); err != nil {
+		w.Close()
+		return fmt.Errorf("decode object %s: %w", hash, err)
+	}
+
+	return w.Close()
+}
+
+// Fetcher fetches git objects published as git-raw blocks.
+type Fetcher struct {
+	api BlockGetter
+	// concurrency is the maximum number of blocks fetched at the same time
+	concurrency int
+}
+
+// Fetched is the result of objects fetching.
+type Fetched struct {
+	// Fetched is the number of fetched objects
+	Fetched int
+	// Missing are reachable objects not fetched as they were not
+	// published, e.g. objects larger than the size limit
+	Missing []plumbing.Hash
+}
+
+// NewFetcher creates a new Fetcher getting blocks through the given API.
+// Not positive concurrency means blocks are fetched one by one.
+func NewFetcher(api BlockGetter, concurrency int) *Fetcher {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &Fetcher{api: api, concurrency: concurrency}
+}
+
+// Fetch fetches all objects reachable from the commit into the storage.
+// Objects already stored are not fetched, neither are objects reachable
+// from them. Unpublished objects are not fetched, they are reported in
+// the result. Submodule commits are not followed.
+func (f *Fetcher) Fetch(ctx context.Context, s storer.EncodedObjectStorer, commit plumbing.Hash, unpublished []plumbing.Hash) (*Fetched, error) {
+	res := &Fetched{}
+
+	skipped := make(map[plumbing.Hash]bool, len(unpublished))
+	for _, hash := range unpublished {
+		skipped[hash] = true
+	}
+
+	seen := make(map[plumbing.Hash]bool)
+
+	// objects are fetched level by level, every level in parallel
+	level := []plumbing.Hash{commit}
+
+	for len(level) != 0 {
+		var fetch []plumbing.Hash
+
+		for _, hash := range level {
+			if seen[hash] {
+				continue
+			}
+			seen[hash] = true
+
+			if s.HasEncodedObject(hash) == nil {
+				continue
+			}
+
+			if skipped[hash] {
+				res.Missing = append(res.Missing, hash)
+				continue
+			}
+
+			fetch = append(fetch, hash)
+		}
+
+		objects, err := f.fetch(ctx, s, fetch)
+		if err != nil {
+			return nil, err
+		}
+
+		level = level[:0]
+
+		for _, obj := range objects {
+			if _, err := s.SetEncodedObject(obj); err != nil {
+				return nil, fmt.Errorf("store object %s: %w", obj.Hash(), err)
+			}
+
+			res.Fetched++
+
+			children, err := references(s, obj)
+			if err != nil {
+				return nil, err
+			}
+
+			level = append(level, children...)
+		}
+	}
+
+	return res, nil
+}
+
+// fetch fetches and decodes objects in parallel
+func (f *Fetcher) fetch(ctx context.Context, s storer.EncodedObjectStorer, hashes []plumbing.Hash) ([]plumbing.EncodedObject, error) {
+	var (
+		// mu serialises creating objects, storage
+		// is not safe for concurrent use
+		mu      sync.Mutex
+		objects = make([]plumbing.EncodedObject, len(hashes))
+		errs    = make([]error, len(hashes))
+		wg      sync.WaitGroup
+	)
+
+	queue := make(chan int)
+
+	for w := 0; w < f.concurrency && w < len(hashes); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				block, err := f.api.BlockGet(ctx, CID(hashes[i]))
+				if err != nil {
+					errs[i] = fmt.Errorf("get object %s: %w", hashes[i], err)
+					continue
+				}
+
+				mu.Lock()
+				obj := s.NewEncodedObject()
+				mu.Unlock()
+
+				errs[i] = Decode(block, obj, hashes[i])
+				objects[i] = obj
+			}
+		}()
+	}
+
+	for i := range hashes {
+		queue <- i
+	}
+	close(queue)
+
+	wg.Wait()
+
+	if err := multierr.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+// references returns hashes of the objects the object references
+func references(s storer.EncodedObjectStorer, obj plumbing.EncodedObject) ([]plumbing.Hash, error) {
+	decoded, err := object.DecodeObject(s, obj)
+	if err != nil {
+		return nil, fmt.Errorf("decode object %s: %w", obj.Hash(), err)
+	}
+
+	switch o := decoded.(type) {
+	case *object.Commit:
+		return append([]plumbing.Hash{o.TreeHash}, o.ParentHashes...), nil
+	case *object.Tree:
+		var hashes []plumbing.Hash
+		for _, e := range o.Entries {
+			if e.Mode != filemode.Submodule {
+				hashes = append(hashes, e.Hash)
+			}
+		}
+		return hashes, nil
+	case *object.Tag:
+		return []plumbing.Hash{o.Target}, nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,262 @@
+// Package gitraw publishes git objects as IPLD git-raw blocks. A git-raw
+// block is the uncompressed loose object, its CID is the git SHA-1 hash
+// wrapped into CIDv1, so published history can be verified by anyone.
+package gitraw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+
+	"gitsec-backend/pkg/multierr"
+)
+
+// ErrNotGitRaw is returned when the CID is not a git-raw CID
+var ErrNotGitRaw = errors.New("not a git-raw cid")
+
+// BlockAPI is the part of IPFS API git objects are published through.
+type BlockAPI interface {
+	// BlockPut stores the raw block with the given codec and returns its CID
+	BlockPut(ctx context.Context, data []byte, codec uint64) (cid.Cid, error)
+
+	// Pin pins the DAG recursively
+	Pin(ctx context.Context, c cid.Cid) error
+
+	// PinBlock pins the block only, its links aren't traversed
+	PinBlock(ctx context.Context, c cid.Cid) error
+}
+
+// CID returns the git-raw CID of the git object with the given hash.
+func CID(hash plumbing.Hash) cid.Cid {
+	// encoding never fails for known hash function and digest length
+	mhash, _ := mh.Encode(hash[:], mh.SHA1)
+	return cid.NewCidV1(cid.GitRaw, mhash)
+}
+
+// Hash returns the git object hash of the git-raw CID.
+func Hash(c cid.Cid) (plumbing.Hash, error) {
+	if c.Type() != cid.GitRaw {
+		return plumbing.ZeroHash, fmt.Errorf("%w: %s", ErrNotGitRaw, c)
+	}
+
+	decoded, err := mh.Decode(c.Hash())
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("decode multihash of %s: %w", c, err)
+	}
+
+	if decoded.Code != mh.SHA1 || len(decoded.Digest) != len(plumbing.ZeroHash) {
+		return plumbing.ZeroHash, fmt.Errorf("%w: %s is not sha1", ErrNotGitRaw, c)
+	}
+
+	var hash plumbing.Hash
+	copy(hash[:], decoded.Digest)
+	return hash, nil
+}
+
+// Encode encodes the git object as git-raw block.
+func Encode(obj plumbing.EncodedObject) ([]byte, error) {
+	r, err := obj.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("open object %s: %w", obj.Hash(), err)
+	}
+	defer r.Close()
+
+	header := obj.Type().String() + " " + strconv.FormatInt(obj.Size(), 10) + "\x00"
+
+	block := make([]byte, len(header), len(header)+int(obj.Size()))
+	copy(block, header)
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read object %s: %w", obj.Hash(), err)
+	}
+
+	return append(block, content...), nil
+}
+
+// Publisher publishes git objects reachable from commits as git-raw blocks.
+type Publisher struct {
+	api BlockAPI
+	// concurrency is the maximum number of blocks put at the same time
+	concurrency int
+	// maxObjectSize is the maximum size of published object,
+	// not positive size means objects are not limited
+	maxObjectSize int64
+}
+
+// Published is the result of objects publishing.
+type Published struct {
+	// Commit is the commit CID
+	Commit cid.Cid
+	// Put is the number of put objects
+	Put int
+	// Skipped are objects larger than the size limit, they are
+	// missing in IPFS
+	Skipped []plumbing.Hash
+	// Complete is whether the commit DAG is complete and pinned
+	// recursively, otherwise put blocks are pinned one by one
+	Complete bool
+}
+
+// NewPublisher creates a new Publisher putting blocks through the given API.
+// Not positive concurrency means blocks are put one by one. Objects larger
+// than maxObjectSize are not published, so they are never held in memory.
+func NewPublisher(api BlockAPI, concurrency int, maxObjectSize int64) *Publisher {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &Publisher{api: api, concurrency: concurrency, maxObjectSize: maxObjectSize}
+}
+
+// Publish puts all objects reachable from the commit into IPFS and pins the
+// commit DAG recursively. Objects reachable from the published commits are
+// skipped, incomplete is whether objects of the published commits were
+// skipped for their size. Recursive pin of the DAG missing skipped objects
+// never completes, so put blocks are pinned one by one instead then. Those
+// pins aren't released, the blocks stay reachable from the later commits.
+func (p *Publisher) Publish(ctx context.Context, s storer.EncodedObjectStorer, commit plumbing.Hash, published []plumbing.Hash, incomplete bool) (*Published, error) {
+	hashes, err := revlist.Objects(s, []plumbing.Hash{commit}, published)
+	if err != nil {
+		return nil, fmt.Errorf("list objects of %s: %w", commit, err)
+	}
+
+	var (
+		// mu serialises reading objects, repository
+		// storage is not safe for concurrent reads
+		mu      sync.Mutex
+		errs    = make([]error, len(hashes))
+		skipped = make([]bool, len(hashes))
+		wg      sync.WaitGroup
+	)
+
+	queue := make(chan int)
+
+	for w := 0; w < p.concurrency && w < len(hashes); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				mu.Lock()
+				block, err := p.read(s, hashes[i])
+				mu.Unlock()
+
+				switch {
+				case err != nil:
+					errs[i] = err
+				case block == nil:
+					skipped[i] = true
+				default:
+					errs[i] = p.put(ctx, hashes[i], block)
+				}
+			}
+		}()
+	}
+
+	for i := range hashes {
+		queue <- i
+	}
+	close(queue)
+
+	wg.Wait()
+
+	if err := multierr.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	res := &Published{Commit: CID(commit)}
+
+	var put []plumbing.Hash
+	for i, hash := range hashes {
+		if skipped[i] {
+			res.Skipped = append(res.Skipped, hash)
+		} else {
+			put = append(put, hash)
+		}
+	}
+
+	res.Put = len(put)
+	res.Complete = !incomplete && len(res.Skipped) == 0
+
+	if res.Complete {
+		if err := p.api.Pin(ctx, res.Commit); err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+
+	if err := p.pinBlocks(ctx, put); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// pinBlocks pins the put blocks without traversing their links
+func (p *Publisher) pinBlocks(ctx context.Context, hashes []plumbing.Hash) error {
+	var (
+		errs = make([]error, len(hashes))
+		wg   sync.WaitGroup
+	)
+
+	queue := make(chan int)
+
+	for w := 0; w < p.concurrency && w < len(hashes); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if err := p.api.PinBlock(ctx, CID(hashes[i])); err != nil {
+					errs[i] = fmt.Errorf("pin object %s: %w", hashes[i], err)
+				}
+			}
+		}()
+	}
+
+	for i := range hashes {
+		queue <- i
+	}
+	close(queue)
+
+	wg.Wait()
+
+	return multierr.Join(errs...)
+}
+
+// read reads the object as git-raw block. It returns nil block
+// for objects larger than the size limit.
+func (p *Publisher) read(s storer.EncodedObjectStorer, hash plumbing.Hash) ([]byte, error) {
+	obj, err := s.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return nil, fmt.Errorf("get object %s: %w", hash, err)
+	}
+
+	if p.maxObjectSize > 0 && obj.Size() > p.maxObjectSize {
+		return nil, nil
+	}
+
+	return Encode(obj)
+}
+
+// put stores the block and verifies its CID matches the object hash
+func (p *Publisher) put(ctx context.Context, hash plumbing.Hash, block []byte) error {
+	c, err := p.api.BlockPut(ctx, block, cid.GitRaw)
+	if err != nil {
+		return fmt.Errorf("put object %s: %w", hash, err)
+	}
+
+	if expected := CID(hash); !c.Equals(expected) {
+		return fmt.Errorf("put object %s: cid mismatch: stored %s, expected %s", hash, c, expected)
+	}
+
+	return nil
+}
@@ -0,0 +1,263 @@
+package gitraw
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAPI stores blocks in memory
+type fakeAPI struct {
+	mu     sync.Mutex
+	blocks map[cid.Cid][]byte
+	pins   []cid.Cid
+	// blockPins are blocks pinned without their links
+	blockPins []cid.Cid
+}
+
+func (f *fakeAPI) BlockPut(_ context.Context, data []byte, codec uint64) (cid.Cid, error) {
+	c, err := cid.Prefix{Version: 1, Codec: codec, MhType: mh.SHA1, MhLength: -1}.Sum(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	f.mu.Lock()
+	f.blocks[c] = data
+	f.mu.Unlock()
+
+	return c, nil
+}
+
+// Pin pins the DAG recursively, the DAG missing blocks is rejected
+// as IPFS node would never complete pinning it
+func (f *fakeAPI) Pin(_ context.Context, c cid.Cid) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s := memory.NewStorage()
+	queue := []cid.Cid{c}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+
+		block, ok := f.blocks[next]
+		if !ok {
+			return fmt.Errorf("pin: block %s not found", next)
+		}
+
+		hash, err := Hash(next)
+		if err != nil {
+			return err
+		}
+
+		obj := s.NewEncodedObject()
+		if err := Decode(block, obj, hash); err != nil {
+			return err
+		}
+
+		refs, err := references(s, obj)
+		if err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			queue = append(queue, CID(ref))
+		}
+	}
+
+	f.pins = append(f.pins, c)
+	return nil
+}
+
+func (f *fakeAPI) PinBlock(_ context.Context, c cid.Cid) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.blocks[c]; !ok {
+		return fmt.Errorf("pin: block %s not found", c)
+	}
+
+	f.blockPins = append(f.blockPins, c)
+	return nil
+}
+
+// commit writes the file to the worktree and commits it
+func commit(t *testing.T, repo *git.Repository, name, content string) plumbing.Hash {
+	t.Helper()
+
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	require.NoError(t, util.WriteFile(wt.Filesystem, name, []byte(content), 0644))
+	_, err = wt.Add(name)
+	require.NoError(t, err)
+
+	hash, err := wt.Commit("commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "alice", When: time.Unix(1700000000, 0)},
+	})
+	require.NoError(t, err)
+
+	return hash
+}
+
+func TestCID(t *testing.T) {
+	hash := plumbing.NewHash("8ab686eafeb1f44702738c8b0f24f2567c36da6d")
+
+	c := CID(hash)
+	assert.Equal(t, uint64(cid.GitRaw), c.Type())
+	assert.Equal(t, uint64(mh.SHA1), c.Prefix().MhType)
+
+	decoded, err := Hash(c)
+	require.NoError(t, err)
+	assert.Equal(t, hash, decoded)
+
+	raw, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.SHA2_256, MhLength: -1}.Sum([]byte("raw"))
+	require.NoError(t, err)
+
+	_, err = Hash(raw)
+	assert.ErrorIs(t, err, ErrNotGitRaw)
+}
+
+func TestPublisher_Publish(t *testing.T) {
+	storage := memory.NewStorage()
+	repo, err := git.Init(storage, memfs.New())
+	require.NoError(t, err)
+
+	first := commit(t, repo, "a.txt", "a")
+	second := commit(t, repo, "b.txt", "b")
+
+	api := &fakeAPI{blocks: make(map[cid.Cid][]byte)}
+	publisher := NewPublisher(api, 2, 0)
+
+	res, err := publisher.Publish(context.Background(), storage, first, nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, CID(first), res.Commit)
+	// commit, tree and blob
+	assert.Equal(t, 3, res.Put)
+
+	res, err = publisher.Publish(context.Background(), storage, second, []plumbing.Hash{first}, false)
+	require.NoError(t, err)
+	assert.Equal(t, CID(second), res.Commit)
+	// commit, tree and new blob
+	assert.Equal(t, 3, res.Put)
+
+	assert.Len(t, api.blocks, 6)
+	assert.Equal(t, []cid.Cid{CID(first), CID(second)}, api.pins)
+
+	for c, block := range api.blocks {
+		hash, err := Hash(c)
+		require.NoError(t, err)
+		assert.Equal(t, [20]byte(hash), sha1.Sum(block))
+	}
+
+	block := api.blocks[CID(second)]
+	assert.Contains(t, string(block), "commit ")
+	assert.Contains(t, string(block), "parent "+first.String())
+}
+
+func TestPublisher_PublishSizeLimit(t *testing.T) {
+	storage := memory.NewStorage()
+	repo, err := git.Init(storage, memfs.New())
+	require.NoError(t, err)
+
+	large := commit(t, repo, "large.txt", strings.Repeat("x", 1024))
+
+	api := &fakeAPI{blocks: make(map[cid.Cid][]byte)}
+
+	publisher := NewPublisher(api, 2, 512)
+
+	res, err := publisher.Publish(context.Background(), storage, large, nil, false)
+	require.NoError(t, err)
+
+	// commit and tree are put, blob is skipped
+	assert.Equal(t, 2, res.Put)
+	require.Len(t, res.Skipped, 1)
+	assert.NotContains(t, api.blocks, CID(res.Skipped[0]))
+
+	// the incomplete DAG isn't pinned recursively, put blocks are pinned
+	assert.False(t, res.Complete)
+	assert.Empty(t, api.pins)
+	largeCommit, err := object.GetCommit(storage, large)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []cid.Cid{CID(large), CID(largeCommit.TreeHash)}, api.blockPins)
+
+	// the history of the next commit misses the skipped blob
+	small := commit(t, repo, "small.txt", "small")
+
+	_, err = publisher.Publish(context.Background(), storage, small, []plumbing.Hash{large}, false)
+	assert.ErrorContains(t, err, "not found", "recursive pin of the incomplete history fails")
+
+	api.blockPins = nil
+
+	res, err = publisher.Publish(context.Background(), storage, small, []plumbing.Hash{large}, true)
+	require.NoError(t, err)
+	assert.False(t, res.Complete)
+	assert.Empty(t, res.Skipped)
+	assert.Empty(t, api.pins)
+	assert.Len(t, api.blockPins, 3)
+	assert.Contains(t, api.blockPins, CID(small))
+}
+
+func (f *fakeAPI) BlockGet(_ context.Context, c cid.Cid) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	block, ok := f.blocks[c]
+	if !ok {
+		return nil, fmt.Errorf("block %s not found", c)
+	}
+	return block, nil
+}
+
+func TestFetcher_Fetch(t *testing.T) {
+	storage := memory.NewStorage()
+	repo, err := git.Init(storage, memfs.New())
+	require.NoError(t, err)
+
+	first := commit(t, repo, "a.txt", "a")
+	second := commit(t, repo, "large.txt", strings.Repeat("x", 1024))
+
+	api := &fakeAPI{blocks: make(map[cid.Cid][]byte)}
+
+	published, err := NewPublisher(api, 2, 512).Publish(context.Background(), storage, second, nil, false)
+	require.NoError(t, err)
+
+	restored := memory.NewStorage()
+	fetcher := NewFetcher(api, 2)
+
+	res, err := fetcher.Fetch(context.Background(), restored, second, published.Skipped)
+	require.NoError(t, err)
+	// two commits, two trees and one blob
+	assert.Equal(t, 5, res.Fetched)
+	assert.Equal(t, published.Skipped, res.Missing)
+
+	commit, err := object.GetCommit(restored, second)
+	require.NoError(t, err)
+	assert.Equal(t, []plumbing.Hash{first}, commit.ParentHashes)
+
+	// stored objects are not fetched again
+	res, err = fetcher.Fetch(context.Background(), restored, second, nil)
+	require.NoError(t, err)
+	assert.Zero(t, res.Fetched)
+
+	// tampered blocks are rejected
+	block := api.blocks[CID(first)]
+	api.blocks[CID(first)] = append(append([]byte{}, block[:len(block)-1]...), 'X')
+
+	_, err = fetcher.Fetch(context.Background(), memory.NewStorage(), second, published.Skipped)
+	assert.ErrorContains(t, err, "hash mismatch")
+}
@@ -0,0 +1,144 @@
+package gitstore
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/ipfs/go-cid"
+
+	"gitsec-backend/pkg/gitraw"
+	"gitsec-backend/pkg/ipfsclient"
+)
+
+// Blockstore stores git objects as git-raw blocks keyed by the object
+// hash. Blocks are immutable, so the blockstore is shared by repositories.
+type Blockstore interface {
+	// Put stores the block of the object with the given hash,
+	// stored block is not stored again
+	Put(ctx context.Context, hash plumbing.Hash, block []byte) error
+
+	// Get returns the block of the object with the given hash,
+	// plumbing.ErrObjectNotFound is returned for the missing block
+	Get(ctx context.Context, hash plumbing.Hash) ([]byte, error)
+}
+
+// IPFSBlockstore stores blocks in the IPFS node blockstore, the block
+// CID is the object hash wrapped into the git-raw CID. Blocks aren't
+// pinned, they are kept by the pins of published commits.
+type IPFSBlockstore struct {
+	client ipfsclient.Client
+}
+
+// NewIPFSBlockstore creates a new IPFSBlockstore of the IPFS node.
+func NewIPFSBlockstore(client ipfsclient.Client) *IPFSBlockstore {
+	return &IPFSBlockstore{client: client}
+}
+
+func (b *IPFSBlockstore) Put(ctx context.Context, hash plumbing.Hash, block []byte) error {
+	c, err := b.client.BlockPut(ctx, block, cid.GitRaw)
+	if err != nil {
+		return fmt.Errorf("put object %s: %w", hash, err)
+	}
+
+	if !c.Equals(gitraw.CID(hash)) {
+		return fmt.Errorf("put object %s: block stored as %s", hash, c)
+	}
+
+	return nil
+}
+
+func (b *IPFSBlockstore) Get(ctx context.Context, hash plumbing.Hash) ([]byte, error) {
+	block, err := b.client.BlockGet(ctx, gitraw.CID(hash))
+	if err != nil {
+		// the node doesn't tell the missing block from the failure
+		return nil, fmt.Errorf("%w: %s: %s", plumbing.ErrObjectNotFound, hash, err)
+	}
+
+	return block, nil
+}
+
+// FSBlockstore stores blocks compressed in files named by the object
+// hash the way git stores loose objects, e.g. in the local directory.
+type FSBlockstore struct {
+	fs billy.Filesystem
+}
+
+// NewFSBlockstore creates a new FSBlockstore on the filesystem.
+func NewFSBlockstore(fs billy.Filesystem) *FSBlockstore {
+	return &FSBlockstore{fs: fs}
+}
+
+func (b *FSBlockstore) Put(_ context.Context, hash plumbing.Hash, block []byte) error {
+	path := b.path(hash)
+
+	if _, err := b.fs.Stat(path); err == nil {
+		return nil
+	}
+
+	dir := path[:2]
+	if err := b.fs.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("put object %s: %w", hash, err)
+	}
+
+	// the block is written aside and renamed,
+	// so it's never read partially written
+	f, err := b.fs.TempFile(dir, "tmp_block_")
+	if err != nil {
+		return fmt.Errorf("put object %s: %w", hash, err)
+	}
+
+	w := zlib.NewWriter(f)
+	_, err = w.Write(block)
+	if err == nil {
+		err = w.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = b.fs.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = b.fs.Remove(f.Name())
+		return fmt.Errorf("put object %s: %w", hash, err)
+	}
+
+	return nil
+}
+
+func (b *FSBlockstore) Get(_ context.Context, hash plumbing.Hash) ([]byte, error) {
+	f, err := b.fs.Open(b.path(hash))
+	if os.IsNotExist(err) {
+		return nil, plumbing.ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get object %s: %w", hash, err)
+	}
+	defer f.Close()
+
+	r, err := zlib.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("get object %s: %w", hash, err)
+	}
+	defer r.Close()
+
+	var block bytes.Buffer
+	if _, err := io.Copy(&block, r); err != nil {
+		return nil, fmt.Errorf("get object %s: %w", hash, err)
+	}
+
+	return block.Bytes(), nil
+}
+
+// path is the path of the block file, blocks are
+// spread across directories by the hash prefix
+func (b *FSBlockstore) path(hash plumbing.Hash) string {
+	h := hash.String()
+	return b.fs.Join(h[:2], h[2:])
+}
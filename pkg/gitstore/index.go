@@ -0,0 +1,233 @@
+package gitstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+)
+
+const (
+	// IndexFile is the repository index keeping references,
+	// config, worktree index and shallow commits
+	IndexFile = "repository.json"
+	// ObjectsFile records objects of the repository, every
+	// record is the object type followed by its hash
+	ObjectsFile = "objects.idx"
+)
+
+// objectRecord is the size of the object record
+const objectRecord = 1 + len(plumbing.ZeroHash)
+
+// repoIndex is the repository index.
+type repoIndex struct {
+	Refs    map[plumbing.ReferenceName]*plumbing.Reference
+	Shallow []plumbing.Hash
+	Index   *index.Index
+	Config  *config.Config
+
+	// objects are the objects of the repository by their hash
+	objects map[plumbing.Hash]plumbing.ObjectType
+	// pending are the records of objects not persisted yet
+	pending []byte
+}
+
+// indexJson is the JSON representation of the repository index
+type indexJson struct {
+	// Refs are reference targets by their names
+	Refs    map[string]string `json:"refs"`
+	Shallow []string          `json:"shallow,omitempty"`
+	// Index is the encoded worktree index
+	Index []byte `json:"index,omitempty"`
+	// Config is the encoded config
+	Config []byte `json:"config,omitempty"`
+}
+
+// openIndex restores the repository index from the filesystem,
+// the missing index is empty
+func openIndex(fs billy.Filesystem) (*repoIndex, error) {
+	idx := &repoIndex{
+		Refs:    make(map[plumbing.ReferenceName]*plumbing.Reference),
+		objects: make(map[plumbing.Hash]plumbing.ObjectType),
+	}
+
+	if err := idx.readObjects(fs); err != nil {
+		return nil, err
+	}
+
+	data, err := readFile(fs, IndexFile)
+	if err != nil || data == nil {
+		return idx, err
+	}
+
+	var ij indexJson
+	if err := json.Unmarshal(data, &ij); err != nil {
+		return nil, fmt.Errorf("failed to decode repository index: %w", err)
+	}
+
+	for name, target := range ij.Refs {
+		idx.Refs[plumbing.ReferenceName(name)] = plumbing.NewReferenceFromStrings(name, target)
+	}
+
+	for _, h := range ij.Shallow {
+		idx.Shallow = append(idx.Shallow, plumbing.NewHash(h))
+	}
+
+	if ij.Index != nil {
+		idx.Index = &index.Index{}
+		if err := index.NewDecoder(bytes.NewReader(ij.Index)).Decode(idx.Index); err != nil {
+			return nil, fmt.Errorf("failed to decode worktree index: %w", err)
+		}
+	}
+
+	if ij.Config != nil {
+		idx.Config = config.NewConfig()
+		if err := idx.Config.Unmarshal(ij.Config); err != nil {
+			return nil, fmt.Errorf("failed to decode repository config: %w", err)
+		}
+	}
+
+	return idx, nil
+}
+
+// readObjects reads the object records, the torn record the crash
+// left is dropped, so records appended later are read whole
+func (idx *repoIndex) readObjects(fs billy.Filesystem) error {
+	data, err := readFile(fs, ObjectsFile)
+	if err != nil {
+		return err
+	}
+
+	if torn := len(data) % objectRecord; torn != 0 {
+		data = data[:len(data)-torn]
+
+		if err := writeFile(fs, ObjectsFile, data, os.O_WRONLY|os.O_TRUNC); err != nil {
+			return fmt.Errorf("failed to drop torn object record: %w", err)
+		}
+	}
+
+	for ; len(data) != 0; data = data[objectRecord:] {
+		var hash plumbing.Hash
+		copy(hash[:], data[1:objectRecord])
+		idx.objects[hash] = plumbing.ObjectType(data[0])
+	}
+
+	return nil
+}
+
+// addObject records the object of the repository
+func (idx *repoIndex) addObject(hash plumbing.Hash, t plumbing.ObjectType) {
+	if _, ok := idx.objects[hash]; ok {
+		return
+	}
+
+	idx.objects[hash] = t
+	idx.pending = append(idx.pending, byte(t))
+	idx.pending = append(idx.pending, hash[:]...)
+}
+
+// save persists the pending object records and writes the index
+// through the temporary file, so it's either replaced or left intact
+func (idx *repoIndex) save(fs billy.Filesystem) error {
+	if err := idx.saveObjects(fs); err != nil {
+		return err
+	}
+
+	ij := indexJson{Refs: make(map[string]string, len(idx.Refs))}
+
+	for name, ref := range idx.Refs {
+		ij.Refs[name.String()] = ref.Strings()[1]
+	}
+
+	for _, h := range idx.Shallow {
+		ij.Shallow = append(ij.Shallow, h.String())
+	}
+
+	if idx.Index != nil {
+		var buf bytes.Buffer
+		if err := index.NewEncoder(&buf).Encode(idx.Index); err != nil {
+			return fmt.Errorf("failed to encode worktree index: %w", err)
+		}
+		ij.Index = buf.Bytes()
+	}
+
+	if idx.Config != nil {
+		cfg, err := idx.Config.Marshal()
+		if err != nil {
+			return fmt.Errorf("failed to encode repository config: %w", err)
+		}
+		ij.Config = cfg
+	}
+
+	data, err := json.Marshal(ij)
+	if err != nil {
+		return fmt.Errorf("failed to encode repository index: %w", err)
+	}
+
+	tmp := IndexFile + ".tmp"
+
+	if err := writeFile(fs, tmp, data, os.O_WRONLY|os.O_CREATE|os.O_TRUNC); err != nil {
+		return fmt.Errorf("failed to write repository index: %w", err)
+	}
+
+	if err := fs.Rename(tmp, IndexFile); err != nil {
+		return fmt.Errorf("failed to write repository index: %w", err)
+	}
+
+	return nil
+}
+
+// saveObjects appends the pending object records
+func (idx *repoIndex) saveObjects(fs billy.Filesystem) error {
+	if len(idx.pending) == 0 {
+		return nil
+	}
+
+	if err := writeFile(fs, ObjectsFile, idx.pending, os.O_WRONLY|os.O_CREATE|os.O_APPEND); err != nil {
+		return fmt.Errorf("failed to record repository objects: %w", err)
+	}
+
+	idx.pending = nil
+
+	return nil
+}
+
+// readFile reads the file, the missing file has no content
+func readFile(fs billy.Filesystem, name string) ([]byte, error) {
+	f, err := fs.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	return data, nil
+}
+
+// writeFile writes the data to the file opened with the flag
+func writeFile(fs billy.Filesystem, name string, data []byte, flag int) error {
+	f, err := fs.OpenFile(name, flag, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
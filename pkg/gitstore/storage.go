@@ -0,0 +1,353 @@
+// Package gitstore is the go-git storage of repositories over
+// content-addressed blocks. Objects are stored as git-raw blocks keyed
+// by their hash in the Blockstore shared by repositories, everything
+// else, references, config, index and shallow commits, is kept in the
+// small repository index. Nothing is emulated as files in the hot path:
+// objects are put and got by hash, references are updated in memory
+// and the index is written atomically once they change.
+package gitstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
+
+	"gitsec-backend/pkg/gitraw"
+)
+
+// Storage is the go-git storage.Storer of the repository. Objects are
+// stored in the Blockstore, the repository index is persisted on the
+// filesystem. The objects of the repository are recorded in its index,
+// so objects of other repositories sharing the Blockstore aren't visible.
+type Storage struct {
+	blocks Blockstore
+	fs     billy.Filesystem
+	// cache caches decoded objects, it may be shared by repositories
+	cache cache.Object
+
+	mu sync.RWMutex
+	// index is the repository index
+	index *repoIndex
+}
+
+var _ storage.Storer = (*Storage)(nil)
+
+// NewStorage opens the repository storage, the repository index is
+// restored from the filesystem. Objects are cached in the given cache.
+func NewStorage(blocks Blockstore, fs billy.Filesystem, objects cache.Object) (*Storage, error) {
+	idx, err := openIndex(fs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Storage{
+		blocks: blocks,
+		fs:     fs,
+		cache:  objects,
+		index:  idx,
+	}, nil
+}
+
+// NewEncodedObject returns a new empty in-memory object.
+func (s *Storage) NewEncodedObject() plumbing.EncodedObject {
+	return &plumbing.MemoryObject{}
+}
+
+// SetEncodedObject puts the object block to the Blockstore and records
+// the object in the repository. The record is persisted with the next
+// reference update, so references never point to unrecorded objects.
+func (s *Storage) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash, error) {
+	if obj.Type() == plumbing.OFSDeltaObject || obj.Type() == plumbing.REFDeltaObject {
+		return plumbing.ZeroHash, plumbing.ErrInvalidType
+	}
+
+	block, err := gitraw.Encode(obj)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	hash := obj.Hash()
+
+	if err := s.blocks.Put(context.Background(), hash, block); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	s.mu.Lock()
+	s.index.addObject(hash, obj.Type())
+	s.mu.Unlock()
+
+	return hash, nil
+}
+
+// EncodedObject returns the object of the repository, objects not
+// recorded in the repository are not found.
+func (s *Storage) EncodedObject(t plumbing.ObjectType, hash plumbing.Hash) (plumbing.EncodedObject, error) {
+	s.mu.RLock()
+	typ, ok := s.index.objects[hash]
+	s.mu.RUnlock()
+
+	if !ok || (t != plumbing.AnyObject && t != typ) {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	if obj, ok := s.cache.Get(hash); ok {
+		return obj, nil
+	}
+
+	block, err := s.blocks.Get(context.Background(), hash)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := s.NewEncodedObject()
+	if err := gitraw.Decode(block, obj, hash); err != nil {
+		return nil, err
+	}
+
+	s.cache.Put(obj)
+
+	return obj, nil
+}
+
+// IterEncodedObjects iterates over the objects of the given type
+// recorded in the repository.
+func (s *Storage) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var hashes []plumbing.Hash
+	for hash, typ := range s.index.objects {
+		if t == plumbing.AnyObject || t == typ {
+			hashes = append(hashes, hash)
+		}
+	}
+
+	return storer.NewEncodedObjectLookupIter(s, t, hashes), nil
+}
+
+// HasEncodedObject checks the object is recorded in the repository.
+func (s *Storage) HasEncodedObject(hash plumbing.Hash) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.index.objects[hash]; !ok {
+		return plumbing.ErrObjectNotFound
+	}
+	return nil
+}
+
+// EncodedObjectSize returns the size of the object content.
+func (s *Storage) EncodedObjectSize(hash plumbing.Hash) (int64, error) {
+	obj, err := s.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return 0, err
+	}
+	return obj.Size(), nil
+}
+
+// SetReference sets the reference and persists the repository index.
+func (s *Storage) SetReference(ref *plumbing.Reference) error {
+	return s.CheckAndSetReference(ref, nil)
+}
+
+// CheckAndSetReference sets the reference if its current value is the
+// old one, storage.ErrReferenceHasChanged is returned otherwise. Nil
+// old reference sets the reference unconditionally.
+func (s *Storage) CheckAndSetReference(ref, old *plumbing.Reference) error {
+	if ref == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old != nil {
+		if current, ok := s.index.Refs[ref.Name()]; ok && current.Hash() != old.Hash() {
+			return storage.ErrReferenceHasChanged
+		}
+	}
+
+	prev, existed := s.index.Refs[ref.Name()]
+	s.index.Refs[ref.Name()] = ref
+
+	if err := s.index.save(s.fs); err != nil {
+		if existed {
+			s.index.Refs[ref.Name()] = prev
+		} else {
+			delete(s.index.Refs, ref.Name())
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Reference returns the reference with the given name.
+func (s *Storage) Reference(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ref, ok := s.index.Refs[name]
+	if !ok {
+		return nil, plumbing.ErrReferenceNotFound
+	}
+	return ref, nil
+}
+
+// IterReferences iterates over the references of the repository.
+func (s *Storage) IterReferences() (storer.ReferenceIter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	refs := make([]*plumbing.Reference, 0, len(s.index.Refs))
+	for _, ref := range s.index.Refs {
+		refs = append(refs, ref)
+	}
+
+	return storer.NewReferenceSliceIter(refs), nil
+}
+
+// RemoveReference removes the reference, missing reference is not an error.
+func (s *Storage) RemoveReference(name plumbing.ReferenceName) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ref, ok := s.index.Refs[name]
+	if !ok {
+		return nil
+	}
+
+	delete(s.index.Refs, name)
+
+	if err := s.index.save(s.fs); err != nil {
+		s.index.Refs[name] = ref
+		return err
+	}
+
+	return nil
+}
+
+// CountLooseRefs returns the number of references, they are all
+// kept in the repository index.
+func (s *Storage) CountLooseRefs() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.index.Refs), nil
+}
+
+// PackRefs does nothing, references are kept in the repository index.
+func (s *Storage) PackRefs() error {
+	return nil
+}
+
+// SetShallow sets the shallow commits of the repository.
+func (s *Storage) SetShallow(commits []plumbing.Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := s.index.Shallow
+	s.index.Shallow = commits
+
+	if err := s.index.save(s.fs); err != nil {
+		s.index.Shallow = prev
+		return err
+	}
+
+	return nil
+}
+
+// Shallow returns the shallow commits of the repository.
+func (s *Storage) Shallow() ([]plumbing.Hash, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.index.Shallow, nil
+}
+
+// SetIndex sets the worktree index of the repository.
+func (s *Storage) SetIndex(idx *index.Index) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := s.index.Index
+	s.index.Index = idx
+
+	if err := s.index.save(s.fs); err != nil {
+		s.index.Index = prev
+		return err
+	}
+
+	return nil
+}
+
+// Index returns the worktree index of the repository,
+// the empty index is returned if it's not set.
+func (s *Storage) Index() (*index.Index, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.index.Index == nil {
+		return &index.Index{Version: 2}, nil
+	}
+	return s.index.Index, nil
+}
+
+// SetConfig validates and sets the repository config.
+func (s *Storage) SetConfig(cfg *config.Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := s.index.Config
+	s.index.Config = cfg
+
+	if err := s.index.save(s.fs); err != nil {
+		s.index.Config = prev
+		return err
+	}
+
+	return nil
+}
+
+// Config returns the repository config,
+// the new config is returned if it's not set.
+func (s *Storage) Config() (*config.Config, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.index.Config == nil {
+		return config.NewConfig(), nil
+	}
+	return s.index.Config, nil
+}
+
+// Module returns the storage of the submodule, its index is
+// kept under the modules directory of the repository.
+func (s *Storage) Module(name string) (storage.Storer, error) {
+	fs, err := s.fs.Chroot(s.fs.Join("modules", name))
+	if err != nil {
+		return nil, fmt.Errorf("open module %s: %w", name, err)
+	}
+
+	return NewStorage(s.blocks, fs, s.cache)
+}
+
+// Close persists objects recorded since the last reference update.
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.index.saveObjects(s.fs)
+}
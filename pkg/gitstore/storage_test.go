@@ -0,0 +1,207 @@
+package gitstore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/pkg/ipfsclient/fakeipfs"
+)
+
+func commit(t *testing.T, repo *git.Repository, name, content string) plumbing.Hash {
+	w, err := repo.Worktree()
+	require.NoError(t, err)
+
+	require.NoError(t, util.WriteFile(w.Filesystem, name, []byte(content), 0644))
+	_, err = w.Add(name)
+	require.NoError(t, err)
+
+	hash, err := w.Commit("add "+name, &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Unix(1700000000, 0)},
+	})
+	require.NoError(t, err)
+
+	return hash
+}
+
+func TestStorage_Repository(t *testing.T) {
+	blocks := NewFSBlockstore(memfs.New())
+	fs := memfs.New()
+
+	s, err := NewStorage(blocks, fs, cache.NewObjectLRUDefault())
+	require.NoError(t, err)
+
+	repo, err := git.Init(s, memfs.New())
+	require.NoError(t, err)
+
+	first := commit(t, repo, "README.md", "# api\n")
+	second := commit(t, repo, "main.go", "package main\n")
+	require.NoError(t, s.Close())
+
+	// everything is restored from the blocks and the repository index
+	s, err = NewStorage(blocks, fs, cache.NewObjectLRUDefault())
+	require.NoError(t, err)
+
+	repo, err = git.Open(s, nil)
+	require.NoError(t, err)
+
+	head, err := repo.Head()
+	require.NoError(t, err)
+	assert.Equal(t, second, head.Hash())
+
+	c, err := repo.CommitObject(second)
+	require.NoError(t, err)
+	assert.Equal(t, []plumbing.Hash{first}, c.ParentHashes)
+
+	f, err := c.File("main.go")
+	require.NoError(t, err)
+	content, err := f.Contents()
+	require.NoError(t, err)
+	assert.Equal(t, "package main\n", content)
+
+	idx, err := s.Index()
+	require.NoError(t, err)
+	assert.Len(t, idx.Entries, 2)
+
+	cfg, err := s.Config()
+	require.NoError(t, err)
+	assert.False(t, cfg.Core.IsBare)
+
+	commits, err := s.IterEncodedObjects(plumbing.CommitObject)
+	require.NoError(t, err)
+	var n int
+	require.NoError(t, commits.ForEach(func(obj plumbing.EncodedObject) error {
+		assert.Equal(t, plumbing.CommitObject, obj.Type())
+		n++
+		return nil
+	}))
+	assert.Equal(t, 2, n)
+
+	// objects of other repositories sharing the blocks aren't visible
+	other, err := NewStorage(blocks, memfs.New(), cache.NewObjectLRUDefault())
+	require.NoError(t, err)
+	assert.ErrorIs(t, other.HasEncodedObject(second), plumbing.ErrObjectNotFound)
+	_, err = other.EncodedObject(plumbing.AnyObject, second)
+	assert.ErrorIs(t, err, plumbing.ErrObjectNotFound)
+}
+
+func TestStorage_ReceivePack(t *testing.T) {
+	src, err := git.Init(memory.NewStorage(), memfs.New())
+	require.NoError(t, err)
+	hash := commit(t, src, "README.md", "# api\n")
+
+	var hashes []plumbing.Hash
+	iter, err := src.Storer.IterEncodedObjects(plumbing.AnyObject)
+	require.NoError(t, err)
+	require.NoError(t, iter.ForEach(func(obj plumbing.EncodedObject) error {
+		hashes = append(hashes, obj.Hash())
+		return nil
+	}))
+
+	var pack bytes.Buffer
+	_, err = packfile.NewEncoder(&pack, src.Storer, false).Encode(hashes, 10)
+	require.NoError(t, err)
+
+	fs := memfs.New()
+	dst, err := NewStorage(NewFSBlockstore(memfs.New()), fs, cache.NewObjectLRUDefault())
+	require.NoError(t, err)
+
+	// the pack is parsed into blocks the way receive-pack stores it
+	require.NoError(t, packfile.UpdateObjectStorage(dst, &pack))
+	assert.NoError(t, dst.HasEncodedObject(hash))
+
+	main := plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), hash)
+	require.NoError(t, dst.SetReference(main))
+
+	// the concurrent update is detected
+	stale := plumbing.NewHashReference(main.Name(), plumbing.ZeroHash)
+	err = dst.CheckAndSetReference(plumbing.NewHashReference(main.Name(), hash), stale)
+	assert.ErrorIs(t, err, storage.ErrReferenceHasChanged)
+
+	// the reference update persists the received objects
+	restored, err := NewStorage(dst.blocks, fs, cache.NewObjectLRUDefault())
+	require.NoError(t, err)
+	assert.NoError(t, restored.HasEncodedObject(hash))
+
+	ref, err := restored.Reference(main.Name())
+	require.NoError(t, err)
+	assert.Equal(t, hash, ref.Hash())
+
+	require.NoError(t, restored.RemoveReference(main.Name()))
+	_, err = restored.Reference(main.Name())
+	assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+}
+
+func TestStorage_TornObjects(t *testing.T) {
+	fs := memfs.New()
+
+	s, err := NewStorage(NewFSBlockstore(memfs.New()), fs, cache.NewObjectLRUDefault())
+	require.NoError(t, err)
+
+	first, err := s.SetEncodedObject(blob("first"))
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// the crash tore the next record
+	f, err := fs.OpenFile(ObjectsFile, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{byte(plumbing.BlobObject), 0xab})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	s, err = NewStorage(s.blocks, fs, cache.NewObjectLRUDefault())
+	require.NoError(t, err)
+	assert.NoError(t, s.HasEncodedObject(first))
+
+	second, err := s.SetEncodedObject(blob("second"))
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	s, err = NewStorage(s.blocks, fs, cache.NewObjectLRUDefault())
+	require.NoError(t, err)
+	assert.NoError(t, s.HasEncodedObject(first))
+	assert.NoError(t, s.HasEncodedObject(second))
+	assert.Len(t, s.index.objects, 2)
+}
+
+func TestIPFSBlockstore(t *testing.T) {
+	ctx := context.Background()
+	blocks := NewIPFSBlockstore(fakeipfs.NewNode())
+
+	s, err := NewStorage(blocks, memfs.New(), cache.NewObjectLRUDefault())
+	require.NoError(t, err)
+
+	hash, err := s.SetEncodedObject(blob("content"))
+	require.NoError(t, err)
+
+	obj, err := s.EncodedObject(plumbing.BlobObject, hash)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("content")), obj.Size())
+
+	_, err = s.EncodedObject(plumbing.CommitObject, hash)
+	assert.ErrorIs(t, err, plumbing.ErrObjectNotFound, "the type doesn't match")
+
+	_, err = blocks.Get(ctx, plumbing.ComputeHash(plumbing.BlobObject, []byte("missing")))
+	assert.ErrorIs(t, err, plumbing.ErrObjectNotFound)
+}
+
+func blob(content string) plumbing.EncodedObject {
+	obj := &plumbing.MemoryObject{}
+	obj.SetType(plumbing.BlobObject)
+	_, _ = obj.Write([]byte(content))
+	return obj
+}
@@ -0,0 +1,75 @@
+// Package ipfsclient is the narrow client of the IPFS node API the
+// filesystems and pinners depend on. Shell implements it over the node
+// HTTP API, fakeipfs implements it in memory for tests.
+package ipfsclient
+
+import (
+	"context"
+	"io"
+
+	"github.com/ipfs/go-cid"
+)
+
+// Client is the part of the IPFS node API content is stored through.
+// Missing MFS files are reported as os.ErrNotExist.
+type Client interface {
+	// Add adds the content as CIDv1 UnixFS file with raw leaves
+	// and returns its CID, the content is pinned if pin is set
+	Add(ctx context.Context, r io.Reader, pin bool) (string, error)
+
+	// Cat returns the content of the IPFS path
+	Cat(ctx context.Context, path string) (io.ReadCloser, error)
+
+	// Pin pins the DAG recursively
+	Pin(ctx context.Context, path string) error
+
+	// Unpin removes the recursive pin of the DAG
+	Unpin(ctx context.Context, path string) error
+
+	// Pins lists CIDs of recursively pinned DAGs
+	Pins(ctx context.Context) ([]string, error)
+
+	// BlockPut stores the raw block with the given codec and returns its
+	// CIDv1. Blocks are hashed with sha2-256, git-raw ones with sha1.
+	BlockPut(ctx context.Context, data []byte, codec uint64) (cid.Cid, error)
+
+	// BlockGet returns the raw block
+	BlockGet(ctx context.Context, c cid.Cid) ([]byte, error)
+
+	// FilesMkdir creates the MFS directory with its parents,
+	// existing directory is not an error
+	FilesMkdir(ctx context.Context, path string) error
+
+	// FilesStat describes the MFS file or directory
+	FilesStat(ctx context.Context, path string) (*FileStat, error)
+
+	// FilesLs lists the MFS directory
+	FilesLs(ctx context.Context, path string) ([]*FileStat, error)
+
+	// FilesRead reads count bytes of the MFS file from the offset,
+	// negative count reads until the end
+	FilesRead(ctx context.Context, path string, offset, count int64) (io.ReadCloser, error)
+
+	// FilesWrite replaces the content of the MFS file,
+	// the file and its parents are created if they are missing
+	FilesWrite(ctx context.Context, path string, r io.Reader) error
+
+	// FilesMv moves the MFS file or directory
+	FilesMv(ctx context.Context, from, to string) error
+
+	// FilesRm removes the MFS file, directories are removed
+	// only recursively
+	FilesRm(ctx context.Context, path string, recursive bool) error
+}
+
+// FileStat describes the MFS file or directory.
+type FileStat struct {
+	// Name is the name of the listed entry
+	Name string
+	// Hash is the CID of the file or directory
+	Hash string
+	// Size is the size of the file content
+	Size uint64
+	// Dir indicates the directory
+	Dir bool
+}
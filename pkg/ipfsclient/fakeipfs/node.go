@@ -0,0 +1,506 @@
+// Package fakeipfs is an in-memory fake of the IPFS node for tests. Content
+// is addressed by the CIDs the node assigns to it, blocks, pins and the
+// Mutable File System are kept in memory.
+package fakeipfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+
+	"gitsec-backend/pkg/ipfsclient"
+	"gitsec-backend/pkg/unixfs"
+)
+
+// Node is the fake IPFS node, it implements ipfsclient.Client.
+type Node struct {
+	mu sync.Mutex
+
+	// local computes CIDs of blocks and added files
+	local *unixfs.LocalAPI
+	// blocks are all stored blocks by their CIDs
+	blocks map[cid.Cid][]byte
+	// contents are added files by their CIDs
+	contents map[string][]byte
+	// pins are recursively pinned CIDs
+	pins map[string]bool
+	// root is the MFS root directory
+	root *entry
+
+	// adds is the number of added files
+	adds int
+}
+
+var _ ipfsclient.Client = (*Node)(nil)
+
+// entry is the MFS file or directory
+type entry struct {
+	dir      bool
+	data     []byte
+	children map[string]*entry
+}
+
+func newDir() *entry {
+	return &entry{dir: true, children: make(map[string]*entry)}
+}
+
+// NewNode creates a new empty Node.
+func NewNode() *Node {
+	return &Node{
+		local:    unixfs.NewLocalAPI(),
+		blocks:   make(map[cid.Cid][]byte),
+		contents: make(map[string][]byte),
+		pins:     make(map[string]bool),
+		root:     newDir(),
+	}
+}
+
+// Adds returns the number of files added to the node.
+func (n *Node) Adds() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.adds
+}
+
+// Forget drops all added contents and blocks, as if
+// they were garbage collected.
+func (n *Node) Forget() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.blocks = make(map[cid.Cid][]byte)
+	n.contents = make(map[string][]byte)
+}
+
+func (n *Node) Add(_ context.Context, r io.Reader, pin bool) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var buf bytes.Buffer
+
+	link, err := unixfs.ImportFile(io.TeeReader(r, &buf), n.putBlock)
+	if err != nil {
+		return "", fmt.Errorf("add: %w", err)
+	}
+
+	hash := link.Cid.String()
+	n.contents[hash] = buf.Bytes()
+	n.adds++
+
+	if pin {
+		n.pins[hash] = true
+	}
+
+	return hash, nil
+}
+
+func (n *Node) Cat(_ context.Context, path string) (io.ReadCloser, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	data, ok := n.contents[strings.TrimPrefix(path, "/ipfs/")]
+	if !ok {
+		return nil, fmt.Errorf("cat %s: content not found", path)
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (n *Node) Pin(_ context.Context, path string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	hash := strings.TrimPrefix(path, "/ipfs/")
+	if !n.has(hash) {
+		return fmt.Errorf("pin %s: content not found", path)
+	}
+
+	n.pins[hash] = true
+	return nil
+}
+
+func (n *Node) Unpin(_ context.Context, path string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	hash := strings.TrimPrefix(path, "/ipfs/")
+	if !n.pins[hash] {
+		return fmt.Errorf("unpin %s: not pinned or pinned indirectly", path)
+	}
+
+	delete(n.pins, hash)
+	return nil
+}
+
+func (n *Node) Pins(context.Context) ([]string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	pins := make([]string, 0, len(n.pins))
+	for hash := range n.pins {
+		pins = append(pins, hash)
+	}
+	sort.Strings(pins)
+
+	return pins, nil
+}
+
+func (n *Node) BlockPut(ctx context.Context, data []byte, codec uint64) (cid.Cid, error) {
+	c, err := n.local.BlockPut(ctx, data, codec)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return c, n.putBlock(c, data)
+}
+
+func (n *Node) BlockGet(_ context.Context, c cid.Cid) ([]byte, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	data, ok := n.blocks[c]
+	if !ok {
+		return nil, fmt.Errorf("block get %s: block not found", c)
+	}
+
+	return append([]byte{}, data...), nil
+}
+
+// putBlock stores the block. The node must be locked.
+func (n *Node) putBlock(c cid.Cid, data []byte) error {
+	n.blocks[c] = append([]byte{}, data...)
+	return nil
+}
+
+// has checks whether the content or the block is stored.
+// The node must be locked.
+func (n *Node) has(hash string) bool {
+	if _, ok := n.contents[hash]; ok {
+		return true
+	}
+
+	c, err := cid.Decode(hash)
+	if err != nil {
+		return false
+	}
+
+	_, ok := n.blocks[c]
+	return ok
+}
+
+func (n *Node) FilesMkdir(_ context.Context, path string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	_, err := n.mkdirAll("mkdir", split(path))
+	return err
+}
+
+func (n *Node) FilesStat(ctx context.Context, path string) (*ipfsclient.FileStat, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	e, err := n.lookup("stat", split(path))
+	if err != nil {
+		return nil, err
+	}
+
+	return n.stat(ctx, base(path), e)
+}
+
+func (n *Node) FilesLs(ctx context.Context, path string) ([]*ipfsclient.FileStat, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	e, err := n.lookup("ls", split(path))
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.dir {
+		stat, err := n.stat(ctx, base(path), e)
+		if err != nil {
+			return nil, err
+		}
+		return []*ipfsclient.FileStat{stat}, nil
+	}
+
+	names := make([]string, 0, len(e.children))
+	for name := range e.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make([]*ipfsclient.FileStat, 0, len(names))
+	for _, name := range names {
+		stat, err := n.stat(ctx, name, e.children[name])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, stat)
+	}
+
+	return res, nil
+}
+
+func (n *Node) FilesRead(_ context.Context, path string, offset, count int64) (io.ReadCloser, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	e, err := n.lookup("read", split(path))
+	if err != nil {
+		return nil, err
+	}
+
+	if e.dir {
+		return nil, pathError("read", path, errors.New("not a file"))
+	}
+
+	if offset < 0 || offset > int64(len(e.data)) {
+		return nil, pathError("read", path, errors.New("offset out of bounds"))
+	}
+
+	data := e.data[offset:]
+	if count >= 0 && count < int64(len(data)) {
+		data = data[:count]
+	}
+
+	return io.NopCloser(bytes.NewReader(append([]byte{}, data...))), nil
+}
+
+func (n *Node) FilesWrite(_ context.Context, path string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return pathError("write", path, err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	elems := split(path)
+	if len(elems) == 0 {
+		return pathError("write", path, errors.New("not a file"))
+	}
+
+	parent, err := n.mkdirAll("write", elems[:len(elems)-1])
+	if err != nil {
+		return err
+	}
+
+	name := elems[len(elems)-1]
+	if existing, ok := parent.children[name]; ok && existing.dir {
+		return pathError("write", path, errors.New("not a file"))
+	}
+
+	parent.children[name] = &entry{data: data}
+	return nil
+}
+
+// FilesMv moves the entry the way MFS does: the entry moved
+// to the existing directory is moved into it.
+func (n *Node) FilesMv(_ context.Context, from, to string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	fromElems := split(from)
+	if len(fromElems) == 0 {
+		return pathError("mv", from, errors.New("cannot move root"))
+	}
+
+	fromParent, err := n.lookup("mv", fromElems[:len(fromElems)-1])
+	if err != nil {
+		return err
+	}
+
+	name := fromElems[len(fromElems)-1]
+	e, ok := fromParent.children[name]
+	if !ok {
+		return pathError("mv", from, os.ErrNotExist)
+	}
+
+	toElems := split(to)
+	if target, err := n.lookup("mv", toElems); err == nil {
+		if !target.dir {
+			return pathError("mv", to, errors.New("directory already has entry by that name"))
+		}
+		toElems = append(toElems, name)
+	}
+
+	if len(toElems) == 0 || strings.HasPrefix(strings.Join(toElems, "/")+"/", strings.Join(fromElems, "/")+"/") {
+		return pathError("mv", to, errors.New("cannot move into itself"))
+	}
+
+	toParent, err := n.lookup("mv", toElems[:len(toElems)-1])
+	if err != nil {
+		return err
+	}
+	if !toParent.dir {
+		return pathError("mv", to, errors.New("not a directory"))
+	}
+
+	toName := toElems[len(toElems)-1]
+	if _, ok := toParent.children[toName]; ok {
+		return pathError("mv", to, errors.New("directory already has entry by that name"))
+	}
+
+	delete(fromParent.children, name)
+	toParent.children[toName] = e
+
+	return nil
+}
+
+func (n *Node) FilesRm(_ context.Context, path string, recursive bool) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	elems := split(path)
+	if len(elems) == 0 {
+		return pathError("rm", path, errors.New("cannot remove root"))
+	}
+
+	parent, err := n.lookup("rm", elems[:len(elems)-1])
+	if err != nil {
+		return err
+	}
+
+	name := elems[len(elems)-1]
+	e, ok := parent.children[name]
+	if !ok {
+		return pathError("rm", path, os.ErrNotExist)
+	}
+
+	if e.dir && !recursive {
+		return pathError("rm", path, errors.New("is a directory, use -r to remove directories"))
+	}
+
+	delete(parent.children, name)
+	return nil
+}
+
+// lookup finds the entry by the path elements. The node must be locked.
+func (n *Node) lookup(op string, elems []string) (*entry, error) {
+	e := n.root
+	for i, elem := range elems {
+		if !e.dir {
+			return nil, pathError(op, "/"+strings.Join(elems[:i], "/"), errors.New("not a directory"))
+		}
+
+		child, ok := e.children[elem]
+		if !ok {
+			return nil, pathError(op, "/"+strings.Join(elems[:i+1], "/"), os.ErrNotExist)
+		}
+		e = child
+	}
+	return e, nil
+}
+
+// mkdirAll creates the directory with its parents. The node must be locked.
+func (n *Node) mkdirAll(op string, elems []string) (*entry, error) {
+	e := n.root
+	for i, elem := range elems {
+		child, ok := e.children[elem]
+		if !ok {
+			child = newDir()
+			e.children[elem] = child
+		}
+
+		if !child.dir {
+			return nil, pathError(op, "/"+strings.Join(elems[:i+1], "/"), errors.New("not a directory"))
+		}
+		e = child
+	}
+	return e, nil
+}
+
+// stat describes the entry with the CID the node assigns to it. The
+// content of the file gets cat-able by its CID. The node must be locked.
+func (n *Node) stat(ctx context.Context, name string, e *entry) (*ipfsclient.FileStat, error) {
+	link, err := n.link(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+
+	stat := &ipfsclient.FileStat{Name: name, Hash: link.Cid.String(), Dir: e.dir}
+	if !e.dir {
+		stat.Size = uint64(len(e.data))
+	}
+
+	return stat, nil
+}
+
+// link builds the DAG of the entry and returns the link to its root.
+// The node must be locked.
+func (n *Node) link(ctx context.Context, e *entry) (unixfs.Link, error) {
+	if !e.dir {
+		link, err := unixfs.ImportFile(bytes.NewReader(e.data), n.putBlock)
+		if err != nil {
+			return unixfs.Link{}, err
+		}
+
+		n.contents[link.Cid.String()] = e.data
+		return link, nil
+	}
+
+	node := unixfs.DirectoryNode()
+
+	var size uint64
+	for name, child := range e.children {
+		link, err := n.link(ctx, child)
+		if err != nil {
+			return unixfs.Link{}, err
+		}
+
+		link.Name = name
+		node.Links = append(node.Links, link)
+		size += link.Tsize
+	}
+
+	data := node.Marshal()
+
+	c, err := n.local.BlockPut(ctx, data, cid.DagProtobuf)
+	if err != nil {
+		return unixfs.Link{}, err
+	}
+
+	if err := n.putBlock(c, data); err != nil {
+		return unixfs.Link{}, err
+	}
+
+	return unixfs.Link{Cid: c, Tsize: size + uint64(len(data))}, nil
+}
+
+// base is the last element of the path
+func base(path string) string {
+	elems := split(path)
+	if len(elems) == 0 {
+		return ""
+	}
+	return elems[len(elems)-1]
+}
+
+// split splits the MFS path into its elements
+func split(path string) []string {
+	var elems []string
+	for _, elem := range strings.Split(path, "/") {
+		if elem != "" && elem != "." {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+func pathError(op, path string, err error) error {
+	return &os.PathError{Op: "files " + op, Path: path, Err: err}
+}
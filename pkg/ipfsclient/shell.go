@@ -0,0 +1,201 @@
+package ipfsclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	ipfs "github.com/ipfs/go-ipfs-api"
+	files "github.com/ipfs/go-ipfs-files"
+
+	"gitsec-backend/pkg/unixfs"
+)
+
+// Shell is Client over the IPFS node HTTP API.
+type Shell struct {
+	shell *ipfs.Shell
+	// blocks puts and gets blocks the way unixfs publishes them
+	blocks *unixfs.ShellAPI
+}
+
+// NewShell creates a new Shell of the node API at the address.
+func NewShell(addr string) *Shell {
+	return Wrap(ipfs.NewShell(addr))
+}
+
+// Wrap creates a new Shell with the given IPFS client.
+func Wrap(shell *ipfs.Shell) *Shell {
+	return &Shell{shell: shell, blocks: unixfs.NewShellAPI(shell)}
+}
+
+func (s *Shell) Add(ctx context.Context, r io.Reader, pin bool) (string, error) {
+	var out struct {
+		Hash string
+	}
+
+	err := s.shell.Request("add").
+		Option("cid-version", 1).
+		Option("pin", pin).
+		Body(multipart(files.NewReaderFile(r))).
+		Exec(ctx, &out)
+	if err != nil {
+		return "", fmt.Errorf("add: %w", err)
+	}
+
+	return out.Hash, nil
+}
+
+func (s *Shell) Cat(ctx context.Context, path string) (io.ReadCloser, error) {
+	resp, err := s.shell.Request("cat", path).Send(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cat %s: %w", path, err)
+	}
+
+	if resp.Error != nil {
+		resp.Close()
+		return nil, fmt.Errorf("cat %s: %w", path, resp.Error)
+	}
+
+	return resp.Output, nil
+}
+
+func (s *Shell) Pin(ctx context.Context, path string) error {
+	if err := s.shell.Request("pin/add", path).Option("recursive", true).Exec(ctx, nil); err != nil {
+		return fmt.Errorf("pin %s: %w", path, err)
+	}
+	return nil
+}
+
+func (s *Shell) Unpin(ctx context.Context, path string) error {
+	if err := s.shell.Request("pin/rm", path).Option("recursive", true).Exec(ctx, nil); err != nil {
+		return fmt.Errorf("unpin %s: %w", path, err)
+	}
+	return nil
+}
+
+func (s *Shell) Pins(ctx context.Context) ([]string, error) {
+	pins, err := s.shell.PinsOfType(ctx, ipfs.RecursivePin)
+	if err != nil {
+		return nil, fmt.Errorf("list pins: %w", err)
+	}
+
+	res := make([]string, 0, len(pins))
+	for hash := range pins {
+		res = append(res, hash)
+	}
+	return res, nil
+}
+
+func (s *Shell) BlockPut(ctx context.Context, data []byte, codec uint64) (cid.Cid, error) {
+	return s.blocks.BlockPut(ctx, data, codec)
+}
+
+func (s *Shell) BlockGet(ctx context.Context, c cid.Cid) ([]byte, error) {
+	return s.blocks.BlockGet(ctx, c)
+}
+
+func (s *Shell) FilesMkdir(ctx context.Context, path string) error {
+	if err := s.shell.FilesMkdir(ctx, path, ipfs.FilesMkdir.Parents(true)); err != nil {
+		return filesError("mkdir", path, err)
+	}
+	return nil
+}
+
+func (s *Shell) FilesStat(ctx context.Context, path string) (*FileStat, error) {
+	stat, err := s.shell.FilesStat(ctx, path)
+	if err != nil {
+		return nil, filesError("stat", path, err)
+	}
+
+	return &FileStat{
+		Name: basename(path),
+		Hash: stat.Hash,
+		Size: stat.Size,
+		Dir:  stat.Type == "directory",
+	}, nil
+}
+
+// mfsDirectory is the type of directory entries listed by files/ls
+const mfsDirectory = 1
+
+func (s *Shell) FilesLs(ctx context.Context, path string) ([]*FileStat, error) {
+	entries, err := s.shell.FilesLs(ctx, path, ipfs.FilesLs.Stat(true))
+	if err != nil {
+		return nil, filesError("ls", path, err)
+	}
+
+	res := make([]*FileStat, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, &FileStat{
+			Name: e.Name,
+			Hash: e.Hash,
+			Size: e.Size,
+			Dir:  e.Type == mfsDirectory,
+		})
+	}
+	return res, nil
+}
+
+func (s *Shell) FilesRead(ctx context.Context, path string, offset, count int64) (io.ReadCloser, error) {
+	opts := []ipfs.FilesOpt{ipfs.FilesRead.Offset(offset)}
+	if count >= 0 {
+		opts = append(opts, ipfs.FilesRead.Count(count))
+	}
+
+	r, err := s.shell.FilesRead(ctx, path, opts...)
+	if err != nil {
+		return nil, filesError("read", path, err)
+	}
+	return r, nil
+}
+
+func (s *Shell) FilesWrite(ctx context.Context, path string, r io.Reader) error {
+	err := s.shell.FilesWrite(ctx, path, r,
+		ipfs.FilesWrite.Create(true),
+		ipfs.FilesWrite.Parents(true),
+		ipfs.FilesWrite.Truncate(true))
+	if err != nil {
+		return filesError("write", path, err)
+	}
+	return nil
+}
+
+func (s *Shell) FilesMv(ctx context.Context, from, to string) error {
+	if err := s.shell.FilesMv(ctx, from, to); err != nil {
+		return filesError("mv", from, err)
+	}
+	return nil
+}
+
+func (s *Shell) FilesRm(ctx context.Context, path string, recursive bool) error {
+	// force removes directories recursively
+	if err := s.shell.FilesRm(ctx, path, recursive); err != nil {
+		return filesError("rm", path, err)
+	}
+	return nil
+}
+
+// filesError wraps the files API error, missing
+// files are reported as os.ErrNotExist
+func filesError(op, path string, err error) error {
+	if strings.Contains(err.Error(), "does not exist") {
+		err = os.ErrNotExist
+	}
+
+	return &os.PathError{Op: "files " + op, Path: path, Err: err}
+}
+
+// basename is the last element of the MFS path
+func basename(path string) string {
+	path = strings.TrimRight(path, "/")
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// multipart wraps the single file into the API request body
+func multipart(f files.Node) io.Reader {
+	dir := files.NewSliceDirectory([]files.DirEntry{files.FileEntry("", f)})
+	return files.NewMultiFileReader(dir, true)
+}
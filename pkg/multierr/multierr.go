@@ -0,0 +1,68 @@
+// Package multierr combines several errors into one. It stands in for
+// errors.Join, which isn't available in the Go version the module targets,
+// errors.Is and errors.As match any of the combined errors.
+package multierr
+
+import (
+	"errors"
+	"strings"
+)
+
+// Error is a list of errors reported as one
+type Error struct {
+	errs []error
+}
+
+// Join returns an error combining the given non-nil errors,
+// it returns nil if there are none of them
+func Join(errs ...error) error {
+	var res []error
+	for _, err := range errs {
+		if err != nil {
+			res = append(res, err)
+		}
+	}
+
+	if len(res) == 0 {
+		return nil
+	}
+
+	return &Error{errs: res}
+}
+
+// Errors returns the combined errors
+func (e *Error) Errors() []error {
+	return e.errs
+}
+
+// Error returns messages of the combined errors separated by newlines
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Is reports whether any of the combined errors matches target
+func (e *Error) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first of the combined errors that matches target
+func (e *Error) As(target any) bool {
+	for _, err := range e.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,31 @@
+package multierr
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoin(t *testing.T) {
+	assert.NoError(t, Join())
+	assert.NoError(t, Join(nil, nil))
+
+	pathErr := &fs.PathError{Op: "open", Path: "a", Err: os.ErrNotExist}
+	err := Join(nil, io.EOF, pathErr)
+	require.Error(t, err)
+	assert.Equal(t, "EOF\nopen a: file does not exist", err.Error())
+	assert.Len(t, err.(*Error).Errors(), 2)
+
+	assert.ErrorIs(t, err, io.EOF)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NotErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	var target *fs.PathError
+	require.True(t, errors.As(err, &target))
+	assert.Equal(t, pathErr, target)
+}
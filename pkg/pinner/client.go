@@ -0,0 +1,147 @@
+package pinner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// defaultBackoff is the delay before the first retry of a failed request
+	defaultBackoff = time.Second
+	// maxBackoff is the maximum delay between retries
+	maxBackoff = 30 * time.Second
+)
+
+// HTTPOptions configures requests to pinner APIs.
+type HTTPOptions struct {
+	// RateLimit is the maximum number of requests per second,
+	// not positive means requests aren't limited
+	RateLimit float64
+	// Retries is the number of retries of transient failures
+	Retries int
+}
+
+// httpClient sends pinner API requests limiting their rate. Transient
+// failures and throttled requests are retried with jittered exponential
+// backoff.
+type httpClient struct {
+	client  *http.Client
+	limiter *rate.Limiter
+	retries int
+	// backoff is the delay before the first retry
+	backoff time.Duration
+}
+
+// newHTTPClient creates a new httpClient with the given options
+func newHTTPClient(opts HTTPOptions) *httpClient {
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	if opts.RateLimit > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.RateLimit), 1)
+	}
+
+	return &httpClient{
+		client:  &http.Client{},
+		limiter: limiter,
+		retries: opts.Retries,
+		backoff: defaultBackoff,
+	}
+}
+
+// do sends the request created by newRequest until the response has the
+// expected status. The request is created again for every attempt, so its
+// body can be read again. Returned response body must be closed.
+func (c *httpClient) do(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error), expected int) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		res, err := c.send(ctx, newRequest, expected)
+		if err == nil {
+			return res, nil
+		}
+
+		if attempt >= c.retries || !Retryable(err) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		delay := c.delay(attempt)
+
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+			delay = statusErr.RetryAfter
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w, retry cancelled: %s", err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// send sends the request once
+func (c *httpClient) send(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error), expected int) (*http.Response, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("wait for rate limit: %w", err)
+	}
+
+	req, err := newRequest(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("do request: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("do request: %w: %s", ErrTransient, err)
+	}
+
+	if res.StatusCode == expected {
+		return res, nil
+	}
+	defer res.Body.Close()
+
+	return nil, statusError(res)
+}
+
+// delay returns the jittered delay before the retry after the given attempt
+func (c *httpClient) delay(attempt int) time.Duration {
+	delay := c.backoff << attempt
+	if delay > maxBackoff || delay <= 0 {
+		delay = maxBackoff
+	}
+
+	// half of the delay is random, so throttled clients don't retry at once
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// statusError reads the API error from the unexpected response
+func statusError(res *http.Response) *StatusError {
+	err := &StatusError{Status: res.StatusCode}
+
+	if seconds, perr := strconv.Atoi(res.Header.Get("Retry-After")); perr == nil && seconds > 0 {
+		err.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+
+	apiErr := &Error{}
+	if json.Unmarshal(body, apiErr) == nil && apiErr.Error.Reason != "" {
+		err.Reason = apiErr.Error.Reason
+		err.Details = apiErr.Error.Details
+		return err
+	}
+
+	err.Details = string(body)
+	return err
+}
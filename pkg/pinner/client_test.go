@@ -0,0 +1,90 @@
+package pinner
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// statusServer responds with the given statuses one by one, the last
+// status is repeated
+func statusServer(statuses ...int) (*httptest.Server, *int32) {
+	var calls int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1)) - 1
+		if n >= len(statuses) {
+			n = len(statuses) - 1
+		}
+		w.WriteHeader(statuses[n])
+		_, _ = w.Write([]byte(`{"error":{"reason":"REASON","details":"details"}}`))
+	})), &calls
+}
+
+func testClient(retries int) *httpClient {
+	c := newHTTPClient(HTTPOptions{Retries: retries})
+	c.backoff = time.Millisecond
+	return c
+}
+
+func get(url string) func(ctx context.Context) (*http.Request, error) {
+	return func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	}
+}
+
+func TestHTTPClient_Retries(t *testing.T) {
+	srv, calls := statusServer(http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	defer srv.Close()
+
+	res, err := testClient(2).do(context.Background(), get(srv.URL), http.StatusOK)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.EqualValues(t, 3, atomic.LoadInt32(calls))
+}
+
+func TestHTTPClient_Errors(t *testing.T) {
+	cases := []struct {
+		status int
+		err    error
+		calls  int32
+	}{
+		{status: http.StatusUnauthorized, err: ErrUnauthorized, calls: 1},
+		{status: http.StatusPaymentRequired, err: ErrQuota, calls: 1},
+		{status: http.StatusTooManyRequests, err: ErrRateLimited, calls: 3},
+		{status: http.StatusBadGateway, err: ErrTransient, calls: 3},
+	}
+
+	for _, tc := range cases {
+		t.Run(http.StatusText(tc.status), func(t *testing.T) {
+			srv, calls := statusServer(tc.status)
+			defer srv.Close()
+
+			_, err := testClient(2).do(context.Background(), get(srv.URL), http.StatusOK)
+			assert.ErrorIs(t, err, tc.err)
+			assert.ErrorContains(t, err, "REASON: details")
+			assert.Equal(t, tc.calls, atomic.LoadInt32(calls))
+		})
+	}
+}
+
+func TestHTTPClient_RateLimit(t *testing.T) {
+	srv, _ := statusServer(http.StatusOK)
+	defer srv.Close()
+
+	c := newHTTPClient(HTTPOptions{RateLimit: 50})
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		res, err := c.do(context.Background(), get(srv.URL), http.StatusOK)
+		require.NoError(t, err)
+		res.Body.Close()
+	}
+
+	assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+}
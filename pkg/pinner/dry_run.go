@@ -0,0 +1,38 @@
+package pinner
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"gitsec-backend/pkg/unixfs"
+)
+
+// DryRun computes CIDs of the content the way other pinners would pin it,
+// without pinning anything.
+type DryRun struct{}
+
+// NewDryRunPinner creates a new DryRun pinner.
+func NewDryRunPinner() IPinner {
+	return DryRun{}
+}
+
+func (DryRun) Pin(_ context.Context, fileName string, file io.Reader) (string, error) {
+	c, err := unixfs.FileCID(file)
+	if err != nil {
+		return "", fmt.Errorf("compute %s cid: %w", fileName, err)
+	}
+	return c.String(), nil
+}
+
+func (DryRun) PinHash(context.Context, string, string) error {
+	return nil
+}
+
+func (DryRun) Unpin(context.Context, string) error {
+	return nil
+}
+
+func (DryRun) List(context.Context, string) ([]PinInfo, error) {
+	return nil, nil
+}
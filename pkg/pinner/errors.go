@@ -0,0 +1,77 @@
+package pinner
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Pinner API failures. StatusError and request errors wrap one of them,
+// so callers can tell failures worth retrying from the permanent ones.
+var (
+	// ErrUnauthorized is returned when the pinner rejects the credentials
+	ErrUnauthorized = errors.New("pinner authorization failed")
+	// ErrQuota is returned when the pinner account is out of its plan limits
+	ErrQuota = errors.New("pinner quota exceeded")
+	// ErrRateLimited is returned when the pinner throttles requests
+	ErrRateLimited = errors.New("pinner rate limit exceeded")
+	// ErrTransient is returned on network failures and server errors
+	ErrTransient = errors.New("pinner temporarily unavailable")
+	// ErrEmptyHash is returned when the pinner doesn't report the pinned CID
+	ErrEmptyHash = errors.New("pinner returned empty CID")
+)
+
+// StatusError is the unexpected HTTP status returned by the pinner API.
+type StatusError struct {
+	Status  int
+	Reason  string
+	Details string
+	// RetryAfter is the delay the server asked to wait before retrying
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	if e.Reason == "" && e.Details == "" {
+		return fmt.Sprintf("unexpected status %d", e.Status)
+	}
+	if e.Reason == "" {
+		return fmt.Sprintf("unexpected status %d: %s", e.Status, e.Details)
+	}
+	return fmt.Sprintf("unexpected status %d: %s: %s", e.Status, e.Reason, e.Details)
+}
+
+// Unwrap returns the failure class of the status.
+func (e *StatusError) Unwrap() error {
+	switch {
+	case e.Status == http.StatusPaymentRequired:
+		return ErrQuota
+	case e.Status == http.StatusForbidden && mentionsLimit(e.Reason+" "+e.Details):
+		return ErrQuota
+	case e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden:
+		return ErrUnauthorized
+	case e.Status == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.Status == http.StatusRequestTimeout || e.Status >= http.StatusInternalServerError:
+		return ErrTransient
+	default:
+		return nil
+	}
+}
+
+// Retryable reports whether the failed request is worth retrying.
+func Retryable(err error) bool {
+	return errors.Is(err, ErrTransient) || errors.Is(err, ErrRateLimited)
+}
+
+// permanent reports whether the request fails until the account is fixed
+func permanent(err error) bool {
+	return errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrQuota)
+}
+
+// mentionsLimit reports whether the error text is about plan limits
+func mentionsLimit(text string) bool {
+	text = strings.ToLower(text)
+	return strings.Contains(text, "limit") || strings.Contains(text, "quota") || strings.Contains(text, "payment")
+}
@@ -0,0 +1,292 @@
+// Package fakepinning is an in-process fake of the IPFS Pinning Service API
+// for tests. Pins are kept in memory and become pinned after a configured
+// number of status checks.
+package fakepinning
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pin statuses
+const (
+	StatusQueued  = "queued"
+	StatusPinning = "pinning"
+	StatusPinned  = "pinned"
+	StatusFailed  = "failed"
+)
+
+// Pin is the pinned object.
+type Pin struct {
+	Cid     string            `json:"cid"`
+	Name    string            `json:"name,omitempty"`
+	Origins []string          `json:"origins,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+// PinStatus is the status of the pin request.
+type PinStatus struct {
+	RequestID string    `json:"requestid"`
+	Status    string    `json:"status"`
+	Created   time.Time `json:"created"`
+	Pin       Pin       `json:"pin"`
+	Delegates []string  `json:"delegates"`
+}
+
+// pinRequest is the stored pin request
+type pinRequest struct {
+	status PinStatus
+	// checks is the number of the status checks
+	checks int
+}
+
+// Server is the fake Pinning Service API.
+type Server struct {
+	token string
+
+	mu       sync.Mutex
+	requests map[string]*pinRequest
+	nextID   int
+
+	// pinAfter is the number of status checks after which the pin is pinned
+	pinAfter int
+	// fail are CIDs which fail to be pinned
+	fail map[string]bool
+}
+
+// NewServer creates a new Server accepting the given bearer token.
+func NewServer(token string) *Server {
+	return &Server{
+		token:    token,
+		requests: make(map[string]*pinRequest),
+		pinAfter: 1,
+		fail:     make(map[string]bool),
+	}
+}
+
+// PinAfter sets the number of status checks after which pins are pinned.
+func (s *Server) PinAfter(checks int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pinAfter = checks
+}
+
+// Fail makes pins of the given CID fail.
+func (s *Server) Fail(cid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fail[cid] = true
+}
+
+// Pins returns statuses of all pin requests.
+func (s *Server) Pins() []PinStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res []PinStatus
+	for _, r := range s.requests {
+		res = append(res, r.status)
+	}
+	return res
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+s.token {
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid access token")
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/pins"), "/")
+
+	switch {
+	case r.Method == http.MethodPost && id == "":
+		s.add(w, r)
+	case r.Method == http.MethodGet && id == "":
+		s.list(w, r)
+	case r.Method == http.MethodGet:
+		s.get(w, id)
+	case r.Method == http.MethodDelete:
+		s.remove(w, id)
+	default:
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", fmt.Sprintf("unsupported %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// add handles POST /pins
+func (s *Server) add(w http.ResponseWriter, r *http.Request) {
+	var pin Pin
+	if err := json.NewDecoder(r.Body).Decode(&pin); err != nil || pin.Cid == "" {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid pin")
+		return
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	req := &pinRequest{status: PinStatus{
+		RequestID: fmt.Sprintf("request-%d", s.nextID),
+		Status:    StatusQueued,
+		Created:   time.Now().UTC(),
+		Pin:       pin,
+		Delegates: []string{"/ip4/127.0.0.1/tcp/4001/p2p/QmFakeDelegate"},
+	}}
+	s.requests[req.status.RequestID] = req
+	status := req.status
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+// get handles GET /pins/{requestid}
+func (s *Server) get(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	req, ok := s.requests[id]
+	if ok {
+		req.checks++
+		switch {
+		case s.fail[req.status.Pin.Cid]:
+			req.status.Status = StatusFailed
+		case req.checks >= s.pinAfter:
+			req.status.Status = StatusPinned
+		default:
+			req.status.Status = StatusPinning
+		}
+	}
+	var status PinStatus
+	if ok {
+		status = req.status
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "pin request not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// list handles GET /pins filtered by cid, name, status and creation time.
+// Results are sorted by creation time in descending order.
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	cids := make(map[string]bool)
+	for _, c := range strings.Split(query.Get("cid"), ",") {
+		if c != "" {
+			cids[c] = true
+		}
+	}
+
+	statuses := make(map[string]bool)
+	for _, st := range strings.Split(query.Get("status"), ",") {
+		if st != "" {
+			statuses[st] = true
+		}
+	}
+
+	var before time.Time
+	if b := query.Get("before"); b != "" {
+		var err error
+		if before, err = time.Parse(time.RFC3339Nano, b); err != nil {
+			writeError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid before")
+			return
+		}
+	}
+
+	limit := 10
+	if l := query.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			writeError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid limit")
+			return
+		}
+	}
+
+	s.mu.Lock()
+	results := []PinStatus{}
+	for _, req := range s.requests {
+		st := req.status
+		if len(cids) != 0 && !cids[st.Pin.Cid] {
+			continue
+		}
+		if name := query.Get("name"); name != "" && !matchName(st.Pin.Name, name, query.Get("match")) {
+			continue
+		}
+		if len(statuses) != 0 && !statuses[st.Status] {
+			continue
+		}
+		if !before.IsZero() && !st.Created.Before(before) {
+			continue
+		}
+		results = append(results, st)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Created.After(results[j].Created)
+	})
+
+	count := len(results)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count":   count,
+		"results": results,
+	})
+}
+
+// matchName matches the pin name with the text match strategy
+func matchName(name, text, match string) bool {
+	switch match {
+	case "iexact":
+		return strings.EqualFold(name, text)
+	case "partial":
+		return strings.Contains(name, text)
+	case "ipartial":
+		return strings.Contains(strings.ToLower(name), strings.ToLower(text))
+	default:
+		return name == text
+	}
+}
+
+// remove handles DELETE /pins/{requestid}
+func (s *Server) remove(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	_, ok := s.requests[id]
+	delete(s.requests, id)
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "pin request not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// writeJSON writes JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes the Pinning Service API error
+func writeError(w http.ResponseWriter, status int, reason, details string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]string{
+			"reason":  reason,
+			"details": details,
+		},
+	})
+}
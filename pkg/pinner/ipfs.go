@@ -1,24 +1,85 @@
 package pinner
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"strings"
 
-	ipfs "github.com/ipfs/go-ipfs-api"
+	"gitsec-backend/pkg/ipfsclient"
 )
 
 type IPFS struct {
-	shell *ipfs.Shell
+	client ipfsclient.Client
 }
 
 func NewIpfsPinner(ipfsAddr string) IPinner {
-	return &IPFS{shell: ipfs.NewShell(ipfsAddr)}
+	return NewIpfsClientPinner(ipfsclient.NewShell(ipfsAddr))
 }
 
-func (p *IPFS) Pin(fileName string, file io.Reader) (string, error) {
-	hash, err := p.shell.Add(file, ipfs.Pin(true))
+// NewIpfsClientPinner creates a new IPFS pinner pinning
+// content on the node of the given client.
+func NewIpfsClientPinner(client ipfsclient.Client) IPinner {
+	return &IPFS{client: client}
+}
+
+func (p *IPFS) Pin(ctx context.Context, fileName string, file io.Reader) (string, error) {
+	hash, err := add(ctx, p.client, file)
+	if err != nil {
+		return "", fmt.Errorf("add %s to ipfs: %w", fileName, err)
+	}
+	return hash, nil
+}
+
+func (p *IPFS) PinHash(ctx context.Context, name, hash string) error {
+	if err := p.client.Pin(ctx, hash); err != nil {
+		return fmt.Errorf("pin %s %s: %w", name, hash, err)
+	}
+	return nil
+}
+
+func (p *IPFS) Unpin(ctx context.Context, hash string) error {
+	err := p.client.Unpin(ctx, hash)
+	if err != nil && !isNotPinned(err) {
+		return fmt.Errorf("unpin %s: %w", hash, err)
+	}
+	return nil
+}
+
+// List returns recursive pins of the node. Local pins have no names,
+// so nothing matches a not empty prefix.
+func (p *IPFS) List(ctx context.Context, prefix string) ([]PinInfo, error) {
+	if prefix != "" {
+		return nil, nil
+	}
+
+	pins, err := p.client.Pins(ctx)
 	if err != nil {
-		return "", fmt.Errorf("add repository metadata to ipfs: %w", err)
+		return nil, fmt.Errorf("list pins: %w", err)
+	}
+
+	res := make([]PinInfo, 0, len(pins))
+	for _, hash := range pins {
+		res = append(res, PinInfo{Hash: hash})
 	}
+	return res, nil
+}
+
+// add adds the content to the node as CIDv1 and pins it
+func add(ctx context.Context, client ipfsclient.Client, r io.Reader) (string, error) {
+	hash, err := client.Add(ctx, r, true)
+	if err != nil {
+		return "", err
+	}
+
+	if hash == "" {
+		return "", ErrEmptyHash
+	}
+
 	return hash, nil
 }
+
+// isNotPinned reports whether the node failed to unpin the not pinned CID
+func isNotPinned(err error) bool {
+	return strings.Contains(err.Error(), "not pinned")
+}
@@ -0,0 +1,47 @@
+package pinner
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/pkg/ipfsclient/fakeipfs"
+	"gitsec-backend/pkg/unixfs"
+)
+
+func TestIPFS_PinUnpinList(t *testing.T) {
+	ctx := context.Background()
+	node := fakeipfs.NewNode()
+	p := NewIpfsClientPinner(node)
+
+	hash, err := p.Pin(ctx, "README.md", strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	// the content gets the CID the node assigns to it
+	expected, err := unixfs.FileCID(strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, expected.String(), hash)
+
+	pins, err := p.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []PinInfo{{Hash: hash}}, pins)
+
+	// local pins have no names
+	pins, err = p.List(ctx, "gitsec")
+	require.NoError(t, err)
+	assert.Empty(t, pins)
+
+	require.NoError(t, p.Unpin(ctx, hash))
+	// unpinning the not pinned content succeeds
+	require.NoError(t, p.Unpin(ctx, hash))
+
+	require.NoError(t, p.PinHash(ctx, "README.md", hash))
+	assert.Error(t, p.PinHash(ctx, "missing", "bafkreiaxnnnb7qz2focittuqq3ya25q7rcv3bqynnczfzao3qr2nnpnr7a"))
+
+	pins, err = p.List(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, pins, 1)
+}
@@ -2,15 +2,30 @@ package pinner
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
-const baseURL = "https://api.pinata.cloud/pinning/pinFileToIPFS"
+const (
+	pinataURL = "https://api.pinata.cloud"
+
+	pinFileToIPFSPath = "/pinning/pinFileToIPFS"
+	pinByHashPath     = "/pinning/pinByHash"
+	unpinPath         = "/pinning/unpin/"
+	pinListPath       = "/data/pinList"
+
+	// pinListPageLimit is the maximum page size of pin list
+	pinListPageLimit = 1000
+)
 
 type Response struct {
 	IpfsHash  string `json:"IpfsHash"`
@@ -18,6 +33,17 @@ type Response struct {
 	Timestamp string `json:"Timestamp"`
 }
 
+// pinList is the Pinata pin list page
+type pinList struct {
+	Count int `json:"count"`
+	Rows  []struct {
+		IpfsPinHash string `json:"ipfs_pin_hash"`
+		Metadata    struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	} `json:"rows"`
+}
+
 type Error struct {
 	Error struct {
 		Reason  string `json:"reason"`
@@ -26,16 +52,22 @@ type Error struct {
 }
 
 type Pinata struct {
+	// url is the Pinata API base URL
+	url    string
 	jwt    string
-	client *http.Client
+	client *httpClient
 }
 
-func NewPinataPinner(jwt string) IPinner {
-	return &Pinata{jwt: jwt, client: &http.Client{}}
+func NewPinataPinner(jwt string, opts HTTPOptions) IPinner {
+	return newPinata(pinataURL, jwt, opts)
 }
 
-func (p *Pinata) Pin(fileName string, file io.Reader) (string, error) {
-	method := "POST"
+// newPinata creates a new Pinata pinner with the given API base URL
+func newPinata(url, jwt string, opts HTTPOptions) *Pinata {
+	return &Pinata{url: url, jwt: jwt, client: newHTTPClient(opts)}
+}
+
+func (p *Pinata) Pin(ctx context.Context, fileName string, file io.Reader) (string, error) {
 	payload := &bytes.Buffer{}
 	writer := multipart.NewWriter(payload)
 
@@ -52,7 +84,12 @@ func (p *Pinata) Pin(fileName string, file io.Reader) (string, error) {
 		return "", fmt.Errorf("failed to write pinataOptions field: %w", err)
 	}
 
-	if err := writer.WriteField("pinataMetadata", fmt.Sprintf("{\"name\": \"%s\"}", fileName)); err != nil {
+	pinataMetadata, err := json.Marshal(map[string]string{"name": fileName})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal pinataMetadata: %w", err)
+	}
+
+	if err := writer.WriteField("pinataMetadata", string(pinataMetadata)); err != nil {
 		return "", fmt.Errorf("failed to write pinataMetadata field: %w", err)
 	}
 
@@ -60,32 +97,129 @@ func (p *Pinata) Pin(fileName string, file io.Reader) (string, error) {
 		return "", fmt.Errorf("write file: %w", err)
 	}
 
-	req, err := http.NewRequest(method, baseURL, payload)
+	res, err := p.client.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := p.request(ctx, http.MethodPost, pinFileToIPFSPath, bytes.NewReader(payload.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req, nil
+	}, http.StatusOK)
 	if err != nil {
-		return "", fmt.Errorf("write file: %w", err)
+		return "", fmt.Errorf("pin %s: %w", fileName, err)
 	}
+	defer res.Body.Close()
 
-	req.Header.Add("Authorization", "Bearer "+p.jwt)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	res, err := p.client.Do(req)
+	response := &Response{}
+	if err := json.NewDecoder(res.Body).Decode(response); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if response.IpfsHash == "" {
+		return "", fmt.Errorf("pin %s: %w", fileName, ErrEmptyHash)
+	}
+
+	return response.IpfsHash, nil
+}
+
+func (p *Pinata) PinHash(ctx context.Context, name, hash string) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"hashToPin": hash,
+		"pinataMetadata": map[string]string{
+			"name": name,
+		},
+	})
 	if err != nil {
-		return "", fmt.Errorf("do pin request: %w", err)
+		return fmt.Errorf("failed to marshal pin request: %w", err)
 	}
 
-	if res != nil {
-		defer res.Body.Close()
+	res, err := p.client.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := p.request(ctx, http.MethodPost, pinByHashPath, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	}, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("pin %s by hash: %w", hash, err)
 	}
+	res.Body.Close()
 
-	body, err := io.ReadAll(res.Body)
+	return nil
+}
+
+func (p *Pinata) Unpin(ctx context.Context, hash string) error {
+	res, err := p.client.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		return p.request(ctx, http.MethodDelete, unpinPath+hash, nil)
+	}, http.StatusOK)
 	if err != nil {
-		return "", fmt.Errorf("read response: %w", err)
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && strings.Contains(statusErr.Reason+statusErr.Details, "NOT_PINNED") {
+			return nil
+		}
+		return fmt.Errorf("unpin %s: %w", hash, err)
 	}
+	res.Body.Close()
 
-	response := &Response{}
+	return nil
+}
 
-	if err := json.Unmarshal(body, &response); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+func (p *Pinata) List(ctx context.Context, prefix string) ([]PinInfo, error) {
+	var res []PinInfo
+
+	for offset := 0; ; offset += pinListPageLimit {
+		query := url.Values{}
+		query.Set("status", "pinned")
+		query.Set("pageLimit", strconv.Itoa(pinListPageLimit))
+		query.Set("pageOffset", strconv.Itoa(offset))
+		if prefix != "" {
+			// Pinata matches names partially, prefix is checked below
+			query.Set("metadata[name]", prefix)
+		}
+
+		page, err := p.pinList(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("list pins: %w", err)
+		}
+
+		for _, row := range page.Rows {
+			if strings.HasPrefix(row.Metadata.Name, prefix) {
+				res = append(res, PinInfo{Hash: row.IpfsPinHash, Name: row.Metadata.Name})
+			}
+		}
+
+		if len(page.Rows) < pinListPageLimit {
+			return res, nil
+		}
 	}
+}
 
-	return response.IpfsHash, nil
+// pinList requests the pin list page
+func (p *Pinata) pinList(ctx context.Context, query url.Values) (*pinList, error) {
+	res, err := p.client.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		return p.request(ctx, http.MethodGet, pinListPath+"?"+query.Encode(), nil)
+	}, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	page := &pinList{}
+	if err := json.NewDecoder(res.Body).Decode(page); err != nil {
+		return nil, fmt.Errorf("decode pin list: %w", err)
+	}
+
+	return page, nil
+}
+
+// request creates the authorized API request
+func (p *Pinata) request(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, p.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+p.jwt)
+	return req, nil
 }
@@ -0,0 +1,40 @@
+package pinner
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPinata_Pin(t *testing.T) {
+	var response string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer jwt" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"reason":"INVALID_CREDENTIALS","details":"invalid jwt"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(response))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	response = `{"IpfsHash":"bafy-meta","PinSize":4}`
+	hash, err := newPinata(srv.URL, "jwt", HTTPOptions{}).Pin(ctx, "meta.json", strings.NewReader("meta"))
+	require.NoError(t, err)
+	assert.Equal(t, "bafy-meta", hash)
+
+	response = `{}`
+	_, err = newPinata(srv.URL, "jwt", HTTPOptions{}).Pin(ctx, "meta.json", strings.NewReader("meta"))
+	assert.ErrorIs(t, err, ErrEmptyHash)
+
+	_, err = newPinata(srv.URL, "wrong", HTTPOptions{Retries: 3}).Pin(ctx, "meta.json", strings.NewReader("meta"))
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.ErrorContains(t, err, "INVALID_CREDENTIALS")
+}
@@ -1,7 +1,26 @@
 package pinner
 
-import "io"
+import (
+	"context"
+	"io"
+)
 
 type IPinner interface {
-	Pin(fileName string, file io.Reader) (string, error)
+	// Pin adds the content to IPFS and pins it, returned CID is never empty
+	Pin(ctx context.Context, fileName string, file io.Reader) (string, error)
+
+	// PinHash pins the content already available in IPFS by its CID
+	PinHash(ctx context.Context, name, hash string) error
+
+	// Unpin removes all pins of the CID, unpinning not pinned CID succeeds
+	Unpin(ctx context.Context, hash string) error
+
+	// List returns pins which names start with the prefix
+	List(ctx context.Context, prefix string) ([]PinInfo, error)
+}
+
+// PinInfo is the pin stored by the pinner
+type PinInfo struct {
+	Hash string
+	Name string
 }
@@ -0,0 +1,313 @@
+package pinner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	ipfs "github.com/ipfs/go-ipfs-api"
+
+	"gitsec-backend/pkg/ipfsclient"
+)
+
+const (
+	// defaultPollInterval is the interval pin status is checked with
+	defaultPollInterval = 2 * time.Second
+	// listLimit is the maximum number of pins returned by one list request
+	listLimit = 1000
+)
+
+// Pinning Service API pin statuses
+const (
+	pinStatusQueued  = "queued"
+	pinStatusPinning = "pinning"
+	pinStatusPinned  = "pinned"
+	pinStatusFailed  = "failed"
+)
+
+// localNode is the IPFS node content is added to before it's pinned remotely
+type localNode interface {
+	Add(ctx context.Context, r io.Reader) (string, error)
+	ID(peer ...string) (*ipfs.IdOutput, error)
+	SwarmConnect(ctx context.Context, addr ...string) error
+}
+
+// shellNode is the localNode of the IPFS client
+type shellNode struct {
+	*ipfs.Shell
+}
+
+// Add adds the content to the node as CIDv1 and pins it
+func (n shellNode) Add(ctx context.Context, r io.Reader) (string, error) {
+	return add(ctx, ipfsclient.Wrap(n.Shell), r)
+}
+
+// pinStatus is the Pinning Service API pin status
+type pinStatus struct {
+	RequestID string    `json:"requestid"`
+	Status    string    `json:"status"`
+	Created   time.Time `json:"created"`
+	Pin       struct {
+		Cid  string `json:"cid"`
+		Name string `json:"name"`
+	} `json:"pin"`
+	Delegates []string `json:"delegates"`
+	Info      struct {
+		StatusDetails string `json:"status_details"`
+	} `json:"info"`
+}
+
+// PinningService pins content with any provider implementing the IPFS
+// Pinning Service API. Content is added to the local IPFS node first and
+// pinned remotely by its CID, local node addresses are passed to the
+// provider as origins.
+type PinningService struct {
+	// endpoint is the API endpoint, e.g. https://api.example.com/psa
+	endpoint string
+	// token is the API access token
+	token  string
+	client *httpClient
+
+	node localNode
+
+	// pollInterval is the interval pin status is checked with
+	pollInterval time.Duration
+	// timeout is the maximum time to wait for the pin to be pinned
+	timeout time.Duration
+}
+
+// NewPinningServicePinner creates a new PinningService pinner adding content
+// to the IPFS node at ipfsAddr. Not positive timeout means the pin status
+// is polled until the pin is pinned or failed.
+func NewPinningServicePinner(endpoint, token, ipfsAddr string, timeout time.Duration, opts HTTPOptions) IPinner {
+	return newPinningService(endpoint, token, shellNode{ipfs.NewShell(ipfsAddr)}, defaultPollInterval, timeout, opts)
+}
+
+// newPinningService creates a new PinningService with the given local node
+func newPinningService(endpoint, token string, node localNode, pollInterval, timeout time.Duration, opts HTTPOptions) *PinningService {
+	return &PinningService{
+		endpoint:     strings.TrimSuffix(endpoint, "/"),
+		token:        token,
+		client:       newHTTPClient(opts),
+		node:         node,
+		pollInterval: pollInterval,
+		timeout:      timeout,
+	}
+}
+
+func (p *PinningService) Pin(ctx context.Context, fileName string, file io.Reader) (string, error) {
+	hash, err := p.node.Add(ctx, file)
+	if err != nil {
+		return "", fmt.Errorf("add %s to local ipfs: %w", fileName, err)
+	}
+
+	if err := p.PinHash(ctx, fileName, hash); err != nil {
+		return "", err
+	}
+
+	return hash, nil
+}
+
+func (p *PinningService) PinHash(ctx context.Context, name, hash string) error {
+	body := map[string]interface{}{
+		"cid":  hash,
+		"name": name,
+	}
+
+	if origins := p.origins(); len(origins) != 0 {
+		body["origins"] = origins
+	}
+
+	status := &pinStatus{}
+	if err := p.do(ctx, http.MethodPost, "/pins", body, http.StatusAccepted, status); err != nil {
+		return fmt.Errorf("request pin %s: %w", hash, err)
+	}
+
+	p.connect(ctx, status.Delegates)
+
+	return p.wait(ctx, hash, status)
+}
+
+func (p *PinningService) Unpin(ctx context.Context, hash string) error {
+	query := url.Values{}
+	query.Set("cid", hash)
+	query.Set("status", strings.Join([]string{pinStatusQueued, pinStatusPinning, pinStatusPinned, pinStatusFailed}, ","))
+
+	statuses, err := p.list(ctx, query)
+	if err != nil {
+		return fmt.Errorf("list pins of %s: %w", hash, err)
+	}
+
+	for _, status := range statuses {
+		if err := p.do(ctx, http.MethodDelete, "/pins/"+status.RequestID, nil, http.StatusAccepted, nil); err != nil {
+			return fmt.Errorf("unpin %s: %w", hash, err)
+		}
+	}
+
+	return nil
+}
+
+func (p *PinningService) List(ctx context.Context, prefix string) ([]PinInfo, error) {
+	query := url.Values{}
+	query.Set("status", pinStatusPinned)
+	if prefix != "" {
+		// the API has no prefix match, prefix is checked below
+		query.Set("name", prefix)
+		query.Set("match", "partial")
+	}
+
+	statuses, err := p.list(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("list pins: %w", err)
+	}
+
+	var res []PinInfo
+	for _, status := range statuses {
+		if strings.HasPrefix(status.Pin.Name, prefix) {
+			res = append(res, PinInfo{Hash: status.Pin.Cid, Name: status.Pin.Name})
+		}
+	}
+	return res, nil
+}
+
+// list returns all pin statuses matching the query. Results are sorted
+// by creation time in descending order, so pages are requested by
+// the creation time of the last returned pin.
+func (p *PinningService) list(ctx context.Context, query url.Values) ([]pinStatus, error) {
+	query.Set("limit", strconv.Itoa(listLimit))
+
+	var res []pinStatus
+	for {
+		page := &struct {
+			Count   int         `json:"count"`
+			Results []pinStatus `json:"results"`
+		}{}
+		if err := p.do(ctx, http.MethodGet, "/pins?"+query.Encode(), nil, http.StatusOK, page); err != nil {
+			return nil, err
+		}
+
+		res = append(res, page.Results...)
+
+		if len(page.Results) < listLimit || len(res) >= page.Count {
+			return res, nil
+		}
+
+		query.Set("before", page.Results[len(page.Results)-1].Created.Format(time.RFC3339Nano))
+	}
+}
+
+// wait polls the pin request status until it's pinned
+func (p *PinningService) wait(ctx context.Context, hash string, status *pinStatus) error {
+	var deadline time.Time
+	if p.timeout > 0 {
+		deadline = time.Now().Add(p.timeout)
+	}
+
+	for {
+		switch status.Status {
+		case pinStatusPinned:
+			return nil
+		case pinStatusFailed:
+			return fmt.Errorf("pin %s failed: %s", hash, status.Info.StatusDetails)
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return fmt.Errorf("pin %s: %w: still %s after %s", hash, ErrTimeout, status.Status, p.timeout)
+		}
+
+		timer := time.NewTimer(p.pollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("pin %s: still %s: %w", hash, status.Status, ctx.Err())
+		case <-timer.C:
+		}
+
+		next := &pinStatus{}
+		if err := p.do(ctx, http.MethodGet, "/pins/"+status.RequestID, nil, http.StatusOK, next); err != nil {
+			return fmt.Errorf("check pin %s status: %w", hash, err)
+		}
+		status = next
+	}
+}
+
+// origins returns addresses of the local node, so the provider
+// can fetch the content from it directly
+func (p *PinningService) origins() []string {
+	id, err := p.node.ID()
+	if err != nil {
+		return nil
+	}
+
+	var origins []string
+	for _, addr := range id.Addresses {
+		if !strings.Contains(addr, "/p2p/") {
+			addr += "/p2p/" + id.ID
+		}
+		origins = append(origins, addr)
+	}
+	return origins
+}
+
+// connect connects the local node to the provider delegates,
+// failure only slows the content transfer down
+func (p *PinningService) connect(ctx context.Context, delegates []string) {
+	if len(delegates) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.pollInterval*5)
+	defer cancel()
+
+	_ = p.node.SwarmConnect(ctx, delegates...)
+}
+
+// do sends the API request and decodes the response into out
+func (p *PinningService) do(ctx context.Context, method, path string, in interface{}, expected int, out interface{}) error {
+	var payload []byte
+	if in != nil {
+		var err error
+		if payload, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("marshal request: %w", err)
+		}
+	}
+
+	res, err := p.client.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		var body io.Reader
+		if payload != nil {
+			body = bytes.NewReader(payload)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, p.endpoint+path, body)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Authorization", "Bearer "+p.token)
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, nil
+	}, expected)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package pinner
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ipfs "github.com/ipfs/go-ipfs-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitsec-backend/pkg/pinner/fakepinning"
+)
+
+// fakeNode is the local IPFS node stub
+type fakeNode struct {
+	added     []string
+	connected []string
+}
+
+func (n *fakeNode) Add(_ context.Context, r io.Reader) (string, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	n.added = append(n.added, string(content))
+	return "bafy-" + string(content), nil
+}
+
+func (n *fakeNode) ID(...string) (*ipfs.IdOutput, error) {
+	return &ipfs.IdOutput{ID: "QmLocal", Addresses: []string{"/ip4/10.0.0.1/tcp/4001"}}, nil
+}
+
+func (n *fakeNode) SwarmConnect(_ context.Context, addr ...string) error {
+	n.connected = append(n.connected, addr...)
+	return nil
+}
+
+func TestPinningService_Pin(t *testing.T) {
+	ctx := context.Background()
+
+	fake := fakepinning.NewServer("secret")
+	fake.PinAfter(3)
+
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	node := &fakeNode{}
+	p := newPinningService(srv.URL+"/", "secret", node, time.Millisecond, time.Second, HTTPOptions{})
+
+	hash, err := p.Pin(ctx, "repo@abc/meta.json", strings.NewReader("meta"))
+	require.NoError(t, err)
+	assert.Equal(t, "bafy-meta", hash)
+	assert.Equal(t, []string{"meta"}, node.added)
+	assert.NotEmpty(t, node.connected)
+
+	pins := fake.Pins()
+	require.Len(t, pins, 1)
+	assert.Equal(t, fakepinning.StatusPinned, pins[0].Status)
+	assert.Equal(t, "bafy-meta", pins[0].Pin.Cid)
+	assert.Equal(t, "repo@abc/meta.json", pins[0].Pin.Name)
+	assert.Equal(t, []string{"/ip4/10.0.0.1/tcp/4001/p2p/QmLocal"}, pins[0].Pin.Origins)
+}
+
+func TestPinningService_PinHashErrors(t *testing.T) {
+	ctx := context.Background()
+
+	fake := fakepinning.NewServer("secret")
+	fake.Fail("bafy-failed")
+	fake.PinAfter(1000)
+
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	p := newPinningService(srv.URL, "secret", &fakeNode{}, time.Millisecond, 20*time.Millisecond, HTTPOptions{})
+
+	err := p.PinHash(ctx, "failed", "bafy-failed")
+	assert.ErrorContains(t, err, "failed")
+
+	err = p.PinHash(ctx, "slow", "bafy-slow")
+	assert.ErrorIs(t, err, ErrTimeout)
+
+	unauthorized := newPinningService(srv.URL, "wrong", &fakeNode{}, time.Millisecond, time.Second, HTTPOptions{})
+	err = unauthorized.PinHash(ctx, "meta", "bafy-meta")
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestPinningService_UnpinList(t *testing.T) {
+	ctx := context.Background()
+
+	fake := fakepinning.NewServer("secret")
+
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	p := newPinningService(srv.URL, "secret", &fakeNode{}, time.Millisecond, time.Second, HTTPOptions{})
+
+	for _, name := range []string{"owner/repo@aaa/meta.json", "owner/repo@bbb/meta.json", "other/owner/repo@ccc/meta.json"} {
+		_, err := p.Pin(ctx, name, strings.NewReader(name))
+		require.NoError(t, err)
+	}
+	require.NoError(t, p.PinHash(ctx, "owner/repo@bbb/meta.json", "bafy-owner/repo@aaa/meta.json"))
+
+	pins, err := p.List(ctx, "owner/repo@")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []PinInfo{
+		{Hash: "bafy-owner/repo@aaa/meta.json", Name: "owner/repo@aaa/meta.json"},
+		{Hash: "bafy-owner/repo@bbb/meta.json", Name: "owner/repo@bbb/meta.json"},
+		{Hash: "bafy-owner/repo@aaa/meta.json", Name: "owner/repo@bbb/meta.json"},
+	}, pins)
+
+	require.NoError(t, p.Unpin(ctx, "bafy-owner/repo@aaa/meta.json"))
+	require.NoError(t, p.Unpin(ctx, "bafy-not-pinned"))
+
+	assert.Len(t, fake.Pins(), 2)
+}
@@ -0,0 +1,166 @@
+package pinner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned when pinning a job takes longer than the pipeline timeout
+var ErrTimeout = errors.New("pin timeout")
+
+// Job is a single content to pin.
+type Job struct {
+	// Key identifies the job in results, e.g. the file path
+	Key string
+	// Name is the pin name
+	Name string
+	// Open returns the content to pin
+	Open func() (io.Reader, error)
+	// Hash is the CID of the content already available in IPFS,
+	// the job pins it by the hash when it's set instead of opening
+	Hash string
+}
+
+// Result is the result of a pinned Job.
+type Result struct {
+	// Key is the pinned Job key
+	Key string
+	// Hash is the IPFS hash of the pinned content
+	Hash string
+	// Err is the pinning error
+	Err error
+}
+
+// Pipeline pins jobs in parallel with a bounded number of workers.
+type Pipeline struct {
+	pinner IPinner
+	// concurrency is the maximum number of jobs pinned at the same time
+	concurrency int
+	// timeout is the maximum time a single job is pinned for
+	timeout time.Duration
+}
+
+// NewPipeline creates a new Pipeline pinning with the given pinner.
+// Not positive concurrency means jobs are pinned one by one,
+// not positive timeout means jobs are never timed out.
+func NewPipeline(pinner IPinner, concurrency int, timeout time.Duration) *Pipeline {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &Pipeline{
+		pinner:      pinner,
+		concurrency: concurrency,
+		timeout:     timeout,
+	}
+}
+
+// Pin pins all given jobs and returns their results in the jobs order.
+// Failure of a single job doesn't stop others, failed jobs are reported
+// with the Result error.
+func (p *Pipeline) Pin(ctx context.Context, jobs []Job) []Result {
+	results := make([]Result, len(jobs))
+	queue := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < p.concurrency && w < len(jobs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = p.pin(ctx, jobs[i])
+			}
+		}()
+	}
+
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+
+	wg.Wait()
+
+	return results
+}
+
+// pin pins a single job within the pipeline timeout. Pin request is
+// cancelled on timeout, request not returning after that is abandoned
+// and its result is ignored.
+func (p *Pipeline) pin(ctx context.Context, job Job) Result {
+	res := Result{Key: job.Key}
+
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	done := make(chan Result, 1)
+	go func() {
+		if job.Hash != "" {
+			if err := p.pinner.PinHash(ctx, job.Name, job.Hash); err != nil {
+				done <- Result{Key: job.Key, Err: fmt.Errorf("pin %s: %w", job.Key, err)}
+				return
+			}
+
+			done <- Result{Key: job.Key, Hash: job.Hash}
+			return
+		}
+
+		r, err := job.Open()
+		if err != nil {
+			done <- Result{Key: job.Key, Err: fmt.Errorf("open %s: %w", job.Key, err)}
+			return
+		}
+
+		hash, err := p.pinner.Pin(ctx, job.Name, r)
+		if err != nil {
+			done <- Result{Key: job.Key, Err: fmt.Errorf("pin %s: %w", job.Key, err)}
+			return
+		}
+
+		done <- Result{Key: job.Key, Hash: hash}
+	}()
+
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		res.Err = fmt.Errorf("pin %s: %w", job.Key, ctx.Err())
+	}
+
+	if errors.Is(res.Err, context.DeadlineExceeded) && p.timeout > 0 {
+		res.Err = fmt.Errorf("pin %s: %w after %s", job.Key, ErrTimeout, p.timeout)
+	}
+
+	return res
+}
+
+// Failed returns failed results.
+func Failed(results []Result) []Result {
+	var failed []Result
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// PinName returns a stable pin name of the file with the given path
+// of the repository at the given commit. Empty path names the whole tree.
+func PinName(repo, commit, path string) string {
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+
+	if path == "" {
+		return fmt.Sprintf("%s@%s", repo, commit)
+	}
+
+	return fmt.Sprintf("%s@%s/%s", repo, commit, strings.TrimPrefix(path, "/"))
+}
@@ -0,0 +1,125 @@
+package pinner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubPinner pins files by returning their content as hash
+type stubPinner struct {
+	mu     sync.Mutex
+	active int
+	peak   int
+
+	delay time.Duration
+	fail  map[string]error
+}
+
+func (s *stubPinner) Pin(_ context.Context, fileName string, file io.Reader) (string, error) {
+	s.mu.Lock()
+	s.active++
+	if s.active > s.peak {
+		s.peak = s.active
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.active--
+		s.mu.Unlock()
+	}()
+
+	time.Sleep(s.delay)
+
+	if err, ok := s.fail[fileName]; ok {
+		return "", err
+	}
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+func (s *stubPinner) PinHash(ctx context.Context, name, hash string) error {
+	_, err := s.Pin(ctx, name, strings.NewReader(hash))
+	return err
+}
+
+func (s *stubPinner) Unpin(context.Context, string) error {
+	return nil
+}
+
+func (s *stubPinner) List(context.Context, string) ([]PinInfo, error) {
+	return nil, nil
+}
+
+func jobs(n int) []Job {
+	res := make([]Job, n)
+	for i := range res {
+		content := fmt.Sprintf("hash-%d", i)
+		res[i] = Job{
+			Key:  fmt.Sprintf("file-%d", i),
+			Name: fmt.Sprintf("name-%d", i),
+			Open: func() (io.Reader, error) {
+				return strings.NewReader(content), nil
+			},
+		}
+	}
+	return res
+}
+
+func TestPipelinePin(t *testing.T) {
+	errPin := errors.New("pin failed")
+
+	stub := &stubPinner{
+		delay: 10 * time.Millisecond,
+		fail:  map[string]error{"name-3": errPin},
+	}
+
+	results := NewPipeline(stub, 4, 0).Pin(context.Background(), jobs(20))
+	require.Len(t, results, 20)
+
+	for i, res := range results {
+		assert.Equal(t, fmt.Sprintf("file-%d", i), res.Key)
+		if i == 3 {
+			assert.ErrorIs(t, res.Err, errPin)
+			continue
+		}
+		assert.NoError(t, res.Err)
+		assert.Equal(t, fmt.Sprintf("hash-%d", i), res.Hash)
+	}
+
+	assert.LessOrEqual(t, stub.peak, 4)
+	assert.Len(t, Failed(results), 1)
+}
+
+func TestPipelinePinTimeout(t *testing.T) {
+	stub := &stubPinner{delay: time.Second}
+
+	results := NewPipeline(stub, 2, 10*time.Millisecond).Pin(context.Background(), jobs(2))
+
+	for _, res := range results {
+		assert.ErrorIs(t, res.Err, ErrTimeout)
+	}
+}
+
+func TestPinName(t *testing.T) {
+	assert.Equal(t, "0xOwner/repo@0123456789ab/dir/file.go",
+		PinName("0xOwner/repo", "0123456789abcdef", "/dir/file.go"))
+	assert.Equal(t, "0xOwner/repo@created/meta.json",
+		PinName("0xOwner/repo", "created", "meta.json"))
+	assert.Equal(t, "0xOwner/repo@0123456789ab",
+		PinName("0xOwner/repo", "0123456789abcdef", ""))
+}
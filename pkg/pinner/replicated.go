@@ -0,0 +1,325 @@
+package pinner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/misnaged/annales/logger"
+
+	"gitsec-backend/pkg/multierr"
+)
+
+// ErrIntegrity is returned when pinners return different CIDs of the same content
+var ErrIntegrity = errors.New("pinners returned different CIDs")
+
+const (
+	// replicaRetries is the number of background retries of a failed replica
+	replicaRetries = 5
+	// replicaRetryDelay is the delay before the first background retry,
+	// it's doubled with every next retry
+	replicaRetryDelay = 10 * time.Second
+	// defaultReplicaTimeout bounds a single replica attempt when
+	// no pinning timeout is configured
+	defaultReplicaTimeout = 10 * time.Minute
+)
+
+// Replica is a named pinner content is replicated to.
+type Replica struct {
+	Name   string
+	Pinner IPinner
+}
+
+// Replicated pins content with all replicas in parallel. Pin succeeds once
+// quorum of replicas pinned the content with the same CID, replicas which
+// failed are retried in the background. Replicas run detached from the
+// request, so slower ones complete after Pin returned, and every CID they
+// pin is compared against the CID of the quorum.
+type Replicated struct {
+	replicas []Replica
+	// quorum is the number of replicas which should pin the content
+	quorum int
+	// timeout bounds every single attempt of a replica
+	timeout time.Duration
+	// retryDelay is the delay before the first background retry
+	retryDelay time.Duration
+	// ctx is cancelled once the service stops,
+	// it cancels replicas still running in the background
+	ctx context.Context
+	// report reports integrity errors found after Pin returned
+	report func(err error)
+}
+
+// replicaResult is the result of pinning with a replica
+type replicaResult struct {
+	replica string
+	hash    string
+	err     error
+	// retry is set for the result of successful background retries
+	retry bool
+}
+
+// quorumResult is the CID pinned by the quorum or the reason it isn't reached
+type quorumResult struct {
+	hash string
+	err  error
+}
+
+// NewReplicatedPinner creates a new Replicated pinner. Quorum must be
+// between one and the number of replicas. Every replica attempt is bounded
+// by the timeout, replicas still running are cancelled once stop is closed.
+func NewReplicatedPinner(replicas []Replica, quorum int, timeout time.Duration, stop <-chan struct{}) (IPinner, error) {
+	return newReplicated(replicas, quorum, timeout, replicaRetryDelay, stop)
+}
+
+// newReplicated creates a new Replicated pinner with the given retry delay
+func newReplicated(replicas []Replica, quorum int, timeout, retryDelay time.Duration, stop <-chan struct{}) (*Replicated, error) {
+	if quorum < 1 || quorum > len(replicas) {
+		return nil, fmt.Errorf("pinning quorum %d is out of range [1, %d]", quorum, len(replicas))
+	}
+
+	if timeout <= 0 {
+		timeout = defaultReplicaTimeout
+	}
+
+	ctx := context.Background()
+	if stop != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		go func() {
+			<-stop
+			cancel()
+		}()
+	}
+
+	return &Replicated{
+		replicas:   replicas,
+		quorum:     quorum,
+		timeout:    timeout,
+		retryDelay: retryDelay,
+		ctx:        ctx,
+		report: func(err error) {
+			logger.Log().Error(err)
+		},
+	}, nil
+}
+
+func (p *Replicated) Pin(ctx context.Context, fileName string, file io.Reader) (string, error) {
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", fileName, err)
+	}
+
+	return p.replicate(ctx, fileName, func(ctx context.Context, pinner IPinner) (string, error) {
+		return pinner.Pin(ctx, fileName, bytes.NewReader(content))
+	})
+}
+
+func (p *Replicated) PinHash(ctx context.Context, name, hash string) error {
+	_, err := p.replicate(ctx, name, func(ctx context.Context, pinner IPinner) (string, error) {
+		if err := pinner.PinHash(ctx, name, hash); err != nil {
+			return "", err
+		}
+		return hash, nil
+	})
+	return err
+}
+
+// Unpin unpins the CID from all replicas, so it isn't paid for anywhere
+func (p *Replicated) Unpin(ctx context.Context, hash string) error {
+	errs := make(chan error, len(p.replicas))
+
+	for _, r := range p.replicas {
+		go func(r Replica) {
+			if err := r.Pinner.Unpin(ctx, hash); err != nil {
+				errs <- fmt.Errorf("%s: %w", r.Name, err)
+				return
+			}
+			errs <- nil
+		}(r)
+	}
+
+	var res []error
+	for range p.replicas {
+		if err := <-errs; err != nil {
+			res = append(res, err)
+		}
+	}
+
+	return multierr.Join(res...)
+}
+
+// List returns pins of all replicas. Replicas which failed to list
+// their pins are skipped unless all of them failed.
+func (p *Replicated) List(ctx context.Context, prefix string) ([]PinInfo, error) {
+	var (
+		res  []PinInfo
+		seen = make(map[PinInfo]bool)
+		errs []error
+	)
+
+	for _, r := range p.replicas {
+		pins, err := r.Pinner.List(ctx, prefix)
+		if err != nil {
+			logger.Log().Errorf("failed to list %s pins: %s", r.Name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", r.Name, err))
+			continue
+		}
+
+		for _, pin := range pins {
+			if !seen[pin] {
+				seen[pin] = true
+				res = append(res, pin)
+			}
+		}
+	}
+
+	if len(errs) == len(p.replicas) {
+		return nil, multierr.Join(errs...)
+	}
+
+	return res, nil
+}
+
+// replicate pins with all replicas and waits for the quorum. It returns
+// the CID pinned by the quorum.
+func (p *Replicated) replicate(ctx context.Context, name string, pin func(context.Context, IPinner) (string, error)) (string, error) {
+	// every replica reports its first attempt and the result of its retries
+	results := make(chan replicaResult, 2*len(p.replicas))
+	decided := make(chan quorumResult, 1)
+
+	var wg sync.WaitGroup
+	for _, r := range p.replicas {
+		wg.Add(1)
+		go func(r Replica) {
+			defer wg.Done()
+			p.run(name, r, pin, results)
+		}(r)
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	go p.collect(name, results, decided)
+
+	select {
+	case res := <-decided:
+		return res.hash, res.err
+	case <-ctx.Done():
+		return "", fmt.Errorf("pin %s: %w", name, ctx.Err())
+	}
+}
+
+// collect counts results of the replicas and reports the CID pinned by the
+// quorum or the reason it isn't reached once all first attempts completed.
+// Results received after the quorum is reached are compared against its CID.
+func (p *Replicated) collect(name string, results <-chan replicaResult, decided chan<- quorumResult) {
+	var (
+		hashes    = make(map[string][]string)
+		errs      []error
+		completed int
+		quorum    string
+		done      bool
+	)
+
+	for res := range results {
+		if !res.retry {
+			completed++
+		}
+
+		switch {
+		case res.err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", res.replica, res.err))
+		case quorum != "":
+			if res.hash != quorum {
+				p.report(fmt.Errorf("pin %s: %w: %s pinned %s, quorum pinned %s", name, ErrIntegrity, res.replica, res.hash, quorum))
+			}
+		default:
+			hashes[res.hash] = append(hashes[res.hash], res.replica)
+
+			if len(hashes) > 1 {
+				logger.Log().Errorf("pin %s: %s: %v", name, ErrIntegrity, hashes)
+			}
+
+			if !done && len(hashes[res.hash]) >= p.quorum {
+				quorum, done = res.hash, true
+				decided <- quorumResult{hash: quorum}
+
+				// replicas which pinned a different CID before the quorum was reached
+				for hash, replicas := range hashes {
+					if hash != quorum {
+						p.report(fmt.Errorf("pin %s: %w: %v pinned %s, quorum pinned %s", name, ErrIntegrity, replicas, hash, quorum))
+					}
+				}
+			}
+		}
+
+		if !done && completed == len(p.replicas) {
+			done = true
+
+			if len(hashes) > 1 {
+				decided <- quorumResult{err: fmt.Errorf("pin %s: %w: %v", name, ErrIntegrity, hashes)}
+			} else {
+				decided <- quorumResult{err: fmt.Errorf("pin %s: quorum of %d replicas is not reached: %w", name, p.quorum, multierr.Join(errs...))}
+			}
+		}
+	}
+}
+
+// run pins with the replica and reports the first attempt result.
+// Failed replica is retried in the background afterwards, unless it
+// rejects the account, and the result of the retries is reported too.
+// Every attempt is bounded by the timeout, retries are given up once
+// the service stops.
+func (p *Replicated) run(name string, r Replica, pin func(context.Context, IPinner) (string, error), results chan<- replicaResult) {
+	hash, err := p.attempt(r, pin)
+	results <- replicaResult{replica: r.Name, hash: hash, err: err}
+
+	if err == nil || permanent(err) {
+		return
+	}
+
+	delay := p.retryDelay
+	for attempt := 1; attempt <= replicaRetries; attempt++ {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-p.ctx.Done():
+			timer.Stop()
+			logger.Log().Warningf("pin %s: retries of %s are stopped: %s", name, r.Name, err)
+			return
+		}
+		delay *= 2
+
+		if hash, err = p.attempt(r, pin); err == nil {
+			logger.Log().Infof("pin %s %s replicated to %s after %d retries", name, hash, r.Name, attempt)
+			results <- replicaResult{replica: r.Name, hash: hash, retry: true}
+			return
+		}
+
+		if permanent(err) {
+			break
+		}
+	}
+
+	logger.Log().Errorf("failed to replicate pin %s to %s: %s", name, r.Name, err)
+}
+
+// attempt pins with the replica once within the attempt timeout
+func (p *Replicated) attempt(r Replica, pin func(context.Context, IPinner) (string, error)) (string, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, p.timeout)
+	defer cancel()
+
+	hash, err := pin(ctx, r.Pinner)
+	if err == nil && hash == "" {
+		err = ErrEmptyHash
+	}
+
+	return hash, err
+}
@@ -0,0 +1,249 @@
+package pinner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replicaStub returns the fixed hash, failing the first attempts
+type replicaStub struct {
+	mu       sync.Mutex
+	hash     string
+	failures int
+	calls    int
+	unpinned []string
+}
+
+func (r *replicaStub) Pin(_ context.Context, _ string, file io.Reader) (string, error) {
+	if _, err := io.ReadAll(file); err != nil {
+		return "", err
+	}
+	return r.pin()
+}
+
+func (r *replicaStub) PinHash(_ context.Context, _, _ string) error {
+	_, err := r.pin()
+	return err
+}
+
+func (r *replicaStub) Unpin(_ context.Context, hash string) error {
+	if _, err := r.pin(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unpinned = append(r.unpinned, hash)
+	return nil
+}
+
+func (r *replicaStub) List(_ context.Context, prefix string) ([]PinInfo, error) {
+	hash, err := r.pin()
+	if err != nil {
+		return nil, err
+	}
+	return []PinInfo{{Hash: hash, Name: prefix + "meta.json"}}, nil
+}
+
+func (r *replicaStub) pin() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls++
+	if r.calls <= r.failures {
+		return "", errors.New("outage")
+	}
+	return r.hash, nil
+}
+
+func (r *replicaStub) Calls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls
+}
+
+func TestReplicated_Pin(t *testing.T) {
+	ctx := context.Background()
+
+	pinata := &replicaStub{hash: "cid", failures: 1}
+	ipfs := &replicaStub{hash: "cid"}
+	service := &replicaStub{hash: "cid"}
+
+	p, err := newReplicated([]Replica{
+		{Name: "pinata", Pinner: pinata},
+		{Name: "ipfs", Pinner: ipfs},
+		{Name: "service", Pinner: service},
+	}, 2, time.Second, time.Millisecond, nil)
+	require.NoError(t, err)
+
+	hash, err := p.Pin(ctx, "meta.json", strings.NewReader("meta"))
+	require.NoError(t, err)
+	assert.Equal(t, "cid", hash)
+
+	// failed replica is retried in the background
+	assert.Eventually(t, func() bool { return pinata.Calls() == 2 }, time.Second, time.Millisecond)
+
+	require.NoError(t, p.PinHash(ctx, "tree", "cid"))
+}
+
+func TestReplicated_PinQuorumNotReached(t *testing.T) {
+	ctx := context.Background()
+
+	p, err := newReplicated([]Replica{
+		{Name: "pinata", Pinner: &replicaStub{failures: 100}},
+		{Name: "ipfs", Pinner: &replicaStub{hash: "cid"}},
+	}, 2, time.Second, time.Hour, nil)
+	require.NoError(t, err)
+
+	_, err = p.Pin(ctx, "meta.json", strings.NewReader("meta"))
+	assert.ErrorContains(t, err, "quorum of 2 replicas is not reached")
+	assert.ErrorContains(t, err, "outage")
+}
+
+func TestReplicated_PinIntegrity(t *testing.T) {
+	ctx := context.Background()
+
+	p, err := newReplicated([]Replica{
+		{Name: "pinata", Pinner: &replicaStub{hash: "cid-a"}},
+		{Name: "ipfs", Pinner: &replicaStub{hash: "cid-b"}},
+	}, 2, time.Second, time.Hour, nil)
+	require.NoError(t, err)
+
+	_, err = p.Pin(ctx, "meta.json", strings.NewReader("meta"))
+	assert.ErrorIs(t, err, ErrIntegrity)
+}
+
+// slowReplica pins the fixed hash once it's released,
+// it reports the error of the context it's cancelled with
+type slowReplica struct {
+	replicaStub
+	release chan struct{}
+	errs    chan error
+}
+
+func (r *slowReplica) PinHash(ctx context.Context, _, _ string) error {
+	select {
+	case <-r.release:
+		return nil
+	case <-ctx.Done():
+		r.errs <- ctx.Err()
+		return ctx.Err()
+	}
+}
+
+func (r *slowReplica) Pin(ctx context.Context, name string, file io.Reader) (string, error) {
+	if err := r.PinHash(ctx, name, ""); err != nil {
+		return "", err
+	}
+	return r.hash, nil
+}
+
+func TestReplicated_PinLateIntegrity(t *testing.T) {
+	slow := &slowReplica{replicaStub: replicaStub{hash: "cid-b"}, release: make(chan struct{}), errs: make(chan error, 1)}
+
+	p, err := newReplicated([]Replica{
+		{Name: "pinata", Pinner: &replicaStub{hash: "cid-a"}},
+		{Name: "ipfs", Pinner: &replicaStub{hash: "cid-a"}},
+		{Name: "service", Pinner: slow},
+	}, 2, time.Second, time.Hour, nil)
+	require.NoError(t, err)
+
+	reported := make(chan error, 1)
+	p.report = func(err error) { reported <- err }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	hash, err := p.Pin(ctx, "meta.json", strings.NewReader("meta"))
+	require.NoError(t, err)
+	assert.Equal(t, "cid-a", hash)
+
+	// the slower replica isn't cancelled with the request
+	cancel()
+	close(slow.release)
+
+	select {
+	case err := <-reported:
+		assert.ErrorIs(t, err, ErrIntegrity)
+		assert.ErrorContains(t, err, "service pinned cid-b")
+	case err := <-slow.errs:
+		t.Fatalf("slow replica is cancelled: %s", err)
+	case <-time.After(time.Second):
+		t.Fatal("late CID isn't compared against the quorum")
+	}
+}
+
+func TestReplicated_Stop(t *testing.T) {
+	stop := make(chan struct{})
+	slow := &slowReplica{replicaStub: replicaStub{hash: "cid"}, release: make(chan struct{}), errs: make(chan error, 1)}
+
+	p, err := newReplicated([]Replica{
+		{Name: "pinata", Pinner: &replicaStub{hash: "cid"}},
+		{Name: "service", Pinner: slow},
+	}, 1, time.Hour, time.Hour, stop)
+	require.NoError(t, err)
+
+	require.NoError(t, p.PinHash(context.Background(), "tree", "cid"))
+
+	// replicas still running are cancelled once the service stops
+	close(stop)
+
+	select {
+	case err := <-slow.errs:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("replica isn't cancelled on stop")
+	}
+}
+
+func TestReplicated_AttemptTimeout(t *testing.T) {
+	slow := &slowReplica{release: make(chan struct{}), errs: make(chan error, 1)}
+
+	p, err := newReplicated([]Replica{
+		{Name: "service", Pinner: slow},
+	}, 1, 10*time.Millisecond, time.Hour, nil)
+	require.NoError(t, err)
+
+	err = p.PinHash(context.Background(), "tree", "cid")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestNewReplicatedPinner(t *testing.T) {
+	replicas := []Replica{{Name: "ipfs", Pinner: &replicaStub{}}}
+
+	_, err := NewReplicatedPinner(replicas, 0, 0, nil)
+	assert.Error(t, err)
+
+	_, err = NewReplicatedPinner(replicas, 2, 0, nil)
+	assert.Error(t, err)
+}
+
+func TestReplicated_UnpinList(t *testing.T) {
+	ctx := context.Background()
+
+	pinata := &replicaStub{hash: "cid-a", failures: 1}
+	ipfs := &replicaStub{hash: "cid-b"}
+
+	p, err := newReplicated([]Replica{
+		{Name: "pinata", Pinner: pinata},
+		{Name: "ipfs", Pinner: ipfs},
+	}, 1, time.Second, time.Hour, nil)
+	require.NoError(t, err)
+
+	err = p.Unpin(ctx, "cid")
+	assert.ErrorContains(t, err, "pinata: outage")
+	assert.Equal(t, []string{"cid"}, ipfs.unpinned)
+
+	pins, err := p.List(ctx, "repo@")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []PinInfo{
+		{Hash: "cid-a", Name: "repo@meta.json"},
+		{Hash: "cid-b", Name: "repo@meta.json"},
+	}, pins)
+}
@@ -0,0 +1,246 @@
+package protov2
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// LsRefsRequest is the ls-refs command request.
+type LsRefsRequest struct {
+	// Symrefs requests targets of symbolic references
+	Symrefs bool
+	// Peel requests targets of annotated tags
+	Peel bool
+	// Unborn requests HEAD pointing to the branch with no commits
+	Unborn bool
+	// Prefixes limit the listed references, all are listed without them
+	Prefixes []string
+}
+
+// ParseLsRefs parses ls-refs command arguments.
+func ParseLsRefs(args []string) (*LsRefsRequest, error) {
+	req := &LsRefsRequest{}
+
+	for _, arg := range args {
+		switch {
+		case arg == "symrefs":
+			req.Symrefs = true
+		case arg == "peel":
+			req.Peel = true
+		case arg == "unborn":
+			req.Unborn = true
+		case strings.HasPrefix(arg, "ref-prefix "):
+			req.Prefixes = append(req.Prefixes, strings.TrimPrefix(arg, "ref-prefix "))
+		default:
+			return nil, fmt.Errorf("%w: ls-refs %q", ErrUnsupportedArgument, arg)
+		}
+	}
+
+	return req, nil
+}
+
+// Match checks whether the reference is requested.
+func (r *LsRefsRequest) Match(name plumbing.ReferenceName) bool {
+	if len(r.Prefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range r.Prefixes {
+		if strings.HasPrefix(name.String(), prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Ref is the listed reference.
+type Ref struct {
+	Name plumbing.ReferenceName
+	// Hash is zero for the unborn HEAD
+	Hash plumbing.Hash
+	// SymrefTarget is the target of the symbolic reference
+	SymrefTarget plumbing.ReferenceName
+	// Peeled is the object the annotated tag points to
+	Peeled plumbing.Hash
+}
+
+// LsRefsResponse is the ls-refs command response.
+type LsRefsResponse []Ref
+
+// Encode writes the listed references.
+func (l LsRefsResponse) Encode(w io.Writer) error {
+	e := newEncoder(w)
+
+	for _, ref := range l {
+		line := ref.Hash.String()
+		if ref.Hash.IsZero() {
+			line = "unborn"
+		}
+
+		line += " " + ref.Name.String()
+
+		if ref.SymrefTarget != "" {
+			line += " symref-target:" + ref.SymrefTarget.String()
+		}
+
+		if !ref.Peeled.IsZero() {
+			line += " peeled:" + ref.Peeled.String()
+		}
+
+		e.linef("%s\n", line)
+	}
+
+	e.flush()
+
+	return e.err
+}
+
+// FetchRequest is the fetch command request.
+type FetchRequest struct {
+	Wants []plumbing.Hash
+	Haves []plumbing.Hash
+	// Done means the client doesn't negotiate, the packfile is sent
+	Done bool
+	// OFSDelta allows offset deltas in the packfile
+	OFSDelta bool
+	// IncludeTag requests annotated tags of the objects sent
+	IncludeTag bool
+}
+
+// ParseFetch parses fetch command arguments. Shallow clones, filters
+// and wanted refs are not advertised, so they are not supported. Thin
+// packs are allowed, not required, so the full packfile is sent.
+func ParseFetch(args []string) (*FetchRequest, error) {
+	req := &FetchRequest{}
+
+	for _, arg := range args {
+		name, value, _ := strings.Cut(arg, " ")
+
+		switch name {
+		case "want", "have":
+			if !plumbing.IsHash(value) {
+				return nil, fmt.Errorf("%w: invalid %s %q", ErrInvalidRequest, name, value)
+			}
+
+			if name == "want" {
+				req.Wants = append(req.Wants, plumbing.NewHash(value))
+			} else {
+				req.Haves = append(req.Haves, plumbing.NewHash(value))
+			}
+		case "done":
+			req.Done = true
+		case "ofs-delta":
+			req.OFSDelta = true
+		case "include-tag":
+			req.IncludeTag = true
+		case "thin-pack", "no-progress", "wait-for-done":
+			// the full packfile without progress is always sent
+		default:
+			return nil, fmt.Errorf("%w: fetch %q", ErrUnsupportedArgument, arg)
+		}
+	}
+
+	if len(req.Wants) == 0 {
+		return nil, fmt.Errorf("%w: no wants", ErrInvalidRequest)
+	}
+
+	return req, nil
+}
+
+// packData is the sideband channel of the packfile data
+const packData = 1
+
+// FetchResponse is the fetch command response. The server is always
+// ready to send the packfile, so there is a single round.
+type FetchResponse struct {
+	// Acknowledge sends acknowledgments of the common objects,
+	// they are not sent if the client is done
+	Acknowledge bool
+	// Common are haves the server has as well
+	Common []plumbing.Hash
+	// Packfile is the packfile sent, it's closed once it's sent
+	Packfile io.ReadCloser
+}
+
+// Encode writes the acknowledgments and the packfile multiplexed
+// on the sideband.
+func (f *FetchResponse) Encode(w io.Writer) error {
+	defer f.Packfile.Close()
+
+	e := newEncoder(w)
+
+	if f.Acknowledge {
+		e.linef("acknowledgments\n")
+
+		for _, hash := range f.Common {
+			e.linef("ACK %s\n", hash)
+		}
+
+		if len(f.Common) == 0 {
+			e.linef("NAK\n")
+		}
+
+		e.linef("ready\n")
+		e.delim()
+	}
+
+	e.linef("packfile\n")
+
+	buf := make([]byte, maxPktSize-lengthSize)
+	buf[0] = packData
+
+	for e.err == nil {
+		n, err := f.Packfile.Read(buf[1:])
+		if n > 0 {
+			e.pkt(buf[:n+1])
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read packfile: %w", err)
+		}
+	}
+
+	e.flush()
+
+	return e.err
+}
+
+// Bundle is the bundle listed to clients.
+type Bundle struct {
+	// ID is the bundle identifier unique within the list
+	ID string
+	// URI is where the bundle is downloaded from
+	URI string
+	// CreationToken orders bundles, a bundle requires
+	// only bundles with lower tokens
+	CreationToken int
+}
+
+// BundleList is the bundle-uri command response. All bundles are applied
+// in the order of their creation tokens, newer bundles are incremental.
+type BundleList []Bundle
+
+// Encode writes the bundle list as key-value pairs.
+func (b BundleList) Encode(w io.Writer) error {
+	e := newEncoder(w)
+
+	e.linef("bundle.version=1\n")
+	e.linef("bundle.mode=all\n")
+	e.linef("bundle.heuristic=creationToken\n")
+
+	for _, bundle := range b {
+		e.linef("bundle.%s.uri=%s\n", bundle.ID, bundle.URI)
+		e.linef("bundle.%s.creationToken=%d\n", bundle.ID, bundle.CreationToken)
+	}
+
+	e.flush()
+
+	return e.err
+}
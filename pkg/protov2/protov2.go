@@ -0,0 +1,211 @@
+// Package protov2 implements the server side of the git wire protocol
+// version 2 for upload-pack: the capability advertisement and the ls-refs,
+// fetch and bundle-uri commands. Every command is a separate stateless
+// request, as it is over HTTP.
+package protov2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Version is the value of the Git-Protocol header requesting version 2
+const Version = "version=2"
+
+const (
+	// CommandLsRefs lists references
+	CommandLsRefs = "ls-refs"
+	// CommandFetch sends the packfile
+	CommandFetch = "fetch"
+	// CommandBundleURI lists bundles clients bootstrap from
+	CommandBundleURI = "bundle-uri"
+)
+
+const (
+	// maxPktSize is the maximum pkt-line size, the length included
+	maxPktSize = 65520
+	// lengthSize is the size of pkt-line length
+	lengthSize = 4
+)
+
+var (
+	// ErrInvalidRequest is returned when the command request can't be parsed
+	ErrInvalidRequest = errors.New("invalid protocol v2 request")
+
+	// ErrUnknownCommand is returned for commands not supported
+	ErrUnknownCommand = errors.New("unknown protocol v2 command")
+
+	// ErrUnsupportedArgument is returned for command arguments not supported
+	ErrUnsupportedArgument = errors.New("unsupported protocol v2 argument")
+)
+
+// Response is the command response.
+type Response interface {
+	Encode(w io.Writer) error
+}
+
+// RequestedVersion2 checks whether the Git-Protocol header value requests
+// protocol version 2, the header is a colon separated list of parameters.
+func RequestedVersion2(header string) bool {
+	for _, param := range strings.Split(header, ":") {
+		if param == Version {
+			return true
+		}
+	}
+	return false
+}
+
+// Capabilities is the capability advertisement.
+type Capabilities struct {
+	// Agent is the server agent
+	Agent string
+	// BundleURI advertises bundle-uri command
+	BundleURI bool
+}
+
+// Encode writes the capability advertisement.
+func (c *Capabilities) Encode(w io.Writer) error {
+	lines := []string{
+		"version 2",
+		"agent=" + c.Agent,
+		CommandLsRefs + "=unborn",
+		CommandFetch,
+		"object-format=sha1",
+	}
+
+	if c.BundleURI {
+		lines = append(lines, CommandBundleURI)
+	}
+
+	e := newEncoder(w)
+	for _, line := range lines {
+		e.linef("%s\n", line)
+	}
+	e.flush()
+
+	return e.err
+}
+
+// Request is the command request.
+type Request struct {
+	// Command is the requested command
+	Command string
+	// Capabilities are the capabilities the client sent
+	Capabilities []string
+	// Args are the command arguments
+	Args []string
+}
+
+// DecodeRequest reads the command request: the command, capabilities
+// and, after the delimiter, the arguments up to the flush packet.
+func DecodeRequest(r io.Reader) (*Request, error) {
+	req := &Request{}
+
+	args := false
+
+	for {
+		line, special, err := readPkt(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidRequest, err)
+		}
+
+		switch special {
+		case flushPkt:
+			if req.Command == "" {
+				return nil, fmt.Errorf("%w: no command", ErrInvalidRequest)
+			}
+			return req, nil
+		case delimPkt:
+			args = true
+			continue
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case args:
+			req.Args = append(req.Args, line)
+		case req.Command == "":
+			command := strings.TrimPrefix(line, "command=")
+			if command == line || command == "" {
+				return nil, fmt.Errorf("%w: expected command, got %q", ErrInvalidRequest, line)
+			}
+			req.Command = command
+		default:
+			req.Capabilities = append(req.Capabilities, line)
+		}
+	}
+}
+
+// special pkt-lines
+const (
+	flushPkt = "0000"
+	delimPkt = "0001"
+)
+
+// readPkt reads the pkt-line, special packets are returned
+// as their length and an empty payload
+func readPkt(r io.Reader) (string, string, error) {
+	var length [lengthSize]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return "", "", err
+	}
+
+	n, err := strconv.ParseUint(string(length[:]), 16, 16)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid pkt-line length %q", length)
+	}
+
+	switch {
+	case n == 0 || n == 1:
+		return "", string(length[:]), nil
+	case n < lengthSize || n > maxPktSize:
+		return "", "", fmt.Errorf("invalid pkt-line length %q", length)
+	}
+
+	payload := make([]byte, n-lengthSize)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", "", err
+	}
+
+	return string(payload), "", nil
+}
+
+// encoder writes pkt-lines keeping the first error
+type encoder struct {
+	w   io.Writer
+	err error
+}
+
+func newEncoder(w io.Writer) *encoder {
+	return &encoder{w: w}
+}
+
+func (e *encoder) write(p []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+func (e *encoder) pkt(payload []byte) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%04x", len(payload)+lengthSize)
+	buf.Write(payload)
+	e.write(buf.Bytes())
+}
+
+func (e *encoder) linef(format string, a ...interface{}) {
+	e.pkt([]byte(fmt.Sprintf(format, a...)))
+}
+
+func (e *encoder) flush() {
+	e.write([]byte(flushPkt))
+}
+
+func (e *encoder) delim() {
+	e.write([]byte(delimPkt))
+}
@@ -0,0 +1,155 @@
+package protov2
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestedVersion2(t *testing.T) {
+	assert.True(t, RequestedVersion2("version=2"))
+	assert.True(t, RequestedVersion2("object-format=sha1:version=2"))
+	assert.False(t, RequestedVersion2("version=1"))
+	assert.False(t, RequestedVersion2(""))
+}
+
+func TestCapabilities_Encode(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, (&Capabilities{Agent: "gitsec", BundleURI: true}).Encode(&buf))
+
+	assert.Equal(t, "000eversion 2\n"+
+		"0011agent=gitsec\n"+
+		"0013ls-refs=unborn\n"+
+		"000afetch\n"+
+		"0017object-format=sha1\n"+
+		"000fbundle-uri\n"+
+		"0000", buf.String())
+}
+
+// pkts encodes lines as pkt-lines, special packets are kept as they are
+func pkts(lines ...string) io.Reader {
+	var buf bytes.Buffer
+	e := newEncoder(&buf)
+	for _, line := range lines {
+		if line == flushPkt || line == delimPkt {
+			e.write([]byte(line))
+			continue
+		}
+		e.linef("%s", line)
+	}
+	return &buf
+}
+
+func TestDecodeRequest(t *testing.T) {
+	req, err := DecodeRequest(pkts("command=ls-refs\n", "agent=git/2.43.0\n", delimPkt, "symrefs\n", "ref-prefix refs/heads/\n", flushPkt))
+	require.NoError(t, err)
+	assert.Equal(t, &Request{
+		Command:      CommandLsRefs,
+		Capabilities: []string{"agent=git/2.43.0"},
+		Args:         []string{"symrefs", "ref-prefix refs/heads/"},
+	}, req)
+
+	// arguments are optional
+	req, err = DecodeRequest(pkts("command=bundle-uri\n", flushPkt))
+	require.NoError(t, err)
+	assert.Equal(t, CommandBundleURI, req.Command)
+
+	for _, invalid := range []io.Reader{
+		pkts(),
+		pkts(flushPkt),
+		pkts("agent\n", flushPkt),
+		pkts("command=ls-refs\n"),
+		strings.NewReader("zzzz"),
+	} {
+		_, err := DecodeRequest(invalid)
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	}
+}
+
+func TestLsRefs(t *testing.T) {
+	req, err := ParseLsRefs([]string{"peel", "symrefs", "ref-prefix refs/tags/"})
+	require.NoError(t, err)
+	assert.True(t, req.Match("refs/tags/v1"))
+	assert.False(t, req.Match("refs/heads/main"))
+
+	_, err = ParseLsRefs([]string{"unknown"})
+	assert.ErrorIs(t, err, ErrUnsupportedArgument)
+
+	hash := plumbing.NewHash("9d2d7f1d6f6b5bd5c0d44b5e3a0b0fb3e3b1c2d4")
+
+	var buf bytes.Buffer
+	require.NoError(t, LsRefsResponse{
+		{Name: plumbing.HEAD, SymrefTarget: "refs/heads/main"},
+		{Name: "refs/tags/v1", Hash: hash, Peeled: hash},
+	}.Encode(&buf))
+
+	assert.Equal(t, "002eunborn HEAD symref-target:refs/heads/main\n"+
+		"006a"+hash.String()+" refs/tags/v1 peeled:"+hash.String()+"\n"+
+		"0000", buf.String())
+}
+
+func TestFetch(t *testing.T) {
+	want := plumbing.NewHash("9d2d7f1d6f6b5bd5c0d44b5e3a0b0fb3e3b1c2d4")
+
+	req, err := ParseFetch([]string{"want " + want.String(), "have " + want.String(), "ofs-delta", "thin-pack", "include-tag", "done"})
+	require.NoError(t, err)
+	assert.Equal(t, &FetchRequest{Wants: []plumbing.Hash{want}, Haves: []plumbing.Hash{want}, Done: true, OFSDelta: true, IncludeTag: true}, req)
+
+	_, err = ParseFetch([]string{"want " + want.String(), "deepen 1"})
+	assert.ErrorIs(t, err, ErrUnsupportedArgument)
+
+	_, err = ParseFetch([]string{"done"})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+
+	pack := bytes.Repeat([]byte("p"), maxPktSize)
+
+	var buf bytes.Buffer
+	require.NoError(t, (&FetchResponse{
+		Acknowledge: true,
+		Packfile:    io.NopCloser(bytes.NewReader(pack)),
+	}).Encode(&buf))
+
+	r := bytes.NewReader(buf.Bytes())
+
+	var lines []string
+	var data []byte
+	for {
+		line, special, err := readPkt(r)
+		require.NoError(t, err)
+
+		if special == flushPkt {
+			break
+		}
+		if special != "" {
+			lines = append(lines, special)
+			continue
+		}
+
+		if line[0] == packData {
+			data = append(data, line[1:]...)
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	assert.Equal(t, []string{"acknowledgments\n", "NAK\n", "ready\n", delimPkt, "packfile\n"}, lines)
+	assert.Equal(t, pack, data)
+	assert.Zero(t, r.Len())
+}
+
+func TestBundleList_Encode(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, BundleList{{ID: "full", URI: "https://ipfs.io/ipfs/full", CreationToken: 1}}.Encode(&buf))
+
+	assert.Equal(t, "0015bundle.version=1\n"+
+		"0014bundle.mode=all\n"+
+		"0023bundle.heuristic=creationToken\n"+
+		"002ebundle.full.uri=https://ipfs.io/ipfs/full\n"+
+		"0020bundle.full.creationToken=1\n"+
+		"0000", buf.String())
+}
@@ -7,7 +7,10 @@ import (
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+
+	"gitsec-backend/pkg/envelope"
 )
 
 // Signer is a structure of ETH account
@@ -52,3 +55,49 @@ func (s *Signer) Sign(chainID *big.Int) (*bind.TransactOpts, error) {
 
 	return signer, nil
 }
+
+// PublicKey returns the account public key
+func (s *Signer) PublicKey() *ecdsa.PublicKey {
+	return &s.private.PublicKey
+}
+
+// Unwrap decrypts the content key wrapped to the account
+func (s *Signer) Unwrap(recipients []envelope.Recipient) ([]byte, error) {
+	return envelope.Unwrap(recipients, s.private)
+}
+
+// RecoverPublicKey recovers the public key of the transaction sender
+// from the transaction signature
+func RecoverPublicKey(tx *types.Transaction, chainID *big.Int) (*ecdsa.PublicKey, error) {
+	v, r, s := tx.RawSignatureValues()
+
+	var txSigner types.Signer = types.LatestSignerForChainID(chainID)
+
+	// legacy transactions carry the chain ID in V, typed
+	// ones carry the recovery ID as is
+	recovery := new(big.Int).Set(v)
+	if tx.Type() == types.LegacyTxType {
+		if tx.Protected() {
+			recovery.Sub(recovery, new(big.Int).Add(new(big.Int).Mul(chainID, big.NewInt(2)), big.NewInt(35)))
+		} else {
+			recovery.Sub(recovery, big.NewInt(27))
+			txSigner = types.HomesteadSigner{}
+		}
+	}
+
+	if !recovery.IsUint64() || recovery.Uint64() > 1 {
+		return nil, fmt.Errorf("invalid signature recovery ID %s", v)
+	}
+
+	sig := make([]byte, crypto.SignatureLength)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:64])
+	sig[64] = byte(recovery.Uint64())
+
+	pub, err := crypto.SigToPub(txSigner.Hash(tx).Bytes(), sig)
+	if err != nil {
+		return nil, fmt.Errorf("recover public key: %w", err)
+	}
+
+	return pub, nil
+}
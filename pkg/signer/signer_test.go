@@ -0,0 +1,40 @@
+package signer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoverPublicKey(t *testing.T) {
+	private, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	chainID := big.NewInt(10200)
+	to := common.HexToAddress("0x01")
+
+	tests := map[string]struct {
+		signer types.Signer
+		data   types.TxData
+	}{
+		"legacy":      {types.LatestSignerForChainID(chainID), &types.LegacyTx{Nonce: 1, To: &to, Gas: 21000, GasPrice: big.NewInt(1)}},
+		"unprotected": {types.HomesteadSigner{}, &types.LegacyTx{Nonce: 1, To: &to, Gas: 21000, GasPrice: big.NewInt(1)}},
+		"dynamic":     {types.LatestSignerForChainID(chainID), &types.DynamicFeeTx{ChainID: chainID, Nonce: 1, To: &to, Gas: 21000, GasFeeCap: big.NewInt(2), GasTipCap: big.NewInt(1)}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tx, err := types.SignNewTx(private, tt.signer, tt.data)
+			require.NoError(t, err)
+
+			pub, err := RecoverPublicKey(tx, chainID)
+			require.NoError(t, err)
+			assert.True(t, private.PublicKey.Equal(pub))
+		})
+	}
+}
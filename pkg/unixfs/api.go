@@ -0,0 +1,170 @@
+package unixfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/ipfs/go-cid"
+	ipfs "github.com/ipfs/go-ipfs-api"
+	files "github.com/ipfs/go-ipfs-files"
+)
+
+// API is the part of IPFS API the DAG is published through.
+type API interface {
+	// Add adds the file content as UnixFS file with raw leaves without
+	// pinning it and returns its root CID and cumulative DAG size
+	Add(ctx context.Context, r io.Reader) (cid.Cid, uint64, error)
+
+	// BlockGet returns the raw block
+	BlockGet(ctx context.Context, c cid.Cid) ([]byte, error)
+
+	// BlockPut stores the raw block with the given codec and returns its
+	// CIDv1. Blocks are hashed with sha2-256, git-raw ones with sha1.
+	BlockPut(ctx context.Context, data []byte, codec uint64) (cid.Cid, error)
+
+	// Stat resolves IPFS path and returns CID and cumulative DAG size
+	Stat(ctx context.Context, path string) (cid.Cid, uint64, error)
+
+	// Pin pins the DAG recursively
+	Pin(ctx context.Context, c cid.Cid) error
+
+	// PinBlock pins the block only, its links aren't traversed
+	PinBlock(ctx context.Context, c cid.Cid) error
+}
+
+// maxBlockSize is the block size IPFS accepts by default,
+// larger blocks are put explicitly allowing them
+const maxBlockSize = 1 << 20
+
+// blockCodec is the block codec and hash function names
+type blockCodec struct {
+	name   string
+	mhType string
+}
+
+// blockCodecs are supported block codecs
+var blockCodecs = map[uint64]blockCodec{
+	cid.Raw:         {name: "raw", mhType: "sha2-256"},
+	cid.DagProtobuf: {name: "dag-pb", mhType: "sha2-256"},
+	cid.GitRaw:      {name: "git-raw", mhType: "sha1"},
+}
+
+// ShellAPI is API implementation over the IPFS HTTP API client.
+type ShellAPI struct {
+	shell *ipfs.Shell
+}
+
+// NewShellAPI creates a new ShellAPI with the given IPFS client.
+func NewShellAPI(shell *ipfs.Shell) *ShellAPI {
+	return &ShellAPI{shell: shell}
+}
+
+func (s *ShellAPI) Add(ctx context.Context, r io.Reader) (cid.Cid, uint64, error) {
+	var out struct {
+		Hash string
+		Size string
+	}
+
+	err := s.shell.Request("add").
+		Option("cid-version", 1).
+		Option("raw-leaves", true).
+		Option("pin", false).
+		Body(multipart(files.NewReaderFile(r))).
+		Exec(ctx, &out)
+	if err != nil {
+		return cid.Undef, 0, fmt.Errorf("add: %w", err)
+	}
+
+	c, err := cid.Decode(out.Hash)
+	if err != nil {
+		return cid.Undef, 0, fmt.Errorf("add: decode cid %q: %w", out.Hash, err)
+	}
+
+	size, err := strconv.ParseUint(out.Size, 10, 64)
+	if err != nil {
+		return cid.Undef, 0, fmt.Errorf("add: parse size %q: %w", out.Size, err)
+	}
+
+	return c, size, nil
+}
+
+func (s *ShellAPI) BlockGet(ctx context.Context, c cid.Cid) ([]byte, error) {
+	resp, err := s.shell.Request("block/get", c.String()).Send(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("block get %s: %w", c, err)
+	}
+	defer resp.Close()
+
+	if resp.Error != nil {
+		return nil, fmt.Errorf("block get %s: %w", c, resp.Error)
+	}
+
+	return io.ReadAll(resp.Output)
+}
+
+func (s *ShellAPI) BlockPut(ctx context.Context, data []byte, codec uint64) (cid.Cid, error) {
+	bc, ok := blockCodecs[codec]
+	if !ok {
+		return cid.Undef, fmt.Errorf("block put: unsupported codec 0x%x", codec)
+	}
+
+	var out struct {
+		Key string
+	}
+
+	rb := s.shell.Request("block/put").
+		Option("cid-codec", bc.name).
+		Option("mhtype", bc.mhType)
+
+	if len(data) > maxBlockSize {
+		rb.Option("allow-big-block", true)
+	}
+
+	err := rb.Body(multipart(files.NewBytesFile(data))).Exec(ctx, &out)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("block put: %w", err)
+	}
+
+	c, err := cid.Decode(out.Key)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("block put: decode cid %q: %w", out.Key, err)
+	}
+
+	return c, nil
+}
+
+func (s *ShellAPI) Stat(ctx context.Context, path string) (cid.Cid, uint64, error) {
+	stat, err := s.shell.FilesStat(ctx, "/ipfs/"+path)
+	if err != nil {
+		return cid.Undef, 0, fmt.Errorf("stat %s: %w", path, err)
+	}
+
+	c, err := cid.Decode(stat.Hash)
+	if err != nil {
+		return cid.Undef, 0, fmt.Errorf("stat %s: decode cid %q: %w", path, stat.Hash, err)
+	}
+
+	return c, stat.CumulativeSize, nil
+}
+
+func (s *ShellAPI) Pin(ctx context.Context, c cid.Cid) error {
+	if err := s.shell.Request("pin/add", c.String()).Option("recursive", true).Exec(ctx, nil); err != nil {
+		return fmt.Errorf("pin %s: %w", c, err)
+	}
+	return nil
+}
+
+func (s *ShellAPI) PinBlock(ctx context.Context, c cid.Cid) error {
+	if err := s.shell.Request("pin/add", c.String()).Option("recursive", false).Exec(ctx, nil); err != nil {
+		return fmt.Errorf("pin block %s: %w", c, err)
+	}
+	return nil
+}
+
+// multipart wraps the single file into the API request body
+func multipart(f files.Node) io.Reader {
+	dir := files.NewSliceDirectory([]files.DirEntry{files.FileEntry("", f)})
+	return files.NewMultiFileReader(dir, true)
+}
@@ -0,0 +1,375 @@
+package unixfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/ipfs/go-cid"
+)
+
+// executableMode is the UnixFS mode of executable files,
+// other files and directories keep the default mode
+const executableMode = 0755
+
+// Builder publishes git trees as UnixFS directory DAGs, so repository
+// snapshots can be browsed through IPFS gateways. Files are added through
+// the IPFS API, directories and symlinks are encoded locally and stored
+// as blocks.
+type Builder struct {
+	api API
+	// concurrency is the maximum number of files added at the same time
+	concurrency int
+	// skip reports files not to be published
+	skip SkipFunc
+
+	// mu serialises reading git objects, repository
+	// storage is not safe for concurrent reads
+	mu sync.Mutex
+}
+
+// Previous is the previously published tree. Its subtrees which are not
+// changed since are reused without adding their files again.
+type Previous struct {
+	// Root is the previously published DAG root
+	Root cid.Cid
+	// Tree is the previously published git tree
+	Tree *object.Tree
+	// Failed are paths of files missing in the previous DAG
+	Failed []string
+}
+
+// Result is the published tree DAG.
+type Result struct {
+	// Root is the DAG root CID
+	Root cid.Cid
+	// Size is the cumulative DAG size
+	Size uint64
+	// Files are CIDs of files and symlinks added by the build by their
+	// paths, files of reused subtrees are not listed
+	Files map[string]cid.Cid
+	// Failed are errors of files failed to be added by their paths,
+	// such files are left out of the DAG
+	Failed map[string]error
+}
+
+// entry is a directory entry being built
+type entry struct {
+	name string
+	path string
+	mode filemode.FileMode
+
+	// children are the entries of a subdirectory
+	children []*entry
+	// blob is the content of a file or a symlink to add
+	blob *object.Blob
+
+	// link is the published entry
+	link Link
+	// err is the error of adding the entry
+	err error
+}
+
+// SkipFunc reports whether the file with the given path
+// and size is left out of the published directory.
+type SkipFunc func(path string, size int64) bool
+
+// NewBuilder creates a new Builder publishing through the given API.
+// Not positive concurrency means files are added one by one. Optional
+// skip function leaves files out of the published directories.
+func NewBuilder(api API, concurrency int, skip SkipFunc) *Builder {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	if skip == nil {
+		skip = func(string, int64) bool { return false }
+	}
+
+	return &Builder{api: api, concurrency: concurrency, skip: skip}
+}
+
+// Build publishes the tree as UnixFS directory and pins its root. Previous
+// tree is optional. Files failed to be added don't fail the build, they are
+// reported in the Result.
+func (b *Builder) Build(ctx context.Context, tree *object.Tree, prev *Previous) (*Result, error) {
+	var prevTree *object.Tree
+	tainted := make(map[string]bool)
+
+	if prev != nil && prev.Root.Defined() {
+		prevTree = prev.Tree
+		for _, p := range prev.Failed {
+			for ; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+				tainted[p] = true
+			}
+		}
+	}
+
+	var blobs []*entry
+
+	plan := &planner{ctx: ctx, builder: b, prev: prev, tainted: tainted, blobs: &blobs}
+
+	entries, err := plan.dir("", tree, prevTree)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{
+		Files:  make(map[string]cid.Cid),
+		Failed: make(map[string]error),
+	}
+
+	b.addBlobs(ctx, blobs, b.addBlob)
+
+	for _, e := range blobs {
+		if e.err != nil {
+			res.Failed[e.path] = e.err
+			continue
+		}
+		res.Files[e.path] = e.link.Cid
+	}
+
+	root, err := b.putDir(ctx, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.api.Pin(ctx, root.Cid); err != nil {
+		return nil, err
+	}
+
+	res.Root = root.Cid
+	res.Size = root.Tsize
+
+	return res, nil
+}
+
+// planner collects entries of the tree to publish
+type planner struct {
+	ctx     context.Context
+	builder *Builder
+	prev    *Previous
+	// tainted are paths which can't be reused from the previous DAG
+	tainted map[string]bool
+	// blobs are collected files and symlinks to add
+	blobs *[]*entry
+}
+
+// dir collects entries of the directory at the given path. Entries not
+// changed since prevTree are resolved in the previous DAG.
+func (p *planner) dir(dirPath string, tree, prevTree *object.Tree) ([]*entry, error) {
+	var entries []*entry
+
+	for _, te := range tree.Entries {
+		te := te
+
+		if te.Mode == filemode.Submodule {
+			continue
+		}
+
+		e := &entry{name: te.Name, path: path.Join(dirPath, te.Name), mode: te.Mode}
+
+		var prevEntry *object.TreeEntry
+		if prevTree != nil {
+			if pe, err := prevTree.FindEntry(te.Name); err == nil {
+				prevEntry = pe
+			}
+		}
+
+		if prevEntry != nil && !p.tainted[e.path] && prevEntry.Hash == te.Hash && prevEntry.Mode == te.Mode {
+			c, size, err := p.builder.api.Stat(p.ctx, p.prev.Root.String()+"/"+e.path)
+			if err == nil {
+				e.link = Link{Cid: c, Name: e.name, Tsize: size}
+				entries = append(entries, e)
+				continue
+			}
+		}
+
+		if te.Mode == filemode.Dir {
+			sub, err := tree.Tree(te.Name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get tree %s: %w", e.path, err)
+			}
+
+			var prevSub *object.Tree
+			if prevEntry != nil && prevEntry.Mode == filemode.Dir {
+				if prevSub, err = prevTree.Tree(te.Name); err != nil {
+					prevSub = nil
+				}
+			}
+
+			if e.children, err = p.dir(e.path, sub, prevSub); err != nil {
+				return nil, err
+			}
+
+			entries = append(entries, e)
+			continue
+		}
+
+		file, err := tree.TreeEntryFile(&te)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get file %s: %w", e.path, err)
+		}
+
+		if p.builder.skip(e.path, file.Size) {
+			continue
+		}
+
+		e.blob = &file.Blob
+		*p.blobs = append(*p.blobs, e)
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// addBlobs adds files and symlinks in parallel with the add function
+func (b *Builder) addBlobs(ctx context.Context, blobs []*entry, add func(context.Context, *entry) (Link, error)) {
+	queue := make(chan *entry)
+
+	var wg sync.WaitGroup
+	for w := 0; w < b.concurrency && w < len(blobs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range queue {
+				e.link, e.err = add(ctx, e)
+				if e.err != nil {
+					e.err = fmt.Errorf("add %s: %w", e.path, e.err)
+				}
+			}
+		}()
+	}
+
+	for _, e := range blobs {
+		queue <- e
+	}
+	close(queue)
+
+	wg.Wait()
+}
+
+// addBlob adds the file or the symlink entry, file content
+// is streamed to IPFS
+func (b *Builder) addBlob(ctx context.Context, e *entry) (Link, error) {
+	r, err := b.open(e.blob)
+	if err != nil {
+		return Link{}, err
+	}
+	defer r.Close()
+
+	if e.mode == filemode.Symlink {
+		target, err := io.ReadAll(r)
+		if err != nil {
+			return Link{}, fmt.Errorf("failed to read symlink: %w", err)
+		}
+
+		return b.put(ctx, e.name, SymlinkNode(string(target)))
+	}
+
+	c, size, err := b.api.Add(ctx, r)
+	if err != nil {
+		return Link{}, err
+	}
+
+	if e.mode == filemode.Executable {
+		return b.withMode(ctx, e.name, c, size, executableMode)
+	}
+
+	return Link{Cid: c, Name: e.name, Tsize: size}, nil
+}
+
+// open opens the blob content
+func (b *Builder) open(blob *object.Blob) (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r, err := blob.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob %s: %w", blob.Hash, err)
+	}
+
+	return r, nil
+}
+
+// withMode republishes the file root with the given mode. Raw leaf is
+// wrapped into UnixFS file node as raw blocks can't carry the mode.
+func (b *Builder) withMode(ctx context.Context, name string, c cid.Cid, size uint64, mode uint32) (Link, error) {
+	if c.Type() == cid.Raw {
+		return b.put(ctx, name, FileNode([]Link{{Cid: c, Tsize: size}}, mode))
+	}
+
+	raw, err := b.api.BlockGet(ctx, c)
+	if err != nil {
+		return Link{}, err
+	}
+
+	node, err := UnmarshalNode(raw)
+	if err != nil {
+		return Link{}, fmt.Errorf("decode file %s: %w", c, err)
+	}
+
+	data, err := UnmarshalData(node.Data)
+	if err != nil {
+		return Link{}, fmt.Errorf("decode file %s: %w", c, err)
+	}
+
+	data.Mode = mode
+	node.Data = data.Marshal()
+
+	return b.put(ctx, name, node)
+}
+
+// putDir stores the directory with its not yet published subdirectories
+func (b *Builder) putDir(ctx context.Context, entries []*entry) (Link, error) {
+	dir := DirectoryNode()
+
+	for _, e := range entries {
+		if e.err != nil {
+			continue
+		}
+
+		if e.mode == filemode.Dir && !e.link.Cid.Defined() {
+			link, err := b.putDir(ctx, e.children)
+			if err != nil {
+				return Link{}, err
+			}
+			e.link = link
+		}
+
+		e.link.Name = e.name
+		dir.Links = append(dir.Links, e.link)
+	}
+
+	return b.put(ctx, "", dir)
+}
+
+// put stores the dag-pb node and verifies the CID assigned by IPFS
+func (b *Builder) put(ctx context.Context, name string, node *Node) (Link, error) {
+	data := node.Marshal()
+
+	expected, err := sum(cid.DagProtobuf, data)
+	if err != nil {
+		return Link{}, err
+	}
+
+	c, err := b.api.BlockPut(ctx, data, cid.DagProtobuf)
+	if err != nil {
+		return Link{}, err
+	}
+
+	if !c.Equals(expected) {
+		return Link{}, fmt.Errorf("block cid mismatch: stored %s, expected %s", c, expected)
+	}
+
+	size := uint64(len(data))
+	for _, l := range node.Links {
+		size += l.Tsize
+	}
+
+	return Link{Cid: c, Name: name, Tsize: size}, nil
+}
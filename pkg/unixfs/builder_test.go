@@ -0,0 +1,384 @@
+package unixfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAPI is in-memory API, files are stored as single raw leaves
+type fakeAPI struct {
+	mu     sync.Mutex
+	blocks map[cid.Cid][]byte
+	pins   []cid.Cid
+	added  int
+
+	// fail fails adding of the content
+	fail string
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{blocks: make(map[cid.Cid][]byte)}
+}
+
+func (f *fakeAPI) Add(_ context.Context, r io.Reader) (cid.Cid, uint64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+
+	if f.fail != "" && string(data) == f.fail {
+		return cid.Undef, 0, errors.New("add failed")
+	}
+
+	f.mu.Lock()
+	f.added++
+	f.mu.Unlock()
+
+	c, err := f.BlockPut(context.Background(), data, cid.Raw)
+	return c, uint64(len(data)), err
+}
+
+func (f *fakeAPI) BlockGet(_ context.Context, c cid.Cid) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, ok := f.blocks[c]
+	if !ok {
+		return nil, fmt.Errorf("block %s not found", c)
+	}
+	return data, nil
+}
+
+func (f *fakeAPI) BlockPut(_ context.Context, data []byte, codec uint64) (cid.Cid, error) {
+	c, err := cid.Prefix{Version: 1, Codec: codec, MhType: mh.SHA2_256, MhLength: -1}.Sum(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	f.mu.Lock()
+	f.blocks[c] = data
+	f.mu.Unlock()
+
+	return c, nil
+}
+
+func (f *fakeAPI) Stat(ctx context.Context, p string) (cid.Cid, uint64, error) {
+	segments := strings.Split(p, "/")
+
+	c, err := cid.Decode(segments[0])
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+
+	var size uint64
+	for _, name := range segments[1:] {
+		data, err := f.BlockGet(ctx, c)
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+
+		node, err := UnmarshalNode(data)
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+
+		link, ok := findLink(node, name)
+		if !ok {
+			return cid.Undef, 0, fmt.Errorf("%s not found", p)
+		}
+
+		c, size = link.Cid, link.Tsize
+	}
+
+	return c, size, nil
+}
+
+func (f *fakeAPI) Pin(_ context.Context, c cid.Cid) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pins = append(f.pins, c)
+	return nil
+}
+
+func (f *fakeAPI) PinBlock(context.Context, cid.Cid) error {
+	return nil
+}
+
+// getNode returns decoded dag-pb node and its UnixFS data
+func getNode(t *testing.T, api *fakeAPI, c cid.Cid) (*Node, *Data) {
+	t.Helper()
+
+	raw, err := api.BlockGet(context.Background(), c)
+	require.NoError(t, err)
+
+	node, err := UnmarshalNode(raw)
+	require.NoError(t, err)
+
+	data, err := UnmarshalData(node.Data)
+	require.NoError(t, err)
+
+	return node, data
+}
+
+// linkNames returns names of the node links
+func linkNames(node *Node) []string {
+	var names []string
+	for _, l := range node.Links {
+		names = append(names, l.Name)
+	}
+	return names
+}
+
+// storeObject stores the encoded object in the storage
+func storeObject(t *testing.T, s *memory.Storage, o interface {
+	Encode(plumbing.EncodedObject) error
+}) plumbing.Hash {
+	t.Helper()
+
+	obj := s.NewEncodedObject()
+	require.NoError(t, o.Encode(obj))
+
+	hash, err := s.SetEncodedObject(obj)
+	require.NoError(t, err)
+
+	return hash
+}
+
+// storeBlob stores the blob with the given content
+func storeBlob(t *testing.T, s *memory.Storage, content string) plumbing.Hash {
+	t.Helper()
+
+	obj := s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+
+	w, err := obj.Writer()
+	require.NoError(t, err)
+	_, err = w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	hash, err := s.SetEncodedObject(obj)
+	require.NoError(t, err)
+
+	return hash
+}
+
+// testTree stores repository tree, the main.go content is given
+func testTree(t *testing.T, s *memory.Storage, main string) *object.Tree {
+	t.Helper()
+
+	lib := storeObject(t, s, &object.Tree{Entries: []object.TreeEntry{
+		{Name: "util.go", Mode: filemode.Regular, Hash: storeBlob(t, s, "util")},
+	}})
+
+	src := storeObject(t, s, &object.Tree{Entries: []object.TreeEntry{
+		{Name: "lib", Mode: filemode.Dir, Hash: lib},
+		{Name: "main.go", Mode: filemode.Regular, Hash: storeBlob(t, s, main)},
+	}})
+
+	root := storeObject(t, s, &object.Tree{Entries: []object.TreeEntry{
+		{Name: "README.md", Mode: filemode.Regular, Hash: storeBlob(t, s, "readme")},
+		{Name: "link", Mode: filemode.Symlink, Hash: storeBlob(t, s, "README.md")},
+		{Name: "run.sh", Mode: filemode.Executable, Hash: storeBlob(t, s, "#!/bin/sh")},
+		{Name: "src", Mode: filemode.Dir, Hash: src},
+	}})
+
+	tree, err := object.GetTree(s, root)
+	require.NoError(t, err)
+
+	return tree
+}
+
+func TestBuilder_Build(t *testing.T) {
+	api := newFakeAPI()
+	s := memory.NewStorage()
+
+	res, err := NewBuilder(api, 2, nil).Build(context.Background(), testTree(t, s, "main"), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []cid.Cid{res.Root}, api.pins)
+	assert.Empty(t, res.Failed)
+	assert.Len(t, res.Files, 5)
+
+	root, data := getNode(t, api, res.Root)
+	assert.Equal(t, TDirectory, data.Type)
+	assert.Equal(t, []string{"README.md", "link", "run.sh", "src"}, linkNames(root))
+
+	readme, _ := findLink(root, "README.md")
+	assert.Equal(t, uint64(cid.Raw), readme.Cid.Type())
+	assert.Equal(t, res.Files["README.md"], readme.Cid)
+
+	link, _ := findLink(root, "link")
+	assert.Equal(t, res.Files["link"], link.Cid)
+	_, data = getNode(t, api, link.Cid)
+	assert.Equal(t, TSymlink, data.Type)
+	assert.Equal(t, "README.md", string(data.Data))
+
+	run, _ := findLink(root, "run.sh")
+	runNode, data := getNode(t, api, run.Cid)
+	assert.Equal(t, TFile, data.Type)
+	assert.Equal(t, uint32(0755), data.Mode)
+	assert.Equal(t, uint64(9), *data.FileSize)
+	require.Len(t, runNode.Links, 1)
+	assert.Equal(t, uint64(cid.Raw), runNode.Links[0].Cid.Type())
+
+	c, _, err := api.Stat(context.Background(), res.Root.String()+"/src/lib/util.go")
+	require.NoError(t, err)
+	assert.Equal(t, res.Files["src/lib/util.go"], c)
+}
+
+func TestBuilder_BuildIncremental(t *testing.T) {
+	api := newFakeAPI()
+	s := memory.NewStorage()
+	builder := NewBuilder(api, 2, nil)
+
+	prevTree := testTree(t, s, "main")
+	prev, err := builder.Build(context.Background(), prevTree, nil)
+	require.NoError(t, err)
+
+	api.added = 0
+
+	res, err := builder.Build(context.Background(), testTree(t, s, "main v2"), &Previous{Root: prev.Root, Tree: prevTree})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, api.added)
+	assert.Equal(t, []string{"src/main.go"}, keys(res.Files))
+
+	for _, p := range []string{"README.md", "run.sh", "link", "src/lib"} {
+		before, _, err := api.Stat(context.Background(), prev.Root.String()+"/"+p)
+		require.NoError(t, err)
+		after, _, err := api.Stat(context.Background(), res.Root.String()+"/"+p)
+		require.NoError(t, err)
+		assert.Equal(t, before, after, p)
+	}
+}
+
+func TestBuilder_BuildFailed(t *testing.T) {
+	api := newFakeAPI()
+	api.fail = "util"
+	s := memory.NewStorage()
+	builder := NewBuilder(api, 2, nil)
+
+	tree := testTree(t, s, "main")
+	prev, err := builder.Build(context.Background(), tree, nil)
+	require.NoError(t, err)
+
+	require.Contains(t, prev.Failed, "src/lib/util.go")
+	_, _, err = api.Stat(context.Background(), prev.Root.String()+"/src/lib/util.go")
+	assert.Error(t, err)
+
+	api.fail = ""
+	api.added = 0
+
+	res, err := builder.Build(context.Background(), tree, &Previous{Root: prev.Root, Tree: tree, Failed: []string{"src/lib/util.go"}})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, api.added)
+	assert.Empty(t, res.Failed)
+	assert.Equal(t, []string{"src/lib/util.go"}, keys(res.Files))
+}
+
+// keys returns keys of the files map
+func keys(files map[string]cid.Cid) []string {
+	var res []string
+	for k := range files {
+		res = append(res, k)
+	}
+	return res
+}
+
+func TestBuilder_BuildSkip(t *testing.T) {
+	api := newFakeAPI()
+	s := memory.NewStorage()
+
+	skip := func(path string, size int64) bool {
+		return path == "src/lib/util.go" || size > 6
+	}
+
+	res, err := NewBuilder(api, 2, skip).Build(context.Background(), testTree(t, s, "main"), nil)
+	require.NoError(t, err)
+
+	// README.md is 6 bytes, run.sh and link are 9 bytes
+	assert.ElementsMatch(t, []string{"README.md", "src/main.go"}, keys(res.Files))
+
+	lib, _, err := api.Stat(context.Background(), res.Root.String()+"/src/lib")
+	require.NoError(t, err)
+
+	node, _ := getNode(t, api, lib)
+	assert.Empty(t, node.Links)
+}
+
+func TestBuilder_BuildFlat(t *testing.T) {
+	api := newFakeAPI()
+	s := memory.NewStorage()
+	builder := NewBuilder(api, 2, nil)
+
+	upper := func(r io.Reader) (io.Reader, error) {
+		data, err := io.ReadAll(r)
+		return strings.NewReader(strings.ToUpper(string(data))), err
+	}
+
+	tree := testTree(t, s, "main")
+
+	prev, err := builder.BuildFlat(context.Background(), tree, &Flat{
+		Add:       []string{"README.md", "link", "src/main.go"},
+		Transform: upper,
+	})
+	require.NoError(t, err)
+	require.Empty(t, prev.Failed)
+
+	readme := prev.Files["README.md"]
+	content, err := api.BlockGet(context.Background(), readme)
+	require.NoError(t, err)
+	assert.Equal(t, "README", string(content))
+
+	root, data := getNode(t, api, prev.Root)
+	assert.Equal(t, TDirectory, data.Type)
+	// the symlink is added as the file of its target
+	names := []string{prev.Files["README.md"].String(), prev.Files["link"].String(), prev.Files["src/main.go"].String()}
+	sort.Strings(names)
+	assert.Equal(t, names, linkNames(root))
+
+	api.added = 0
+
+	res, err := builder.BuildFlat(context.Background(), testTree(t, s, "main v2"), &Flat{
+		Add:       []string{"src/main.go"},
+		Keep:      map[string]cid.Cid{"README.md": readme, "link": prev.Files["link"]},
+		Previous:  prev.Root,
+		Transform: upper,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, api.added)
+	assert.Equal(t, []string{"src/main.go"}, keys(res.Files))
+
+	root, _ = getNode(t, api, res.Root)
+	assert.ElementsMatch(t, []string{res.Files["src/main.go"].String(), readme.String(), prev.Files["link"].String()}, linkNames(root))
+
+	// kept files missing in the previous directory are resolved through the API
+	missing, err := cid.Decode("bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku")
+	require.NoError(t, err)
+
+	res, err = builder.BuildFlat(context.Background(), tree, &Flat{Keep: map[string]cid.Cid{"missing": missing}, Previous: prev.Root})
+	require.NoError(t, err)
+	assert.Empty(t, res.Failed)
+
+	root, _ = getNode(t, api, res.Root)
+	assert.Equal(t, []string{missing.String()}, linkNames(root))
+}
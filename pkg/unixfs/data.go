@@ -0,0 +1,123 @@
+package unixfs
+
+import (
+	"fmt"
+)
+
+// DataType is the UnixFS node type
+type DataType uint64
+
+const (
+	TRaw       DataType = 0
+	TDirectory DataType = 1
+	TFile      DataType = 2
+	TMetadata  DataType = 3
+	TSymlink   DataType = 4
+	THAMTShard DataType = 5
+)
+
+// Data is the UnixFS payload of dag-pb node. Optional fields are
+// encoded only when set, so decoded nodes are encoded back unchanged.
+type Data struct {
+	Type DataType
+	// Data is the inline content, e.g. symlink target
+	Data []byte
+	// FileSize is the size of the file content
+	FileSize *uint64
+	// BlockSizes are content sizes of the file node children
+	BlockSizes []uint64
+	HashType   *uint64
+	Fanout     *uint64
+	// Mode is the POSIX permissions, zero means the default one
+	Mode uint32
+	// Mtime is the encoded modification time
+	Mtime []byte
+}
+
+// Marshal encodes the UnixFS data.
+func (d *Data) Marshal() []byte {
+	var buf []byte
+
+	buf = appendVarint(buf, 1, uint64(d.Type))
+	if d.Data != nil {
+		buf = appendBytes(buf, 2, d.Data)
+	}
+	if d.FileSize != nil {
+		buf = appendVarint(buf, 3, *d.FileSize)
+	}
+	for _, size := range d.BlockSizes {
+		buf = appendVarint(buf, 4, size)
+	}
+	if d.HashType != nil {
+		buf = appendVarint(buf, 5, *d.HashType)
+	}
+	if d.Fanout != nil {
+		buf = appendVarint(buf, 6, *d.Fanout)
+	}
+	if d.Mode != 0 {
+		buf = appendVarint(buf, 7, uint64(d.Mode))
+	}
+	if d.Mtime != nil {
+		buf = appendBytes(buf, 8, d.Mtime)
+	}
+
+	return buf
+}
+
+// UnmarshalData decodes UnixFS data.
+func UnmarshalData(b []byte) (*Data, error) {
+	d := &Data{}
+
+	err := fields(b, func(num int, wire int, v uint64, data []byte) error {
+		switch {
+		case num == 1 && wire == wireVarint:
+			d.Type = DataType(v)
+		case num == 2 && wire == wireBytes:
+			d.Data = append([]byte{}, data...)
+		case num == 3 && wire == wireVarint:
+			d.FileSize = &v
+		case num == 4 && wire == wireVarint:
+			d.BlockSizes = append(d.BlockSizes, v)
+		case num == 5 && wire == wireVarint:
+			d.HashType = &v
+		case num == 6 && wire == wireVarint:
+			d.Fanout = &v
+		case num == 7 && wire == wireVarint:
+			d.Mode = uint32(v)
+		case num == 8 && wire == wireBytes:
+			d.Mtime = append([]byte{}, data...)
+		default:
+			return fmt.Errorf("%w: unexpected unixfs field %d", ErrMalformed, num)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// DirectoryNode returns an empty UnixFS directory node.
+func DirectoryNode() *Node {
+	return &Node{Data: (&Data{Type: TDirectory}).Marshal()}
+}
+
+// SymlinkNode returns UnixFS symlink node pointing to the target.
+func SymlinkNode(target string) *Node {
+	return &Node{Data: (&Data{Type: TSymlink, Data: []byte(target)}).Marshal()}
+}
+
+// FileNode returns UnixFS file node with the given raw leaves.
+func FileNode(leaves []Link, mode uint32) *Node {
+	d := &Data{Type: TFile, Mode: mode}
+
+	var size uint64
+	for _, l := range leaves {
+		size += l.Tsize
+		d.BlockSizes = append(d.BlockSizes, l.Tsize)
+	}
+	d.FileSize = &size
+
+	return &Node{Links: leaves, Data: d.Marshal()}
+}
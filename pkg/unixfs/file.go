@@ -0,0 +1,205 @@
+package unixfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	// ChunkSize is the size of file chunks, the IPFS node default
+	ChunkSize = 256 << 10
+	// MaxLinks is the maximum number of links of a file node,
+	// the IPFS node default for the balanced layout
+	MaxLinks = 174
+)
+
+// BlockFunc receives blocks of the imported file.
+type BlockFunc func(c cid.Cid, data []byte) error
+
+// FileCID returns the CID IPFS node assigns to the content added as CIDv1.
+func FileCID(r io.Reader) (cid.Cid, error) {
+	link, err := ImportFile(r, nil)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return link.Cid, nil
+}
+
+// ImportFile builds UnixFS file DAG of the content the way IPFS node adds it
+// with CIDv1: fixed size chunks are raw leaves, which are linked into the
+// balanced tree of file nodes. Every block is passed to put, if it's set.
+// Returned link has the root CID and the cumulative DAG size.
+func ImportFile(r io.Reader, put BlockFunc) (Link, error) {
+	im := &importer{r: r, put: put, buf: make([]byte, ChunkSize)}
+
+	if err := im.next(); err != nil {
+		return Link{}, err
+	}
+
+	// the first leaf is the root of a single chunk file,
+	// every next root links the previous one as its first child
+	root, err := im.leaf()
+	if err != nil {
+		return Link{}, err
+	}
+
+	for depth := 1; !im.done(); depth++ {
+		node := &fileNode{}
+		node.add(root)
+
+		if root, err = im.fill(node, depth); err != nil {
+			return Link{}, err
+		}
+	}
+
+	return root.Link, nil
+}
+
+// importer reads file chunks one ahead, so it knows when the file is done
+type importer struct {
+	r   io.Reader
+	put BlockFunc
+	buf []byte
+	// chunk is the next chunk, nil once the content is read
+	chunk []byte
+	// read is set once the first chunk is read
+	read bool
+}
+
+// child is a file DAG node with the size of its file content
+type child struct {
+	Link
+	fileSize uint64
+}
+
+// fileNode is a not yet stored file node
+type fileNode struct {
+	links []Link
+	sizes []uint64
+}
+
+func (n *fileNode) add(c child) {
+	n.links = append(n.links, c.Link)
+	n.sizes = append(n.sizes, c.fileSize)
+}
+
+// next reads the next chunk
+func (im *importer) next() error {
+	n, err := io.ReadFull(im.r, im.buf)
+	switch {
+	case errors.Is(err, io.EOF):
+		im.chunk = nil
+	case errors.Is(err, io.ErrUnexpectedEOF), err == nil:
+		im.chunk = append([]byte{}, im.buf[:n]...)
+	default:
+		return fmt.Errorf("read file: %w", err)
+	}
+
+	if !im.read {
+		im.read = true
+		// empty file is a single empty leaf
+		if im.chunk == nil {
+			im.chunk = []byte{}
+		}
+	}
+
+	return nil
+}
+
+// done reports whether all chunks are stored
+func (im *importer) done() bool {
+	return im.chunk == nil
+}
+
+// leaf stores the next chunk as raw leaf
+func (im *importer) leaf() (child, error) {
+	data := im.chunk
+
+	c, err := im.store(cid.Raw, data)
+	if err != nil {
+		return child{}, err
+	}
+
+	if err := im.next(); err != nil {
+		return child{}, err
+	}
+
+	size := uint64(len(data))
+	return child{Link: Link{Cid: c, Tsize: size}, fileSize: size}, nil
+}
+
+// fill adds children of the given depth to the node until it's full
+// or the file is done and stores it
+func (im *importer) fill(node *fileNode, depth int) (child, error) {
+	for len(node.links) < MaxLinks && !im.done() {
+		var (
+			c   child
+			err error
+		)
+
+		if depth == 1 {
+			c, err = im.leaf()
+		} else {
+			c, err = im.fill(&fileNode{}, depth-1)
+		}
+		if err != nil {
+			return child{}, err
+		}
+
+		node.add(c)
+	}
+
+	var fileSize uint64
+	for _, size := range node.sizes {
+		fileSize += size
+	}
+
+	data := &Data{Type: TFile, FileSize: &fileSize, BlockSizes: node.sizes}
+	raw := (&Node{Links: node.links, Data: data.Marshal()}).Marshal()
+
+	c, err := im.store(cid.DagProtobuf, raw)
+	if err != nil {
+		return child{}, err
+	}
+
+	size := uint64(len(raw))
+	for _, l := range node.links {
+		size += l.Tsize
+	}
+
+	return child{Link: Link{Cid: c, Tsize: size}, fileSize: fileSize}, nil
+}
+
+// store computes the block CID and passes the block to put
+func (im *importer) store(codec uint64, data []byte) (cid.Cid, error) {
+	c, err := sum(codec, data)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if im.put != nil {
+		if err := im.put(c, data); err != nil {
+			return cid.Undef, err
+		}
+	}
+
+	return c, nil
+}
+
+// sum computes CIDv1 of the block hashed the way IPFS node hashes it
+func sum(codec uint64, data []byte) (cid.Cid, error) {
+	mhType := uint64(mh.SHA2_256)
+	if codec == cid.GitRaw {
+		mhType = mh.SHA1
+	}
+
+	c, err := cid.Prefix{Version: 1, Codec: codec, MhType: mhType, MhLength: -1}.Sum(data)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("compute cid: %w", err)
+	}
+	return c, nil
+}
@@ -0,0 +1,109 @@
+package unixfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// zeros reads zero bytes
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestFileCID(t *testing.T) {
+	c, err := FileCID(strings.NewReader(""))
+	require.NoError(t, err)
+	assert.Equal(t, "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku", c.String())
+
+	c, err = FileCID(strings.NewReader("hello world"))
+	require.NoError(t, err)
+	assert.Equal(t, "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e", c.String())
+
+	c, err = FileCID(bytes.NewReader(make([]byte, ChunkSize)))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(cid.Raw), c.Type(), "single chunk file is a raw leaf")
+}
+
+func TestImportFile_Balanced(t *testing.T) {
+	blocks := make(map[cid.Cid][]byte)
+	put := func(c cid.Cid, data []byte) error {
+		blocks[c] = append([]byte{}, data...)
+		return nil
+	}
+
+	size := int64(MaxLinks*ChunkSize + 10)
+	root, err := ImportFile(io.LimitReader(zeros{}, size), put)
+	require.NoError(t, err)
+
+	node := func(c cid.Cid) (*Node, *Data) {
+		n, err := UnmarshalNode(blocks[c])
+		require.NoError(t, err)
+		d, err := UnmarshalData(n.Data)
+		require.NoError(t, err)
+		return n, d
+	}
+
+	// the first full level is linked as the first child of the new root
+	n, d := node(root.Cid)
+	require.Len(t, n.Links, 2)
+	assert.Equal(t, uint64(size), *d.FileSize)
+	assert.Equal(t, []uint64{MaxLinks * ChunkSize, 10}, d.BlockSizes)
+
+	full, _ := node(n.Links[0].Cid)
+	assert.Len(t, full.Links, MaxLinks)
+
+	last, d := node(n.Links[1].Cid)
+	require.Len(t, last.Links, 1)
+	assert.Equal(t, uint64(cid.Raw), last.Links[0].Cid.Type())
+	assert.Equal(t, []uint64{10}, d.BlockSizes)
+
+	// cumulative size covers the content and all file nodes
+	nodes := len(blocks[root.Cid]) + len(blocks[n.Links[0].Cid]) + len(blocks[n.Links[1].Cid])
+	assert.Equal(t, uint64(size)+uint64(nodes), root.Tsize)
+}
+
+func TestLocalAPI(t *testing.T) {
+	ctx := context.Background()
+	api := NewLocalAPI()
+
+	file, size, err := api.Add(ctx, io.LimitReader(zeros{}, 3*ChunkSize))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(cid.DagProtobuf), file.Type())
+
+	dir := DirectoryNode()
+	dir.Links = []Link{{Cid: file, Name: "zeros", Tsize: size}}
+
+	root, err := api.BlockPut(ctx, dir.Marshal(), cid.DagProtobuf)
+	require.NoError(t, err)
+
+	c, statSize, err := api.Stat(ctx, "/ipfs/"+root.String()+"/zeros")
+	require.NoError(t, err)
+	assert.Equal(t, file, c)
+	assert.Equal(t, size, statSize)
+
+	// raw leaves aren't kept
+	n, err := UnmarshalNode(mustBlock(t, api, file))
+	require.NoError(t, err)
+	_, err = api.BlockGet(ctx, n.Links[0].Cid)
+	assert.ErrorIs(t, err, ErrNotStored)
+}
+
+func mustBlock(t *testing.T, api *LocalAPI, c cid.Cid) []byte {
+	t.Helper()
+
+	data, err := api.BlockGet(context.Background(), c)
+	require.NoError(t, err)
+	return data
+}
@@ -0,0 +1,164 @@
+package unixfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/ipfs/go-cid"
+)
+
+// TransformFunc transforms the file content before it's added, e.g. encrypts it.
+type TransformFunc func(r io.Reader) (io.Reader, error)
+
+// Flat describes the flat directory to publish.
+type Flat struct {
+	// Add are paths of the tree files to add
+	Add []string
+	// Keep are the previously added files kept in the directory by their paths
+	Keep map[string]cid.Cid
+	// Previous is the optional previously published flat directory root,
+	// kept files are linked with the sizes recorded there, files missing
+	// in it are resolved through the API
+	Previous cid.Cid
+	// Transform is the optional transformation of the added content
+	Transform TransformFunc
+}
+
+// BuildFlat publishes the files of the tree as a single directory, which
+// entries are named by their CIDs, and pins its root. The directory doesn't
+// reveal file paths and the tree layout, so it suits encrypted content.
+// Symlinks are added as files of their targets, modes are not kept. Files
+// failed to be added don't fail the build, they are reported in the Result.
+func (b *Builder) BuildFlat(ctx context.Context, tree *object.Tree, flat *Flat) (*Result, error) {
+	var blobs []*entry
+
+	for _, p := range flat.Add {
+		file, err := tree.File(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get file %s: %w", p, err)
+		}
+
+		blobs = append(blobs, &entry{name: path.Base(p), path: p, mode: file.Mode, blob: &file.Blob})
+	}
+
+	transform := flat.Transform
+	if transform == nil {
+		transform = func(r io.Reader) (io.Reader, error) { return r, nil }
+	}
+
+	b.addBlobs(ctx, blobs, func(ctx context.Context, e *entry) (Link, error) {
+		return b.addTransformed(ctx, e, transform)
+	})
+
+	res := &Result{
+		Files:  make(map[string]cid.Cid),
+		Failed: make(map[string]error),
+	}
+
+	links := make(map[cid.Cid]Link)
+
+	for _, e := range blobs {
+		if e.err != nil {
+			res.Failed[e.path] = e.err
+			continue
+		}
+
+		res.Files[e.path] = e.link.Cid
+		links[e.link.Cid] = e.link
+	}
+
+	var previous map[cid.Cid]Link
+	if len(flat.Keep) != 0 && flat.Previous.Defined() {
+		previous = b.flatLinks(ctx, flat.Previous)
+	}
+
+	for p, c := range flat.Keep {
+		if _, ok := links[c]; ok {
+			continue
+		}
+
+		if link, ok := previous[c]; ok {
+			links[c] = link
+			continue
+		}
+
+		_, size, err := b.api.Stat(ctx, c.String())
+		if err != nil {
+			res.Failed[p] = fmt.Errorf("keep %s: %w", p, err)
+			continue
+		}
+
+		links[c] = Link{Cid: c, Tsize: size}
+	}
+
+	dir := DirectoryNode()
+	for c, link := range links {
+		link.Name = c.String()
+		dir.Links = append(dir.Links, link)
+	}
+
+	sort.Slice(dir.Links, func(i, j int) bool {
+		return dir.Links[i].Name < dir.Links[j].Name
+	})
+
+	root, err := b.put(ctx, "", dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.api.Pin(ctx, root.Cid); err != nil {
+		return nil, err
+	}
+
+	res.Root = root.Cid
+	res.Size = root.Tsize
+
+	return res, nil
+}
+
+// addTransformed adds the transformed blob content as a file
+func (b *Builder) addTransformed(ctx context.Context, e *entry, transform TransformFunc) (Link, error) {
+	r, err := b.open(e.blob)
+	if err != nil {
+		return Link{}, err
+	}
+	defer r.Close()
+
+	content, err := transform(r)
+	if err != nil {
+		return Link{}, err
+	}
+
+	c, size, err := b.api.Add(ctx, content)
+	if err != nil {
+		return Link{}, err
+	}
+
+	return Link{Cid: c, Tsize: size}, nil
+}
+
+// flatLinks returns links of the flat directory by their CIDs. The directory
+// failed to be fetched has no links, kept files are resolved one by one then.
+func (b *Builder) flatLinks(ctx context.Context, root cid.Cid) map[cid.Cid]Link {
+	links := make(map[cid.Cid]Link)
+
+	raw, err := b.api.BlockGet(ctx, root)
+	if err != nil {
+		return links
+	}
+
+	node, err := UnmarshalNode(raw)
+	if err != nil {
+		return links
+	}
+
+	for _, link := range node.Links {
+		links[link.Cid] = link
+	}
+
+	return links
+}
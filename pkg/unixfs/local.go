@@ -0,0 +1,140 @@
+package unixfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+)
+
+// ErrNotStored is returned when LocalAPI is asked for the block it dropped
+var ErrNotStored = errors.New("block is not stored locally")
+
+// LocalAPI is API computing CIDs locally without any IPFS node, the way the
+// node does with its default settings. Nothing is published or pinned.
+// Only dag-pb nodes are kept, so files and directories can be resolved,
+// raw leaves are dropped. It's meant for dry runs and audits.
+type LocalAPI struct {
+	mu    sync.Mutex
+	nodes map[cid.Cid][]byte
+}
+
+// NewLocalAPI creates a new LocalAPI.
+func NewLocalAPI() *LocalAPI {
+	return &LocalAPI{nodes: make(map[cid.Cid][]byte)}
+}
+
+func (l *LocalAPI) Add(_ context.Context, r io.Reader) (cid.Cid, uint64, error) {
+	link, err := ImportFile(r, l.keep)
+	if err != nil {
+		return cid.Undef, 0, fmt.Errorf("add: %w", err)
+	}
+	return link.Cid, link.Tsize, nil
+}
+
+func (l *LocalAPI) BlockGet(_ context.Context, c cid.Cid) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data, ok := l.nodes[c]
+	if !ok {
+		return nil, fmt.Errorf("block get %s: %w", c, ErrNotStored)
+	}
+	return data, nil
+}
+
+func (l *LocalAPI) BlockPut(_ context.Context, data []byte, codec uint64) (cid.Cid, error) {
+	if _, ok := blockCodecs[codec]; !ok {
+		return cid.Undef, fmt.Errorf("block put: unsupported codec %d", codec)
+	}
+
+	c, err := sum(codec, data)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("block put: %w", err)
+	}
+
+	return c, l.keep(c, data)
+}
+
+// Stat resolves the path through kept directory nodes.
+func (l *LocalAPI) Stat(ctx context.Context, path string) (cid.Cid, uint64, error) {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(path, "/ipfs/"), "/"), "/")
+
+	c, err := cid.Decode(segments[0])
+	if err != nil {
+		return cid.Undef, 0, fmt.Errorf("stat %s: %w", path, err)
+	}
+
+	raw, err := l.BlockGet(ctx, c)
+	if err != nil {
+		return cid.Undef, 0, fmt.Errorf("stat %s: %w", path, err)
+	}
+
+	node, err := UnmarshalNode(raw)
+	if err != nil {
+		return cid.Undef, 0, fmt.Errorf("stat %s: %w", path, err)
+	}
+
+	size := uint64(len(raw))
+	for _, link := range node.Links {
+		size += link.Tsize
+	}
+
+	for _, name := range segments[1:] {
+		link, ok := findLink(node, name)
+		if !ok {
+			return cid.Undef, 0, fmt.Errorf("stat %s: no link named %q", path, name)
+		}
+
+		c, size = link.Cid, link.Tsize
+
+		if c.Type() != cid.DagProtobuf {
+			continue
+		}
+
+		if raw, err = l.BlockGet(ctx, c); err != nil {
+			return cid.Undef, 0, fmt.Errorf("stat %s: %w", path, err)
+		}
+
+		if node, err = UnmarshalNode(raw); err != nil {
+			return cid.Undef, 0, fmt.Errorf("stat %s: %w", path, err)
+		}
+	}
+
+	return c, size, nil
+}
+
+func (l *LocalAPI) Pin(context.Context, cid.Cid) error {
+	return nil
+}
+
+func (l *LocalAPI) PinBlock(context.Context, cid.Cid) error {
+	return nil
+}
+
+// keep keeps dag-pb nodes
+func (l *LocalAPI) keep(c cid.Cid, data []byte) error {
+	if c.Type() != cid.DagProtobuf {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.nodes[c] = append([]byte{}, data...)
+	return nil
+}
+
+// findLink returns the node link with the given name
+func findLink(node *Node, name string) (Link, bool) {
+	for _, link := range node.Links {
+		if link.Name == name {
+			return link, true
+		}
+	}
+	return Link{}, false
+}
@@ -0,0 +1,169 @@
+package unixfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+)
+
+// ErrMalformed is returned when a block can't be decoded
+var ErrMalformed = errors.New("malformed protobuf")
+
+// protobuf wire types
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// Link is a dag-pb link to a child node.
+type Link struct {
+	// Cid is the child node CID
+	Cid cid.Cid
+	// Name is the link name, empty for file chunks
+	Name string
+	// Tsize is the cumulative size of the child DAG
+	Tsize uint64
+}
+
+// Node is a dag-pb node.
+type Node struct {
+	Links []Link
+	// Data is the node payload, usually encoded UnixFS Data
+	Data []byte
+}
+
+// Marshal encodes the node in the canonical dag-pb form: links
+// go before the data and are sorted by name.
+func (n *Node) Marshal() []byte {
+	links := make([]Link, len(n.Links))
+	copy(links, n.Links)
+	sort.SliceStable(links, func(i, j int) bool {
+		return links[i].Name < links[j].Name
+	})
+
+	var buf []byte
+	for _, l := range links {
+		var link []byte
+		link = appendBytes(link, 1, l.Cid.Bytes())
+		link = appendBytes(link, 2, []byte(l.Name))
+		link = appendVarint(link, 3, l.Tsize)
+
+		buf = appendBytes(buf, 2, link)
+	}
+
+	if n.Data != nil {
+		buf = appendBytes(buf, 1, n.Data)
+	}
+
+	return buf
+}
+
+// UnmarshalNode decodes dag-pb node.
+func UnmarshalNode(b []byte) (*Node, error) {
+	n := &Node{}
+
+	err := fields(b, func(num int, wire int, v uint64, data []byte) error {
+		switch {
+		case num == 1 && wire == wireBytes:
+			n.Data = append([]byte{}, data...)
+		case num == 2 && wire == wireBytes:
+			l, err := unmarshalLink(data)
+			if err != nil {
+				return err
+			}
+			n.Links = append(n.Links, l)
+		default:
+			return fmt.Errorf("%w: unexpected dag-pb field %d", ErrMalformed, num)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// unmarshalLink decodes dag-pb link
+func unmarshalLink(b []byte) (Link, error) {
+	var l Link
+
+	err := fields(b, func(num int, wire int, v uint64, data []byte) error {
+		switch {
+		case num == 1 && wire == wireBytes:
+			c, err := cid.Cast(data)
+			if err != nil {
+				return fmt.Errorf("%w: link cid: %s", ErrMalformed, err)
+			}
+			l.Cid = c
+		case num == 2 && wire == wireBytes:
+			l.Name = string(data)
+		case num == 3 && wire == wireVarint:
+			l.Tsize = v
+		default:
+			return fmt.Errorf("%w: unexpected link field %d", ErrMalformed, num)
+		}
+		return nil
+	})
+
+	return l, err
+}
+
+// appendVarint appends varint field to the buffer
+func appendVarint(buf []byte, num int, v uint64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(num)<<3|wireVarint)
+	return binary.AppendUvarint(buf, v)
+}
+
+// appendBytes appends length delimited field to the buffer
+func appendBytes(buf []byte, num int, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(num)<<3|wireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// fields iterates over protobuf message fields. Varint value is passed
+// as v, length delimited one as data.
+func fields(b []byte, fn func(num int, wire int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("%w: field key", ErrMalformed)
+		}
+		b = b[n:]
+
+		num, wire := int(key>>3), int(key&7)
+
+		var (
+			v    uint64
+			data []byte
+		)
+
+		switch wire {
+		case wireVarint:
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return fmt.Errorf("%w: field %d value", ErrMalformed, num)
+			}
+			b = b[n:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return fmt.Errorf("%w: field %d length", ErrMalformed, num)
+			}
+			data = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			return fmt.Errorf("%w: field %d wire type %d", ErrMalformed, num, wire)
+		}
+
+		if err := fn(num, wire, v, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}